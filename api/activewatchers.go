@@ -0,0 +1,145 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatcherInfo describes a watcher started through a
+// Connection, as returned by Connection.ActiveWatchers.
+type WatcherInfo struct {
+	// Facade holds the name of the facade whose method started
+	// the watcher, such as "Uniter".
+	Facade string
+
+	// Method holds the name of the method that started
+	// the watcher, such as "WatchConfigSettings".
+	Method string
+
+	// Id holds the id of the watcher, which is unique
+	// among the watchers of the connection.
+	Id string
+
+	// Started holds when the call that started
+	// the watcher completed.
+	Started time.Time
+}
+
+// ActiveWatchers implements Connection.ActiveWatchers.
+func (s *state) ActiveWatchers() []WatcherInfo {
+	return s.watchers.active()
+}
+
+// watcherTracker records the watchers started through a
+// connection that have not yet been stopped through it.
+type watcherTracker struct {
+	mu       sync.Mutex
+	watchers map[string]WatcherInfo
+}
+
+// record updates the watchers recorded to account for the given
+// successful call. A call of any facade method whose name starts
+// with "Watch" starts the watchers whose ids are held in its response,
+// found by watcherIds in the string fields whose names end with
+// "WatcherId", as they do in the results of all such methods; a call
+// whose response holds no such ids starts none. A call of Stop on a
+// watcher facade, whose name ends with "Watcher", stops the watcher
+// with the id called.
+func (t *watcherTracker) record(facade, method, id string, response interface{}, now time.Time) {
+	switch {
+	case method == "Stop" && strings.HasSuffix(facade, "Watcher"):
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.watchers, id)
+	case strings.HasPrefix(method, "Watch"):
+		ids := watcherIds(reflect.ValueOf(response), nil)
+		if len(ids) == 0 {
+			return
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.watchers == nil {
+			t.watchers = make(map[string]WatcherInfo)
+		}
+		for _, watcherId := range ids {
+			t.watchers[watcherId] = WatcherInfo{
+				Facade:  facade,
+				Method:  method,
+				Id:      watcherId,
+				Started: now,
+			}
+		}
+	}
+}
+
+// active returns the watchers recorded, ordered
+// by when they were started and then by id.
+func (t *watcherTracker) active() []WatcherInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.watchers) == 0 {
+		return nil
+	}
+	watchers := make([]WatcherInfo, 0, len(t.watchers))
+	for _, w := range t.watchers {
+		watchers = append(watchers, w)
+	}
+	sort.Sort(watcherInfosByStart(watchers))
+	return watchers
+}
+
+// watcherIds appends to ids the non-empty values of the string
+// fields of v, or of the structs it holds, whose names end with
+// "WatcherId", and returns the result.
+func watcherIds(v reflect.Value, ids []string) []string {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			ids = watcherIds(v.Elem(), ids)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			ids = watcherIds(v.Index(i), ids)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			fv := v.Field(i)
+			if fv.Kind() == reflect.String {
+				if strings.HasSuffix(field.Name, "WatcherId") && fv.String() != "" {
+					ids = append(ids, fv.String())
+				}
+				continue
+			}
+			ids = watcherIds(fv, ids)
+		}
+	}
+	return ids
+}
+
+type watcherInfosByStart []WatcherInfo
+
+func (ws watcherInfosByStart) Len() int {
+	return len(ws)
+}
+
+func (ws watcherInfosByStart) Less(i, j int) bool {
+	if !ws[i].Started.Equal(ws[j].Started) {
+		return ws[i].Started.Before(ws[j].Started)
+	}
+	return ws[i].Id < ws[j].Id
+}
+
+func (ws watcherInfosByStart) Swap(i, j int) {
+	ws[i], ws[j] = ws[j], ws[i]
+}
@@ -0,0 +1,149 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/apiserver/params"
+	"github.com/juju/juju/rpc"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type activeWatchersSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&activeWatchersSuite{})
+
+func (s *activeWatchersSuite) TestActiveWatchers(c *gc.C) {
+	conn := &watcherRPCConnection{}
+	clk := &fakeClock{now: time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)}
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: conn,
+		Clock:         clk,
+	})
+	c.Assert(st.ActiveWatchers(), gc.HasLen, 0)
+
+	var notifyResult params.NotifyWatchResult
+	err := st.APICall("Uniter", 4, "", "WatchConfigSettings", nil, &notifyResult)
+	c.Assert(err, jc.ErrorIsNil)
+	start0 := clk.now
+
+	clk.now = clk.now.Add(time.Minute)
+	var stringsResults params.StringsWatchResults
+	err = st.APICall("Provisioner", 3, "", "WatchContainers", nil, &stringsResults)
+	c.Assert(err, jc.ErrorIsNil)
+	start1 := clk.now
+
+	c.Assert(st.ActiveWatchers(), jc.DeepEquals, []api.WatcherInfo{{
+		Facade:  "Uniter",
+		Method:  "WatchConfigSettings",
+		Id:      "0",
+		Started: start0,
+	}, {
+		Facade:  "Provisioner",
+		Method:  "WatchContainers",
+		Id:      "1",
+		Started: start1,
+	}, {
+		Facade:  "Provisioner",
+		Method:  "WatchContainers",
+		Id:      "2",
+		Started: start1,
+	}})
+
+	// Calling Next leaves the watcher active.
+	err = st.APICall("NotifyWatcher", 1, "0", "Next", nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.ActiveWatchers(), gc.HasLen, 3)
+
+	err = st.APICall("StringsWatcher", 1, "1", "Stop", nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	err = st.APICall("NotifyWatcher", 1, "0", "Stop", nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.ActiveWatchers(), jc.DeepEquals, []api.WatcherInfo{{
+		Facade:  "Provisioner",
+		Method:  "WatchContainers",
+		Id:      "2",
+		Started: start1,
+	}})
+
+	err = st.APICall("StringsWatcher", 1, "2", "Stop", nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.ActiveWatchers(), gc.HasLen, 0)
+}
+
+func (s *activeWatchersSuite) TestFailedCallsNotTracked(c *gc.C) {
+	conn := &watcherRPCConnection{}
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: conn,
+	})
+	var notifyResult params.NotifyWatchResult
+	err := st.APICall("Uniter", 4, "", "WatchConfigSettings", nil, &notifyResult)
+	c.Assert(err, jc.ErrorIsNil)
+
+	conn.err = errors.New("boom")
+	err = st.APICall("Uniter", 4, "", "WatchConfigSettings", nil, &notifyResult)
+	c.Assert(err, gc.ErrorMatches, "boom")
+	err = st.APICall("NotifyWatcher", 1, "0", "Stop", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "boom")
+
+	watchers := st.ActiveWatchers()
+	c.Assert(watchers, gc.HasLen, 1)
+	c.Assert(watchers[0].Id, gc.Equals, "0")
+}
+
+func (s *activeWatchersSuite) TestOtherCallsNotTracked(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: &watcherRPCConnection{},
+	})
+	// The response holds a watcher id, but the
+	// method is not one that starts watchers.
+	var notifyResult params.NotifyWatchResult
+	err := st.APICall("Uniter", 4, "", "ConfigSettings", nil, &notifyResult)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.ActiveWatchers(), gc.HasLen, 0)
+}
+
+// watcherRPCConnection is an RPC connection that responds to
+// calls as watcher-starting methods do, giving each watcher
+// an id in sequence, and returns err, if set, from every call.
+type watcherRPCConnection struct {
+	nextId int
+	err    error
+}
+
+func (c *watcherRPCConnection) Close() error {
+	return nil
+}
+
+func (c *watcherRPCConnection) Call(req rpc.Request, args, response interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	switch r := response.(type) {
+	case *params.NotifyWatchResult:
+		r.NotifyWatcherId = c.newId()
+	case *params.StringsWatchResults:
+		r.Results = []params.StringsWatchResult{
+			{StringsWatcherId: c.newId()},
+			{StringsWatcherId: c.newId()},
+			{Error: &params.Error{Message: "permission denied"}},
+		}
+	}
+	return nil
+}
+
+func (c *watcherRPCConnection) newId() string {
+	id := fmt.Sprint(c.nextId)
+	c.nextId++
+	return id
+}
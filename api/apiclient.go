@@ -10,10 +10,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/juju/errors"
@@ -23,6 +30,7 @@ import (
 	"github.com/juju/utils/clock"
 	"github.com/juju/utils/parallel"
 	"github.com/juju/version"
+	"golang.org/x/net/context"
 	"golang.org/x/net/websocket"
 	"gopkg.in/juju/names.v2"
 	"gopkg.in/macaroon-bakery.v1/httpbakery"
@@ -31,6 +39,7 @@ import (
 	"github.com/juju/juju/api/base"
 	"github.com/juju/juju/apiserver/observer"
 	"github.com/juju/juju/apiserver/params"
+	"github.com/juju/juju/cert"
 	"github.com/juju/juju/network"
 	"github.com/juju/juju/rpc"
 	"github.com/juju/juju/rpc/jsoncodec"
@@ -61,17 +70,76 @@ type state struct {
 	conn   *websocket.Conn
 	clock  clock.Clock
 
+	// tlsState holds the state of the TLS handshake made when
+	// establishing conn, or nil if conn is not made over TLS.
+	tlsState *tls.ConnectionState
+
+	// localAddr and remoteAddr hold the addresses of the network
+	// connection underlying conn, or nil if there is none.
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	// counts holds the numbers of bytes transferred over the
+	// connection to the API server and in HTTP requests to it.
+	counts *byteCounts
+
+	// dialInfo and dialOpts hold the Info and DialOpts with which
+	// the connection was opened, for use by ForModel, with the
+	// credentials of dialInfo replaced by those given to any
+	// successful ChangeUser. dialInfo is nil if the connection
+	// was not opened by Open.
+	dialInfo *Info
+	dialOpts DialOpts
+
+	// readLimit, if non-nil, limits the size of messages
+	// received over conn.
+	readLimit *jsoncodec.ReadLimit
+
+	// limiter, if non-nil, limits the rate of API calls.
+	limiter *rateLimiter
+
+	// responseBudget, if non-nil, limits the memory
+	// taken by the responses to calls in flight.
+	responseBudget *responseBudget
+
+	// callInterceptor holds DialOpts.CallInterceptor.
+	callInterceptor func(facade, method string, version int, args interface{}) error
+
+	// tracer holds DialOpts.Tracer. While the connection is being
+	// opened, openSpan holds the span traced for Open, if any.
+	tracer   Tracer
+	openSpan Span
+
 	// addr is the address used to connect to the API server.
 	addr string
 
+	// label holds DialOpts.Label.
+	label string
+
+	// defaultCallTimeout holds DialOpts.DefaultCallTimeout.
+	defaultCallTimeout time.Duration
+
+	// logger holds DialOpts.Logger, or the package
+	// logger if it is nil.
+	logger Logger
+
 	// cookieURL is the URL that HTTP cookies for the API
 	// will be associated with (specifically macaroon auth cookies).
 	cookieURL *url.URL
 
+	// pathPrefix holds DialOpts.PathPrefix, without any
+	// trailing slash. It is prepended to websocket paths.
+	pathPrefix string
+
 	// modelTag holds the model tag.
 	// It is empty if there is no model tag associated with the connection.
 	modelTag names.ModelTag
 
+	// loginResultMu guards controllerTag, serverVersion, authTag,
+	// anonymous, modelAccess and controllerAccess, which are set
+	// by each successful login.
+	loginResultMu sync.Mutex
+
 	// controllerTag holds the controller's tag once we're connected.
 	controllerTag names.ControllerTag
 
@@ -80,13 +148,33 @@ type state struct {
 	// server does not report this during login.
 	serverVersion version.Number
 
+	// hostPortsMu guards hostPorts.
+	hostPortsMu sync.Mutex
+
 	// hostPorts is the API server addresses returned from Login,
 	// which the client may cache and use for failover.
 	hostPorts [][]network.HostPort
 
 	// facadeVersions holds the versions of all facades as reported by
-	// Login
-	facadeVersions map[string][]int
+	// Login. It is guarded by facadeVersionsMu, as is facadesChanged,
+	// which is created on demand by facadeVersionsChanged and closed
+	// when facadeVersions is next changed.
+	facadeVersionsMu sync.Mutex
+	facadeVersions   map[string][]int
+	facadesChanged   chan struct{}
+
+	// allowLegacyLogin holds DialOpts.AllowLegacyLogin. If it is set
+	// and a login result reports no facade versions,
+	// legacyFacadeVersions is set, and all facades are assumed to be
+	// available at version 0.
+	allowLegacyLogin     bool
+	legacyFacadeVersions bool
+
+	// requireMacaroonAuth holds DialOpts.RequireMacaroonAuth.
+	requireMacaroonAuth bool
+
+	// notifications receives the messages pushed by the API server.
+	notifications <-chan Notification
 
 	// pingFacadeVersion is the version to use for the pinger. This is lazily
 	// set at initialization to avoid a race in our tests. See
@@ -96,6 +184,10 @@ type state struct {
 	// authTag holds the authenticated entity's tag after login.
 	authTag names.Tag
 
+	// anonymous holds whether the login result identified
+	// no authenticated entity.
+	anonymous bool
+
 	// mpdelAccess holds the access level of the user to the connected model.
 	modelAccess string
 
@@ -114,12 +206,22 @@ type state struct {
 	// access it safely.
 	loggedIn int32
 
+	// loggedInC is closed when the client first logs in
+	// successfully. It is created on demand by loggedInChan,
+	// guarded by loggedInMu, and closed once by setLoggedIn.
+	loggedInMu   sync.Mutex
+	loggedInC    chan struct{}
+	loggedInOnce sync.Once
+
 	// tag, password, macaroons and nonce hold the cached login
-	// credentials. These are only valid if loggedIn is 1.
-	tag       string
-	password  string
-	macaroons []macaroon.Slice
-	nonce     string
+	// credentials. These are only valid if loggedIn is 1. They are
+	// guarded by credentialsMu, as is dialInfo, as they are changed
+	// by ChangeUser.
+	credentialsMu sync.Mutex
+	tag           string
+	password      string
+	macaroons     []macaroon.Slice
+	nonce         string
 
 	// serverRootAddress holds the cached API server address and port used
 	// to login.
@@ -139,6 +241,44 @@ type state struct {
 	// bakeryClient holds the client that will be used to
 	// authorize macaroon based login requests.
 	bakeryClient *httpbakery.Client
+
+	// pingJitter holds whether the first health check ping
+	// should be delayed by a random offset.
+	pingJitter bool
+
+	// reconnectMu guards reconnectCallbacks and connectCallbacks,
+	// which hold the callbacks registered with OnReconnect and
+	// OnConnect respectively, in registration order.
+	reconnectMu        sync.Mutex
+	reconnectCallbacks []*reconnectCallback
+	connectCallbacks   []*connectCallback
+
+	// reconnectRunMu is held while the reconnect and connect
+	// callbacks run, so that they are serialized.
+	reconnectRunMu sync.Mutex
+
+	// healthMu guards lastPing, which holds the time of the last
+	// successful ping, reconnects, which holds the number of times
+	// the reconnect callbacks have been run, and brokenReason,
+	// which holds why the health check found the connection
+	// broken, for Health, and lastConnectedAt.
+	healthMu     sync.Mutex
+	lastPing     time.Time
+	reconnects   int
+	brokenReason string
+
+	// openedAt holds when Open succeeded, and lastConnectedAt,
+	// guarded by healthMu, when the connection last logged in,
+	// whether on opening or on reconnecting.
+	openedAt        time.Time
+	lastConnectedAt time.Time
+
+	// watchers records the watchers started through
+	// the connection and its clones, for ActiveWatchers.
+	watchers watcherTracker
+
+	// stats counts the calls made with APICall, for Stats.
+	stats callStats
 }
 
 // RedirectError is returned from Open when the controller
@@ -165,9 +305,16 @@ func (e *RedirectError) Error() string {
 // will return an error with a *RedirectError cause
 // holding the details of another server to connect to.
 //
+// If none of the API addresses can be connected to, Open
+// returns a *DialError describing the failure for each.
+//
 // See Connect for details of the connection mechanics.
 func Open(info *Info, opts DialOpts) (Connection, error) {
-	return open(info, opts, clock.WallClock)
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.WallClock
+	}
+	return open(info, opts, clk)
 }
 
 // open is the unexported version of open that also includes
@@ -176,19 +323,41 @@ func open(
 	info *Info,
 	opts DialOpts,
 	clock clock.Clock,
-) (Connection, error) {
+) (_ Connection, err error) {
+	openSpan := startOpenSpan(info, opts)
+	if openSpan != nil {
+		defer func() { openSpan.End(err) }()
+	}
 	if err := info.Validate(); err != nil {
 		return nil, errors.Annotate(err, "validating info for opening an API connection")
 	}
 	if clock == nil {
 		return nil, errors.NotValidf("nil clock")
 	}
-	conn, tlsConfig, err := connectWebsocket(info, opts)
+	if opts.RequireMacaroonAuth && opts.LoginProvider != nil {
+		return nil, errors.NotValidf("specifying RequireMacaroonAuth and LoginProvider")
+	}
+	pathPrefix, err := cleanPathPrefix(opts.PathPrefix)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if opts.Headers != nil {
+		// Copy the headers so that those used to
+		// reconnect are those given here.
+		headers := make(http.Header)
+		addHeaders(headers, opts.Headers)
+		opts.Headers = headers
+	}
+	conn, tlsConfig, err := connectWebsocket(info, opts)
+	if err != nil {
+		// Not traced; see dialWebSocket.
+		return nil, err
+	}
 
-	client := rpc.NewConn(jsoncodec.NewWebsocket(conn), observer.None())
+	readLimit := jsoncodec.NewReadLimit(opts.MaxMessageBytes)
+	codec := jsoncodec.NewWebsocketReadLimit(conn.Conn, readLimit)
+	client := rpc.NewConn(codec, observer.None())
+	notifications := serveNotifications(client, opts.Label, connLogger(opts))
 	client.Start()
 
 	bakeryClient := opts.BakeryClient
@@ -202,20 +371,37 @@ func open(
 		bakeryClient.Client = &httpc
 	}
 	apiHost := conn.Config().Location.Host
+	fallback := http.DefaultTransport
+	if opts.DisableConnectionReuse {
+		fallback = utils.NewHttpTLSTransport(nil)
+	}
 	// Technically when there's no CACert, we don't need this
 	// machinery, because we could just use http.DefaultTransport
 	// for everything, but it's easier just to leave it in place.
+	primary := conn.counts.transport(utils.NewHttpTLSTransport(tlsConfig))
+	if len(opts.Headers) > 0 {
+		// The headers are sent only to the API server.
+		primary = &headerTransport{
+			headers:   opts.Headers,
+			transport: primary,
+		}
+	}
 	bakeryClient.Client.Transport = &hostSwitchingTransport{
 		primaryHost: apiHost,
-		primary:     utils.NewHttpTLSTransport(tlsConfig),
-		fallback:    http.DefaultTransport,
+		primary:     primary,
+		fallback:    fallback,
+	}
+	if opts.ClockSkewTolerance > 0 && bakeryClient.Client.Jar != nil {
+		bakeryClient.Client.Jar = newSkewTolerantJar(bakeryClient.Client.Jar, clock, opts.ClockSkewTolerance)
 	}
 
+	dialInfo := *info
 	st := &state{
 		client: client,
-		conn:   conn,
+		conn:   conn.Conn,
 		clock:  clock,
 		addr:   apiHost,
+		label:  opts.Label,
 		cookieURL: &url.URL{
 			Scheme: "https",
 			Host:   conn.Config().Location.Host,
@@ -228,20 +414,65 @@ func open(
 		// login because, when doing HTTP requests, we'll want
 		// to use the same username and password for authenticating
 		// those. If login fails, we discard the connection.
-		tag:          tagToString(info.Tag),
-		password:     info.Password,
-		macaroons:    info.Macaroons,
-		nonce:        info.Nonce,
-		tlsConfig:    tlsConfig,
-		bakeryClient: bakeryClient,
-		modelTag:     info.ModelTag,
+		tag:                 tagToString(info.Tag),
+		password:            passwordUnlessMacaroonAuth(info.Password, opts),
+		macaroons:           info.Macaroons,
+		nonce:               info.Nonce,
+		tlsConfig:           tlsConfig,
+		bakeryClient:        bakeryClient,
+		modelTag:            info.ModelTag,
+		pathPrefix:          pathPrefix,
+		pingJitter:          opts.PingJitter,
+		tlsState:            conn.tlsState,
+		localAddr:           conn.localAddr,
+		remoteAddr:          conn.remoteAddr,
+		counts:              conn.counts,
+		allowLegacyLogin:    opts.AllowLegacyLogin,
+		requireMacaroonAuth: opts.RequireMacaroonAuth,
+		notifications:       notifications,
+		readLimit:           readLimit,
+		callInterceptor:     opts.CallInterceptor,
+		tracer:              opts.Tracer,
+		logger:              connLogger(opts),
+		defaultCallTimeout:  opts.DefaultCallTimeout,
+		openSpan:            openSpan,
+		dialInfo:            &dialInfo,
+		dialOpts:            opts,
+	}
+	if opts.RequestRateLimit > 0 {
+		st.limiter = newRateLimiter(clock, opts.RequestRateLimit, opts.RequestBurst)
+	}
+	if opts.MaxConcurrentResponseBytes > 0 {
+		st.responseBudget = newResponseBudget(opts.MaxConcurrentResponseBytes)
+	}
+	if opts.ClockSkewTolerance > 0 {
+		st.warnClockSkew(opts.ClockSkewTolerance)
 	}
 	if !info.SkipLogin {
-		if err := st.Login(info.Tag, info.Password, info.Nonce, info.Macaroons); err != nil {
+		loginProvider := opts.LoginProvider
+		if loginProvider == nil {
+			loginProvider, err = st.builtinLoginProvider(info.Tag, info.Password, info.Nonce, info.Macaroons)
+			if err != nil {
+				conn.Close()
+				return nil, errors.Trace(err)
+			}
+		}
+		if err := st.loginWithProvider(loginProvider); err != nil {
 			conn.Close()
 			return nil, errors.Trace(err)
 		}
+		if opts.AcceptServerVersion != nil {
+			serverVersion := st.ServerVersionOrZero()
+			if err := opts.AcceptServerVersion(serverVersion); err != nil {
+				conn.Close()
+				return nil, errors.Annotatef(err, "server version %v not accepted", serverVersion)
+			}
+		}
 	}
+	// Calls made from now on are not part of opening the connection.
+	st.openSpan = nil
+	st.openedAt = clock.Now()
+	st.lastConnectedAt = st.openedAt
 	st.broken = make(chan struct{})
 	st.closed = make(chan struct{})
 	go st.heartbeatMonitor()
@@ -274,44 +505,126 @@ func (t *hostSwitchingTransport) RoundTrip(req *http.Request) (*http.Response, e
 // connection wins.
 //
 // It also returns the TLS configuration that it has derived from the Info.
-func connectWebsocket(info *Info, opts DialOpts) (*websocket.Conn, *tls.Config, error) {
+func connectWebsocket(info *Info, opts DialOpts) (*websocketConn, *tls.Config, error) {
 	if len(info.Addrs) == 0 {
 		return nil, nil, errors.New("no API addresses to connect to")
 	}
+	tlsConfig, err := newTLSConfig(info, opts)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	path, err := websocketPath(info.ModelTag, opts.PathPrefix, "/api")
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	conn, err := dialWebSocket(info.Addrs, path, tlsConfig, opts)
+	if err != nil {
+		// Not traced; see dialWebSocket.
+		return nil, nil, err
+	}
+	connLogger(opts).Infof("%sconnection established to %q", logPrefix(opts.Label), conn.RemoteAddr())
+	return conn, tlsConfig, nil
+}
+
+// newTLSConfig returns the TLS configuration to use when
+// connecting to the API server described by info.
+func newTLSConfig(info *Info, opts DialOpts) (*tls.Config, error) {
 	tlsConfig := utils.SecureTLSConfig()
 	tlsConfig.InsecureSkipVerify = opts.InsecureSkipVerify
+	if opts.DisableConnectionReuse {
+		tlsConfig.ClientSessionCache = nil
+		tlsConfig.SessionTicketsDisabled = true
+	}
+	if tlsConfig.InsecureSkipVerify {
+		return tlsConfig, nil
+	}
 
-	if info.CACert != "" && !tlsConfig.InsecureSkipVerify {
+	caCert := info.CACert
+	if caCert == "" && opts.CACertFile != "" {
+		// The file is read each time we connect, so that
+		// a rotated certificate is picked up.
+		data, err := ioutil.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot read CA certificate file")
+		}
+		if _, err := cert.ParseCert(string(data)); err != nil {
+			return nil, errors.Annotatef(err, "invalid CA certificate file %q", opts.CACertFile)
+		}
+		caCert = string(data)
+	}
+	if caCert != "" {
 		// We want to be specific here (rather than just using "anything".
 		// See commit 7fc118f015d8480dfad7831788e4b8c0432205e8 (PR 899).
 		tlsConfig.ServerName = "juju-apiserver"
-		certPool, err := CreateCertPool(info.CACert)
+		certPool, err := CreateCertPool(caCert)
 		if err != nil {
-			return nil, nil, errors.Annotate(err, "cert pool creation failed")
+			return nil, errors.Annotate(err, "cert pool creation failed")
 		}
 		tlsConfig.RootCAs = certPool
 	}
-	path, err := apiPath(info.ModelTag, "/api")
+	return tlsConfig, nil
+}
+
+// CheckReachable checks that each of the API addresses in info can be
+// connected to, and that the API server's certificate is valid according
+// to info.CACert, without logging in. Each address is dialed only once,
+// regardless of opts.Timeout and opts.RetryDelay. If any address cannot
+// be reached, the returned error describes the failure for every
+// unreachable address.
+func (info *Info) CheckReachable(opts DialOpts) error {
+	if err := info.Validate(); err != nil {
+		return errors.Annotate(err, "validating info for checking API reachability")
+	}
+	tlsConfig, err := newTLSConfig(info, opts)
 	if err != nil {
-		return nil, nil, errors.Trace(err)
+		return errors.Trace(err)
 	}
-	conn, err := dialWebSocket(info.Addrs, path, tlsConfig, opts)
+	path, err := websocketPath(info.ModelTag, opts.PathPrefix, "/api")
 	if err != nil {
-		return nil, nil, errors.Trace(err)
+		return errors.Trace(err)
 	}
-	logger.Infof("connection established to %q", conn.RemoteAddr())
-	return conn, tlsConfig, nil
+	if err := opts.IPVersionPreference.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	var failures []string
+	for _, addr := range filterIPVersion(info.Addrs, opts.IPVersionPreference) {
+		cfg, err := websocket.NewConfig("wss://"+addr+path, "http://localhost/")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.TlsConfig = tlsConfig
+		addHeaders(cfg.Header, opts.Headers)
+		conn, err := dialWebsocketConfig(cfg, opts)
+		if err != nil {
+			connLogger(opts).Debugf("%sAPI address %q is not reachable: %v", logPrefix(opts.Label), addr, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", addr, err))
+			continue
+		}
+		conn.Close()
+	}
+	if len(failures) > 0 {
+		return errors.Errorf("cannot reach API addresses: %s", strings.Join(failures, "; "))
+	}
+	return nil
 }
 
 // dialWebSocket dials a websocket with one of the provided addresses, the
 // specified URL path, TLS configuration, and dial options. Each of the
 // specified addresses will be attempted concurrently, and the first
 // successful connection will be returned.
-func dialWebSocket(addrs []string, path string, tlsConfig *tls.Config, opts DialOpts) (*websocket.Conn, error) {
+func dialWebSocket(addrs []string, path string, tlsConfig *tls.Config, opts DialOpts) (*websocketConn, error) {
 	// Dial all addresses at reasonable intervals.
-	try := parallel.NewTry(0, nil)
+	if err := opts.IPVersionPreference.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	allAddrs := addrs
+	addrs = filterIPVersion(addrs, opts.IPVersionPreference)
+	if len(addrs) == 0 {
+		return nil, errors.Errorf("no API addresses of IP version preference %q in %q", opts.IPVersionPreference, allAddrs)
+	}
+	try := parallel.NewTry(0, combineDialErrors)
 	defer try.Kill()
-	for _, addr := range addrs {
+	for _, addr := range prioritizeAddrs(addrs, opts.AddressPriority) {
 		err := dialWebsocket(addr, path, opts, tlsConfig, try)
 		if err == parallel.ErrStopped {
 			break
@@ -327,9 +640,51 @@ func dialWebSocket(addrs []string, path string, tlsConfig *tls.Config, opts Dial
 	try.Close()
 	result, err := try.Result()
 	if err != nil {
-		return nil, errors.Trace(err)
+		// The error is deliberately not traced, so that callers
+		// can inspect the *DialError describing each failure.
+		return nil, err
 	}
-	return result.(*websocket.Conn), nil
+	return result.(*websocketConn), nil
+}
+
+// prioritizeAddrs returns a copy of addrs sorted by the given
+// priority function, preserving the original order of addresses
+// with equal priority. If priority is nil, addrs is returned
+// unchanged.
+func prioritizeAddrs(addrs []string, priority func(string) int) []string {
+	if priority == nil {
+		return addrs
+	}
+	sorted := byPriority{
+		addrs:      make([]string, len(addrs)),
+		priorities: make([]int, len(addrs)),
+	}
+	for i, addr := range addrs {
+		sorted.addrs[i] = addr
+		sorted.priorities[i] = priority(addr)
+	}
+	sort.Stable(sorted)
+	return sorted.addrs
+}
+
+// byPriority implements sort.Interface, ordering
+// addresses by ascending priority.
+type byPriority struct {
+	addrs      []string
+	priorities []int
+}
+
+func (b byPriority) Len() int {
+	return len(b.addrs)
+}
+
+func (b byPriority) Less(i, j int) bool {
+	return b.priorities[i] < b.priorities[j]
+}
+
+func (b byPriority) Swap(i, j int) {
+	b.addrs[i], b.addrs[j] = b.addrs[j], b.addrs[i]
+	b.priorities[i], b.priorities[j] = b.priorities[j], b.priorities[i]
 }
 
 // ConnectStream implements StreamConnector.ConnectStream.
@@ -369,6 +724,7 @@ func (st *state) connectStream(path string, attrs url.Values) (base.Stream, erro
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	path = st.pathPrefix + path
 	target := url.URL{
 		Scheme:   "wss",
 		Host:     st.addr,
@@ -376,15 +732,17 @@ func (st *state) connectStream(path string, attrs url.Values) (base.Stream, erro
 		RawQuery: attrs.Encode(),
 	}
 	cfg, err := websocket.NewConfig(target.String(), "http://localhost/")
-	if st.tag != "" {
-		cfg.Header = utils.BasicAuthHeader(st.tag, st.password)
+	tag, password, nonce, _ := st.loginCredentials()
+	if tag != "" {
+		cfg.Header = utils.BasicAuthHeader(tag, password)
 	}
-	if st.nonce != "" {
-		cfg.Header.Set(params.MachineNonceHeader, st.nonce)
+	if nonce != "" {
+		cfg.Header.Set(params.MachineNonceHeader, nonce)
 	}
 	// Add any cookies because they will not be sent to websocket
 	// connections by default.
 	st.addCookiesToHeader(cfg.Header)
+	addHeaders(cfg.Header, st.dialOpts.Headers)
 
 	cfg.TlsConfig = st.tlsConfig
 	connection, err := websocketDialConfig(cfg)
@@ -466,6 +824,36 @@ func apiPath(modelTag names.ModelTag, path string) (string, error) {
 	return "/model/" + modelUUID + path, nil
 }
 
+// websocketPath returns the path of the given API websocket
+// endpoint relative to the given model tag, below the given
+// prefix, which is validated as for DialOpts.PathPrefix.
+func websocketPath(modelTag names.ModelTag, prefix, path string) (string, error) {
+	prefix, err := cleanPathPrefix(prefix)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	path, err = apiPath(modelTag, path)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return prefix + path, nil
+}
+
+// cleanPathPrefix checks that the given websocket path prefix
+// is valid, and returns it without any trailing slash.
+func cleanPathPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return "", errors.NotValidf("path prefix %q (must start with \"/\")", prefix)
+	}
+	if strings.ContainsAny(prefix, "?#") {
+		return "", errors.NotValidf("path prefix %q (must not contain a query string)", prefix)
+	}
+	return strings.TrimRight(prefix, "/"), nil
+}
+
 // tagToString returns the value of a tag's String method, or "" if the tag is nil.
 func tagToString(tag names.Tag) string {
 	if tag == nil {
@@ -484,7 +872,15 @@ func dialWebsocket(addr, path string, opts DialOpts, tlsConfig *tls.Config, try
 		return errors.Trace(err)
 	}
 	cfg.TlsConfig = tlsConfig
-	return try.Start(newWebsocketDialer(cfg, opts))
+	addHeaders(cfg.Header, opts.Headers)
+	dial := newWebsocketDialer(cfg, opts)
+	return try.Start(func(stop <-chan struct{}) (io.Closer, error) {
+		conn, err := dial(stop)
+		if err != nil && err != parallel.ErrStopped {
+			return nil, &AddrError{Addr: addr, Err: err}
+		}
+		return conn, err
+	})
 }
 
 // newWebsocketDialer returns a function that
@@ -504,16 +900,18 @@ func createWebsocketDialer(cfg *websocket.Config, opts DialOpts) func(<-chan str
 				return nil, parallel.ErrStopped
 			default:
 			}
-			logger.Infof("dialing %q", cfg.Location)
-			conn, err := websocket.DialConfig(cfg)
+			connLogger(opts).Infof("%sdialing %q", logPrefix(opts.Label), cfg.Location)
+			conn, err := dialWebsocketConfig(cfg, opts)
 			if err == nil {
 				return conn, nil
 			}
-			if !a.HasNext() || isX509Error(err) {
+			noRoute := opts.FailFastOnNoRoute && isNoRouteError(err)
+			if !a.HasNext() || isX509Error(err) || noRoute {
 				// We won't reconnect when there's an X509 error
 				// because we're not going to succeed if we retry
-				// in that case.
-				logger.Infof("error dialing %q: %v", cfg.Location, err)
+				// in that case, nor when asked not to reconnect
+				// to unreachable addresses.
+				connLogger(opts).Infof("%serror dialing %q: %v", logPrefix(opts.Label), cfg.Location, err)
 				return nil, errors.Annotatef(err, "unable to connect to API")
 			}
 		}
@@ -521,6 +919,176 @@ func createWebsocketDialer(cfg *websocket.Config, opts DialOpts) func(<-chan str
 	}
 }
 
+// minDefaultHandshakeTimeout is the shortest handshake timeout
+// derived from DialOpts.Timeout when DialOpts.HandshakeTimeout
+// is zero.
+const minDefaultHandshakeTimeout = time.Second
+
+// handshakeTimeout returns the time allowed for each attempt to
+// connect to an address, or zero if it is not limited.
+func handshakeTimeout(opts DialOpts) time.Duration {
+	if opts.HandshakeTimeout > 0 {
+		return opts.HandshakeTimeout
+	}
+	if opts.Timeout <= 0 {
+		return 0
+	}
+	timeout := opts.Timeout / 3
+	if timeout < minDefaultHandshakeTimeout {
+		timeout = minDefaultHandshakeTimeout
+	}
+	if timeout > opts.Timeout {
+		timeout = opts.Timeout
+	}
+	return timeout
+}
+
+// websocketConn is a websocket connection to the API server.
+type websocketConn struct {
+	*websocket.Conn
+
+	// tlsState holds the state of the TLS handshake made
+	// when establishing the connection.
+	tlsState *tls.ConnectionState
+
+	// localAddr and remoteAddr hold the addresses of the
+	// underlying network connection. The websocket.Conn
+	// methods of the same names report the websocket origin
+	// and location instead.
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	// counts holds the numbers of bytes transferred over
+	// the underlying network connection.
+	counts *byteCounts
+}
+
+// dialWebsocketConfig establishes a websocket connection as described
+// by cfg. It is equivalent to websocket.DialConfig, except that it dials
+// the underlying TCP connection itself so that the socket options in
+// opts can be applied before the TLS and websocket handshakes.
+func dialWebsocketConfig(cfg *websocket.Config, opts DialOpts) (*websocketConn, error) {
+	host := cfg.Location.Host
+	var deadline time.Time
+	if timeout := handshakeTimeout(opts); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialTCP(&dialer, opts.IPVersionPreference.tcpNetwork(), host)
+	if err != nil {
+		return nil, &websocket.DialError{Config: cfg, Err: err}
+	}
+	setSocketOptions(conn, opts)
+	counts := &byteCounts{}
+	conn = counts.conn(conn)
+	// The deadline applies to the handshakes as well as to
+	// the connect, and is cleared once they are done.
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, &websocket.DialError{Config: cfg, Err: err}
+	}
+
+	tlsConfig := cfg.TlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		// This mirrors what tls.Dial does for us when
+		// no server name has been specified.
+		serverName, _, err := net.SplitHostPort(host)
+		if err != nil {
+			serverName = host
+		}
+		c := *tlsConfig
+		c.ServerName = serverName
+		tlsConfig = &c
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, &websocket.DialError{Config: cfg, Err: err}
+	}
+	wsConn, err := websocket.NewClient(cfg, tlsConn)
+	if err != nil {
+		tlsConn.Close()
+		return nil, &websocket.DialError{Config: cfg, Err: err}
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		wsConn.Close()
+		return nil, &websocket.DialError{Config: cfg, Err: err}
+	}
+	tlsState := tlsConn.ConnectionState()
+	return &websocketConn{
+		Conn:       wsConn,
+		tlsState:   &tlsState,
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: conn.RemoteAddr(),
+		counts:     counts,
+	}, nil
+}
+
+// dialTCP makes the TCP connection underlying a websocket
+// connection to the given address with the given dialer,
+// over the given network: tcp, tcp4 or tcp6.
+var dialTCP = func(dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	return dialer.Dial(network, addr)
+}
+
+// tcpSocket holds the methods of *net.TCPConn that are
+// used to apply the socket options in DialOpts.
+type tcpSocket interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+	SetNoDelay(noDelay bool) error
+}
+
+// setSocketOptions applies the TCP socket options in opts to
+// the given connection. This is done on a best-effort basis;
+// connections that are not TCP connections are left alone,
+// and failures are logged but otherwise ignored.
+func setSocketOptions(conn net.Conn, opts DialOpts) {
+	sock, ok := conn.(tcpSocket)
+	if !ok {
+		connLogger(opts).Debugf("%snot applying socket options to non-TCP connection %T", logPrefix(opts.Label), conn)
+		return
+	}
+	if err := sock.SetNoDelay(!opts.DisableTCPNoDelay); err != nil {
+		connLogger(opts).Warningf("%scannot set TCP no-delay option: %v", logPrefix(opts.Label), err)
+	}
+	if opts.TCPKeepAlive > 0 {
+		if err := sock.SetKeepAlive(true); err != nil {
+			connLogger(opts).Warningf("%scannot enable TCP keepalive: %v", logPrefix(opts.Label), err)
+			return
+		}
+		if err := sock.SetKeepAlivePeriod(opts.TCPKeepAlive); err != nil {
+			connLogger(opts).Warningf("%scannot set TCP keepalive period: %v", logPrefix(opts.Label), err)
+		}
+	}
+}
+
+// isNoRouteError reports whether the given websocket error results
+// from the network or host being unreachable, as opposed to the
+// connection being refused or timing out.
+func isNoRouteError(err error) bool {
+	wsErr, ok := errors.Cause(err).(*websocket.DialError)
+	if !ok {
+		return false
+	}
+	opErr, ok := wsErr.Err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	errno := opErr.Err
+	if sysErr, ok := errno.(*os.SyscallError); ok {
+		errno = sysErr.Err
+	}
+	switch errno {
+	case syscall.ENETUNREACH, syscall.EHOSTUNREACH:
+		return true
+	}
+	return false
+}
+
 // isX509Error reports whether the given websocket error
 // results from an X509 problem.
 func isX509Error(err error) bool {
@@ -545,7 +1113,18 @@ func isX509Error(err error) bool {
 	return false
 }
 
-func callWithTimeout(f func() error, timeout time.Duration) bool {
+// logPrefix returns the prefix of the messages logged
+// for a connection with the given label.
+func logPrefix(label string) string {
+	if label == "" {
+		return ""
+	}
+	return fmt.Sprintf("[%s] ", label)
+}
+
+// callWithTimeout calls f, returning an error if it
+// fails or does not return within the given timeout.
+func callWithTimeout(f func() error, timeout time.Duration, label string, logger Logger) error {
 	result := make(chan error, 1)
 	go func() {
 		// Note that result is buffered so that we don't leak this
@@ -555,30 +1134,111 @@ func callWithTimeout(f func() error, timeout time.Duration) bool {
 	select {
 	case err := <-result:
 		if err != nil {
-			logger.Debugf("health ping failed: %v", err)
+			logger.Debugf("%shealth ping failed: %v", logPrefix(label), err)
+			return errors.Annotate(err, "health ping failed")
 		}
-		return err == nil
+		return nil
 	case <-time.After(timeout):
-		logger.Errorf("health ping timed out after %s", timeout)
-		return false
+		logger.Errorf("%shealth ping timed out after %s", logPrefix(label), timeout)
+		return errors.Errorf("health ping timed out after %s", timeout)
 	}
 }
 
+// heartbeatMonitor pings the API server every PingPeriod, and closes
+// s.broken once a ping fails. The connection is also broken as soon as
+// a message larger than the read limit is received, as the connection
+// can receive no more once that happens; the reason given is then the
+// read error rather than that of any ping.
 func (s *state) heartbeatMonitor() {
+	var exceeded <-chan struct{}
+	if s.readLimit != nil {
+		exceeded = s.readLimit.Exceeded()
+	}
+	if s.pingJitter {
+		select {
+		case <-s.clock.After(firstPingDelay(s.clock, PingPeriod)):
+		case <-exceeded:
+		case <-s.closed:
+		}
+	}
 	for {
-		if !callWithTimeout(s.Ping, PingTimeout) {
+		err := s.readLimitErr()
+		if err == nil {
+			err = callWithTimeout(s.Ping, PingTimeout, s.label, s.logger)
+		}
+		if err != nil {
+			// A ping fails when the read limit is exceeded
+			// while it is in progress, but the read error
+			// is the cause.
+			if readErr := s.readLimitErr(); readErr != nil {
+				err = readErr
+			}
+			s.logger.Warningf("%sconnection to %q broken: %v", logPrefix(s.label), s.addr, err)
+			s.setBrokenReason(err.Error())
 			close(s.broken)
 			return
 		}
 		select {
-		case <-time.After(PingPeriod):
+		case <-s.clock.After(PingPeriod):
+		case <-exceeded:
 		case <-s.closed:
 		}
 	}
 }
 
+// readLimitErr returns the error with which the connection failed
+// to receive a message larger than its read limit, if it has.
+func (s *state) readLimitErr() error {
+	if s.readLimit == nil {
+		return nil
+	}
+	return s.readLimit.Err()
+}
+
+// firstPingDelay returns a random delay in [0, period) to wait
+// before the first health check ping. The random source is seeded
+// from the clock so that connections made at different times
+// choose different delays.
+func firstPingDelay(clock clock.Clock, period time.Duration) time.Duration {
+	if period <= 0 {
+		return 0
+	}
+	r := rand.New(rand.NewSource(clock.Now().UnixNano()))
+	return time.Duration(r.Int63n(int64(period)))
+}
+
+// Ping implements Connection.Ping.
 func (s *state) Ping() error {
-	return s.APICall("Pinger", s.pingerFacadeVersion, "", "Ping", nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), PingTimeout)
+	defer cancel()
+	return s.PingContext(ctx)
+}
+
+// PingContext implements Connection.PingContext.
+func (s *state) PingContext(ctx context.Context) error {
+	return s.pingWith(ctx, s.call)
+}
+
+// pingWith pings the API server as PingContext does, making the
+// call with the given function, which is the call method of the
+// connection or of one of its clones, so that pings are not
+// counted in Stats.
+func (s *state) pingWith(ctx context.Context, call func(facade string, version int, id, method string, args, response interface{}) error) error {
+	result := make(chan error, 1)
+	go func() {
+		// result is buffered so that this goroutine
+		// is not leaked if the context is done first.
+		result <- call("Pinger", s.pingerFacadeVersion, "", "Ping", nil, nil)
+	}()
+	select {
+	case err := <-result:
+		if err == nil {
+			s.setLastPing(s.clock.Now())
+		}
+		return err
+	case <-ctx.Done():
+		return errors.Annotate(ctx.Err(), "ping")
+	}
 }
 
 type hasErrorCode interface {
@@ -591,6 +1251,61 @@ type hasErrorCode interface {
 // object id, and the specific RPC method. It marshalls the Arguments, and will
 // unmarshall the result into the response object that is supplied.
 func (s *state) APICall(facade string, version int, id, method string, args, response interface{}) error {
+	err := s.call(facade, version, id, method, args, response)
+	s.stats.record(err)
+	return err
+}
+
+// call makes the call given to APICall, for the connection
+// or one of its clones, without counting it in either's Stats.
+func (s *state) call(facade string, version int, id, method string, args, response interface{}) (err error) {
+	if span := s.startCallSpan(facade, version, id, method); span != nil {
+		defer func() { span.End(err) }()
+	}
+	if s.defaultCallTimeout > 0 && !exemptFromCallTimeout(facade, method) {
+		call := func(result interface{}) error {
+			return s.apiCall(facade, version, id, method, args, result)
+		}
+		return callWithDeadline(call, s.clock, s.defaultCallTimeout, facade, version, method, response)
+	}
+	return s.apiCall(facade, version, id, method, args, response)
+}
+
+// apiCall makes the call given to APICall, once any default call
+// timeout has been applied. The watchers started by the call are
+// recorded here, rather than when APICall returns, so that those
+// started by a call that completes after its deadline, whose result
+// is discarded, are recorded all the same, as they remain active on
+// the server.
+func (s *state) apiCall(facade string, version int, id, method string, args, response interface{}) error {
+	result := response
+	if s.callInterceptor != nil {
+		if err := s.callInterceptor(facade, method, version, args); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// Pings are not rate limited, nor held up behind large calls
+	// (see exemptFromResponseBudget), either of which could delay
+	// them beyond PingTimeout and so make the health check fail.
+	if s.limiter != nil && facade != "Pinger" {
+		if err := s.limiter.wait(s.closed); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// Calls whose results are discarded take no memory for them.
+	var sized *sizedResponse
+	budgetKey := responseKey(facade, method)
+	if s.responseBudget != nil && response != nil && !exemptFromResponseBudget(facade, method) {
+		n, err := s.responseBudget.acquire(budgetKey, s.ReadLimit(), s.closed)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer s.responseBudget.release(n)
+		// The response is unmarshalled through sized so that
+		// its size can be reserved by later calls.
+		sized = &sizedResponse{response: response}
+		response = sized
+	}
 	retrySpec := retry.CallArgs{
 		Func: func() error {
 			return s.client.Call(rpc.Request{
@@ -615,7 +1330,14 @@ func (s *state) APICall(facade string, version int, id, method string, args, res
 		Clock:       s.clock,
 	}
 	err := retry.Call(retrySpec)
-	return errors.Trace(err)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if sized != nil {
+		s.responseBudget.record(budgetKey, sized.size)
+	}
+	s.watchers.record(facade, method, id, result, s.clock.Now())
+	return nil
 }
 
 func (s *state) Close() error {
@@ -629,6 +1351,39 @@ func (s *state) Close() error {
 	return err
 }
 
+// SetReadLimit implements Connection.
+func (s *state) SetReadLimit(n int64) {
+	if s.readLimit != nil {
+		s.readLimit.Set(n)
+	}
+}
+
+// ReadLimit implements Connection.
+func (s *state) ReadLimit() int64 {
+	if s.readLimit == nil {
+		return 0
+	}
+	n := s.readLimit.Get()
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// Label implements Connection.
+func (s *state) Label() string {
+	return s.label
+}
+
+// String returns a description of the connection
+// that includes its label, if it has one.
+func (s *state) String() string {
+	if s.label == "" {
+		return fmt.Sprintf("API connection to %s", s.addr)
+	}
+	return fmt.Sprintf("API connection %q to %s", s.label, s.addr)
+}
+
 // Broken returns a channel that's closed when the connection is broken.
 func (s *state) Broken() <-chan struct{} {
 	return s.broken
@@ -646,6 +1401,8 @@ func (s *state) ModelTag() (names.ModelTag, bool) {
 
 // ControllerTag implements base.APICaller.ControllerTag.
 func (s *state) ControllerTag() names.ControllerTag {
+	s.loginResultMu.Lock()
+	defer s.loginResultMu.Unlock()
 	return s.controllerTag
 }
 
@@ -658,6 +1415,8 @@ func (s *state) ControllerTag() names.ControllerTag {
 // be invoked both within and outside the model (think
 // private clouds).
 func (s *state) APIHostPorts() [][]network.HostPort {
+	s.hostPortsMu.Lock()
+	defer s.hostPortsMu.Unlock()
 	// NOTE: We're making a copy of s.hostPorts before returning it,
 	// for safety.
 	hostPorts := make([][]network.HostPort, len(s.hostPorts))
@@ -667,8 +1426,36 @@ func (s *state) APIHostPorts() [][]network.HostPort {
 	return hostPorts
 }
 
+// RefreshAPIHostPorts implements Connection.RefreshAPIHostPorts.
+func (s *state) RefreshAPIHostPorts() ([][]network.HostPort, error) {
+	return s.refreshAPIHostPorts(s.Client())
+}
+
+// refreshAPIHostPorts refreshes the API server
+// addresses, fetching them with the given client.
+func (s *state) refreshAPIHostPorts(client *Client) ([][]network.HostPort, error) {
+	servers, err := client.APIHostPorts()
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot get API addresses")
+	}
+	hostPorts, err := addAddress(servers, s.addr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.setHostPorts(hostPorts)
+	return s.APIHostPorts(), nil
+}
+
+func (s *state) setHostPorts(hostPorts [][]network.HostPort) {
+	s.hostPortsMu.Lock()
+	defer s.hostPortsMu.Unlock()
+	s.hostPorts = hostPorts
+}
+
 // AllFacadeVersions returns what versions we know about for all facades
 func (s *state) AllFacadeVersions() map[string][]int {
+	s.facadeVersionsMu.Lock()
+	defer s.facadeVersionsMu.Unlock()
 	facades := make(map[string][]int, len(s.facadeVersions))
 	for name, versions := range s.facadeVersions {
 		facades[name] = append([]int{}, versions...)
@@ -683,6 +1470,8 @@ func (s *state) AllFacadeVersions() map[string][]int {
 // Facade we will want to use. It needs to line up the versions that the server
 // reports to us, with the versions that our client knows how to use.
 func (s *state) BestFacadeVersion(facade string) int {
+	s.facadeVersionsMu.Lock()
+	defer s.facadeVersionsMu.Unlock()
 	return bestVersion(facadeVersions[facade], s.facadeVersions[facade])
 }
 
@@ -698,4 +1487,18 @@ func (s *state) isLoggedIn() bool {
 
 func (s *state) setLoggedIn() {
 	atomic.StoreInt32(&s.loggedIn, 1)
+	s.loggedInOnce.Do(func() {
+		close(s.loggedInChan())
+	})
+}
+
+// loggedInChan returns a channel that is closed
+// when the client first logs in successfully.
+func (s *state) loggedInChan() chan struct{} {
+	s.loggedInMu.Lock()
+	defer s.loggedInMu.Unlock()
+	if s.loggedInC == nil {
+		s.loggedInC = make(chan struct{})
+	}
+	return s.loggedInC
 }
@@ -4,8 +4,22 @@
 package api_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/juju/errors"
@@ -14,11 +28,14 @@ import (
 	jc "github.com/juju/testing/checkers"
 	"github.com/juju/utils/clock"
 	"github.com/juju/utils/parallel"
+	"github.com/juju/version"
+	"golang.org/x/net/context"
 	"golang.org/x/net/websocket"
 	gc "gopkg.in/check.v1"
 	"gopkg.in/juju/names.v2"
 
 	"github.com/juju/juju/api"
+	"github.com/juju/juju/api/base"
 	"github.com/juju/juju/apiserver/params"
 	apiservertesting "github.com/juju/juju/apiserver/testing"
 	jjtesting "github.com/juju/juju/juju/testing"
@@ -39,7 +56,7 @@ func (s *apiclientSuite) TestConnectWebsocketToEnv(c *gc.C) {
 	conn, _, err := api.ConnectWebsocket(info, api.DialOpts{})
 	c.Assert(err, jc.ErrorIsNil)
 	defer conn.Close()
-	assertConnAddrForEnv(c, conn, info.Addrs[0], s.State.ModelUUID(), "/api")
+	assertConnAddrForEnv(c, conn.Conn, info.Addrs[0], s.State.ModelUUID(), "/api")
 }
 
 func (s *apiclientSuite) TestConnectWebsocketToRoot(c *gc.C) {
@@ -48,7 +65,7 @@ func (s *apiclientSuite) TestConnectWebsocketToRoot(c *gc.C) {
 	conn, _, err := api.ConnectWebsocket(info, api.DialOpts{})
 	c.Assert(err, jc.ErrorIsNil)
 	defer conn.Close()
-	assertConnAddrForRoot(c, conn, info.Addrs[0])
+	assertConnAddrForRoot(c, conn.Conn, info.Addrs[0])
 }
 
 func (s *apiclientSuite) TestConnectWebsocketMultiple(c *gc.C) {
@@ -63,7 +80,7 @@ func (s *apiclientSuite) TestConnectWebsocketMultiple(c *gc.C) {
 	conn, _, err := api.ConnectWebsocket(info, api.DialOpts{})
 	c.Assert(err, jc.ErrorIsNil)
 	conn.Close()
-	assertConnAddrForEnv(c, conn, proxy.Addr(), s.State.ModelUUID(), "/api")
+	assertConnAddrForEnv(c, conn.Conn, proxy.Addr(), s.State.ModelUUID(), "/api")
 
 	// Now break Addrs[0], and ensure that Addrs[1]
 	// is successfully connected to.
@@ -72,7 +89,7 @@ func (s *apiclientSuite) TestConnectWebsocketMultiple(c *gc.C) {
 	conn, _, err = api.ConnectWebsocket(info, api.DialOpts{})
 	c.Assert(err, jc.ErrorIsNil)
 	conn.Close()
-	assertConnAddrForEnv(c, conn, serverAddr, s.State.ModelUUID(), "/api")
+	assertConnAddrForEnv(c, conn.Conn, serverAddr, s.State.ModelUUID(), "/api")
 }
 
 func (s *apiclientSuite) TestConnectWebsocketMultipleError(c *gc.C) {
@@ -99,6 +116,154 @@ func (s *apiclientSuite) TestConnectWebsocketMultipleError(c *gc.C) {
 	c.Assert(atomic.LoadInt32(&count), gc.Equals, int32(3))
 }
 
+func (s *apiclientSuite) TestConnectWebsocketHandshakeTimeout(c *gc.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	defer listener.Close()
+	// count holds the number of times we've accepted a connection.
+	// The connections are held open without a response, so that
+	// the TLS handshake hangs.
+	var count int32
+	var mu sync.Mutex
+	var clients []net.Conn
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&count, 1)
+			mu.Lock()
+			clients = append(clients, client)
+			mu.Unlock()
+		}
+	}()
+	info := s.APIInfo(c)
+	info.Addrs = []string{listener.Addr().String()}
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := api.ConnectWebsocket(info, api.DialOpts{
+			Timeout:          500 * time.Millisecond,
+			RetryDelay:       10 * time.Millisecond,
+			HandshakeTimeout: 100 * time.Millisecond,
+		})
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		c.Assert(err, gc.ErrorMatches, `unable to connect to API: websocket.Dial wss://.*: .*i/o timeout`)
+	case <-time.After(jtesting.LongWait):
+		c.Fatalf("timed out waiting for handshake to time out")
+	}
+	// Each attempt timed out before the overall
+	// timeout, and so was retried.
+	c.Assert(atomic.LoadInt32(&count) > 1, jc.IsTrue)
+}
+
+func (s *apiclientSuite) TestHandshakeTimeoutDefault(c *gc.C) {
+	for i, test := range []struct {
+		opts   api.DialOpts
+		expect time.Duration
+	}{{
+		opts:   api.DialOpts{},
+		expect: 0,
+	}, {
+		opts:   api.DialOpts{Timeout: 30 * time.Second},
+		expect: 10 * time.Second,
+	}, {
+		opts:   api.DialOpts{Timeout: 2 * time.Second},
+		expect: time.Second,
+	}, {
+		opts:   api.DialOpts{Timeout: 500 * time.Millisecond},
+		expect: 500 * time.Millisecond,
+	}, {
+		opts:   api.DialOpts{Timeout: 30 * time.Second, HandshakeTimeout: time.Second},
+		expect: time.Second,
+	}, {
+		opts:   api.DialOpts{HandshakeTimeout: time.Second},
+		expect: time.Second,
+	}} {
+		c.Logf("test %d: %+v", i, test.opts)
+		c.Check(api.HandshakeTimeoutFor(test.opts), gc.Equals, test.expect)
+	}
+}
+
+// patchDialTCPError patches the dialing of API addresses to fail with
+// the given system error, returning a function that reports the number
+// of times an address has been dialed.
+func (s *apiclientSuite) patchDialTCPError(errno syscall.Errno) func() int32 {
+	var count int32
+	s.PatchValue(api.DialTCP, func(dialer *net.Dialer, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&count, 1)
+		return nil, &net.OpError{
+			Op:  "dial",
+			Net: "tcp",
+			Err: os.NewSyscallError("connect", errno),
+		}
+	})
+	return func() int32 {
+		return atomic.LoadInt32(&count)
+	}
+}
+
+func (s *apiclientSuite) TestOpenFailFastOnNoRoute(c *gc.C) {
+	for _, errno := range []syscall.Errno{syscall.ENETUNREACH, syscall.EHOSTUNREACH} {
+		c.Logf("errno %v", errno)
+		dialed := s.patchDialTCPError(errno)
+		info := s.APIInfo(c)
+		info.Addrs = []string{"10.0.0.1:17070", "10.0.0.2:17070"}
+		result := make(chan error, 1)
+		go func() {
+			_, err := api.Open(info, api.DialOpts{
+				Timeout:           time.Minute,
+				RetryDelay:        10 * time.Millisecond,
+				FailFastOnNoRoute: true,
+			})
+			result <- err
+		}()
+		select {
+		case err := <-result:
+			c.Assert(err, gc.ErrorMatches, `unable to connect to any API address: .*`+errno.Error())
+		case <-time.After(jtesting.LongWait):
+			c.Fatalf("timed out waiting for Open to fail")
+		}
+		// Each address was dialed once only.
+		c.Assert(dialed(), gc.Equals, int32(2))
+	}
+}
+
+func (s *apiclientSuite) TestOpenFailFastOnNoRouteRetriesConnectionRefused(c *gc.C) {
+	dialed := s.patchDialTCPError(syscall.ECONNREFUSED)
+	info := s.APIInfo(c)
+	info.Addrs = []string{"10.0.0.1:17070"}
+	_, err := api.Open(info, api.DialOpts{
+		Timeout:           200 * time.Millisecond,
+		RetryDelay:        10 * time.Millisecond,
+		FailFastOnNoRoute: true,
+	})
+	c.Assert(err, gc.ErrorMatches, `unable to connect to API: .*connection refused`)
+	c.Assert(dialed() > 1, jc.IsTrue)
+}
+
+func (s *apiclientSuite) TestOpenRetriesNoRouteByDefault(c *gc.C) {
+	dialed := s.patchDialTCPError(syscall.EHOSTUNREACH)
+	info := s.APIInfo(c)
+	info.Addrs = []string{"10.0.0.1:17070"}
+	_, err := api.Open(info, api.DialOpts{
+		Timeout:    200 * time.Millisecond,
+		RetryDelay: 10 * time.Millisecond,
+	})
+	c.Assert(err, gc.ErrorMatches, `unable to connect to API: .*no route to host`)
+	c.Assert(dialed() > 1, jc.IsTrue)
+}
+
 func (s *apiclientSuite) TestOpen(c *gc.C) {
 	info := s.APIInfo(c)
 	st, err := api.Open(info, api.DialOpts{})
@@ -147,6 +312,42 @@ func (s *apiclientSuite) TestOpenHonorsModelTag(c *gc.C) {
 	st.Close()
 }
 
+func (s *apiclientSuite) TestCheckReachable(c *gc.C) {
+	info := s.APIInfo(c)
+	err := info.CheckReachable(api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *apiclientSuite) TestCheckReachableSomeUnreachable(c *gc.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, jc.ErrorIsNil)
+	badAddr := listener.Addr().String()
+	listener.Close()
+
+	info := s.APIInfo(c)
+	goodAddr := info.Addrs[0]
+	info.Addrs = []string{badAddr, goodAddr}
+	err = info.CheckReachable(api.DialOpts{})
+	c.Assert(err, gc.ErrorMatches, `cannot reach API addresses: `+regexp.QuoteMeta(badAddr)+`: websocket.Dial wss://.*: connection refused`)
+}
+
+func (s *apiclientSuite) TestCheckReachableBadCACert(c *gc.C) {
+	info := s.APIInfo(c)
+	info.Addrs = []string{info.Addrs[0], info.Addrs[0]}
+	info.CACert = ""
+	err := info.CheckReachable(api.DialOpts{})
+	c.Assert(err, gc.ErrorMatches, `cannot reach API addresses: `+
+		`.*: websocket.Dial wss://.*: x509: .*; `+
+		`.*: websocket.Dial wss://.*: x509: .*`)
+}
+
+func (s *apiclientSuite) TestCheckReachableDoesNotLogin(c *gc.C) {
+	info := s.APIInfo(c)
+	info.Password = "not-the-password"
+	err := info.CheckReachable(api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+}
+
 func (s *apiclientSuite) TestServerRoot(c *gc.C) {
 	url := api.ServerRoot(s.APIState.Client())
 	c.Assert(url, gc.Matches, "https://localhost:[0-9]+")
@@ -161,6 +362,327 @@ func (s *apiclientSuite) TestDialWebsocketStopped(c *gc.C) {
 	c.Assert(result, gc.IsNil)
 }
 
+func (s *apiclientSuite) TestOpenDialsAddressesInPriorityOrder(c *gc.C) {
+	var dialed []string
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, _ api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		dialed = append(dialed, cfg.Location.Host)
+		return func(<-chan struct{}) (io.Closer, error) {
+			return nil, errors.New("boom")
+		}
+	})
+	info := s.APIInfo(c)
+	info.Addrs = []string{
+		"other-region-1:17070",
+		"same-region-1:17070",
+		"other-region-2:17070",
+		"same-region-2:17070",
+	}
+	priority := func(addr string) int {
+		if strings.HasPrefix(addr, "same-region") {
+			return 0
+		}
+		return 1
+	}
+	_, err := api.Open(info, api.DialOpts{
+		DialAddressInterval: time.Millisecond,
+		AddressPriority:     priority,
+	})
+	c.Assert(err, gc.ErrorMatches, "unable to connect to any API address: .*boom")
+	c.Assert(dialed, jc.DeepEquals, []string{
+		"same-region-1:17070",
+		"same-region-2:17070",
+		"other-region-1:17070",
+		"other-region-2:17070",
+	})
+	// The caller's addresses are left untouched.
+	c.Assert(info.Addrs[0], gc.Equals, "other-region-1:17070")
+}
+
+func (s *apiclientSuite) TestOpenIPVersionPreference(c *gc.C) {
+	addrs := []string{
+		"[2001:db8::1]:17070",
+		"10.0.0.1:17070",
+		"controller:17070",
+		"[2001:db8::2]:17070",
+	}
+	for i, test := range []struct {
+		preference api.IPVersionPreference
+		expect     []string
+	}{{
+		preference: "",
+		expect:     addrs,
+	}, {
+		preference: api.IPVersionAny,
+		expect:     addrs,
+	}, {
+		preference: api.IPVersionIPv4,
+		expect:     []string{"10.0.0.1:17070", "controller:17070"},
+	}, {
+		preference: api.IPVersionIPv6,
+		expect:     []string{"[2001:db8::1]:17070", "controller:17070", "[2001:db8::2]:17070"},
+	}} {
+		c.Logf("test %d: %q", i, test.preference)
+		var dialed []string
+		s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, _ api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+			dialed = append(dialed, cfg.Location.Host)
+			return func(<-chan struct{}) (io.Closer, error) {
+				return nil, errors.New("boom")
+			}
+		})
+		info := s.APIInfo(c)
+		info.Addrs = addrs
+		_, err := api.Open(info, api.DialOpts{
+			DialAddressInterval: time.Millisecond,
+			IPVersionPreference: test.preference,
+		})
+		c.Check(err, gc.ErrorMatches, "unable to connect to any API address: .*boom")
+		c.Check(dialed, jc.DeepEquals, test.expect)
+	}
+}
+
+func (s *apiclientSuite) TestOpenIPVersionPreferenceWithPriority(c *gc.C) {
+	var dialed []string
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, _ api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		dialed = append(dialed, cfg.Location.Host)
+		return func(<-chan struct{}) (io.Closer, error) {
+			return nil, errors.New("boom")
+		}
+	})
+	info := s.APIInfo(c)
+	info.Addrs = []string{"10.0.0.1:17070", "[2001:db8::1]:17070", "10.0.0.2:17070"}
+	_, err := api.Open(info, api.DialOpts{
+		DialAddressInterval: time.Millisecond,
+		IPVersionPreference: api.IPVersionIPv4,
+		AddressPriority: func(addr string) int {
+			if addr == "10.0.0.2:17070" {
+				return 0
+			}
+			return 1
+		},
+	})
+	c.Assert(err, gc.ErrorMatches, "unable to connect to any API address: .*boom")
+	c.Assert(dialed, jc.DeepEquals, []string{"10.0.0.2:17070", "10.0.0.1:17070"})
+}
+
+func (s *apiclientSuite) TestOpenIPVersionPreferenceResolvesHostNames(c *gc.C) {
+	// The host name resolves to addresses of both versions;
+	// only those of the preferred version are dialed.
+	resolved := map[string]string{
+		"tcp4": "10.0.0.1:17070",
+		"tcp6": "[2001:db8::1]:17070",
+	}
+	for _, test := range []struct {
+		preference api.IPVersionPreference
+		network    string
+	}{
+		{api.IPVersionAny, "tcp"},
+		{api.IPVersionIPv4, "tcp4"},
+		{api.IPVersionIPv6, "tcp6"},
+	} {
+		c.Logf("preference %q", test.preference)
+		var dialed []string
+		s.PatchValue(api.DialTCP, func(dialer *net.Dialer, network, addr string) (net.Conn, error) {
+			c.Check(addr, gc.Equals, "controller:17070")
+			c.Check(network, gc.Equals, test.network)
+			if ip, ok := resolved[network]; ok {
+				dialed = append(dialed, ip)
+			} else {
+				dialed = append(dialed, resolved["tcp6"], resolved["tcp4"])
+			}
+			return nil, errors.New("boom")
+		})
+		info := s.APIInfo(c)
+		info.Addrs = []string{"controller:17070"}
+		_, err := api.Open(info, api.DialOpts{
+			IPVersionPreference: test.preference,
+		})
+		c.Check(err, gc.ErrorMatches, "unable to connect to API: .*boom")
+		if test.network == "tcp" {
+			c.Check(dialed, jc.DeepEquals, []string{"[2001:db8::1]:17070", "10.0.0.1:17070"})
+		} else {
+			c.Check(dialed, jc.DeepEquals, []string{resolved[test.network]})
+		}
+	}
+}
+
+func (s *apiclientSuite) TestOpenIPVersionPreferenceNoAddresses(c *gc.C) {
+	info := s.APIInfo(c)
+	info.Addrs = []string{"[2001:db8::1]:17070"}
+	_, err := api.Open(info, api.DialOpts{
+		IPVersionPreference: api.IPVersionIPv4,
+	})
+	c.Assert(err, gc.ErrorMatches, `.*no API addresses of IP version preference "ipv4" in \["\[2001:db8::1\]:17070"\]`)
+}
+
+func (s *apiclientSuite) TestOpenInvalidIPVersionPreference(c *gc.C) {
+	_, err := api.Open(s.APIInfo(c), api.DialOpts{
+		IPVersionPreference: "ipv5",
+	})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	c.Assert(err, gc.ErrorMatches, `.*IP version preference "ipv5" not valid`)
+}
+
+func (s *apiclientSuite) TestOpenDialsAddressesInOrderWithoutPriority(c *gc.C) {
+	var dialed []string
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, _ api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		dialed = append(dialed, cfg.Location.Host)
+		return func(<-chan struct{}) (io.Closer, error) {
+			return nil, errors.New("boom")
+		}
+	})
+	info := s.APIInfo(c)
+	info.Addrs = []string{"b:17070", "a:17070", "c:17070"}
+	_, err := api.Open(info, api.DialOpts{
+		DialAddressInterval: time.Millisecond,
+	})
+	c.Assert(err, gc.ErrorMatches, "unable to connect to any API address: .*boom")
+	c.Assert(dialed, jc.DeepEquals, []string{"b:17070", "a:17070", "c:17070"})
+}
+
+func (s *apiclientSuite) TestOpenPathPrefix(c *gc.C) {
+	var dialed []string
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, _ api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		dialed = append(dialed, cfg.Location.RequestURI())
+		return func(<-chan struct{}) (io.Closer, error) {
+			return nil, errors.New("boom")
+		}
+	})
+	info := s.APIInfo(c)
+	info.Addrs = info.Addrs[:1]
+	_, err := api.Open(info, api.DialOpts{
+		PathPrefix: "/juju/controller/",
+	})
+	c.Assert(err, gc.ErrorMatches, "unable to connect to any API address: .*boom")
+	c.Assert(dialed, jc.DeepEquals, []string{
+		"/juju/controller/model/" + info.ModelTag.Id() + "/api",
+	})
+}
+
+func (s *apiclientSuite) TestOpenInvalidPathPrefix(c *gc.C) {
+	for i, test := range []struct {
+		prefix string
+		err    string
+	}{{
+		prefix: "juju",
+		err:    `path prefix "juju" \(must start with "/"\) not valid`,
+	}, {
+		prefix: "/juju?x=y",
+		err:    `path prefix "/juju\?x=y" \(must not contain a query string\) not valid`,
+	}} {
+		c.Logf("test %d: %q", i, test.prefix)
+		_, err := api.Open(s.APIInfo(c), api.DialOpts{
+			PathPrefix: test.prefix,
+		})
+		c.Check(err, gc.ErrorMatches, test.err)
+		c.Check(errors.Cause(err), jc.Satisfies, errors.IsNotValid)
+	}
+}
+
+func (s *apiclientSuite) TestOpenReturnsDialError(c *gc.C) {
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, _ api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		return func(<-chan struct{}) (io.Closer, error) {
+			if cfg.Location.Host == "bad-cert:17070" {
+				return nil, errors.Annotate(x509.UnknownAuthorityError{}, "unable to connect to API")
+			}
+			return nil, errors.NotFoundf("route to %s", cfg.Location.Host)
+		}
+	})
+	info := s.APIInfo(c)
+	info.Addrs = []string{"bad-cert:17070", "no-route:17070"}
+	_, err := api.Open(info, api.DialOpts{
+		DialAddressInterval: 50 * time.Millisecond,
+	})
+	c.Assert(err, gc.ErrorMatches, "unable to connect to any API address: "+
+		"bad-cert:17070: unable to connect to API: x509: .*; "+
+		"no-route:17070: route to no-route:17070 not found")
+	dialErr, ok := err.(*api.DialError)
+	c.Assert(ok, jc.IsTrue)
+	errs := dialErr.Errors()
+	c.Assert(errs, gc.HasLen, 2)
+	c.Assert(errs[0].Addr, gc.Equals, "bad-cert:17070")
+	c.Assert(errors.Cause(errs[0].Err), gc.FitsTypeOf, x509.UnknownAuthorityError{})
+	c.Assert(errs[1].Addr, gc.Equals, "no-route:17070")
+	c.Assert(errs[1].Err, jc.Satisfies, errors.IsNotFound)
+	// The cause is that of the last failure.
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *apiclientSuite) TestOpenDialErrorSingleAddress(c *gc.C) {
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, _ api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		return func(<-chan struct{}) (io.Closer, error) {
+			return nil, errors.Unauthorizedf("go away")
+		}
+	})
+	info := s.APIInfo(c)
+	info.Addrs = info.Addrs[:1]
+	_, err := api.Open(info, api.DialOpts{})
+	c.Assert(err, gc.ErrorMatches, "go away")
+	c.Assert(err, jc.Satisfies, errors.IsUnauthorized)
+	c.Assert(err.(*api.DialError).Errors(), gc.HasLen, 1)
+}
+
+func (s *apiclientSuite) TestOpenWithCACertFile(c *gc.C) {
+	info := s.APIInfo(c)
+	caCertFile := filepath.Join(c.MkDir(), "ca.crt")
+	err := ioutil.WriteFile(caCertFile, []byte(info.CACert), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	info.CACert = ""
+
+	conn, err := api.Open(info, api.DialOpts{CACertFile: caCertFile})
+	c.Assert(err, jc.ErrorIsNil)
+	conn.Close()
+}
+
+func (s *apiclientSuite) TestOpenWithCACertFileRereadsFile(c *gc.C) {
+	info := s.APIInfo(c)
+	caCert := info.CACert
+	info.CACert = ""
+	caCertFile := filepath.Join(c.MkDir(), "ca.crt")
+	err := ioutil.WriteFile(caCertFile, []byte(jtesting.OtherCACert), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = api.Open(info, api.DialOpts{CACertFile: caCertFile})
+	c.Assert(err, gc.ErrorMatches, `unable to connect to API: .*: x509: .*`)
+
+	// Rotate the certificate; the next Open will pick it up.
+	err = ioutil.WriteFile(caCertFile, []byte(caCert), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+	conn, err := api.Open(info, api.DialOpts{CACertFile: caCertFile})
+	c.Assert(err, jc.ErrorIsNil)
+	conn.Close()
+}
+
+func (s *apiclientSuite) TestOpenWithInvalidCACertFile(c *gc.C) {
+	info := s.APIInfo(c)
+	info.CACert = ""
+	caCertFile := filepath.Join(c.MkDir(), "ca.crt")
+	err := ioutil.WriteFile(caCertFile, []byte("not a certificate"), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	_, err = api.Open(info, api.DialOpts{CACertFile: caCertFile})
+	c.Assert(err, gc.ErrorMatches, `invalid CA certificate file ".*ca.crt": .*`)
+}
+
+func (s *apiclientSuite) TestOpenWithMissingCACertFile(c *gc.C) {
+	info := s.APIInfo(c)
+	info.CACert = ""
+	caCertFile := filepath.Join(c.MkDir(), "ca.crt")
+
+	_, err := api.Open(info, api.DialOpts{CACertFile: caCertFile})
+	c.Assert(err, gc.ErrorMatches, `cannot read CA certificate file: .*no such file or directory`)
+}
+
+func (s *apiclientSuite) TestOpenCACertTakesPrecedenceOverFile(c *gc.C) {
+	info := s.APIInfo(c)
+	caCertFile := filepath.Join(c.MkDir(), "ca.crt")
+	err := ioutil.WriteFile(caCertFile, []byte("not a certificate"), 0644)
+	c.Assert(err, jc.ErrorIsNil)
+
+	conn, err := api.Open(info, api.DialOpts{CACertFile: caCertFile})
+	c.Assert(err, jc.ErrorIsNil)
+	conn.Close()
+}
+
 func (s *apiclientSuite) TestOpenWithNoCACert(c *gc.C) {
 	// This is hard to test as we have no way of affecting the system roots,
 	// so instead we check that the error that we get implies that
@@ -210,6 +732,208 @@ func (s *apiclientSuite) TestOpenWithRedirect(c *gc.C) {
 	})
 }
 
+func (s *apiclientSuite) TestOpenWithLoginProvider(c *gc.C) {
+	info := s.APIInfo(c)
+	provider := &fakeLoginProvider{
+		LoginProvider: api.DefaultLoginProvider(info.Tag, info.Password, "", nil, nil, nil),
+	}
+	st, err := api.Open(info, api.DialOpts{LoginProvider: provider})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	provider.CheckCallNames(c, "Login")
+	c.Assert(st.AuthTag(), gc.Equals, info.Tag)
+	c.Assert(st.ControllerTag(), gc.Equals, jtesting.ControllerTag)
+	_, versionSet := st.ServerVersion()
+	c.Assert(versionSet, jc.IsTrue)
+}
+
+func (s *apiclientSuite) TestOpenWithLoginProviderError(c *gc.C) {
+	info := s.APIInfo(c)
+	provider := &fakeLoginProvider{}
+	provider.SetErrors(errors.New("no entry"))
+	_, err := api.Open(info, api.DialOpts{LoginProvider: provider})
+	c.Assert(err, gc.ErrorMatches, "no entry")
+	provider.CheckCallNames(c, "Login")
+}
+
+func (s *apiclientSuite) TestOpenDisableConnectionReuse(c *gc.C) {
+	var tlsConfigs []*tls.Config
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, opts api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		tlsConfigs = append(tlsConfigs, cfg.TlsConfig)
+		return api.NewWebsocketDialer(cfg, opts)
+	})
+	opts := api.DialOpts{DisableConnectionReuse: true}
+	st0, err := api.Open(s.APIInfo(c), opts)
+	c.Assert(err, jc.ErrorIsNil)
+	defer st0.Close()
+	st1, err := api.Open(s.APIInfo(c), opts)
+	c.Assert(err, jc.ErrorIsNil)
+	defer st1.Close()
+
+	c.Assert(tlsConfigs, gc.HasLen, 2)
+	for _, tlsConfig := range tlsConfigs {
+		c.Check(tlsConfig.ClientSessionCache, gc.IsNil)
+		c.Check(tlsConfig.SessionTicketsDisabled, jc.IsTrue)
+	}
+	c.Check(tlsConfigs[0], gc.Not(gc.Equals), tlsConfigs[1])
+
+	transport0 := api.FallbackTransport(st0)
+	transport1 := api.FallbackTransport(st1)
+	c.Check(transport0, gc.Not(gc.Equals), http.DefaultTransport)
+	c.Check(transport1, gc.Not(gc.Equals), http.DefaultTransport)
+	c.Check(transport0, gc.Not(gc.Equals), transport1)
+}
+
+func (s *apiclientSuite) TestOpenSharesDefaultTransport(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(api.FallbackTransport(st), gc.Equals, http.DefaultTransport)
+}
+
+func (s *apiclientSuite) TestOpenAcceptServerVersion(c *gc.C) {
+	var accepted []version.Number
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		AcceptServerVersion: func(v version.Number) error {
+			accepted = append(accepted, v)
+			return nil
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(accepted, jc.DeepEquals, []version.Number{jujuversion.Current})
+}
+
+func (s *apiclientSuite) TestOpenRejectServerVersion(c *gc.C) {
+	var conn io.Closer
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, opts api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		dial := api.NewWebsocketDialer(cfg, opts)
+		return func(stop <-chan struct{}) (io.Closer, error) {
+			wsConn, err := dial(stop)
+			conn = wsConn
+			return wsConn, err
+		}
+	})
+	rejectErr := errors.New("too new")
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		AcceptServerVersion: func(v version.Number) error {
+			return rejectErr
+		},
+	})
+	c.Assert(err, gc.ErrorMatches, `server version .* not accepted: too new`)
+	c.Assert(errors.Cause(err), gc.Equals, rejectErr)
+	c.Assert(st, gc.IsNil)
+
+	// The connection has been closed.
+	c.Assert(conn, gc.NotNil)
+	_, err = conn.(io.Writer).Write([]byte("{}"))
+	c.Assert(err, gc.ErrorMatches, ".*use of closed.* connection")
+}
+
+func (s *apiclientSuite) TestTLSConnectionState(c *gc.C) {
+	info := s.APIInfo(c)
+	st, err := api.Open(info, api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	state, ok := st.TLSConnectionState()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(state.HandshakeComplete, jc.IsTrue)
+	c.Assert(state.Version, gc.Not(gc.Equals), uint16(0))
+	c.Assert(state.CipherSuite, gc.Not(gc.Equals), uint16(0))
+	c.Assert(state.PeerCertificates, gc.Not(gc.HasLen), 0)
+
+	// The peer certificate is the one presented by the API server.
+	pool, err := api.CreateCertPool(info.CACert)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName: "juju-apiserver",
+		Roots:   pool,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *apiclientSuite) TestTLSConnectionStateNotTLS(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: &fakeRPCConnection{},
+		Clock:         &fakeClock{},
+	})
+	_, ok := st.TLSConnectionState()
+	c.Assert(ok, jc.IsFalse)
+}
+
+func (s *apiclientSuite) TestLocalAndRemoteAddr(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	local, ok := st.LocalAddr().(*net.TCPAddr)
+	c.Assert(ok, jc.IsTrue, gc.Commentf("local address %#v", st.LocalAddr()))
+	remote, ok := st.RemoteAddr().(*net.TCPAddr)
+	c.Assert(ok, jc.IsTrue, gc.Commentf("remote address %#v", st.RemoteAddr()))
+
+	// The test server listens on the loopback interface, at the
+	// port of the address that was connected to.
+	c.Assert(remote.IP.IsLoopback(), jc.IsTrue)
+	c.Assert(local.IP.IsLoopback(), jc.IsTrue)
+	_, port, err := net.SplitHostPort(st.Addr())
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(strconv.Itoa(remote.Port), gc.Equals, port)
+	c.Assert(local.Port, gc.Not(gc.Equals), 0)
+}
+
+func (s *apiclientSuite) TestLocalAndRemoteAddrNotNetwork(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: &fakeRPCConnection{},
+		Clock:         &fakeClock{},
+	})
+	c.Assert(st.LocalAddr(), gc.IsNil)
+	c.Assert(st.RemoteAddr(), gc.IsNil)
+}
+
+func (s *apiclientSuite) TestBytesReadAndWritten(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	// Logging in has already transferred data.
+	read, written := st.BytesRead(), st.BytesWritten()
+	c.Assert(read, jc.GreaterThan, int64(0))
+	c.Assert(written, jc.GreaterThan, int64(0))
+
+	payload := strings.Repeat("x", 64*1024)
+	var result params.FullStatus
+	err = st.APICall("Client", st.BestFacadeVersion("Client"), "", "FullStatus", params.StatusParams{
+		Patterns: []string{payload},
+	}, &result)
+	c.Logf("FullStatus error: %v", err)
+	c.Assert(st.BytesWritten()-written >= int64(len(payload)), jc.IsTrue)
+	c.Assert(st.BytesRead(), jc.GreaterThan, read)
+}
+
+func (s *apiclientSuite) TestBytesReadAndWrittenNotNetwork(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: &fakeRPCConnection{},
+		Clock:         &fakeClock{},
+	})
+	c.Assert(st.BytesRead(), gc.Equals, int64(0))
+	c.Assert(st.BytesWritten(), gc.Equals, int64(0))
+}
+
+func (s *apiclientSuite) TestOpenWithLoginProviderSkipLogin(c *gc.C) {
+	info := s.APIInfo(c)
+	info.Tag = nil
+	info.Password = ""
+	info.Macaroons = nil
+	info.SkipLogin = true
+	provider := &fakeLoginProvider{}
+	st, err := api.Open(info, api.DialOpts{LoginProvider: provider})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	provider.CheckNoCalls(c)
+}
+
 func (s *apiclientSuite) TestAPICallNoError(c *gc.C) {
 	clock := &fakeClock{}
 	conn := api.NewTestingState(api.TestingStateParams{
@@ -287,11 +1011,627 @@ func (s *apiclientSuite) TestAPICallRetriesLimit(c *gc.C) {
 	})
 }
 
-type fakeClock struct {
-	clock.Clock
+func (s *apiclientSuite) TestPingJitterSpreadsFirstPing(c *gc.C) {
+	t0 := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	delay0 := s.firstPingDelay(c, t0, true)
+	delay1 := s.firstPingDelay(c, t0.Add(time.Second), true)
+	c.Assert(delay0, gc.Not(gc.Equals), delay1)
+	for _, delay := range []time.Duration{delay0, delay1} {
+		c.Assert(delay >= 0, jc.IsTrue)
+		c.Assert(delay < api.PingPeriod, jc.IsTrue)
+	}
+	// The offset is deterministic for a given clock.
+	c.Assert(s.firstPingDelay(c, t0, true), gc.Equals, delay0)
+}
 
-	now   time.Time
-	waits []time.Duration
+func (s *apiclientSuite) TestNoPingJitter(c *gc.C) {
+	t0 := time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)
+	c.Assert(s.firstPingDelay(c, t0, false), gc.Equals, api.PingPeriod)
+}
+
+func (s *apiclientSuite) TestDefaultDialOptsPingJitter(c *gc.C) {
+	c.Assert(api.DefaultDialOpts().PingJitter, jc.IsTrue)
+}
+
+// firstPingDelay opens an API connection using a clock fixed at the
+// given time, and returns the first delay waited for by the
+// connection's health check monitor.
+func (s *apiclientSuite) firstPingDelay(c *gc.C, now time.Time, jitter bool) time.Duration {
+	clock := &afterRecordingClock{
+		now:    now,
+		afters: make(chan time.Duration, 1),
+	}
+	conn, err := api.Open(s.APIInfo(c), api.DialOpts{
+		PingJitter: jitter,
+		Clock:      clock,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer conn.Close()
+	select {
+	case d := <-clock.afters:
+		return d
+	case <-time.After(jtesting.LongWait):
+		c.Fatalf("timed out waiting for health check")
+	}
+	panic("unreachable")
+}
+
+// afterRecordingClock is a clock.Clock that reports a fixed time,
+// and records the durations passed to After. The channels returned
+// from After never fire.
+type afterRecordingClock struct {
+	clock.Clock
+
+	now    time.Time
+	afters chan time.Duration
+}
+
+func (c *afterRecordingClock) Now() time.Time {
+	return c.now
+}
+
+func (c *afterRecordingClock) After(d time.Duration) <-chan time.Time {
+	select {
+	case c.afters <- d:
+	default:
+	}
+	return nil
+}
+
+func (s *apiclientSuite) TestAPICallRateLimit(c *gc.C) {
+	clock := &fakeClock{}
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:    &fakeRPCConnection{},
+		Clock:            clock,
+		RequestRateLimit: 10,
+		RequestBurst:     2,
+	})
+	for i := 0; i < 6; i++ {
+		err := conn.APICall("facade", 1, "id", "method", nil, nil)
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	// The first two calls are allowed in a burst; the rest are
+	// paced at 10 calls per second.
+	c.Assert(clock.waits, jc.DeepEquals, []time.Duration{
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+	})
+
+	// Once the limiter has caught up, a burst is allowed again.
+	clock.now = clock.now.Add(time.Second)
+	clock.waits = nil
+	for i := 0; i < 2; i++ {
+		err := conn.APICall("facade", 1, "id", "method", nil, nil)
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	c.Assert(clock.waits, gc.HasLen, 0)
+}
+
+func (s *apiclientSuite) TestAPICallNoRateLimit(c *gc.C) {
+	clock := &fakeClock{}
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: &fakeRPCConnection{},
+		Clock:         clock,
+	})
+	for i := 0; i < 100; i++ {
+		err := conn.APICall("facade", 1, "id", "method", nil, nil)
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	c.Assert(clock.waits, gc.HasLen, 0)
+}
+
+func (s *apiclientSuite) TestAPICallMaxConcurrentResponseBytes(c *gc.C) {
+	rpcConn := newBlockingRPCConnection()
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:              rpcConn,
+		Clock:                      &fakeClock{},
+		MaxMessageBytes:            100,
+		MaxConcurrentResponseBytes: 250,
+	})
+	const calls = 6
+	done := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		go func() {
+			var response string
+			done <- conn.APICall("facade", 1, "id", "method", nil, &response)
+		}()
+	}
+	// Each call may receive a response of up to 100 bytes, and
+	// every response is that large, so only two are dispatched at
+	// a time, and another only once one of those has returned.
+	rpcConn.waitStarted(c)
+	rpcConn.waitStarted(c)
+	rpcConn.assertNotStarted(c)
+	for i := 2; i < calls; i++ {
+		rpcConn.release <- struct{}{}
+		rpcConn.waitStarted(c)
+		rpcConn.assertNotStarted(c)
+	}
+	rpcConn.release <- struct{}{}
+	rpcConn.release <- struct{}{}
+	for i := 0; i < calls; i++ {
+		select {
+		case err := <-done:
+			c.Assert(err, jc.ErrorIsNil)
+		case <-time.After(jtesting.LongWait):
+			c.Fatalf("timed out waiting for call to return")
+		}
+	}
+	c.Assert(rpcConn.maxInFlight, gc.Equals, 2)
+}
+
+func (s *apiclientSuite) TestAPICallMaxConcurrentResponseBytesIgnoresPings(c *gc.C) {
+	rpcConn := newBlockingRPCConnection()
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:              rpcConn,
+		Clock:                      &fakeClock{},
+		MaxMessageBytes:            100,
+		MaxConcurrentResponseBytes: 100,
+	})
+	done := make(chan error, 2)
+	go func() {
+		var response string
+		done <- conn.APICall("facade", 1, "id", "method", nil, &response)
+	}()
+	rpcConn.waitStarted(c)
+
+	// The budget is used up, but a ping is dispatched regardless.
+	go func() {
+		done <- conn.APICall("Pinger", 1, "", "Ping", nil, nil)
+	}()
+	rpcConn.waitStarted(c)
+	for i := 0; i < 2; i++ {
+		rpcConn.release <- struct{}{}
+		c.Assert(<-done, jc.ErrorIsNil)
+	}
+}
+
+func (s *apiclientSuite) TestAPICallMaxConcurrentResponseBytesIgnoresWatcherNext(c *gc.C) {
+	rpcConn := newBlockingRPCConnection()
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:              rpcConn,
+		Clock:                      &fakeClock{},
+		MaxMessageBytes:            100,
+		MaxConcurrentResponseBytes: 100,
+	})
+	done := make(chan error, 2)
+	go func() {
+		var response string
+		done <- conn.APICall("NotifyWatcher", 1, "0", "Next", nil, &response)
+	}()
+	rpcConn.waitStarted(c)
+
+	// The watcher is waiting for a change to report, but
+	// holds none of the budget, so another call is dispatched.
+	go func() {
+		var response string
+		done <- conn.APICall("facade", 1, "id", "method", nil, &response)
+	}()
+	rpcConn.waitStarted(c)
+	for i := 0; i < 2; i++ {
+		rpcConn.release <- struct{}{}
+		c.Assert(<-done, jc.ErrorIsNil)
+	}
+}
+
+func (s *apiclientSuite) TestAPICallMaxConcurrentResponseBytesActualSizes(c *gc.C) {
+	rpcConn := newBlockingRPCConnection()
+	rpcConn.sizes = map[string]int{"method": 10}
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:              rpcConn,
+		Clock:                      &fakeClock{},
+		MaxMessageBytes:            100,
+		MaxConcurrentResponseBytes: 250,
+	})
+	// The first call may receive a response of up to 100
+	// bytes, but receives one of 10.
+	done := make(chan error, 6)
+	go func() {
+		var response string
+		done <- conn.APICall("facade", 1, "id", "method", nil, &response)
+	}()
+	rpcConn.waitStarted(c)
+	rpcConn.release <- struct{}{}
+	c.Assert(<-done, jc.ErrorIsNil)
+
+	// Later calls count as the size of that response, so
+	// all are dispatched at once, rather than two at a time.
+	const calls = 6
+	for i := 0; i < calls; i++ {
+		go func() {
+			var response string
+			done <- conn.APICall("facade", 1, "id", "method", nil, &response)
+		}()
+	}
+	for i := 0; i < calls; i++ {
+		rpcConn.waitStarted(c)
+	}
+	for i := 0; i < calls; i++ {
+		rpcConn.release <- struct{}{}
+		c.Assert(<-done, jc.ErrorIsNil)
+	}
+	c.Assert(rpcConn.maxInFlight, gc.Equals, calls)
+}
+
+func (s *apiclientSuite) TestOpenHeaders(c *gc.C) {
+	var handshakeHeaders []http.Header
+	s.PatchValue(api.NewWebsocketDialerPtr, func(cfg *websocket.Config, opts api.DialOpts) func(<-chan struct{}) (io.Closer, error) {
+		handshakeHeaders = append(handshakeHeaders, cfg.Header)
+		return api.NewWebsocketDialer(cfg, opts)
+	})
+	headers := http.Header{"X-Gateway-Token": {"token"}}
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		Headers: headers,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(handshakeHeaders, gc.HasLen, 1)
+	c.Assert(handshakeHeaders[0].Get("X-Gateway-Token"), gc.Equals, "token")
+
+	// Changing the headers given does not change those sent.
+	headers.Set("X-Gateway-Token", "changed")
+
+	var streamHeader http.Header
+	s.PatchValue(api.WebsocketDialConfig, func(cfg *websocket.Config) (base.Stream, error) {
+		streamHeader = cfg.Header
+		return nil, errors.New("boom")
+	})
+	_, err = st.ConnectStream("/log", nil)
+	c.Assert(err, gc.ErrorMatches, "boom")
+	c.Assert(streamHeader.Get("X-Gateway-Token"), gc.Equals, "token")
+}
+
+func (s *apiclientSuite) TestOpenHeadersDoNotOverrideJujuHeaders(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		Headers: http.Header{
+			"Authorization":   {"Basic bad"},
+			"X-Gateway-Token": {"token"},
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	var streamHeader http.Header
+	s.PatchValue(api.WebsocketDialConfig, func(cfg *websocket.Config) (base.Stream, error) {
+		streamHeader = cfg.Header
+		return nil, errors.New("boom")
+	})
+	_, err = st.ConnectStream("/log", nil)
+	c.Assert(err, gc.ErrorMatches, "boom")
+	c.Assert(streamHeader["Authorization"], gc.HasLen, 1)
+	c.Assert(streamHeader.Get("Authorization"), gc.Not(gc.Equals), "Basic bad")
+	c.Assert(streamHeader.Get("X-Gateway-Token"), gc.Equals, "token")
+}
+
+func (s *apiclientSuite) TestHeaderTransport(c *gc.C) {
+	var sent *http.Request
+	transport := api.NewHeaderTransport(http.Header{
+		"Content-Type":    {"text/plain"},
+		"X-Gateway-Token": {"token"},
+	}, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req
+		return nil, errors.New("boom")
+	}))
+	req, err := http.NewRequest("POST", "https://example.com/charms", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	req.Header.Set("Content-Type", "application/zip")
+	_, err = transport.RoundTrip(req)
+	c.Assert(err, gc.ErrorMatches, "boom")
+	c.Assert(sent.Header.Get("X-Gateway-Token"), gc.Equals, "token")
+	c.Assert(sent.Header.Get("Content-Type"), gc.Equals, "application/zip")
+
+	// The request given is not modified.
+	c.Assert(req.Header.Get("X-Gateway-Token"), gc.Equals, "")
+}
+
+// roundTripperFunc is an http.RoundTripper
+// that calls the function it holds.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (s *apiclientSuite) TestOpenOpenedAt(c *gc.C) {
+	clk := testing.NewClock(time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC))
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		Clock: clk,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.OpenedAt(), gc.Equals, clk.Now())
+	c.Assert(st.LastConnectedAt(), gc.Equals, clk.Now())
+	clk.Advance(time.Hour)
+	c.Assert(st.Age(), gc.Equals, time.Hour)
+}
+
+func (s *apiclientSuite) TestOpenRequestRateLimit(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		RequestRateLimit: 1000,
+		RequestBurst:     10,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	for i := 0; i < 20; i++ {
+		c.Assert(st.Ping(), jc.ErrorIsNil)
+	}
+}
+
+func (s *apiclientSuite) TestOpenMaxMessageBytes(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		MaxMessageBytes: 10,
+	})
+	c.Assert(err, gc.ErrorMatches, ".*message larger than 10 bytes")
+	c.Assert(st, gc.IsNil)
+}
+
+func (s *apiclientSuite) TestStatsExcludeLoginAndPings(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.Stats(), jc.DeepEquals, api.CallStats{})
+
+	c.Assert(st.Ping(), jc.ErrorIsNil)
+	c.Assert(st.Stats(), jc.DeepEquals, api.CallStats{})
+
+	_, err = st.Client().AgentVersion()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.Stats(), jc.DeepEquals, api.CallStats{Calls: 1})
+}
+
+func (s *apiclientSuite) TestReadLimitExceededBreaksConnection(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	st.SetReadLimit(10)
+	err = st.Ping()
+	c.Assert(err, gc.ErrorMatches, ".*message larger than 10 bytes")
+	// The connection is broken at once, rather
+	// than at the next health check ping.
+	select {
+	case <-st.Broken():
+	case <-time.After(jtesting.LongWait):
+		c.Fatalf("connection not broken")
+	}
+	health := st.Health(false)
+	c.Assert(health.Connected, jc.IsFalse)
+	c.Assert(health.BrokenReason, gc.Equals, "message larger than 10 bytes")
+}
+
+func (s *apiclientSuite) TestOpenDefaultMaxMessageBytes(c *gc.C) {
+	c.Assert(api.DefaultDialOpts().MaxMessageBytes, gc.Equals, int64(api.DefaultMaxMessageBytes))
+	st, err := api.Open(s.APIInfo(c), api.DefaultDialOpts())
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.Ping(), jc.ErrorIsNil)
+}
+
+func (s *apiclientSuite) TestOpenLabel(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		Label: "fleet-7",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.Label(), gc.Equals, "fleet-7")
+	c.Assert(fmt.Sprint(st), gc.Matches, `API connection "fleet-7" to .+`)
+	c.Assert(c.GetTestLog(), gc.Matches, `(?s).*INFO juju.api \[fleet-7\] connection established to .*`)
+}
+
+func (s *apiclientSuite) TestOpenNoLabel(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.Label(), gc.Equals, "")
+	c.Assert(fmt.Sprint(st), gc.Matches, `API connection to .+`)
+}
+
+func (s *apiclientSuite) TestOpenCallInterceptorPassThrough(c *gc.C) {
+	var mu sync.Mutex
+	var calls []string
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		CallInterceptor: func(facade, method string, version int, args interface{}) error {
+			if facade == "Pinger" {
+				// Ignore the health checks.
+				return nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, fmt.Sprintf("%s(%d).%s", facade, version, method))
+			return nil
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	var result params.FullStatus
+	err = st.APICall("Client", st.BestFacadeVersion("Client"), "", "FullStatus", params.StatusParams{}, &result)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(result.Model.Name, gc.Not(gc.Equals), "")
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(calls, gc.Not(gc.HasLen), 0)
+	c.Assert(calls[0], gc.Matches, `Admin\(\d+\)\.Login`)
+	c.Assert(calls[len(calls)-1], gc.Matches, `Client\(\d+\)\.FullStatus`)
+}
+
+func (s *apiclientSuite) TestOpenCallInterceptorShortCircuit(c *gc.C) {
+	injected := errors.New("injected failure")
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		CallInterceptor: func(facade, method string, version int, args interface{}) error {
+			if facade == "Client" && method == "FullStatus" {
+				return injected
+			}
+			return nil
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	var result params.FullStatus
+	err = st.APICall("Client", st.BestFacadeVersion("Client"), "", "FullStatus", params.StatusParams{}, &result)
+	c.Assert(errors.Cause(err), gc.Equals, injected)
+	c.Assert(result, jc.DeepEquals, params.FullStatus{})
+
+	// Other calls are made as usual.
+	err = st.APICall("Pinger", st.BestFacadeVersion("Pinger"), "", "Ping", nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *apiclientSuite) TestOpenCallInterceptorFailsLogin(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{
+		CallInterceptor: func(facade, method string, version int, args interface{}) error {
+			return errors.New("no calls allowed")
+		},
+	})
+	c.Assert(err, gc.ErrorMatches, ".*no calls allowed")
+	c.Assert(st, gc.IsNil)
+}
+
+func (s *apiclientSuite) TestSetReadLimit(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DefaultDialOpts())
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.ReadLimit(), gc.Equals, int64(api.DefaultMaxMessageBytes))
+
+	// A ping response is larger than 10 bytes, so succeeds
+	// only once the limit is raised again.
+	st.SetReadLimit(10)
+	c.Assert(st.ReadLimit(), gc.Equals, int64(10))
+	st.SetReadLimit(1000)
+	c.Assert(st.Ping(), jc.ErrorIsNil)
+
+	st.SetReadLimit(10)
+	c.Assert(st.Ping(), gc.ErrorMatches, ".*message larger than 10 bytes")
+}
+
+func (s *apiclientSuite) TestSetReadLimitUnlimited(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.ReadLimit(), gc.Equals, int64(0))
+	st.SetReadLimit(10)
+	c.Assert(st.ReadLimit(), gc.Equals, int64(10))
+	st.SetReadLimit(-1)
+	c.Assert(st.ReadLimit(), gc.Equals, int64(0))
+	c.Assert(st.Ping(), jc.ErrorIsNil)
+}
+
+func (s *apiclientSuite) TestOpenNoFacadeVersions(c *gc.C) {
+	info := s.APIInfo(c)
+	provider := &legacyLoginProvider{
+		api.DefaultLoginProvider(info.Tag, info.Password, "", nil, nil, nil),
+	}
+	st, err := api.Open(info, api.DialOpts{LoginProvider: provider})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.AllFacadeVersions(), gc.HasLen, 0)
+	c.Assert(st.BestFacadeVersion("Client"), gc.Equals, 0)
+	c.Assert(st.Ping(), jc.ErrorIsNil)
+	// Without AllowLegacyLogin, Facade does not guess.
+	_, err = st.Facade("Client", 1)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *apiclientSuite) TestOpenAllowLegacyLogin(c *gc.C) {
+	info := s.APIInfo(c)
+	provider := &legacyLoginProvider{
+		api.DefaultLoginProvider(info.Tag, info.Password, "", nil, nil, nil),
+	}
+	st, err := api.Open(info, api.DialOpts{
+		LoginProvider:    provider,
+		AllowLegacyLogin: true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.AllFacadeVersions(), gc.HasLen, 0)
+	c.Assert(st.BestFacadeVersion("Client"), gc.Equals, 0)
+	facade, err := st.Facade("Client", 1)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(facade.BestAPIVersion(), gc.Equals, 0)
+	c.Assert(facade.Name(), gc.Equals, "Client")
+}
+
+func (s *apiclientSuite) TestOpenAllowLegacyLoginWithFacadeVersions(c *gc.C) {
+	st, err := api.Open(s.APIInfo(c), api.DialOpts{AllowLegacyLogin: true})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.AllFacadeVersions(), gc.Not(gc.HasLen), 0)
+	_, err = st.Facade("NoSuchFacade", 1)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *apiclientSuite) TestPingContext(c *gc.C) {
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: &fakeRPCConnection{},
+		Clock:         &fakeClock{},
+	})
+	err := conn.PingContext(context.Background())
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *apiclientSuite) TestPingContextCancelled(c *gc.C) {
+	rpcConn := &hangingRPCConnection{release: make(chan struct{})}
+	defer close(rpcConn.release)
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: rpcConn,
+		Clock:         &fakeClock{},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.PingContext(ctx)
+	}()
+	select {
+	case err := <-done:
+		c.Fatalf("ping returned early: %v", err)
+	case <-time.After(jtesting.ShortWait):
+	}
+	cancel()
+	select {
+	case err := <-done:
+		c.Assert(err, gc.ErrorMatches, "ping: context canceled")
+		c.Assert(errors.Cause(err), gc.Equals, context.Canceled)
+	case <-time.After(jtesting.LongWait):
+		c.Fatalf("timed out waiting for ping to be cancelled")
+	}
+}
+
+func (s *apiclientSuite) TestPingTimeout(c *gc.C) {
+	s.PatchValue(&api.PingTimeout, time.Millisecond)
+	rpcConn := &hangingRPCConnection{release: make(chan struct{})}
+	defer close(rpcConn.release)
+	conn := api.NewTestingState(api.TestingStateParams{
+		RPCConnection: rpcConn,
+		Clock:         &fakeClock{},
+	})
+	err := conn.Ping()
+	c.Assert(errors.Cause(err), gc.Equals, context.DeadlineExceeded)
+}
+
+// hangingRPCConnection is an api.RPCConnection whose
+// calls block until release is closed.
+type hangingRPCConnection struct {
+	release chan struct{}
+}
+
+func (f *hangingRPCConnection) Close() error {
+	return nil
+}
+
+func (f *hangingRPCConnection) Call(req rpc.Request, params, response interface{}) error {
+	<-f.release
+	return nil
+}
+
+type fakeClock struct {
+	clock.Clock
+
+	now   time.Time
+	waits []time.Duration
 }
 
 func (f *fakeClock) Now() time.Time {
@@ -325,6 +1665,106 @@ func (f *fakeRPCConnection) Call(req rpc.Request, params, response interface{})
 	return err
 }
 
+// blockingRPCConnection is an api.RPCConnection whose calls each
+// block until a value is sent on release, and then succeed,
+// unmarshalling into any response a JSON string of the size held in
+// sizes for the method called, or of 100 bytes if there is none.
+type blockingRPCConnection struct {
+	started chan struct{}
+	release chan struct{}
+	sizes   map[string]int
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func newBlockingRPCConnection() *blockingRPCConnection {
+	return &blockingRPCConnection{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (f *blockingRPCConnection) Close() error {
+	return nil
+}
+
+func (f *blockingRPCConnection) Call(req rpc.Request, params, response interface{}) error {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+	f.started <- struct{}{}
+	<-f.release
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+	if response == nil {
+		return nil
+	}
+	size, ok := f.sizes[req.Action]
+	if !ok {
+		size = 100
+	}
+	// As the JSON codec does, unmarshal
+	// the response from its encoded form.
+	data := `"` + strings.Repeat("x", size-2) + `"`
+	return json.Unmarshal([]byte(data), response)
+}
+
+// waitStarted waits for a call to be made.
+func (f *blockingRPCConnection) waitStarted(c *gc.C) {
+	select {
+	case <-f.started:
+	case <-time.After(jtesting.LongWait):
+		c.Fatalf("timed out waiting for call to be made")
+	}
+}
+
+// assertNotStarted asserts that no further call is made.
+func (f *blockingRPCConnection) assertNotStarted(c *gc.C) {
+	select {
+	case <-f.started:
+		c.Fatalf("call made beyond the response budget")
+	case <-time.After(jtesting.ShortWait):
+	}
+}
+
+// legacyLoginProvider is an api.LoginProvider that discards the
+// facade versions from the result of the embedded LoginProvider,
+// as if logging in to a controller that does not report them.
+type legacyLoginProvider struct {
+	api.LoginProvider
+}
+
+func (p *legacyLoginProvider) Login(ctx context.Context, caller base.APICaller) (*api.LoginResultInfo, error) {
+	result, err := p.LoginProvider.Login(ctx, caller)
+	if err != nil {
+		return nil, err
+	}
+	result.Facades = nil
+	return result, nil
+}
+
+// fakeLoginProvider is an api.LoginProvider that records calls
+// to Login, delegating to the embedded LoginProvider if the
+// stub does not return an error.
+type fakeLoginProvider struct {
+	testing.Stub
+	api.LoginProvider
+}
+
+func (p *fakeLoginProvider) Login(ctx context.Context, caller base.APICaller) (*api.LoginResultInfo, error) {
+	p.MethodCall(p, "Login", ctx, caller)
+	if err := p.NextErr(); err != nil {
+		return nil, err
+	}
+	return p.LoginProvider.Login(ctx, caller)
+}
+
 type redirectAPI struct {
 	redirected       bool
 	modelUUID        string
@@ -372,3 +1812,73 @@ func assertConnAddrForEnv(c *gc.C, conn *websocket.Conn, addr, modelUUID, tail s
 func assertConnAddrForRoot(c *gc.C, conn *websocket.Conn, addr string) {
 	c.Assert(conn.RemoteAddr(), gc.Matches, "^wss://"+addr+"/api$")
 }
+
+type socketOptionsSuite struct {
+	jtesting.BaseSuite
+}
+
+var _ = gc.Suite(&socketOptionsSuite{})
+
+func (s *socketOptionsSuite) TestSetSocketOptions(c *gc.C) {
+	conn := &fakeTCPConn{}
+	api.SetSocketOptions(conn, api.DialOpts{
+		TCPKeepAlive: 30 * time.Second,
+	})
+	conn.CheckCalls(c, []testing.StubCall{
+		{"SetNoDelay", []interface{}{true}},
+		{"SetKeepAlive", []interface{}{true}},
+		{"SetKeepAlivePeriod", []interface{}{30 * time.Second}},
+	})
+}
+
+func (s *socketOptionsSuite) TestSetSocketOptionsNoKeepAlive(c *gc.C) {
+	conn := &fakeTCPConn{}
+	api.SetSocketOptions(conn, api.DialOpts{})
+	conn.CheckCalls(c, []testing.StubCall{
+		{"SetNoDelay", []interface{}{true}},
+	})
+}
+
+func (s *socketOptionsSuite) TestSetSocketOptionsDisableTCPNoDelay(c *gc.C) {
+	conn := &fakeTCPConn{}
+	api.SetSocketOptions(conn, api.DialOpts{
+		DisableTCPNoDelay: true,
+	})
+	conn.CheckCalls(c, []testing.StubCall{
+		{"SetNoDelay", []interface{}{false}},
+	})
+}
+
+func (s *socketOptionsSuite) TestSetSocketOptionsNonTCP(c *gc.C) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	// This should not panic or otherwise fail.
+	api.SetSocketOptions(client, api.DefaultDialOpts())
+}
+
+func (s *socketOptionsSuite) TestDefaultDialOptsNoDelay(c *gc.C) {
+	c.Assert(api.DefaultDialOpts().DisableTCPNoDelay, jc.IsFalse)
+}
+
+// fakeTCPConn is a net.Conn that records the TCP socket
+// options applied to it.
+type fakeTCPConn struct {
+	net.Conn
+	testing.Stub
+}
+
+func (c *fakeTCPConn) SetKeepAlive(keepalive bool) error {
+	c.MethodCall(c, "SetKeepAlive", keepalive)
+	return c.NextErr()
+}
+
+func (c *fakeTCPConn) SetKeepAlivePeriod(d time.Duration) error {
+	c.MethodCall(c, "SetKeepAlivePeriod", d)
+	return c.NextErr()
+}
+
+func (c *fakeTCPConn) SetNoDelay(noDelay bool) error {
+	c.MethodCall(c, "SetNoDelay", noDelay)
+	return c.NextErr()
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// byteCounts holds the numbers of bytes read and written by an API
+// connection. It is safe to use concurrently.
+type byteCounts struct {
+	read    int64
+	written int64
+}
+
+// Read returns the number of bytes read.
+func (c *byteCounts) Read() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.read)
+}
+
+// Written returns the number of bytes written.
+func (c *byteCounts) Written() int64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.written)
+}
+
+// conn returns a connection that reads and writes
+// using the given one, counting the bytes transferred.
+func (c *byteCounts) conn(conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, counts: c}
+}
+
+// transport returns an http.RoundTripper that makes requests
+// using the given one, counting the bytes of the request and
+// response bodies.
+func (c *byteCounts) transport(t http.RoundTripper) http.RoundTripper {
+	return &countingTransport{RoundTripper: t, counts: c}
+}
+
+// countingConn is a net.Conn that counts the bytes
+// read from and written to the connection it wraps.
+type countingConn struct {
+	net.Conn
+	counts *byteCounts
+}
+
+// Read implements net.Conn.Read.
+func (c *countingConn) Read(buf []byte) (int, error) {
+	n, err := c.Conn.Read(buf)
+	atomic.AddInt64(&c.counts.read, int64(n))
+	return n, err
+}
+
+// Write implements net.Conn.Write.
+func (c *countingConn) Write(buf []byte) (int, error) {
+	n, err := c.Conn.Write(buf)
+	atomic.AddInt64(&c.counts.written, int64(n))
+	return n, err
+}
+
+// countingTransport is an http.RoundTripper that counts the bytes of
+// the bodies of the requests made and responses received with the
+// RoundTripper it wraps.
+type countingTransport struct {
+	http.RoundTripper
+	counts *byteCounts
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		r := *req
+		r.Body = &countingReadCloser{ReadCloser: req.Body, count: &t.counts.written}
+		req = &r
+	}
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingReadCloser{ReadCloser: resp.Body, count: &t.counts.read}
+	return resp, nil
+}
+
+// countingReadCloser is an io.ReadCloser that adds
+// the number of bytes read from the one it wraps to
+// count.
+type countingReadCloser struct {
+	io.ReadCloser
+	count *int64
+}
+
+// Read implements io.Reader.Read.
+func (r *countingReadCloser) Read(buf []byte) (int, error) {
+	n, err := r.ReadCloser.Read(buf)
+	atomic.AddInt64(r.count, int64(n))
+	return n, err
+}
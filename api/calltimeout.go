@@ -0,0 +1,102 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/clock"
+
+	"github.com/juju/juju/api/base"
+)
+
+// ErrCallTimeout is the cause of the error returned when an API call
+// made through a caller returned by WithCallTimeout, or by a connection
+// opened with DialOpts.DefaultCallTimeout, does not complete in time.
+var ErrCallTimeout = errors.New("API call timed out")
+
+// WithCallTimeout returns a base.APICaller that makes calls using the
+// given connection, failing any individual call that takes longer than
+// d with an error whose cause is ErrCallTimeout. The connection itself
+// is left open and usable when a call times out.
+func WithCallTimeout(conn Connection, d time.Duration) base.APICaller {
+	return &timeoutCaller{
+		APICaller: conn,
+		timeout:   d,
+		clock:     clock.WallClock,
+	}
+}
+
+// timeoutCaller is a base.APICaller that bounds the
+// time taken by each call made through it.
+type timeoutCaller struct {
+	base.APICaller
+	timeout time.Duration
+	clock   clock.Clock
+}
+
+// APICall implements base.APICaller.APICall.
+func (c *timeoutCaller) APICall(facade string, version int, id, method string, args, response interface{}) error {
+	call := func(result interface{}) error {
+		return c.APICaller.APICall(facade, version, id, method, args, result)
+	}
+	return callWithDeadline(call, c.clock, c.timeout, facade, version, method, response)
+}
+
+// exemptFromCallTimeout reports whether calls of the given facade
+// method are exempt from DialOpts.DefaultCallTimeout. Pings are timed
+// out by the health check itself, and the Next methods of watchers
+// block until there is a change to report, for however long that is.
+func exemptFromCallTimeout(facade, method string) bool {
+	return facade == "Pinger" || (method == "Next" && strings.HasSuffix(facade, "Watcher"))
+}
+
+// callWithDeadline makes a call using the given function, passing it
+// the value to unmarshal the result into, and returns an error with an
+// ErrCallTimeout cause if it has not completed within timeout. The
+// facade, version and method are those of the call, and are reported
+// in the error.
+//
+// The underlying RPC cannot be abandoned, so it is made with a private
+// response value that is only copied into response if the call completes
+// in time; this ensures that a late reply cannot race with the caller's
+// use of response. A response that is a nil pointer has nothing to be
+// copied into, so is passed to the call as it is. The goroutine making
+// an abandoned call exits once the call returns, which it does at the
+// latest when the connection is closed.
+func callWithDeadline(
+	call func(response interface{}) error,
+	clock clock.Clock,
+	timeout time.Duration,
+	facade string, version int, method string,
+	response interface{},
+) error {
+	result := response
+	responseValue := reflect.ValueOf(response)
+	isPtr := response != nil && responseValue.Kind() == reflect.Ptr && !responseValue.IsNil()
+	if isPtr {
+		result = reflect.New(responseValue.Type().Elem()).Interface()
+	}
+	expired := make(chan struct{})
+	timer := clock.AfterFunc(timeout, func() {
+		close(expired)
+	})
+	defer timer.Stop()
+	done := make(chan error, 1)
+	go func() {
+		done <- call(result)
+	}()
+	select {
+	case err := <-done:
+		if isPtr {
+			responseValue.Elem().Set(reflect.ValueOf(result).Elem())
+		}
+		return err
+	case <-expired:
+		return errors.Annotatef(ErrCallTimeout, "%s(%d).%s after %v", facade, version, method, timeout)
+	}
+}
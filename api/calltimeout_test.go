@@ -0,0 +1,251 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/utils/clock"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/apiserver/params"
+	"github.com/juju/juju/rpc"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type callTimeoutSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&callTimeoutSuite{})
+
+func (s *callTimeoutSuite) TestCallCompletes(c *gc.C) {
+	conn := &slowCallConnection{result: "hello"}
+	caller := api.WithCallTimeout(conn, coretesting.LongWait)
+
+	var response string
+	err := caller.APICall("Facade", 1, "", "Method", nil, &response)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(response, gc.Equals, "hello")
+}
+
+func (s *callTimeoutSuite) TestCallError(c *gc.C) {
+	conn := &slowCallConnection{err: errors.New("boom")}
+	caller := api.WithCallTimeout(conn, coretesting.LongWait)
+
+	err := caller.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "boom")
+}
+
+func (s *callTimeoutSuite) TestCallTimesOut(c *gc.C) {
+	release := make(chan struct{})
+	conn := &slowCallConnection{
+		release: release,
+		result:  "late",
+	}
+	caller := api.WithCallTimeout(conn, coretesting.ShortWait)
+
+	var response string
+	err := caller.APICall("Facade", 1, "", "Method", nil, &response)
+	c.Assert(errors.Cause(err), gc.Equals, api.ErrCallTimeout)
+	c.Assert(err, gc.ErrorMatches, `Facade\(1\).Method after .*: API call timed out`)
+
+	// Allow the timed out call to complete; its result must
+	// not be written to the abandoned response.
+	close(release)
+	var response2 string
+	err = caller.APICall("Facade", 1, "", "Method", nil, &response2)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(response2, gc.Equals, "late")
+	c.Assert(response, gc.Equals, "")
+}
+
+func (s *callTimeoutSuite) TestDefaultCallTimeout(c *gc.C) {
+	release := make(chan struct{})
+	defer close(release)
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:      &slowRPCConnection{release: release},
+		DefaultCallTimeout: coretesting.ShortWait,
+	})
+
+	var response string
+	err := st.APICall("Facade", 1, "", "Method", nil, &response)
+	c.Assert(errors.Cause(err), gc.Equals, api.ErrCallTimeout)
+	c.Assert(err, gc.ErrorMatches, `Facade\(1\).Method after 50ms: API call timed out`)
+	c.Assert(response, gc.Equals, "")
+}
+
+func (s *callTimeoutSuite) TestDefaultCallTimeoutNotReached(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:      &slowRPCConnection{},
+		DefaultCallTimeout: coretesting.LongWait,
+	})
+
+	var response string
+	err := st.APICall("Facade", 1, "", "Method", nil, &response)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(response, gc.Equals, "Facade.Method")
+}
+
+func (s *callTimeoutSuite) TestShorterCallTimeoutOverridesDefault(c *gc.C) {
+	release := make(chan struct{})
+	defer close(release)
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:      &slowRPCConnection{release: release},
+		DefaultCallTimeout: coretesting.LongWait,
+	})
+	caller := api.WithCallTimeout(st, coretesting.ShortWait)
+
+	err := caller.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(errors.Cause(err), gc.Equals, api.ErrCallTimeout)
+	c.Assert(err, gc.ErrorMatches, `Facade\(1\).Method after 50ms: API call timed out`)
+}
+
+func (s *callTimeoutSuite) TestDefaultCallTimeoutExemptions(c *gc.C) {
+	for _, call := range []struct{ facade, method string }{
+		{"Pinger", "Ping"},
+		{"NotifyWatcher", "Next"},
+		{"AllWatcher", "Next"},
+	} {
+		c.Logf("%s.%s", call.facade, call.method)
+		release := make(chan struct{})
+		st := api.NewTestingState(api.TestingStateParams{
+			RPCConnection:      &slowRPCConnection{release: release},
+			DefaultCallTimeout: coretesting.ShortWait,
+		})
+		done := make(chan error, 1)
+		go func() {
+			var response string
+			done <- st.APICall(call.facade, 1, "", call.method, nil, &response)
+		}()
+		select {
+		case err := <-done:
+			c.Fatalf("call completed early: %v", err)
+		case <-time.After(2 * coretesting.ShortWait):
+		}
+		close(release)
+		select {
+		case err := <-done:
+			c.Check(err, jc.ErrorIsNil)
+		case <-time.After(coretesting.LongWait):
+			c.Fatalf("call never completed")
+		}
+	}
+}
+
+func (s *callTimeoutSuite) TestDefaultCallTimeoutNilResponse(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:      &slowRPCConnection{},
+		DefaultCallTimeout: coretesting.LongWait,
+	})
+	err := st.APICall("Facade", 1, "", "Method", nil, (*string)(nil))
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *callTimeoutSuite) TestDefaultCallTimeoutLateWatcherRecorded(c *gc.C) {
+	release := make(chan struct{})
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:      &slowRPCConnection{release: release},
+		DefaultCallTimeout: coretesting.ShortWait,
+	})
+	var result params.NotifyWatchResult
+	err := st.APICall("Uniter", 4, "", "WatchConfigSettings", nil, &result)
+	c.Assert(errors.Cause(err), gc.Equals, api.ErrCallTimeout)
+	c.Assert(result.NotifyWatcherId, gc.Equals, "")
+	c.Assert(st.ActiveWatchers(), gc.HasLen, 0)
+
+	// The watcher started by the call once it completes is
+	// recorded, although the caller never learns of it.
+	close(release)
+	for a := coretesting.LongAttempt.Start(); a.Next(); {
+		if len(st.ActiveWatchers()) > 0 {
+			break
+		}
+	}
+	watchers := st.ActiveWatchers()
+	c.Assert(watchers, gc.HasLen, 1)
+	c.Assert(watchers[0].Facade, gc.Equals, "Uniter")
+	c.Assert(watchers[0].Method, gc.Equals, "WatchConfigSettings")
+	c.Assert(watchers[0].Id, gc.Equals, "1")
+}
+
+func (s *callTimeoutSuite) TestDefaultCallTimeoutTimerStopped(c *gc.C) {
+	clk := &timerRecordingClock{Clock: clock.WallClock}
+	st := api.NewTestingState(api.TestingStateParams{
+		RPCConnection:      &slowRPCConnection{},
+		Clock:              clk,
+		DefaultCallTimeout: coretesting.LongWait,
+	})
+	var response string
+	err := st.APICall("Facade", 1, "", "Method", nil, &response)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(clk.timers, gc.HasLen, 1)
+	c.Assert(clk.timers[0].Stop(), jc.IsFalse)
+}
+
+// timerRecordingClock is a clock.Clock that records
+// the timers created by AfterFunc.
+type timerRecordingClock struct {
+	clock.Clock
+	timers []clock.Timer
+}
+
+func (c *timerRecordingClock) AfterFunc(d time.Duration, f func()) clock.Timer {
+	t := c.Clock.AfterFunc(d, f)
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// slowRPCConnection is an RPC connection whose Call method blocks
+// until release is closed (if non-nil), and then stores the facade
+// and method called in any string response, or a watcher id in any
+// NotifyWatchResult.
+type slowRPCConnection struct {
+	release chan struct{}
+}
+
+func (c *slowRPCConnection) Close() error {
+	return nil
+}
+
+func (c *slowRPCConnection) Call(req rpc.Request, args, response interface{}) error {
+	if c.release != nil {
+		<-c.release
+	}
+	switch r := response.(type) {
+	case *string:
+		if r != nil {
+			*r = req.Type + "." + req.Action
+		}
+	case *params.NotifyWatchResult:
+		r.NotifyWatcherId = "1"
+	}
+	return nil
+}
+
+// slowCallConnection is an api.Connection whose APICall method
+// blocks until release is closed (if non-nil), and then stores
+// result in the response.
+type slowCallConnection struct {
+	api.Connection
+	release chan struct{}
+	result  string
+	err     error
+}
+
+func (c *slowCallConnection) APICall(facade string, version int, id, method string, args, response interface{}) error {
+	if c.release != nil {
+		<-c.release
+	}
+	if c.err != nil {
+		return c.err
+	}
+	if r, ok := response.(*string); ok {
+		*r = c.result
+	}
+	return nil
+}
@@ -19,8 +19,13 @@ type API struct {
 
 // NewAPI creates a new client-side Cleaner facade.
 func NewAPI(caller base.APICaller) *API {
-	facadeCaller := base.NewFacadeCaller(caller, cleanerFacade)
-	return &API{facade: facadeCaller}
+	return NewAPIFromFacade(base.NewFacadeCaller(caller, cleanerFacade))
+}
+
+// NewAPIFromFacade creates a new client-side Cleaner facade
+// using the given caller for the Cleaner facade.
+func NewAPIFromFacade(facade base.FacadeCaller) *API {
+	return &API{facade: facade}
 }
 
 // Cleanup calls the server-side Cleanup method.
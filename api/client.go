@@ -36,7 +36,7 @@ import (
 type Client struct {
 	base.ClientFacade
 	facade base.FacadeCaller
-	st     *state
+	st     Connection
 }
 
 // Status returns the status of the juju model.
@@ -0,0 +1,137 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/clock"
+)
+
+// clockSkewProbeTimeout is the longest time spent asking the API
+// server for its time when checking for clock skew.
+const clockSkewProbeTimeout = 10 * time.Second
+
+// checkClockSkew reports the difference between the local time now
+// and the server time serverNow, positive if the local clock is
+// ahead, and whether it is within the given tolerance.
+func checkClockSkew(serverNow, now time.Time, tolerance time.Duration) (time.Duration, bool) {
+	skew := now.Sub(serverNow)
+	if skew < 0 {
+		return skew, -skew <= tolerance
+	}
+	return skew, skew <= tolerance
+}
+
+// ControllerTime implements Connection.ControllerTime.
+func (st *state) ControllerTime() (time.Time, error) {
+	client := *st.bakeryClient.Client
+	client.Jar = nil
+	client.Timeout = clockSkewProbeTimeout
+	resp, err := client.Get((&url.URL{
+		Scheme: st.serverScheme,
+		Host:   st.addr,
+		Path:   "/",
+	}).String())
+	if err != nil {
+		return time.Time{}, errors.Annotate(err, "cannot get controller time")
+	}
+	resp.Body.Close()
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, errors.NotSupportedf("reporting the controller time")
+	}
+	serverNow, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, errors.Annotate(err, "invalid Date header")
+	}
+	return serverNow, nil
+}
+
+// warnClockSkew compares the time reported by the API server, as
+// returned by ControllerTime, against the local time, and logs a
+// warning if they differ by more than the given tolerance. Failures
+// are logged but otherwise ignored, as the check is only advisory.
+func (st *state) warnClockSkew(tolerance time.Duration) {
+	serverNow, err := st.ControllerTime()
+	if err != nil {
+		st.logger.Debugf("%scannot check API server clock: %v", logPrefix(st.label), err)
+		return
+	}
+	if skew, ok := checkClockSkew(serverNow, st.clock.Now(), tolerance); !ok {
+		st.logger.Warningf(
+			"%slocal clock differs from API server clock by %v, more than the tolerance of %v; authentication may fail",
+			logPrefix(st.label), skew, tolerance,
+		)
+	}
+}
+
+// skewTolerantJar is an http.CookieJar that keeps cookies for a
+// tolerance beyond their expiry time, so that macaroon cookies,
+// which expire with the time-before caveats set by the server's
+// clock, are not discarded early when the local clock is ahead.
+//
+// Expiry is tracked by the jar itself, using its clock, rather than
+// by the underlying jar, which uses the wall clock. It is tracked by
+// cookie name alone, which suffices for macaroon cookies, as their
+// names are derived from the macaroons they hold.
+type skewTolerantJar struct {
+	http.CookieJar
+	clock     clock.Clock
+	tolerance time.Duration
+
+	mu       sync.Mutex
+	expiries map[string]time.Time
+}
+
+// newSkewTolerantJar returns a jar that stores cookies in the given
+// jar, keeping them for the given tolerance beyond their expiry time
+// as measured by the given clock.
+func newSkewTolerantJar(jar http.CookieJar, clock clock.Clock, tolerance time.Duration) *skewTolerantJar {
+	return &skewTolerantJar{
+		CookieJar: jar,
+		clock:     clock,
+		tolerance: tolerance,
+		expiries:  make(map[string]time.Time),
+	}
+}
+
+// SetCookies implements http.CookieJar.
+func (j *skewTolerantJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	stored := make([]*http.Cookie, len(cookies))
+	for i, cookie := range cookies {
+		c := *cookie
+		if c.MaxAge == 0 && !c.Expires.IsZero() {
+			j.expiries[c.Name] = c.Expires
+			if c.Expires.After(j.clock.Now().Add(-j.tolerance)) {
+				// Leave expiry to Cookies.
+				c.Expires = time.Time{}
+			}
+		}
+		stored[i] = &c
+	}
+	j.CookieJar.SetCookies(u, stored)
+}
+
+// Cookies implements http.CookieJar. Cookies that expired more than
+// the tolerance ago are omitted.
+func (j *skewTolerantJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	deadline := j.clock.Now().Add(-j.tolerance)
+	var result []*http.Cookie
+	for _, c := range j.CookieJar.Cookies(u) {
+		if expiry, ok := j.expiries[c.Name]; ok && expiry.Before(deadline) {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result
+}
@@ -0,0 +1,213 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/macaroon-bakery.v1/httpbakery"
+
+	"github.com/juju/juju/api"
+	jujutesting "github.com/juju/juju/juju/testing"
+)
+
+type clockSkewSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&clockSkewSuite{})
+
+func (s *clockSkewSuite) TestCheckClockSkew(c *gc.C) {
+	server := time.Date(2016, 9, 1, 10, 0, 0, 0, time.UTC)
+	for i, test := range []struct {
+		now  time.Time
+		skew time.Duration
+		ok   bool
+	}{{
+		now:  server,
+		skew: 0,
+		ok:   true,
+	}, {
+		now:  server.Add(30 * time.Second),
+		skew: 30 * time.Second,
+		ok:   true,
+	}, {
+		now:  server.Add(30*time.Second + time.Nanosecond),
+		skew: 30*time.Second + time.Nanosecond,
+		ok:   false,
+	}, {
+		now:  server.Add(-30 * time.Second),
+		skew: -30 * time.Second,
+		ok:   true,
+	}, {
+		now:  server.Add(-30*time.Second - time.Nanosecond),
+		skew: -30*time.Second - time.Nanosecond,
+		ok:   false,
+	}} {
+		c.Logf("test %d: %v", i, test.now)
+		skew, ok := api.CheckClockSkew(server, test.now, 30*time.Second)
+		c.Check(skew, gc.Equals, test.skew)
+		c.Check(ok, gc.Equals, test.ok)
+	}
+}
+
+func (s *clockSkewSuite) newJar(c *gc.C, clock *testing.Clock, tolerance time.Duration) http.CookieJar {
+	inner, err := cookiejar.New(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	return api.NewSkewTolerantJar(inner, clock, tolerance)
+}
+
+func cookieNames(cookies []*http.Cookie) []string {
+	var names []string
+	for _, c := range cookies {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func (s *clockSkewSuite) TestSkewTolerantJarBoundary(c *gc.C) {
+	// The local clock is ahead of the server's, so by local time
+	// the macaroon cookies expire a minute early.
+	now := time.Now()
+	clock := testing.NewClock(now)
+	jar := s.newJar(c, clock, 10*time.Second)
+	u := &url.URL{Scheme: "https", Host: "controller.example.com", Path: "/"}
+	jar.SetCookies(u, []*http.Cookie{{
+		Name:    "macaroon-a",
+		Value:   "a",
+		Path:    "/",
+		Expires: now.Add(time.Minute),
+	}, {
+		Name:  "session",
+		Value: "s",
+		Path:  "/",
+	}})
+	c.Assert(cookieNames(jar.Cookies(u)), jc.SameContents, []string{"macaroon-a", "session"})
+
+	// At the expiry time plus the tolerance, the cookie is kept.
+	clock.Advance(time.Minute + 10*time.Second)
+	c.Assert(cookieNames(jar.Cookies(u)), jc.SameContents, []string{"macaroon-a", "session"})
+
+	// Beyond it, the cookie is dropped.
+	clock.Advance(time.Nanosecond)
+	c.Assert(cookieNames(jar.Cookies(u)), jc.DeepEquals, []string{"session"})
+}
+
+func (s *clockSkewSuite) TestSkewTolerantJarAlreadyExpired(c *gc.C) {
+	now := time.Now()
+	clock := testing.NewClock(now)
+	jar := s.newJar(c, clock, 10*time.Second)
+	u := &url.URL{Scheme: "https", Host: "controller.example.com", Path: "/"}
+	jar.SetCookies(u, []*http.Cookie{{
+		Name:    "macaroon-a",
+		Value:   "a",
+		Path:    "/",
+		Expires: now.Add(-11 * time.Second),
+	}})
+	c.Assert(jar.Cookies(u), gc.HasLen, 0)
+}
+
+// newDateServerState returns a connection to an HTTP server that
+// reports the given Date header, or none if date is empty, and a
+// function that closes the server.
+func newDateServerState(c *gc.C, date string, clock *testing.Clock) (api.Connection, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if date == "" {
+			// Suppress the Date header the server would add.
+			w.Header()["Date"] = nil
+		} else {
+			w.Header().Set("Date", date)
+		}
+	}))
+	u, err := url.Parse(srv.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	conn := api.NewTestingState(api.TestingStateParams{
+		Address:      u.Host,
+		ServerScheme: "http",
+		Clock:        clock,
+		BakeryClient: httpbakery.NewClient(),
+	})
+	return conn, srv.Close
+}
+
+func (s *clockSkewSuite) TestControllerTime(c *gc.C) {
+	serverNow := time.Date(2016, 9, 1, 10, 0, 0, 0, time.UTC)
+	clock := testing.NewClock(serverNow.Add(90 * time.Second))
+	conn, closeServer := newDateServerState(c, serverNow.Format(http.TimeFormat), clock)
+	defer closeServer()
+
+	t, err := conn.ControllerTime()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(t.Equal(serverNow), jc.IsTrue, gc.Commentf("got %v", t))
+
+	skew, ok := api.CheckClockSkew(t, clock.Now(), time.Minute)
+	c.Assert(skew, gc.Equals, 90*time.Second)
+	c.Assert(ok, jc.IsFalse)
+}
+
+func (s *clockSkewSuite) TestControllerTimeNotSupported(c *gc.C) {
+	conn, closeServer := newDateServerState(c, "", testing.NewClock(time.Now()))
+	defer closeServer()
+
+	_, err := conn.ControllerTime()
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, "reporting the controller time not supported")
+}
+
+func (s *clockSkewSuite) TestControllerTimeInvalidDate(c *gc.C) {
+	conn, closeServer := newDateServerState(c, "yesterday", testing.NewClock(time.Now()))
+	defer closeServer()
+
+	_, err := conn.ControllerTime()
+	c.Assert(err, gc.ErrorMatches, `invalid Date header: .*`)
+}
+
+type clockSkewOpenSuite struct {
+	jujutesting.JujuConnSuite
+}
+
+var _ = gc.Suite(&clockSkewOpenSuite{})
+
+func (s *clockSkewOpenSuite) TestOpenWarnsOfClockSkew(c *gc.C) {
+	conn, err := api.Open(s.APIInfo(c), api.DialOpts{
+		Clock:              testing.NewClock(time.Now().Add(time.Hour)),
+		ClockSkewTolerance: time.Minute,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer conn.Close()
+	c.Assert(c.GetTestLog(), gc.Matches, `(?s).*WARNING juju.api local clock differs from API server clock by 1h.*, more than the tolerance of 1m0s.*`)
+}
+
+func (s *clockSkewOpenSuite) TestOpenNoClockSkewWarningWithinTolerance(c *gc.C) {
+	conn, err := api.Open(s.APIInfo(c), api.DialOpts{
+		Clock:              testing.NewClock(time.Now().Add(30 * time.Second)),
+		ClockSkewTolerance: time.Minute,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer conn.Close()
+	c.Assert(c.GetTestLog(), gc.Not(jc.Contains), "local clock differs")
+}
+
+func (s *clockSkewOpenSuite) TestControllerTime(c *gc.C) {
+	now := time.Now()
+	conn, err := api.Open(s.APIInfo(c), api.DialOpts{
+		Clock: testing.NewClock(now.Add(time.Hour)),
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer conn.Close()
+
+	t, err := conn.ControllerTime()
+	c.Assert(err, jc.ErrorIsNil)
+	// The Date header has a resolution of a second.
+	c.Assert(t.After(now.Add(-2*time.Second)), jc.IsTrue, gc.Commentf("got %v, now %v", t, now))
+	c.Assert(t.Before(time.Now().Add(time.Second)), jc.IsTrue, gc.Commentf("got %v", t))
+}
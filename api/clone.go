@@ -0,0 +1,318 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+	"golang.org/x/net/context"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/juju/juju/api/base"
+	"github.com/juju/juju/api/charmrevisionupdater"
+	"github.com/juju/juju/api/cleaner"
+	"github.com/juju/juju/api/discoverspaces"
+	"github.com/juju/juju/api/imagemetadata"
+	"github.com/juju/juju/api/instancepoller"
+	"github.com/juju/juju/api/reboot"
+	"github.com/juju/juju/api/unitassigner"
+	"github.com/juju/juju/api/uniter"
+	"github.com/juju/juju/api/upgrader"
+	"github.com/juju/juju/network"
+	"github.com/juju/juju/rpc"
+)
+
+// CallStats holds the numbers of API calls made
+// through a Connection, as returned by Connection.Stats.
+type CallStats struct {
+	// Calls holds the number of calls made.
+	Calls int64
+
+	// Failures holds the number of those
+	// calls that returned an error.
+	Failures int64
+}
+
+// callStats counts API calls. It is safe to use concurrently.
+type callStats struct {
+	calls    int64
+	failures int64
+}
+
+// record counts a call that returned the given error.
+func (s *callStats) record(err error) {
+	atomic.AddInt64(&s.calls, 1)
+	if err != nil {
+		atomic.AddInt64(&s.failures, 1)
+	}
+}
+
+// get returns the numbers of calls counted.
+func (s *callStats) get() CallStats {
+	return CallStats{
+		Calls:    atomic.LoadInt64(&s.calls),
+		Failures: atomic.LoadInt64(&s.failures),
+	}
+}
+
+// Stats implements Connection.Stats.
+func (s *state) Stats() CallStats {
+	return s.stats.get()
+}
+
+// internalCaller makes calls through the state it holds, as
+// APICall does, but without counting them in Stats. It is used
+// for the calls the state makes itself, to log in.
+type internalCaller struct {
+	*state
+}
+
+// APICall implements base.APICaller.APICall.
+func (c internalCaller) APICall(facade string, version int, id, method string, args, response interface{}) error {
+	return c.state.call(facade, version, id, method, args, response)
+}
+
+// Clone implements Connection.Clone.
+func (s *state) Clone(label string) Connection {
+	c := &clone{
+		state:  s,
+		label:  label,
+		closed: make(chan struct{}),
+		broken: make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-s.broken:
+		case <-c.closed:
+		}
+		close(c.broken)
+	}()
+	return c
+}
+
+// clone is a Connection that shares the network connection, login and
+// facade versions of the state it holds, but has its own label, call
+// counts and liveness, as returned by state.Clone. Its facades make
+// their calls through it, so that they are counted by it.
+type clone struct {
+	*state
+	label string
+	stats callStats
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	broken    chan struct{}
+}
+
+// APICall implements base.APICaller.APICall. Once the clone
+// is closed, calls fail as they do on a closed connection.
+func (c *clone) APICall(facade string, version int, id, method string, args, response interface{}) error {
+	err := c.call(facade, version, id, method, args, response)
+	c.stats.record(err)
+	return err
+}
+
+// call makes the call given to APICall without counting it in Stats.
+func (c *clone) call(facade string, version int, id, method string, args, response interface{}) error {
+	if isClosed(c.closed) {
+		return rpc.ErrShutdown
+	}
+	return c.state.call(facade, version, id, method, args, response)
+}
+
+// Close implements Connection.Close. It leaves
+// the state's connection to the API server open.
+func (c *clone) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	<-c.broken
+	return nil
+}
+
+// Broken implements Connection.Broken. The channel returned is
+// closed when the clone is closed, or the state's connection broken.
+func (c *clone) Broken() <-chan struct{} {
+	return c.broken
+}
+
+// Health implements Connection.Health.
+func (c *clone) Health(ping bool) HealthResult {
+	var pingErr error
+	if ping {
+		pingErr = c.Ping()
+	}
+	result := c.state.Health(false)
+	result.PingError = pingErr
+	if isClosed(c.closed) {
+		result.Connected = false
+		result.BrokenReason = "connection is closed"
+	}
+	return result
+}
+
+// Ping implements Connection.Ping.
+func (c *clone) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), PingTimeout)
+	defer cancel()
+	return c.PingContext(ctx)
+}
+
+// PingContext implements Connection.PingContext.
+func (c *clone) PingContext(ctx context.Context) error {
+	return c.state.pingWith(ctx, c.call)
+}
+
+// RefreshAPIHostPorts implements Connection.RefreshAPIHostPorts.
+func (c *clone) RefreshAPIHostPorts() ([][]network.HostPort, error) {
+	return c.state.refreshAPIHostPorts(c.Client())
+}
+
+// Login implements Connection.Login. Once the clone is
+// closed, it fails as calls do.
+func (c *clone) Login(tag names.Tag, password, nonce string, macaroons []macaroon.Slice) error {
+	if isClosed(c.closed) {
+		return rpc.ErrShutdown
+	}
+	return c.state.Login(tag, password, nonce, macaroons)
+}
+
+// EnsureLogin implements Connection.EnsureLogin.
+func (c *clone) EnsureLogin(tag names.Tag, password, nonce string, macaroons []macaroon.Slice) error {
+	if isClosed(c.closed) {
+		return rpc.ErrShutdown
+	}
+	return c.state.EnsureLogin(tag, password, nonce, macaroons)
+}
+
+// ChangeUser implements Connection.ChangeUser.
+func (c *clone) ChangeUser(tag names.Tag, password string, ms []macaroon.Slice) error {
+	if isClosed(c.closed) {
+		return rpc.ErrShutdown
+	}
+	return c.state.ChangeUser(tag, password, ms)
+}
+
+// ForModel implements Connection.ForModel.
+func (c *clone) ForModel(modelTag names.ModelTag) (Connection, error) {
+	if isClosed(c.closed) {
+		return nil, rpc.ErrShutdown
+	}
+	return c.state.ForModel(modelTag)
+}
+
+// WatchFacadeVersions implements Connection.WatchFacadeVersions.
+// The channel returned is closed when the clone is broken.
+func (c *clone) WatchFacadeVersions() (<-chan map[string][]int, func(), error) {
+	if isClosed(c.closed) {
+		return nil, nil, errors.New("connection is closed")
+	}
+	out, stop := c.state.watchFacadeVersions(c.broken)
+	return out, stop, nil
+}
+
+// ActiveWatchers implements Connection.ActiveWatchers. The watchers
+// are those of the state, shared by its clones, until the clone is
+// closed, when there are none.
+func (c *clone) ActiveWatchers() []WatcherInfo {
+	if isClosed(c.closed) {
+		return nil
+	}
+	return c.state.ActiveWatchers()
+}
+
+// Stats implements Connection.Stats.
+func (c *clone) Stats() CallStats {
+	return c.stats.get()
+}
+
+// Clone implements Connection.Clone. The new clone is a
+// clone of the state, so is unaffected by closing this one.
+func (c *clone) Clone(label string) Connection {
+	return c.state.Clone(label)
+}
+
+// Label implements Connection.Label.
+func (c *clone) Label() string {
+	return c.label
+}
+
+// String returns a description of the clone
+// that includes its label, if it has one.
+func (c *clone) String() string {
+	if c.label == "" {
+		return fmt.Sprintf("API connection to %s", c.addr)
+	}
+	return fmt.Sprintf("API connection %q to %s", c.label, c.addr)
+}
+
+// Pipeline implements Connection.Pipeline.
+func (c *clone) Pipeline() *Pipeline {
+	return NewPipeline(c)
+}
+
+// Facade implements Connection.Facade.
+func (c *clone) Facade(name string, version int) (base.FacadeCaller, error) {
+	return c.state.facadeFor(c, name, version)
+}
+
+// Client implements Connection.Client.
+func (c *clone) Client() *Client {
+	frontend, backend := base.NewClientFacade(c, "Client")
+	return &Client{ClientFacade: frontend, facade: backend, st: c}
+}
+
+// UnitAssigner implements Connection.UnitAssigner.
+func (c *clone) UnitAssigner() unitassigner.API {
+	return unitassigner.New(c)
+}
+
+// Uniter implements Connection.Uniter.
+func (c *clone) Uniter() (*uniter.State, error) {
+	return newUniter(c)
+}
+
+// Upgrader implements Connection.Upgrader.
+func (c *clone) Upgrader() *upgrader.State {
+	return upgrader.NewState(c)
+}
+
+// Reboot implements Connection.Reboot.
+func (c *clone) Reboot() (reboot.State, error) {
+	return newReboot(c)
+}
+
+// DiscoverSpaces implements Connection.DiscoverSpaces.
+func (c *clone) DiscoverSpaces() *discoverspaces.API {
+	return discoverspaces.NewAPI(c)
+}
+
+// InstancePoller implements Connection.InstancePoller.
+func (c *clone) InstancePoller() *instancepoller.API {
+	return instancepoller.NewAPI(c)
+}
+
+// CharmRevisionUpdater implements Connection.CharmRevisionUpdater.
+func (c *clone) CharmRevisionUpdater() *charmrevisionupdater.State {
+	return charmrevisionupdater.NewState(c)
+}
+
+// Cleaner implements Connection.Cleaner.
+func (c *clone) Cleaner() *cleaner.API {
+	return newCleaner(c)
+}
+
+// CleanerFacade implements Connection.CleanerFacade.
+func (c *clone) CleanerFacade() (*cleaner.API, error) {
+	return newCleanerFacade(c)
+}
+
+// MetadataUpdater implements Connection.MetadataUpdater.
+func (c *clone) MetadataUpdater() *imagemetadata.Client {
+	return imagemetadata.NewClient(c)
+}
@@ -0,0 +1,208 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/network"
+	"github.com/juju/juju/rpc"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type cloneSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&cloneSuite{})
+
+func (s *cloneSuite) newState(broken chan struct{}) api.Connection {
+	return api.NewTestingState(api.TestingStateParams{
+		Address:        "localhost:17070",
+		RPCConnection:  &cloneRPCConnection{},
+		FacadeVersions: map[string][]int{"Facade": {1}},
+		Label:          "root",
+		Broken:         broken,
+	})
+}
+
+func (s *cloneSuite) TestCloneLabel(c *gc.C) {
+	st := s.newState(nil)
+	clone := st.Clone("worker")
+	defer clone.Close()
+	c.Assert(clone.Label(), gc.Equals, "worker")
+	c.Assert(st.Label(), gc.Equals, "root")
+	c.Assert(clone.Addr(), gc.Equals, st.Addr())
+	c.Assert(clone, gc.Not(gc.Equals), st)
+}
+
+func (s *cloneSuite) TestCloneStatsIndependent(c *gc.C) {
+	st := s.newState(nil)
+	clone0 := st.Clone("clone-0")
+	defer clone0.Close()
+	clone1 := st.Clone("clone-1")
+	defer clone1.Close()
+
+	var response string
+	err := clone0.APICall("Facade", 1, "", "Method", nil, &response)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(response, gc.Equals, "Facade.Method")
+	err = clone0.APICall("Facade", 1, "", "Fail", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "boom")
+
+	// Calls made by facades obtained from a clone are counted by it.
+	facade, err := clone1.Facade("Facade", 1)
+	c.Assert(err, jc.ErrorIsNil)
+	err = facade.FacadeCall("Method", nil, &response)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = st.APICall("Facade", 1, "", "Method", nil, &response)
+	c.Assert(err, jc.ErrorIsNil)
+
+	c.Assert(clone0.Stats(), jc.DeepEquals, api.CallStats{Calls: 2, Failures: 1})
+	c.Assert(clone1.Stats(), jc.DeepEquals, api.CallStats{Calls: 1})
+	c.Assert(st.Stats(), jc.DeepEquals, api.CallStats{Calls: 1})
+}
+
+func (s *cloneSuite) TestPingsNotCounted(c *gc.C) {
+	st := s.newState(nil)
+	clone := st.Clone("worker")
+	defer clone.Close()
+
+	c.Assert(st.Ping(), jc.ErrorIsNil)
+	c.Assert(clone.Ping(), jc.ErrorIsNil)
+	c.Assert(st.Stats(), jc.DeepEquals, api.CallStats{})
+	c.Assert(clone.Stats(), jc.DeepEquals, api.CallStats{})
+}
+
+func (s *cloneSuite) TestCloseCloneLeavesStateOpen(c *gc.C) {
+	st := s.newState(make(chan struct{}))
+	clone := st.Clone("worker")
+	other := st.Clone("other")
+	defer other.Close()
+
+	err := clone.Close()
+	c.Assert(err, jc.ErrorIsNil)
+	select {
+	case <-clone.Broken():
+	default:
+		c.Fatalf("closed clone not broken")
+	}
+	err = clone.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(errors.Cause(err), gc.Equals, rpc.ErrShutdown)
+	c.Assert(clone.Health(false).Connected, jc.IsFalse)
+	c.Assert(clone.Stats(), jc.DeepEquals, api.CallStats{Calls: 1, Failures: 1})
+
+	// The state and its other clones are unaffected.
+	for _, conn := range []api.Connection{st, other} {
+		select {
+		case <-conn.Broken():
+			c.Fatalf("%s broken", conn.Label())
+		default:
+		}
+		err = conn.APICall("Facade", 1, "", "Method", nil, nil)
+		c.Check(err, jc.ErrorIsNil)
+	}
+
+	// Closing a clone again does nothing.
+	err = clone.Close()
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *cloneSuite) TestCloneRefreshAPIHostPorts(c *gc.C) {
+	st := s.newState(make(chan struct{}))
+	clone := st.Clone("worker")
+	hostPorts, err := clone.RefreshAPIHostPorts()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(hostPorts, jc.DeepEquals, [][]network.HostPort{
+		network.NewHostPorts(17070, "localhost"),
+	})
+	c.Assert(st.APIHostPorts(), jc.DeepEquals, hostPorts)
+	// The call is made through the clone.
+	c.Assert(clone.Stats(), jc.DeepEquals, api.CallStats{Calls: 1})
+	c.Assert(st.Stats(), jc.DeepEquals, api.CallStats{})
+
+	err = clone.Close()
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = clone.RefreshAPIHostPorts()
+	c.Assert(errors.Cause(err), gc.Equals, rpc.ErrShutdown)
+}
+
+func (s *cloneSuite) TestClosedCloneFails(c *gc.C) {
+	st := s.newState(make(chan struct{}))
+	clone := st.Clone("worker")
+	err := clone.Close()
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = clone.Login(nil, "", "", nil)
+	c.Check(errors.Cause(err), gc.Equals, rpc.ErrShutdown)
+	err = clone.EnsureLogin(nil, "", "", nil)
+	c.Check(errors.Cause(err), gc.Equals, rpc.ErrShutdown)
+	err = clone.ChangeUser(nil, "", nil)
+	c.Check(errors.Cause(err), gc.Equals, rpc.ErrShutdown)
+	_, err = clone.ForModel(coretesting.ModelTag)
+	c.Check(errors.Cause(err), gc.Equals, rpc.ErrShutdown)
+	_, _, err = clone.WatchFacadeVersions()
+	c.Check(err, gc.ErrorMatches, "connection is closed")
+	c.Check(clone.ActiveWatchers(), gc.HasLen, 0)
+
+	// The state is unaffected.
+	_, stop, err := st.WatchFacadeVersions()
+	c.Assert(err, jc.ErrorIsNil)
+	stop()
+}
+
+func (s *cloneSuite) TestCloneBrokenWithState(c *gc.C) {
+	broken := make(chan struct{})
+	st := s.newState(broken)
+	clone := st.Clone("worker")
+	c.Assert(clone.Health(false).Connected, jc.IsTrue)
+
+	close(broken)
+	select {
+	case <-clone.Broken():
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("clone not broken with state")
+	}
+	err := clone.Close()
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *cloneSuite) TestCloneOfClone(c *gc.C) {
+	st := s.newState(make(chan struct{}))
+	clone := st.Clone("worker")
+	nested := clone.Clone("nested")
+	defer nested.Close()
+	c.Assert(nested.Label(), gc.Equals, "nested")
+
+	err := clone.Close()
+	c.Assert(err, jc.ErrorIsNil)
+	err = nested.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(nested.Stats(), jc.DeepEquals, api.CallStats{Calls: 1})
+}
+
+// cloneRPCConnection is an RPC connection that responds to calls of
+// Fail with an error, and to other calls with the facade and method
+// called, if the response is a string.
+type cloneRPCConnection struct{}
+
+func (*cloneRPCConnection) Close() error {
+	return nil
+}
+
+func (*cloneRPCConnection) Call(req rpc.Request, args, response interface{}) error {
+	if req.Action == "Fail" {
+		return errors.New("boom")
+	}
+	if r, ok := response.(*string); ok {
+		*r = req.Type + "." + req.Action
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"time"
+
+	"github.com/juju/utils/clock"
+)
+
+// ConnectObserver is notified of the outcome of attempts to open
+// an API connection.
+type ConnectObserver interface {
+	// Connected is called when a connection has been opened
+	// successfully, taking the given time.
+	Connected(elapsed time.Duration)
+
+	// Failed is called when an attempt to open a connection
+	// failed with the given error after the given time.
+	Failed(elapsed time.Duration, err error)
+}
+
+// InstrumentedOpen returns an OpenFunc that opens connections using
+// the given function, reporting how long each attempt took and its
+// outcome to the given observer. The time is measured using
+// DialOpts.Clock, or the wall clock if that is nil.
+func InstrumentedOpen(open OpenFunc, obs ConnectObserver) OpenFunc {
+	return func(info *Info, opts DialOpts) (Connection, error) {
+		clk := opts.Clock
+		if clk == nil {
+			clk = clock.WallClock
+		}
+		start := clk.Now()
+		conn, err := open(info, opts)
+		elapsed := clk.Now().Sub(start)
+		if err != nil {
+			obs.Failed(elapsed, err)
+			return nil, err
+		}
+		obs.Connected(elapsed)
+		return conn, nil
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type instrumentedOpenSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&instrumentedOpenSuite{})
+
+func (s *instrumentedOpenSuite) TestConnected(c *gc.C) {
+	clock := testing.NewClock(time.Now())
+	conn := api.NewTestingState(api.TestingStateParams{})
+	info := &api.Info{Addrs: []string{"0.1.2.3:17070"}}
+	open := func(gotInfo *api.Info, opts api.DialOpts) (api.Connection, error) {
+		c.Check(gotInfo, gc.Equals, info)
+		clock.Advance(3 * time.Second)
+		return conn, nil
+	}
+	var obs recordingConnectObserver
+	gotConn, err := api.InstrumentedOpen(open, &obs)(info, api.DialOpts{Clock: clock})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(gotConn, gc.Equals, conn)
+	obs.CheckCall(c, 0, "Connected", 3*time.Second)
+	obs.CheckCallNames(c, "Connected")
+}
+
+func (s *instrumentedOpenSuite) TestFailed(c *gc.C) {
+	clock := testing.NewClock(time.Now())
+	open := func(*api.Info, api.DialOpts) (api.Connection, error) {
+		clock.Advance(time.Minute)
+		return nil, errors.New("boom")
+	}
+	var obs recordingConnectObserver
+	conn, err := api.InstrumentedOpen(open, &obs)(&api.Info{}, api.DialOpts{Clock: clock})
+	c.Assert(err, gc.ErrorMatches, "boom")
+	c.Assert(conn, gc.IsNil)
+	obs.CheckCallNames(c, "Failed")
+	args := obs.Calls()[0].Args
+	c.Assert(args[0], gc.Equals, time.Minute)
+	c.Assert(args[1], gc.ErrorMatches, "boom")
+}
+
+type recordingConnectObserver struct {
+	testing.Stub
+}
+
+func (o *recordingConnectObserver) Connected(elapsed time.Duration) {
+	o.MethodCall(o, "Connected", elapsed)
+}
+
+func (o *recordingConnectObserver) Failed(elapsed time.Duration, err error) {
+	o.MethodCall(o, "Failed", elapsed, err)
+}
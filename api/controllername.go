@@ -0,0 +1,50 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"sort"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/jujuclient"
+)
+
+// ResolveControllerName returns the name by which the controller
+// the given connection is connected to is known in the given store.
+// If the store holds it under several names, the current controller
+// is preferred, and then the first name in alphabetical order. If
+// the store does not hold it, or the connection has not yet learned
+// the controller's tag, an error satisfying errors.IsNotFound is
+// returned.
+func ResolveControllerName(conn Connection, store jujuclient.ControllerGetter) (string, error) {
+	uuid := conn.ControllerTag().Id()
+	if uuid == "" {
+		return "", errors.NotFoundf("controller tag for connection")
+	}
+	all, err := store.AllControllers()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var names []string
+	for name, details := range all {
+		if details.ControllerUUID == uuid {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", errors.NotFoundf("controller %q in local store", uuid)
+	}
+	current, err := store.CurrentController()
+	if err != nil && !errors.IsNotFound(err) {
+		return "", errors.Trace(err)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == current {
+			return name, nil
+		}
+	}
+	return names[0], nil
+}
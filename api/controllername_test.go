@@ -0,0 +1,78 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/jujuclient"
+	"github.com/juju/juju/jujuclient/jujuclienttesting"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type controllerNameSuite struct {
+	coretesting.BaseSuite
+	store *jujuclienttesting.MemStore
+}
+
+var _ = gc.Suite(&controllerNameSuite{})
+
+const (
+	prodUUID    = "deadbeef-0bad-400d-8000-4b1d0d06f00d"
+	stagingUUID = "deadbeef-0bad-400d-8000-5b1d0d06f00d"
+)
+
+func (s *controllerNameSuite) SetUpTest(c *gc.C) {
+	s.BaseSuite.SetUpTest(c)
+	s.store = jujuclienttesting.NewMemStore()
+	s.store.Controllers["production"] = jujuclient.ControllerDetails{ControllerUUID: prodUUID}
+	s.store.Controllers["staging"] = jujuclient.ControllerDetails{ControllerUUID: stagingUUID}
+}
+
+func (s *controllerNameSuite) TestResolveControllerName(c *gc.C) {
+	conn := &controllerTagConnection{tag: names.NewControllerTag(stagingUUID)}
+	name, err := api.ResolveControllerName(conn, s.store)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(name, gc.Equals, "staging")
+}
+
+func (s *controllerNameSuite) TestResolveControllerNamePrefersCurrent(c *gc.C) {
+	s.store.Controllers["prod"] = jujuclient.ControllerDetails{ControllerUUID: prodUUID}
+	conn := &controllerTagConnection{tag: names.NewControllerTag(prodUUID)}
+	name, err := api.ResolveControllerName(conn, s.store)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(name, gc.Equals, "prod")
+
+	s.store.CurrentControllerName = "production"
+	name, err = api.ResolveControllerName(conn, s.store)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(name, gc.Equals, "production")
+}
+
+func (s *controllerNameSuite) TestResolveControllerNameNotFound(c *gc.C) {
+	conn := &controllerTagConnection{tag: names.NewControllerTag("deadbeef-0bad-400d-8000-6b1d0d06f00d")}
+	_, err := api.ResolveControllerName(conn, s.store)
+	c.Assert(err, gc.ErrorMatches, `controller "deadbeef-0bad-400d-8000-6b1d0d06f00d" in local store not found`)
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *controllerNameSuite) TestResolveControllerNameNoControllerTag(c *gc.C) {
+	_, err := api.ResolveControllerName(&controllerTagConnection{}, s.store)
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+// controllerTagConnection is an api.Connection that
+// reports the given controller tag.
+type controllerTagConnection struct {
+	api.Connection
+	tag names.ControllerTag
+}
+
+func (c *controllerTagConnection) ControllerTag() names.ControllerTag {
+	return c.tag
+}
@@ -0,0 +1,123 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/api/base"
+	"github.com/juju/juju/apiserver/params"
+)
+
+// debugLogTimeFormat is the format of the timestamps of the
+// lines read from the debug log.
+const debugLogTimeFormat = "2006-01-02 15:04:05"
+
+// ErrDebugLogBroken is the error returned when reading the debug log
+// after the connection it was opened on is broken.
+var ErrDebugLogBroken = errors.New("debug log stream closed: API connection broken")
+
+// DebugLog implements Connection.
+func (st *state) DebugLog(args DebugLogParams) (io.ReadCloser, error) {
+	stream, err := st.ConnectStream("/log", args.URLQuery())
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot open debug log")
+	}
+	return newDebugLogReader(stream, st.broken), nil
+}
+
+// debugLogReader reads the messages of a debug log stream as
+// lines of text.
+type debugLogReader struct {
+	stream base.Stream
+	pr     *io.PipeReader
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// newDebugLogReader returns a reader of the messages of the given
+// debug log stream. The stream is closed when the reader is closed,
+// or when the broken channel is closed.
+func newDebugLogReader(stream base.Stream, broken <-chan struct{}) *debugLogReader {
+	pr, pw := io.Pipe()
+	r := &debugLogReader{
+		stream: stream,
+		pr:     pr,
+		closed: make(chan struct{}),
+	}
+	go r.watch(broken)
+	go r.copy(pw, broken)
+	return r
+}
+
+// watch closes the stream if the connection is broken first,
+// so that the reader does not block forever.
+func (r *debugLogReader) watch(broken <-chan struct{}) {
+	select {
+	case <-broken:
+		r.stream.Close()
+	case <-r.closed:
+	}
+}
+
+// copy writes the messages read from the stream to pw, as lines
+// of text, until the stream ends or fails.
+func (r *debugLogReader) copy(pw *io.PipeWriter, broken <-chan struct{}) {
+	for {
+		var msg params.LogMessage
+		if err := r.stream.ReadJSON(&msg); err != nil {
+			select {
+			case <-broken:
+				err = ErrDebugLogBroken
+			default:
+				if err != io.EOF {
+					err = errors.Annotate(err, "cannot read debug log")
+				}
+			}
+			pw.CloseWithError(err)
+			return
+		}
+		line := formatLogMessage(msg)
+		if _, err := io.WriteString(pw, line); err != nil {
+			// The reader has been closed.
+			return
+		}
+	}
+}
+
+// formatLogMessage returns the given message as a line of text,
+// in the form it is written to the log files on the controller.
+func formatLogMessage(msg params.LogMessage) string {
+	return fmt.Sprintf("%s: %s %s %s %s %s\n",
+		msg.Entity,
+		msg.Timestamp.UTC().Format(debugLogTimeFormat),
+		msg.Severity,
+		msg.Module,
+		msg.Location,
+		msg.Message,
+	)
+}
+
+// Read implements io.Reader. When the log stream ends, as when
+// DebugLogParams.NoTail or Limit is set, it returns io.EOF. If the
+// connection breaks, it returns ErrDebugLogBroken.
+func (r *debugLogReader) Read(p []byte) (int, error) {
+	return r.pr.Read(p)
+}
+
+// Close implements io.Closer.
+func (r *debugLogReader) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		err = r.stream.Close()
+		r.pr.Close()
+	})
+	return errors.Trace(err)
+}
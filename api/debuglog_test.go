@@ -0,0 +1,178 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/loggo"
+	jc "github.com/juju/testing/checkers"
+	"golang.org/x/net/websocket"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/api/base"
+	"github.com/juju/juju/apiserver/params"
+	jujutesting "github.com/juju/juju/juju/testing"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type debugLogSuite struct {
+	jujutesting.JujuConnSuite
+}
+
+var _ = gc.Suite(&debugLogSuite{})
+
+// fakeLogServer is a fake controller debug log endpoint.
+type fakeLogServer struct {
+	query  chan url.Values
+	header chan string
+	done   chan struct{}
+}
+
+// startLogServer starts a fake debug log endpoint that sends the
+// given messages and then, if hold is true, keeps the stream open
+// until the test finishes. Stream connections made by the API
+// client are redirected to it.
+func (s *debugLogSuite) startLogServer(c *gc.C, hold bool, msgs ...params.LogMessage) *fakeLogServer {
+	fake := &fakeLogServer{
+		query:  make(chan url.Values, 1),
+		header: make(chan string, 1),
+		done:   make(chan struct{}),
+	}
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		fake.query <- ws.Request().URL.Query()
+		fake.header <- ws.Request().Header.Get("Authorization")
+		if err := websocket.JSON.Send(ws, params.ErrorResult{}); err != nil {
+			c.Errorf("cannot send initial response: %v", err)
+			return
+		}
+		for _, msg := range msgs {
+			if err := websocket.JSON.Send(ws, msg); err != nil {
+				c.Errorf("cannot send message: %v", err)
+				return
+			}
+		}
+		if hold {
+			<-fake.done
+		}
+	}))
+	s.AddCleanup(func(*gc.C) {
+		close(fake.done)
+		srv.Close()
+	})
+	srvURL, err := url.Parse(srv.URL)
+	c.Assert(err, jc.ErrorIsNil)
+	dial := *api.WebsocketDialConfig
+	s.PatchValue(api.WebsocketDialConfig, func(cfg *websocket.Config) (base.Stream, error) {
+		location := *cfg.Location
+		location.Scheme = "ws"
+		location.Host = srvURL.Host
+		cfg.Location = &location
+		return dial(cfg)
+	})
+	return fake
+}
+
+var debugLogMessages = []params.LogMessage{{
+	Entity:    "machine-0",
+	Timestamp: time.Date(2016, 9, 1, 10, 20, 30, 0, time.UTC),
+	Severity:  "INFO",
+	Module:    "juju.worker",
+	Location:  "runner.go:200",
+	Message:   "start \"api\"",
+}, {
+	Entity:    "unit-mysql-0",
+	Timestamp: time.Date(2016, 9, 1, 10, 20, 31, 0, time.UTC),
+	Severity:  "ERROR",
+	Module:    "juju.worker.uniter",
+	Location:  "uniter.go:300",
+	Message:   "hook failed",
+}}
+
+func (s *debugLogSuite) TestDebugLog(c *gc.C) {
+	fake := s.startLogServer(c, false, debugLogMessages...)
+	reader, err := s.APIState.DebugLog(api.DebugLogParams{
+		IncludeModule: []string{"juju.worker"},
+		ExcludeModule: []string{"juju.worker.peergrouper"},
+		Backlog:       10,
+		Level:         loggo.INFO,
+		NoTail:        true,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer reader.Close()
+
+	c.Assert(<-fake.query, jc.DeepEquals, url.Values{
+		"includeModule": {"juju.worker"},
+		"excludeModule": {"juju.worker.peergrouper"},
+		"backlog":       {"10"},
+		"level":         {"INFO"},
+		"noTail":        {"true"},
+	})
+	c.Assert(<-fake.header, gc.Matches, "Basic .+")
+
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Equals, `
+machine-0: 2016-09-01 10:20:30 INFO juju.worker runner.go:200 start "api"
+unit-mysql-0: 2016-09-01 10:20:31 ERROR juju.worker.uniter uniter.go:300 hook failed
+`[1:])
+}
+
+func (s *debugLogSuite) TestDebugLogConnectionBroken(c *gc.C) {
+	s.startLogServer(c, true, debugLogMessages[0])
+	conn, err := api.Open(s.APIInfo(c), api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	reader, err := conn.DebugLog(api.DebugLogParams{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer reader.Close()
+
+	lines := bufio.NewReader(reader)
+	line, err := lines.ReadString('\n')
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(line, jc.HasPrefix, "machine-0: ")
+
+	err = conn.Close()
+	c.Assert(err, jc.ErrorIsNil)
+	result := make(chan error, 1)
+	go func() {
+		_, err := lines.ReadString('\n')
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		c.Assert(err, gc.Equals, api.ErrDebugLogBroken)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("timed out waiting for debug log to close")
+	}
+}
+
+func (s *debugLogSuite) TestDebugLogClose(c *gc.C) {
+	s.startLogServer(c, true)
+	reader, err := s.APIState.DebugLog(api.DebugLogParams{})
+	c.Assert(err, jc.ErrorIsNil)
+	err = reader.Close()
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = reader.Read(make([]byte, 1))
+	c.Assert(err, gc.Equals, io.ErrClosedPipe)
+	// Closing again is harmless.
+	err = reader.Close()
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *debugLogSuite) TestDebugLogConnectError(c *gc.C) {
+	s.PatchValue(api.WebsocketDialConfig, func(*websocket.Config) (base.Stream, error) {
+		return fakeStreamReader{strings.NewReader(`{"error": {"message": "permission denied"}}` + "\n")}, nil
+	})
+	reader, err := s.APIState.DebugLog(api.DebugLogParams{})
+	c.Assert(err, gc.ErrorMatches, "cannot open debug log: permission denied")
+	c.Assert(reader, gc.IsNil)
+}
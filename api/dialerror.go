@@ -0,0 +1,85 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/parallel"
+)
+
+// AddrError records the failure to connect to a single
+// API address.
+type AddrError struct {
+	// Addr holds the address that was dialed.
+	Addr string
+
+	// Err holds the reason the connection failed.
+	Err error
+}
+
+// Error implements error.
+func (e *AddrError) Error() string {
+	return e.Addr + ": " + e.Err.Error()
+}
+
+// DialError is returned by Open when no API address could be
+// connected to. It records the failure for each address dialed.
+//
+// errors.Cause applied to a DialError returns the cause of the
+// last failure, so existing checks on the underlying error
+// continue to work.
+type DialError struct {
+	errs []*AddrError
+}
+
+// Errors returns the failures for each address dialed,
+// in the order that they occurred.
+func (e *DialError) Errors() []*AddrError {
+	return append([]*AddrError(nil), e.errs...)
+}
+
+// Error implements error. If only one address was dialed,
+// its error message is returned unchanged.
+func (e *DialError) Error() string {
+	switch len(e.errs) {
+	case 0:
+		return "no API addresses dialed"
+	case 1:
+		return e.errs[0].Err.Error()
+	}
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return "unable to connect to any API address: " + strings.Join(msgs, "; ")
+}
+
+// Cause implements errors.Causer.
+func (e *DialError) Cause() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return errors.Cause(e.errs[len(e.errs)-1].Err)
+}
+
+// combineDialErrors is used as the combineErrors function of
+// the parallel.Try used to dial API addresses, accumulating
+// the errors of each address into a *DialError.
+func combineDialErrors(err0, err1 error) error {
+	if err1 == parallel.ErrStopped {
+		return err0
+	}
+	dialErr, _ := err0.(*DialError)
+	if dialErr == nil {
+		dialErr = &DialError{}
+	}
+	addrErr, ok := err1.(*AddrError)
+	if !ok {
+		addrErr = &AddrError{Err: err1}
+	}
+	dialErr.errs = append(dialErr.errs, addrErr)
+	return dialErr
+}
@@ -4,11 +4,18 @@
 package api
 
 import (
+	"net/http"
+	"net/url"
+	"time"
+
 	"github.com/juju/errors"
 	"github.com/juju/juju/api/base"
 	"github.com/juju/juju/network"
+	"github.com/juju/juju/rpc"
+	"github.com/juju/juju/rpc/jsoncodec"
 	"github.com/juju/utils/clock"
 	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v1/httpbakery"
 )
 
 var (
@@ -20,21 +27,39 @@ var (
 	BestVersion           = bestVersion
 	FacadeVersions        = &facadeVersions
 	ConnectWebsocket      = connectWebsocket
+	SetSocketOptions      = setSocketOptions
+	CheckClockSkew        = checkClockSkew
+	HandshakeTimeoutFor   = handshakeTimeout
+	DialTCP               = &dialTCP
 )
 
 // RPCConnection defines the methods that are called on the rpc.Conn instance.
 type RPCConnection rpcConnection
 
+// NewSkewTolerantJar returns a cookie jar that keeps cookies for the
+// given tolerance beyond their expiry time, as used for
+// DialOpts.ClockSkewTolerance.
+func NewSkewTolerantJar(jar http.CookieJar, clock clock.Clock, tolerance time.Duration) http.CookieJar {
+	return newSkewTolerantJar(jar, clock, tolerance)
+}
+
 // SetServerAddress allows changing the URL to the internal API server
 // that AddLocalCharm uses in order to test NotImplementedError.
 func SetServerAddress(c *Client, scheme, addr string) {
-	c.st.serverScheme = scheme
-	c.st.addr = addr
+	st := c.st.(*state)
+	st.serverScheme = scheme
+	st.addr = addr
+}
+
+// FallbackTransport returns the transport used by the connection
+// for HTTP requests to hosts other than the API server.
+func FallbackTransport(c Connection) http.RoundTripper {
+	return c.(*state).bakeryClient.Client.Transport.(*hostSwitchingTransport).fallback
 }
 
 // ServerRoot is exported so that we can test the built URL.
 func ServerRoot(c *Client) string {
-	return c.st.serverRoot()
+	return c.st.(*state).serverRoot()
 }
 
 // TestingStateParams is the parameters for NewTestingState, so that you can
@@ -48,6 +73,23 @@ type TestingStateParams struct {
 	ServerRoot     string
 	RPCConnection  RPCConnection
 	Clock          clock.Clock
+	BakeryClient   *httpbakery.Client
+	Label          string
+	Logger         Logger
+
+	// Broken, if non-nil, is returned by the state's Broken
+	// method, so that closing it breaks the connection.
+	Broken chan struct{}
+
+	DefaultCallTimeout time.Duration
+
+	RequestRateLimit float64
+	RequestBurst     int
+
+	MaxMessageBytes            int64
+	MaxConcurrentResponseBytes int64
+
+	RequireMacaroonAuth bool
 }
 
 // NewTestingState creates an api.State object that can be used for testing. It
@@ -62,19 +104,69 @@ func NewTestingState(params TestingStateParams) Connection {
 		}
 		modelTag = t
 	}
+	clk := params.Clock
+	if clk == nil {
+		clk = clock.WallClock
+	}
+	logger := connLogger(DialOpts{Logger: params.Logger})
 	st := &state{
-		client:            params.RPCConnection,
-		clock:             params.Clock,
-		addr:              params.Address,
-		modelTag:          modelTag,
-		hostPorts:         params.APIHostPorts,
-		facadeVersions:    params.FacadeVersions,
-		serverScheme:      params.ServerScheme,
-		serverRootAddress: params.ServerRoot,
+		client:              params.RPCConnection,
+		clock:               clk,
+		addr:                params.Address,
+		modelTag:            modelTag,
+		hostPorts:           params.APIHostPorts,
+		facadeVersions:      params.FacadeVersions,
+		serverScheme:        params.ServerScheme,
+		serverRootAddress:   params.ServerRoot,
+		bakeryClient:        params.BakeryClient,
+		requireMacaroonAuth: params.RequireMacaroonAuth,
+		label:               params.Label,
+		defaultCallTimeout:  params.DefaultCallTimeout,
+		logger:              logger,
+		broken:              params.Broken,
+		cookieURL: &url.URL{
+			Scheme: "https",
+			Host:   params.Address,
+			Path:   "/",
+		},
+	}
+	st.openedAt = clk.Now()
+	st.lastConnectedAt = st.openedAt
+	if params.RequestRateLimit > 0 {
+		st.limiter = newRateLimiter(clk, params.RequestRateLimit, params.RequestBurst)
+	}
+	if params.MaxMessageBytes != 0 {
+		st.readLimit = jsoncodec.NewReadLimit(params.MaxMessageBytes)
+	}
+	if params.MaxConcurrentResponseBytes > 0 {
+		st.responseBudget = newResponseBudget(params.MaxConcurrentResponseBytes)
 	}
 	return st
 }
 
+// SetFacadeVersions sets the facade versions of the given connection,
+// which must have been returned by NewTestingState, as if they had
+// been reported by the API server on logging in.
+func SetFacadeVersions(c Connection, facades map[string][]int) {
+	c.(*state).setFacadeVersions(facades)
+}
+
+// ServeNotifications makes the given RPC connection deliver the
+// requests made of it as notifications, as for connections made
+// by Open, returning the channel on which they are received.
+func ServeNotifications(conn *rpc.Conn) <-chan Notification {
+	return serveNotifications(conn, "", logger)
+}
+
+// RunHeartbeatMonitor runs the health check of the given connection,
+// which must have been returned by NewTestingState, until it finds
+// the connection broken.
+func RunHeartbeatMonitor(c Connection) {
+	st := c.(*state)
+	st.broken = make(chan struct{})
+	st.heartbeatMonitor()
+}
+
 // PatchClientFacadeCall changes the internal FacadeCaller to one that lets
 // you mock out the FacadeCall method. The function returned by
 // PatchClientFacadeCall is a cleanup function that returns the client to its
@@ -113,3 +205,12 @@ func IsMinVersionError(err error) bool {
 	_, ok := errors.Cause(err).(minJujuVersionErr)
 	return ok
 }
+
+// NewHeaderTransport returns the transport with which a connection
+// adds DialOpts.Headers to its HTTP requests to the API server.
+func NewHeaderTransport(headers http.Header, transport http.RoundTripper) http.RoundTripper {
+	return &headerTransport{
+		headers:   headers,
+		transport: transport,
+	}
+}
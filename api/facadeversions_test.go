@@ -4,6 +4,8 @@
 package api_test
 
 import (
+	"time"
+
 	jc "github.com/juju/testing/checkers"
 	"github.com/juju/utils/set"
 	gc "gopkg.in/check.v1"
@@ -115,3 +117,42 @@ func (s *facadeVersionSuite) TestBestFacadeVersionServerUnknown(c *gc.C) {
 		}})
 	c.Check(st.BestFacadeVersion("TestingAPI"), gc.Equals, 0)
 }
+
+func (*facadeVersionSuite) TestWatchFacadeVersions(c *gc.C) {
+	conn := api.NewTestingState(api.TestingStateParams{
+		FacadeVersions: map[string][]int{"Client": {1}},
+	})
+	versions, stop, err := conn.WatchFacadeVersions()
+	c.Assert(err, jc.ErrorIsNil)
+	defer stop()
+	assertFacadeVersions(c, versions, map[string][]int{"Client": {1}})
+
+	api.SetFacadeVersions(conn, map[string][]int{"Client": {1, 2}, "Pinger": {1}})
+	assertFacadeVersions(c, versions, map[string][]int{"Client": {1, 2}, "Pinger": {1}})
+
+	// Versions reported again unchanged are not sent.
+	api.SetFacadeVersions(conn, map[string][]int{"Client": {1, 2}, "Pinger": {1}})
+	select {
+	case v := <-versions:
+		c.Fatalf("unexpected facade versions %v", v)
+	case <-time.After(coretesting.ShortWait):
+	}
+
+	stop()
+	select {
+	case _, ok := <-versions:
+		c.Assert(ok, jc.IsFalse)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("facade versions channel not closed")
+	}
+}
+
+func assertFacadeVersions(c *gc.C, versions <-chan map[string][]int, expect map[string][]int) {
+	select {
+	case v, ok := <-versions:
+		c.Assert(ok, jc.IsTrue)
+		c.Assert(v, jc.DeepEquals, expect)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("facade versions not sent")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// WatchFacadeVersions implements Connection.WatchFacadeVersions.
+func (s *state) WatchFacadeVersions() (<-chan map[string][]int, func(), error) {
+	select {
+	case <-s.closed:
+		return nil, nil, errors.New("connection is closed")
+	default:
+	}
+	out, stop := s.watchFacadeVersions(s.broken)
+	return out, stop, nil
+}
+
+// watchFacadeVersions watches the facade versions for the connection
+// or one of its clones, closing the channel returned when broken is.
+func (s *state) watchFacadeVersions(broken <-chan struct{}) (<-chan map[string][]int, func()) {
+	out := make(chan map[string][]int)
+	stop := make(chan struct{})
+	go func() {
+		defer close(out)
+		var last map[string][]int
+		for first := true; ; first = false {
+			versions, changed := s.facadeVersionsChanged()
+			if first || !reflect.DeepEqual(versions, last) {
+				select {
+				case out <- versions:
+				case <-stop:
+					return
+				case <-broken:
+					return
+				}
+				last = versions
+			}
+			select {
+			case <-changed:
+			case <-stop:
+				return
+			case <-broken:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return out, func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// facadeVersionsChanged returns a copy of the facade versions
+// reported by the API server, and a channel that is closed
+// when they are next changed.
+func (s *state) facadeVersionsChanged() (map[string][]int, <-chan struct{}) {
+	s.facadeVersionsMu.Lock()
+	defer s.facadeVersionsMu.Unlock()
+	if s.facadesChanged == nil {
+		s.facadesChanged = make(chan struct{})
+	}
+	versions := make(map[string][]int, len(s.facadeVersions))
+	for name, v := range s.facadeVersions {
+		versions[name] = append([]int{}, v...)
+	}
+	return versions, s.facadesChanged
+}
+
+// setFacadeVersions records the facade versions reported by
+// the API server, notifying any watchers of the change.
+func (s *state) setFacadeVersions(facades map[string][]int) {
+	s.facadeVersionsMu.Lock()
+	defer s.facadeVersionsMu.Unlock()
+	s.facadeVersions = make(map[string][]int, len(facades))
+	for name, versions := range facades {
+		s.facadeVersions[name] = versions
+	}
+	if s.facadesChanged != nil {
+		close(s.facadesChanged)
+		s.facadesChanged = nil
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"net/http"
+)
+
+// addHeaders adds the given extra headers to h, except for those
+// already set in h, so that they cannot override the headers Juju
+// itself sends, such as those with which it authenticates.
+func addHeaders(h, extra http.Header) {
+	for key, values := range extra {
+		key = http.CanonicalHeaderKey(key)
+		if _, ok := h[key]; ok {
+			continue
+		}
+		h[key] = append([]string(nil), values...)
+	}
+}
+
+// headerTransport is an http.RoundTripper that adds
+// headers to the requests it sends; see addHeaders.
+type headerTransport struct {
+	headers   http.Header
+	transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it is
+	// given, so the headers are added to a copy.
+	req1 := *req
+	req1.Header = make(http.Header, len(req.Header)+len(t.headers))
+	for key, values := range req.Header {
+		req1.Header[key] = values
+	}
+	addHeaders(req1.Header, t.headers)
+	return t.transport.RoundTrip(&req1)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"time"
+)
+
+// HealthResult holds a snapshot of the health of a Connection,
+// as returned by Connection.Health.
+type HealthResult struct {
+	// Connected holds whether the connection to the API
+	// server is up: it is neither broken nor closed.
+	Connected bool
+
+	// Authenticated holds whether the connection
+	// has logged in, as reported by Authenticated.
+	Authenticated bool
+
+	// LastPing holds the time of the last successful ping of the
+	// API server, by the health check or otherwise, or the zero
+	// time if there has been none.
+	LastPing time.Time
+
+	// Reconnects holds the number of times the connection has
+	// logged in again, as reported to OnReconnect callbacks.
+	Reconnects int
+
+	// BrokenReason holds why the connection is not connected,
+	// or the empty string if it is.
+	BrokenReason string
+
+	// PingError holds the error of the ping made by Health,
+	// if it was asked to make one and the ping failed.
+	PingError error
+}
+
+// Health implements Connection.Health.
+func (s *state) Health(ping bool) HealthResult {
+	var pingErr error
+	if ping {
+		pingErr = s.Ping()
+	}
+	result := HealthResult{
+		Authenticated: s.Authenticated(),
+		PingError:     pingErr,
+	}
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	result.LastPing = s.lastPing
+	result.Reconnects = s.reconnects
+	switch {
+	case isClosed(s.closed):
+		result.BrokenReason = "connection is closed"
+	case isClosed(s.broken):
+		result.BrokenReason = s.brokenReason
+	default:
+		result.Connected = true
+	}
+	return result
+}
+
+// setLastPing records the time of a successful ping.
+func (s *state) setLastPing(t time.Time) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	s.lastPing = t
+}
+
+// setBrokenReason records why the health
+// check found the connection broken.
+func (s *state) setBrokenReason(reason string) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	s.brokenReason = reason
+}
+
+// isClosed reports whether the given channel is closed.
+// It never blocks, and a nil channel is never closed.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// OpenedAt implements Connection.OpenedAt.
+func (s *state) OpenedAt() time.Time {
+	return s.openedAt
+}
+
+// LastConnectedAt implements Connection.LastConnectedAt.
+func (s *state) LastConnectedAt() time.Time {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	return s.lastConnectedAt
+}
+
+// Age implements Connection.Age.
+func (s *state) Age() time.Duration {
+	return s.clock.Now().Sub(s.openedAt)
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/utils/clock"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/apiserver/params"
+	"github.com/juju/juju/rpc"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type healthSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&healthSuite{})
+
+func (s *healthSuite) TestHealth(c *gc.C) {
+	conn := &healthRPCConnection{}
+	clk := &fakeClock{}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "localhost:17070",
+		RPCConnection: conn,
+		Clock:         clk,
+	})
+	c.Assert(st.Health(false), jc.DeepEquals, api.HealthResult{
+		Connected: true,
+	})
+
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.Health(false), jc.DeepEquals, api.HealthResult{
+		Connected:     true,
+		Authenticated: true,
+	})
+
+	lastPing := clk.Now()
+	c.Assert(st.Health(true), jc.DeepEquals, api.HealthResult{
+		Connected:     true,
+		Authenticated: true,
+		LastPing:      lastPing,
+	})
+
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.Health(false).Reconnects, gc.Equals, 1)
+
+	// A failed ping is reported, but leaves the
+	// connection up until the health check breaks it.
+	conn.setPingError(errors.New("boom"))
+	clk.now = clk.now.Add(time.Minute)
+	health := st.Health(true)
+	c.Assert(health.PingError, gc.ErrorMatches, "boom")
+	c.Assert(health.Connected, jc.IsTrue)
+	c.Assert(health.LastPing, gc.Equals, lastPing)
+
+	api.RunHeartbeatMonitor(st)
+	c.Assert(st.Health(false), jc.DeepEquals, api.HealthResult{
+		Authenticated: true,
+		LastPing:      lastPing,
+		Reconnects:    1,
+		BrokenReason:  "health ping failed: boom",
+	})
+}
+
+func (s *healthSuite) TestOpenedAtAndLastConnectedAt(c *gc.C) {
+	clk := &fakeClock{now: time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)}
+	openedAt := clk.Now()
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "localhost:17070",
+		RPCConnection: &healthRPCConnection{},
+		Clock:         clk,
+	})
+	c.Assert(st.OpenedAt(), gc.Equals, openedAt)
+	c.Assert(st.LastConnectedAt(), gc.Equals, openedAt)
+	c.Assert(st.Age(), gc.Equals, time.Duration(0))
+
+	// Logging in for the first time is not reconnecting.
+	clk.now = clk.now.Add(time.Minute)
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.LastConnectedAt(), gc.Equals, openedAt)
+
+	clk.now = clk.now.Add(time.Hour)
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	reconnectedAt := clk.Now()
+	c.Assert(st.OpenedAt(), gc.Equals, openedAt)
+	c.Assert(st.LastConnectedAt(), gc.Equals, reconnectedAt)
+	c.Assert(st.Age(), gc.Equals, time.Hour+time.Minute)
+
+	clk.now = clk.now.Add(time.Hour)
+	c.Assert(st.LastConnectedAt(), gc.Equals, reconnectedAt)
+	c.Assert(st.Age(), gc.Equals, 2*time.Hour+time.Minute)
+}
+
+func (s *healthSuite) TestHealthCheckNotRateLimited(c *gc.C) {
+	conn := &healthRPCConnection{}
+	clk := &pingPeriodClock{
+		now: time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC),
+		// Once a ping has succeeded, the next one
+		// fails so that the health check stops.
+		onPingPeriod: func() {
+			conn.setPingError(errors.New("boom"))
+		},
+	}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:          "localhost:17070",
+		RPCConnection:    conn,
+		Clock:            clk,
+		RequestRateLimit: 0.001,
+		RequestBurst:     1,
+	})
+	// Logging in uses up the burst, so that any
+	// further call would wait for 1000 seconds.
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	api.RunHeartbeatMonitor(st)
+	c.Assert(st.Health(false), jc.DeepEquals, api.HealthResult{
+		Authenticated: true,
+		LastPing:      clk.now,
+		BrokenReason:  "health ping failed: boom",
+	})
+}
+
+// pingPeriodClock is a clock whose time stands still. It calls
+// onPingPeriod when it is asked to wait for the health check ping
+// period, and then fires at once; waits of any other duration never
+// end.
+type pingPeriodClock struct {
+	clock.Clock
+
+	now          time.Time
+	onPingPeriod func()
+}
+
+func (c *pingPeriodClock) Now() time.Time {
+	return c.now
+}
+
+func (c *pingPeriodClock) After(d time.Duration) <-chan time.Time {
+	if d != api.PingPeriod {
+		return nil
+	}
+	c.onPingPeriod()
+	return time.After(0)
+}
+
+// healthRPCConnection is an rpc connection that accepts
+// any login, and answers pings with pingErr.
+type healthRPCConnection struct {
+	mu      sync.Mutex
+	pingErr error
+}
+
+func (f *healthRPCConnection) setPingError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pingErr = err
+}
+
+func (f *healthRPCConnection) Close() error {
+	return nil
+}
+
+func (f *healthRPCConnection) Call(req rpc.Request, args, response interface{}) error {
+	switch {
+	case req.Type == "Admin" && req.Action == "Login":
+		*response.(*params.LoginResult) = params.LoginResult{
+			ControllerTag: coretesting.ControllerTag.String(),
+			ServerVersion: "2.0.1",
+		}
+		return nil
+	case req.Type == "Pinger" && req.Action == "Ping":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.pingErr
+	}
+	return errors.Errorf("unexpected call to %s.%s", req.Type, req.Action)
+}
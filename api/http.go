@@ -71,20 +71,21 @@ func (doer httpRequestDoer) Do(req *http.Request) (*http.Response, error) {
 func (doer httpRequestDoer) DoWithBody(req *http.Request, body io.ReadSeeker) (*http.Response, error) {
 	// Add basic auth if appropriate
 	// Call doer.bakeryClient.DoWithBodyAndCustomError
-	if doer.st.tag != "" {
+	tag, password, nonce, macaroons := doer.st.loginCredentials()
+	if tag != "" {
 		// Note that password may be empty here; we still
 		// want to pass the tag along. An empty password
 		// indicates that we're using macaroon authentication.
-		req.SetBasicAuth(doer.st.tag, doer.st.password)
+		req.SetBasicAuth(tag, password)
 	}
 
 	// Set the machine nonce if it was provided.
-	if doer.st.nonce != "" {
-		req.Header.Set(params.MachineNonceHeader, doer.st.nonce)
+	if nonce != "" {
+		req.Header.Set(params.MachineNonceHeader, nonce)
 	}
 
 	// Add any explicitly-specified macaroons.
-	for _, ms := range doer.st.macaroons {
+	for _, ms := range macaroons {
 		encoded, err := encodeMacaroonSlice(ms)
 		if err != nil {
 			return nil, errors.Trace(err)
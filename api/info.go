@@ -0,0 +1,139 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/juju/errors"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// infoDoc is the serialized form of Info. Tags are held
+// in their string form, and each macaroon slice is held
+// as a JSON document, as neither can be represented
+// directly in YAML. The keys match those that would be
+// used when encoding Info itself.
+type infoDoc struct {
+	Addrs         []string `yaml:"addrs"`
+	CACert        string   `yaml:"cacert"`
+	ModelTag      string   `yaml:"modeltag"`
+	ControllerTag string   `yaml:"controllertag,omitempty"`
+	Tag           string   `yaml:"tag"`
+	Password      string   `yaml:"password"`
+	Macaroons     []string `yaml:"macaroons,omitempty"`
+	Nonce         string   `yaml:"nonce,omitempty"`
+}
+
+// Marshal returns the YAML serialization of info, which may be
+// read back with ParseInfo. SkipLogin is not serialized.
+func (info *Info) Marshal() ([]byte, error) {
+	doc := infoDoc{
+		Addrs:    info.Addrs,
+		CACert:   info.CACert,
+		Password: info.Password,
+		Nonce:    info.Nonce,
+	}
+	if info.ModelTag.Id() != "" {
+		doc.ModelTag = info.ModelTag.String()
+	}
+	if info.ControllerTag.Id() != "" {
+		doc.ControllerTag = info.ControllerTag.String()
+	}
+	if info.Tag != nil {
+		doc.Tag = info.Tag.String()
+	}
+	for _, ms := range info.Macaroons {
+		data, err := json.Marshal(ms)
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot marshal macaroons")
+		}
+		doc.Macaroons = append(doc.Macaroons, string(data))
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return data, nil
+}
+
+// ParseInfo parses the YAML serialization of an Info,
+// as produced by Info.Marshal.
+func ParseInfo(data []byte) (*Info, error) {
+	var doc infoDoc
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Annotate(err, "cannot unmarshal API info")
+	}
+	info := &Info{
+		Addrs:    doc.Addrs,
+		CACert:   doc.CACert,
+		Password: doc.Password,
+		Nonce:    doc.Nonce,
+	}
+	if doc.ModelTag != "" {
+		modelTag, err := names.ParseModelTag(doc.ModelTag)
+		if err != nil {
+			return nil, errors.Annotate(err, "invalid model tag")
+		}
+		info.ModelTag = modelTag
+	}
+	if doc.ControllerTag != "" {
+		controllerTag, err := names.ParseControllerTag(doc.ControllerTag)
+		if err != nil {
+			return nil, errors.Annotate(err, "invalid controller tag")
+		}
+		info.ControllerTag = controllerTag
+	}
+	if doc.Tag != "" {
+		tag, err := names.ParseTag(doc.Tag)
+		if err != nil {
+			return nil, errors.Annotate(err, "invalid tag")
+		}
+		info.Tag = tag
+	}
+	for _, data := range doc.Macaroons {
+		var ms macaroon.Slice
+		if err := json.Unmarshal([]byte(data), &ms); err != nil {
+			return nil, errors.Annotate(err, "cannot unmarshal macaroons")
+		}
+		info.Macaroons = append(info.Macaroons, ms)
+	}
+	return info, nil
+}
+
+// NewControllerInfo returns the Info for a controller-only
+// connection to the controller with the given tag and API
+// addresses. The model tag is left empty.
+func NewControllerInfo(tag names.ControllerTag, addrs []string, caCert string) (*Info, error) {
+	if tag.Id() == "" {
+		return nil, errors.NotValidf("empty controller tag")
+	}
+	info := &Info{
+		Addrs:         addrs,
+		CACert:        caCert,
+		ControllerTag: tag,
+	}
+	if err := info.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return info, nil
+}
+
+// NewModelInfo returns the Info for a connection to the given model,
+// hosted by the controller with the given tag and API addresses.
+func NewModelInfo(controller names.ControllerTag, model names.ModelTag, addrs []string, caCert string) (*Info, error) {
+	if model.Id() == "" {
+		// An empty model tag would silently result in a
+		// controller-only login.
+		return nil, errors.NotValidf("empty model tag")
+	}
+	info, err := NewControllerInfo(controller, addrs, caCert)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	info.ModelTag = model
+	return info, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"encoding/json"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/juju/juju/api"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type infoSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&infoSuite{})
+
+func (s *infoSuite) TestMarshalRoundTrip(c *gc.C) {
+	mac, err := macaroon.New([]byte("root-key"), "id", "juju")
+	c.Assert(err, jc.ErrorIsNil)
+	tests := []struct {
+		about string
+		info  api.Info
+	}{{
+		about: "empty info",
+	}, {
+		about: "controller-only login with empty model tag",
+		info: api.Info{
+			Addrs:    []string{"0.1.2.3:17070", "[2001:db8::1]:17070"},
+			CACert:   coretesting.CACert,
+			Tag:      names.NewUserTag("admin"),
+			Password: "hunter2",
+		},
+	}, {
+		about: "model login",
+		info: api.Info{
+			Addrs:    []string{"0.1.2.3:17070"},
+			CACert:   coretesting.CACert,
+			ModelTag: coretesting.ModelTag,
+			Tag:      names.NewUserTag("bob@external"),
+			Password: "hunter2",
+		},
+	}, {
+		about: "controller tag",
+		info: api.Info{
+			Addrs:         []string{"0.1.2.3:17070"},
+			ModelTag:      coretesting.ModelTag,
+			ControllerTag: coretesting.ControllerTag,
+		},
+	}, {
+		about: "machine agent with nonce",
+		info: api.Info{
+			Addrs:    []string{"0.1.2.3:17070"},
+			ModelTag: coretesting.ModelTag,
+			Tag:      names.NewMachineTag("0"),
+			Password: "hunter2",
+			Nonce:    "fake_nonce",
+		},
+	}, {
+		about: "macaroons",
+		info: api.Info{
+			Addrs:     []string{"0.1.2.3:17070"},
+			ModelTag:  coretesting.ModelTag,
+			Macaroons: []macaroon.Slice{{mac}, {mac, mac}},
+		},
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.about)
+		data, err := test.info.Marshal()
+		c.Assert(err, jc.ErrorIsNil)
+		info, err := api.ParseInfo(data)
+		c.Assert(err, jc.ErrorIsNil)
+
+		// Macaroons do not compare well directly,
+		// so compare their JSON serializations.
+		c.Assert(marshalJSON(c, info.Macaroons), gc.Equals, marshalJSON(c, test.info.Macaroons))
+		info.Macaroons, test.info.Macaroons = nil, nil
+		c.Assert(info, jc.DeepEquals, &test.info)
+	}
+}
+
+func (s *infoSuite) TestMarshalOmitsSkipLogin(c *gc.C) {
+	info := &api.Info{
+		Addrs:     []string{"0.1.2.3:17070"},
+		SkipLogin: true,
+	}
+	data, err := info.Marshal()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Not(jc.Contains), "skip")
+	c.Assert(string(data), gc.Not(jc.Contains), "macaroons")
+	c.Assert(string(data), gc.Not(jc.Contains), "nonce")
+	parsed, err := api.ParseInfo(data)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(parsed.SkipLogin, jc.IsFalse)
+}
+
+func (s *infoSuite) TestParseInfoInvalidTag(c *gc.C) {
+	_, err := api.ParseInfo([]byte("tag: foo\n"))
+	c.Assert(err, gc.ErrorMatches, `invalid tag: "foo" is not a valid tag`)
+}
+
+func (s *infoSuite) TestParseInfoInvalidModelTag(c *gc.C) {
+	_, err := api.ParseInfo([]byte("modeltag: user-bob\n"))
+	c.Assert(err, gc.ErrorMatches, `invalid model tag: "user-bob" is not a valid model tag`)
+}
+
+func (s *infoSuite) TestParseInfoInvalidControllerTag(c *gc.C) {
+	_, err := api.ParseInfo([]byte("controllertag: user-bob\n"))
+	c.Assert(err, gc.ErrorMatches, `invalid controller tag: "user-bob" is not a valid controller tag`)
+}
+
+func (s *infoSuite) TestParseInfoInvalidYAML(c *gc.C) {
+	_, err := api.ParseInfo([]byte("addrs: {"))
+	c.Assert(err, gc.ErrorMatches, "cannot unmarshal API info: .*")
+}
+
+func (s *infoSuite) TestNewControllerInfo(c *gc.C) {
+	info, err := api.NewControllerInfo(coretesting.ControllerTag, []string{"0.1.2.3:17070"}, coretesting.CACert)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info, jc.DeepEquals, &api.Info{
+		Addrs:         []string{"0.1.2.3:17070"},
+		CACert:        coretesting.CACert,
+		ControllerTag: coretesting.ControllerTag,
+	})
+}
+
+func (s *infoSuite) TestNewModelInfo(c *gc.C) {
+	info, err := api.NewModelInfo(coretesting.ControllerTag, coretesting.ModelTag, []string{"0.1.2.3:17070"}, coretesting.CACert)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info, jc.DeepEquals, &api.Info{
+		Addrs:         []string{"0.1.2.3:17070"},
+		CACert:        coretesting.CACert,
+		ModelTag:      coretesting.ModelTag,
+		ControllerTag: coretesting.ControllerTag,
+	})
+}
+
+func (s *infoSuite) TestNewInfoValidationFailures(c *gc.C) {
+	addrs := []string{"0.1.2.3:17070"}
+	for i, test := range []struct {
+		about  string
+		new    func() (*api.Info, error)
+		expect string
+	}{{
+		about: "controller info with empty controller tag",
+		new: func() (*api.Info, error) {
+			return api.NewControllerInfo(names.ControllerTag{}, addrs, "")
+		},
+		expect: "empty controller tag not valid",
+	}, {
+		about: "controller info with no addresses",
+		new: func() (*api.Info, error) {
+			return api.NewControllerInfo(coretesting.ControllerTag, nil, "")
+		},
+		expect: "missing addresses not valid",
+	}, {
+		about: "controller info with invalid address",
+		new: func() (*api.Info, error) {
+			return api.NewControllerInfo(coretesting.ControllerTag, []string{"0.1.2.3"}, "")
+		},
+		expect: "host addresses: .* not valid",
+	}, {
+		about: "model info with empty model tag",
+		new: func() (*api.Info, error) {
+			return api.NewModelInfo(coretesting.ControllerTag, names.ModelTag{}, addrs, "")
+		},
+		expect: "empty model tag not valid",
+	}, {
+		about: "model info with empty controller tag",
+		new: func() (*api.Info, error) {
+			return api.NewModelInfo(names.ControllerTag{}, coretesting.ModelTag, addrs, "")
+		},
+		expect: "empty controller tag not valid",
+	}, {
+		about: "model info with no addresses",
+		new: func() (*api.Info, error) {
+			return api.NewModelInfo(coretesting.ControllerTag, coretesting.ModelTag, nil, "")
+		},
+		expect: "missing addresses not valid",
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		info, err := test.new()
+		c.Check(err, gc.ErrorMatches, test.expect)
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+		c.Check(info, gc.IsNil)
+	}
+}
+
+func marshalJSON(c *gc.C, x interface{}) string {
+	data, err := json.Marshal(x)
+	c.Assert(err, jc.ErrorIsNil)
+	return string(data)
+}
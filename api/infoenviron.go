@@ -0,0 +1,92 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/juju/names.v2"
+)
+
+// Environment variables read by InfoFromEnviron.
+const (
+	apiAddrsEnvKey      = "JUJU_API_ADDRS"
+	apiCACertEnvKey     = "JUJU_API_CACERT"
+	apiCACertFileEnvKey = "JUJU_API_CACERT_FILE"
+	apiModelTagEnvKey   = "JUJU_MODEL_TAG"
+	apiTagEnvKey        = "JUJU_API_TAG"
+	apiPasswordEnvKey   = "JUJU_API_PASSWORD"
+	apiNonceEnvKey      = "JUJU_API_NONCE"
+)
+
+// InfoFromEnviron returns the API connection details held in
+// environment variables, for use by scripted clients:
+//
+//	JUJU_API_ADDRS        comma-separated API addresses (required)
+//	JUJU_API_CACERT       the CA certificate, in PEM format
+//	JUJU_API_CACERT_FILE  a file holding the CA certificate, used
+//	                      if JUJU_API_CACERT is not set
+//	JUJU_MODEL_TAG        the tag of the model to connect to; if
+//	                      not set, a controller-only login is made
+//	JUJU_API_TAG          the tag of the entity to log in as
+//	JUJU_API_PASSWORD     the password to log in with
+//	JUJU_API_NONCE        the machine nonce, for machine agents
+//
+// JUJU_API_TAG and JUJU_API_PASSWORD must be set together. If any of
+// the variables are missing or invalid, the returned error describes
+// every problem found.
+func InfoFromEnviron() (*Info, DialOpts, error) {
+	var problems []string
+	info := &Info{
+		CACert:   os.Getenv(apiCACertEnvKey),
+		Password: os.Getenv(apiPasswordEnvKey),
+		Nonce:    os.Getenv(apiNonceEnvKey),
+	}
+	opts := DefaultDialOpts()
+	opts.CACertFile = os.Getenv(apiCACertFileEnvKey)
+
+	if addrs := os.Getenv(apiAddrsEnvKey); addrs != "" {
+		for _, addr := range strings.Split(addrs, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				info.Addrs = append(info.Addrs, addr)
+			}
+		}
+	}
+	if len(info.Addrs) == 0 {
+		problems = append(problems, apiAddrsEnvKey+" not set")
+	}
+	if s := os.Getenv(apiModelTagEnvKey); s != "" {
+		modelTag, err := names.ParseModelTag(s)
+		if err != nil {
+			problems = append(problems, apiModelTagEnvKey+": "+err.Error())
+		}
+		info.ModelTag = modelTag
+	}
+	tag := os.Getenv(apiTagEnvKey)
+	switch {
+	case tag != "":
+		t, err := names.ParseTag(tag)
+		if err != nil {
+			problems = append(problems, apiTagEnvKey+": "+err.Error())
+		}
+		info.Tag = t
+		if info.Password == "" {
+			problems = append(problems, apiPasswordEnvKey+" not set")
+		}
+	case info.Password != "":
+		problems = append(problems, apiTagEnvKey+" not set")
+	}
+	if len(problems) > 0 {
+		return nil, DialOpts{}, errors.Errorf(
+			"cannot get API info from environment: %s",
+			strings.Join(problems, "; "),
+		)
+	}
+	if err := info.Validate(); err != nil {
+		return nil, DialOpts{}, errors.Annotate(err, "cannot get API info from environment")
+	}
+	return info, opts, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/juju/api"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type infoFromEnvironSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&infoFromEnvironSuite{})
+
+func (s *infoFromEnvironSuite) TestInfoFromEnviron(c *gc.C) {
+	s.PatchEnvironment("JUJU_API_ADDRS", "0.1.2.3:17070, [2001:db8::1]:17070")
+	s.PatchEnvironment("JUJU_API_CACERT", coretesting.CACert)
+	s.PatchEnvironment("JUJU_MODEL_TAG", coretesting.ModelTag.String())
+	s.PatchEnvironment("JUJU_API_TAG", "machine-0")
+	s.PatchEnvironment("JUJU_API_PASSWORD", "hunter2")
+	s.PatchEnvironment("JUJU_API_NONCE", "fake_nonce")
+
+	info, opts, err := api.InfoFromEnviron()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info, jc.DeepEquals, &api.Info{
+		Addrs:    []string{"0.1.2.3:17070", "[2001:db8::1]:17070"},
+		CACert:   coretesting.CACert,
+		ModelTag: coretesting.ModelTag,
+		Tag:      names.NewMachineTag("0"),
+		Password: "hunter2",
+		Nonce:    "fake_nonce",
+	})
+	c.Assert(opts, jc.DeepEquals, api.DefaultDialOpts())
+}
+
+func (s *infoFromEnvironSuite) TestInfoFromEnvironMinimal(c *gc.C) {
+	s.PatchEnvironment("JUJU_API_ADDRS", "0.1.2.3:17070")
+	s.PatchEnvironment("JUJU_API_CACERT_FILE", "/path/to/ca.crt")
+
+	info, opts, err := api.InfoFromEnviron()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(info, jc.DeepEquals, &api.Info{
+		Addrs: []string{"0.1.2.3:17070"},
+	})
+	c.Assert(opts.CACertFile, gc.Equals, "/path/to/ca.crt")
+}
+
+func (s *infoFromEnvironSuite) TestInfoFromEnvironErrors(c *gc.C) {
+	for i, test := range []struct {
+		about  string
+		env    map[string]string
+		expect string
+	}{{
+		about:  "nothing set",
+		expect: `cannot get API info from environment: JUJU_API_ADDRS not set`,
+	}, {
+		about: "password without tag",
+		env: map[string]string{
+			"JUJU_API_PASSWORD": "hunter2",
+		},
+		expect: `cannot get API info from environment: JUJU_API_ADDRS not set; JUJU_API_TAG not set`,
+	}, {
+		about: "tag without password",
+		env: map[string]string{
+			"JUJU_API_ADDRS": "0.1.2.3:17070",
+			"JUJU_API_TAG":   "user-bob",
+		},
+		expect: `cannot get API info from environment: JUJU_API_PASSWORD not set`,
+	}, {
+		about: "invalid tags",
+		env: map[string]string{
+			"JUJU_API_ADDRS":    "0.1.2.3:17070",
+			"JUJU_MODEL_TAG":    "user-bob",
+			"JUJU_API_TAG":      "bob",
+			"JUJU_API_PASSWORD": "hunter2",
+		},
+		expect: `cannot get API info from environment: ` +
+			`JUJU_MODEL_TAG: "user-bob" is not a valid model tag; ` +
+			`JUJU_API_TAG: "bob" is not a valid tag`,
+	}, {
+		about: "invalid address",
+		env: map[string]string{
+			"JUJU_API_ADDRS": "0.1.2.3",
+		},
+		expect: `cannot get API info from environment: host addresses: .* not valid`,
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		for _, key := range []string{
+			"JUJU_API_ADDRS", "JUJU_API_CACERT", "JUJU_API_CACERT_FILE",
+			"JUJU_MODEL_TAG", "JUJU_API_TAG", "JUJU_API_PASSWORD", "JUJU_API_NONCE",
+		} {
+			s.PatchEnvironment(key, test.env[key])
+		}
+		info, _, err := api.InfoFromEnviron()
+		c.Check(err, gc.ErrorMatches, test.expect)
+		c.Check(info, gc.IsNil)
+	}
+}
@@ -4,11 +4,17 @@
 package api
 
 import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/juju/errors"
+	"github.com/juju/utils/clock"
 	"github.com/juju/version"
+	"golang.org/x/net/context"
 	"gopkg.in/juju/names.v2"
 	"gopkg.in/macaroon-bakery.v1/httpbakery"
 	"gopkg.in/macaroon.v1"
@@ -47,8 +53,15 @@ type Info struct {
 	// login will be made.
 	ModelTag names.ModelTag
 
+	// ControllerTag, if set, holds the tag of the controller
+	// being connected to, as given to NewControllerInfo or
+	// NewModelInfo. It is recorded only, and is not checked
+	// against the controller that is connected to.
+	ControllerTag names.ControllerTag
+
 	// ...but this block of fields is all about the authentication mechanism
 	// to use after connecting -- if any -- and should probably be extracted.
+	// DialOpts.LoginProvider may be used to supply an alternative mechanism.
 
 	// SkipLogin, if true, skips the Login call on connection. It is an
 	// error to set Tag, Password, or Macaroons if SkipLogin is true.
@@ -122,6 +135,15 @@ type DialOpts struct {
 	// unsuccessful connection attempts.
 	RetryDelay time.Duration
 
+	// HandshakeTimeout bounds each attempt to connect to an address,
+	// including the TLS and websocket handshakes. If it is zero, a
+	// third of Timeout is used; if both are zero, it is unbounded.
+	HandshakeTimeout time.Duration
+
+	// FailFastOnNoRoute, if true, stops retrying an address once
+	// dialing it fails because the network or host is unreachable.
+	FailFastOnNoRoute bool
+
 	// BakeryClient is the httpbakery Client, which
 	// is used to do the macaroon-based authorization.
 	// This and the *http.Client inside it are copied
@@ -134,6 +156,116 @@ type DialOpts struct {
 	// be used in tests, or when verification cannot be
 	// performed and the communication need not be secure.
 	InsecureSkipVerify bool
+
+	// LoginProvider, if non-nil, is used by Open to log in
+	// in place of the credentials held in Info.
+	LoginProvider LoginProvider
+
+	// RequireMacaroonAuth, if true, ensures that no password is ever
+	// sent, so that only macaroons are used to log in. It cannot be
+	// combined with LoginProvider.
+	RequireMacaroonAuth bool
+
+	// AcceptServerVersion, if non-nil, is called by Open with the
+	// version reported on login; if it returns an error, Open fails.
+	AcceptServerVersion func(version.Number) error
+
+	// TCPKeepAlive, if non-zero, is the interval at which TCP
+	// keepalives are sent on the connection to the controller.
+	TCPKeepAlive time.Duration
+
+	// DisableTCPNoDelay, if true, enables Nagle's algorithm on
+	// the TCP connection to the controller.
+	DisableTCPNoDelay bool
+
+	// AddressPriority, if non-nil, orders the addresses in Info.Addrs
+	// before they are dialed, lowest first. If it is nil, they are
+	// dialed in the order given.
+	AddressPriority func(addr string) int
+
+	// IPVersionPreference restricts the IP version used to
+	// connect to the API server. If it is empty, both are used.
+	IPVersionPreference IPVersionPreference
+
+	// PingJitter, if true, delays the first health check ping
+	// on a new connection by a random offset within PingPeriod.
+	// It is enabled by DefaultDialOpts.
+	PingJitter bool
+
+	// DisableAddressLearning, if true, prevents the addresses
+	// reported on login from replacing Info.Addrs for later
+	// connections.
+	DisableAddressLearning bool
+
+	// CACertFile, if non-empty, holds the path of a PEM file holding
+	// the CA certificate to use when Info.CACert is empty.
+	CACertFile string
+
+	// DisableConnectionReuse, if true, ensures that no transport
+	// state is shared with other connections.
+	DisableConnectionReuse bool
+
+	// RequestRateLimit, if positive, holds the maximum average
+	// number of API calls per second made by the connection.
+	RequestRateLimit float64
+
+	// RequestBurst holds the number of API calls that may be made
+	// in a burst above RequestRateLimit. Values below 1 are
+	// treated as 1.
+	RequestBurst int
+
+	// MaxMessageBytes, if positive, holds the size of the largest
+	// message the connection will receive; a larger one breaks the
+	// connection. DefaultDialOpts sets it to DefaultMaxMessageBytes.
+	MaxMessageBytes int64
+
+	// MaxConcurrentResponseBytes, if positive, bounds the memory
+	// taken by the responses to the API calls in flight. If it is
+	// zero, the memory is not bounded.
+	MaxConcurrentResponseBytes int64
+
+	// AllowLegacyLogin, if true, makes Connection.Facade assume
+	// that all facades are available at version 0 when the API
+	// server reports no facade versions.
+	AllowLegacyLogin bool
+
+	// PathPrefix, if non-empty, is prepended to the path of every
+	// websocket connection made to the API server.
+	PathPrefix string
+
+	// ClockSkewTolerance, if positive, is how far the local clock
+	// may differ from the API server's before a warning is logged.
+	ClockSkewTolerance time.Duration
+
+	// DefaultCallTimeout, if positive, is the longest time any API
+	// call may take. If it is zero, calls are not timed out.
+	DefaultCallTimeout time.Duration
+
+	// CallInterceptor, if non-nil, is called before each API call
+	// with its facade, method, version and arguments; if it returns
+	// an error, the call is not made and fails with that error.
+	CallInterceptor func(facade, method string, version int, args interface{}) error
+
+	// Tracer, if non-nil, is used to trace Open
+	// and the API calls made by the connection.
+	Tracer Tracer
+
+	// Headers, if non-empty, holds HTTP headers sent to the API
+	// server with every websocket handshake and HTTP request.
+	Headers http.Header
+
+	// Logger, if non-nil, is used to log the messages about the
+	// connection. If it is nil, the package logger is used.
+	Logger Logger
+
+	// Label, if non-empty, is a human-readable name for the
+	// connection, included in its String form and log messages.
+	Label string
+
+	// Clock is used by the connection for timing health checks
+	// and retries. The offset chosen for PingJitter is seeded
+	// from its current time. If it is nil, the wall clock is used.
+	Clock clock.Clock
 }
 
 // DefaultDialOpts returns a DialOpts representing the default
@@ -143,9 +275,17 @@ func DefaultDialOpts() DialOpts {
 		DialAddressInterval: 50 * time.Millisecond,
 		Timeout:             10 * time.Minute,
 		RetryDelay:          2 * time.Second,
+		PingJitter:          true,
+		MaxMessageBytes:     DefaultMaxMessageBytes,
 	}
 }
 
+// DefaultMaxMessageBytes is the limit on the size of messages
+// received from the API server set by DefaultDialOpts. It is
+// large enough for the results of any API call made against
+// a large model.
+const DefaultMaxMessageBytes = 128 << 20
+
 // OpenFunc is the usual form of a function that opens an API connection.
 type OpenFunc func(*Info, DialOpts) (Connection, error)
 
@@ -162,15 +302,74 @@ type Connection interface {
 	Addr() string
 	APIHostPorts() [][]network.HostPort
 
+	// RefreshAPIHostPorts fetches the current API server addresses
+	// using the Client facade, updating those returned by
+	// APIHostPorts, and returns them.
+	RefreshAPIHostPorts() ([][]network.HostPort, error)
+
 	// These are a bit off -- ServerVersion is apparently not known until after
 	// Login()? Maybe evidence of need for a separate AuthenticatedConnection..?
 	Login(name names.Tag, password, nonce string, ms []macaroon.Slice) error
+
 	ServerVersion() (version.Number, bool)
 
+	// NegotiatedServerVersion returns the version of the API server
+	// as reported on login. The boolean result is false if the
+	// connection has not logged in.
+	NegotiatedServerVersion() (version.Number, bool)
+
+	// ServerVersionOrZero returns the version of the API server, or
+	// version.Zero if it is not yet known, for callers that can
+	// tolerate the version being unknown.
+	ServerVersionOrZero() version.Number
+
+	// AwaitServerVersion waits until the connection has logged in
+	// and returns the version of the API server, or an error
+	// satisfying errors.IsNotFound if it was not reported.
+	AwaitServerVersion(ctx context.Context) (version.Number, error)
+
+	// Macaroons returns copies of the macaroons used by the
+	// connection to authenticate, including any discharges.
+	Macaroons() []macaroon.Slice
+
+	// EnsureLogin logs in as Login does, unless the connection is
+	// already authenticated as the entity with the given tag.
+	EnsureLogin(name names.Tag, password, nonce string, ms []macaroon.Slice) error
+
+	// ForModel opens a new connection to the given model on the
+	// same controller, with the same credentials and DialOpts. It
+	// logs in only if this connection has, with this connection's
+	// macaroons unless a password is used. It must be closed separately.
+	ForModel(modelTag names.ModelTag) (Connection, error)
+
+	// OnReconnect registers f to be called each time the connection
+	// logs in again, having already been logged in. The returned
+	// function deregisters f.
+	OnReconnect(f func()) func()
+
+	// OnConnect registers f to be called with the address of the
+	// API server at once, and again before the OnReconnect callbacks
+	// are run. The returned function deregisters f.
+	OnConnect(f func(addr string)) func()
+
+	// ChangeUser logs in again over the existing connection as the
+	// entity with the given tag, whose credentials are then used by
+	// HTTP requests and ForModel. It is not reported as a reconnect.
+	ChangeUser(tag names.Tag, password string, ms []macaroon.Slice) error
+
 	// APICaller provides the facility to make API calls directly.
 	// This should not be used outside the api/* packages or tests.
 	base.APICaller
 
+	// Facade returns a caller for the named facade at the highest
+	// version supported by the API server no greater than version.
+	Facade(name string, version int) (base.FacadeCaller, error)
+
+	// DebugLog opens the debug log of the connected model, or of
+	// the controller, filtered as specified by args, returning
+	// its lines of text.
+	DebugLog(args DebugLogParams) (io.ReadCloser, error)
+
 	// ControllerTag returns the tag of the controller.
 	// This could be defined on base.APICaller.
 	ControllerTag() names.ControllerTag
@@ -182,26 +381,123 @@ type Connection interface {
 	// *should* be handled outside the State type, but it's also handled
 	// inside it as well. We should figure this out sometime -- we should
 	// either expose Ping() or Broken() but not both.
+	// Ping is equivalent to PingContext with a timeout of PingTimeout.
 	Ping() error
 
+	// PingContext checks that the API server is responding,
+	// giving up when the context is done.
+	PingContext(ctx context.Context) error
+
+	// ControllerTime returns the current time of the controller's
+	// clock, to the second.
+	ControllerTime() (time.Time, error)
+
+	// Notifications returns a channel on which the messages the
+	// API server pushes outside of any request are received.
+	Notifications() <-chan Notification
+
+	// Health returns a snapshot of the health of the connection,
+	// first pinging the API server if ping is true.
+	Health(ping bool) HealthResult
+
+	// OpenedAt returns when Open succeeded in making the
+	// connection, as told by DialOpts.Clock.
+	OpenedAt() time.Time
+
+	// LastConnectedAt returns when the connection last logged in
+	// again, or, if it has not, when Open succeeded.
+	LastConnectedAt() time.Time
+
+	// Age returns how long ago Open succeeded in making
+	// the connection, as told by DialOpts.Clock.
+	Age() time.Duration
+
+	// ActiveWatchers returns the watchers started through the
+	// connection that it has not since stopped, oldest first.
+	ActiveWatchers() []WatcherInfo
+
 	// I think this is actually dead code. It's tested, at least, so I'm
 	// keeping it for now, but it's not apparently used anywhere else.
 	AllFacadeVersions() map[string][]int
 
+	// WatchFacadeVersions returns a channel on which the facade
+	// versions are sent, at once and whenever a login changes them,
+	// and a function that stops the watch, closing the channel.
+	WatchFacadeVersions() (<-chan map[string][]int, func(), error)
+
 	// AuthTag returns the tag of the authorized user of the state API
 	// connection.
 	AuthTag() names.Tag
 
+	// TLSConnectionState returns the state of the TLS handshake made
+	// with the API server, or false if the connection is not over TLS.
+	TLSConnectionState() (tls.ConnectionState, bool)
+
+	// LocalAddr and RemoteAddr return the local and remote addresses
+	// of the network connection to the API server, or nil if none.
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+
+	// BytesRead and BytesWritten return the numbers of bytes read
+	// from and written to the API server since the connection was
+	// opened, including those of its HTTP requests.
+	BytesRead() int64
+	BytesWritten() int64
+
+	// Pipeline returns a new Pipeline with which independent
+	// calls can be made over the connection concurrently.
+	Pipeline() *Pipeline
+
+	// SetReadLimit replaces DialOpts.MaxMessageBytes as the size of
+	// the largest message the connection will receive. If n is not
+	// positive, the size of messages is not limited.
+	SetReadLimit(n int64)
+
+	// ReadLimit returns the size of the largest message that the
+	// connection will receive from the API server, or 0 if it is
+	// not limited.
+	ReadLimit() int64
+
+	// Label returns DialOpts.Label, or,
+	// for a clone, the label given to Clone.
+	Label() string
+
+	// Stats returns the numbers of API calls made through the
+	// connection, excluding those of its clones, logins and pings.
+	Stats() CallStats
+
+	// Clone returns a new Connection with the given label that shares
+	// this one's network connection and login but counts its own Stats.
+	// Closing the clone leaves this connection open.
+	Clone(label string) Connection
+
+	// IsAnonymous reports whether the connection has not logged
+	// in, or logged in without identifying an entity.
+	IsAnonymous() bool
+
+	// Authenticated reports whether the connection has logged in,
+	// whether or not the login identified an entity. It says nothing
+	// of whether the connection is broken; see Broken and Health.
+	Authenticated() bool
+
 	// ModelAccess returns the access level of authorized user to the model.
 	ModelAccess() string
 
 	// ControllerAccess returns the access level of authorized user to the controller.
 	ControllerAccess() string
 
+	// LoginResult returns the outcome of
+	// the most recent successful login.
+	LoginResult() LoginResultInfo
+
 	// CookieURL returns the URL that HTTP cookies for the API will be
 	// associated with.
 	CookieURL() *url.URL
 
+	// ExportCookies returns copies of the cookies held
+	// in the connection's cookie jar for CookieURL.
+	ExportCookies() []*http.Cookie
+
 	// These methods expose a bunch of worker-specific facades, and basically
 	// just should not exist; but removing them is too noisy for a single CL.
 	// Client in particular is intimately coupled with State -- and the others
@@ -217,4 +513,8 @@ type Connection interface {
 	Cleaner() *cleaner.API
 	MetadataUpdater() *imagemetadata.Client
 	UnitAssigner() unitassigner.API
+
+	// CleanerFacade returns the Cleaner API, or an error satisfying
+	// errors.IsNotSupported if the API server does not support it.
+	CleanerFacade() (*cleaner.API, error)
 }
@@ -0,0 +1,70 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"net"
+
+	"github.com/juju/errors"
+)
+
+// IPVersionPreference says which IP versions are used
+// to connect to the API server; see DialOpts.IPVersionPreference.
+type IPVersionPreference string
+
+const (
+	// IPVersionAny uses addresses of either IP version.
+	IPVersionAny IPVersionPreference = "any"
+
+	// IPVersionIPv4 uses IPv4 addresses only.
+	IPVersionIPv4 IPVersionPreference = "ipv4"
+
+	// IPVersionIPv6 uses IPv6 addresses only.
+	IPVersionIPv6 IPVersionPreference = "ipv6"
+)
+
+// Validate returns an error if the preference is not one
+// of those defined above, or the empty string.
+func (p IPVersionPreference) Validate() error {
+	switch p {
+	case "", IPVersionAny, IPVersionIPv4, IPVersionIPv6:
+		return nil
+	}
+	return errors.NotValidf("IP version preference %q", string(p))
+}
+
+// tcpNetwork returns the network passed to net.Dial
+// to connect using the given IP versions.
+func (p IPVersionPreference) tcpNetwork() string {
+	switch p {
+	case IPVersionIPv4:
+		return "tcp4"
+	case IPVersionIPv6:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// filterIPVersion returns those of the given addresses that may be
+// dialed with the given preference: those that are not IP addresses of
+// the other version. Host names are kept, as they are resolved when
+// dialed, to addresses of the preferred version only. The addresses
+// are returned unchanged if either version may be used.
+func filterIPVersion(addrs []string, p IPVersionPreference) []string {
+	if p.tcpNetwork() == "tcp" {
+		return addrs
+	}
+	var result []string
+	for _, addr := range addrs {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if ip := net.ParseIP(host); ip != nil && (ip.To4() != nil) != (p == IPVersionIPv4) {
+			continue
+		}
+		result = append(result, addr)
+	}
+	return result
+}
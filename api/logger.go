@@ -0,0 +1,26 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+// Logger is the interface through which a connection logs the
+// messages it logs about itself, such as those reporting dialing,
+// reconnecting, failed health pings and breakage. It is implemented
+// by loggo.Logger, and can be implemented by an adapter for any
+// other logging system.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warningf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// connLogger returns the logger with which a connection
+// made with the given options logs: DialOpts.Logger, or the
+// package logger if it is nil.
+func connLogger(opts DialOpts) Logger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+	return logger
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+
+	"github.com/juju/juju/api"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type loggerSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&loggerSuite{})
+
+func (s *loggerSuite) TestReconnectAndBreakageLogged(c *gc.C) {
+	conn := &healthRPCConnection{}
+	logger := &recordingLogger{}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "localhost:17070",
+		RPCConnection: conn,
+		Clock:         &fakeClock{},
+		Label:         "agent",
+		Logger:        logger,
+	})
+
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(logger.messages(), gc.HasLen, 0)
+
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(logger.messages(), jc.DeepEquals, []string{
+		`INFO [agent] logged in again to "localhost:17070"`,
+	})
+
+	conn.setPingError(errors.New("boom"))
+	api.RunHeartbeatMonitor(st)
+	c.Assert(logger.messages(), jc.DeepEquals, []string{
+		`INFO [agent] logged in again to "localhost:17070"`,
+		`DEBUG [agent] health ping failed: boom`,
+		`WARNING [agent] connection to "localhost:17070" broken: health ping failed: boom`,
+	})
+}
+
+// recordingLogger is an api.Logger that records
+// the messages logged, prefixed by their level.
+type recordingLogger struct {
+	mu     sync.Mutex
+	logged []string
+}
+
+func (l *recordingLogger) log(level, format string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logged = append(l.logged, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.logged...)
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.log("DEBUG", format, args)
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.log("INFO", format, args)
+}
+
+func (l *recordingLogger) Warningf(format string, args ...interface{}) {
+	l.log("WARNING", format, args)
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.log("ERROR", format, args)
+}
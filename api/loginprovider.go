@@ -0,0 +1,181 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"net/url"
+
+	"github.com/juju/errors"
+	"github.com/juju/version"
+	"golang.org/x/net/context"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v1/httpbakery"
+	"gopkg.in/macaroon.v1"
+
+	"github.com/juju/juju/api/base"
+	"github.com/juju/juju/apiserver/params"
+)
+
+// LoginProvider is responsible for authenticating a newly
+// established API connection. It may be specified in DialOpts
+// to use an authentication scheme other than the built-in
+// tag, password and macaroon based login.
+type LoginProvider interface {
+	// Login authenticates with the API server using the given
+	// caller, returning the outcome of the login.
+	Login(ctx context.Context, caller base.APICaller) (*LoginResultInfo, error)
+}
+
+// DefaultLoginProvider returns a LoginProvider that logs in as the
+// entity with the given tag, using the given password or macaroons.
+// This is the login performed by Open when no LoginProvider is
+// specified. The bakery client is used to discharge any macaroons
+// required by the API server, and cookieURL is the URL that the
+// discharged macaroons will be associated with.
+func DefaultLoginProvider(
+	tag names.Tag,
+	password, nonce string,
+	macaroons []macaroon.Slice,
+	bakeryClient *httpbakery.Client,
+	cookieURL *url.URL,
+) LoginProvider {
+	return &defaultLoginProvider{
+		tag:          tag,
+		password:     password,
+		nonce:        nonce,
+		macaroons:    macaroons,
+		bakeryClient: bakeryClient,
+		cookieURL:    cookieURL,
+	}
+}
+
+// defaultLoginProvider is the LoginProvider returned
+// by DefaultLoginProvider.
+type defaultLoginProvider struct {
+	tag          names.Tag
+	password     string
+	nonce        string
+	macaroons    []macaroon.Slice
+	bakeryClient *httpbakery.Client
+	cookieURL    *url.URL
+}
+
+// Login implements LoginProvider.Login.
+func (p *defaultLoginProvider) Login(ctx context.Context, caller base.APICaller) (*LoginResultInfo, error) {
+	var result params.LoginResult
+	request := &params.LoginRequest{
+		AuthTag:     tagToString(p.tag),
+		Credentials: p.password,
+		Nonce:       p.nonce,
+		Macaroons:   p.macaroons,
+	}
+	if p.password == "" {
+		// Add any macaroons from the cookie jar that might work for
+		// authenticating the login request.
+		request.Macaroons = append(request.Macaroons,
+			httpbakery.MacaroonsForURL(p.bakeryClient.Client.Jar, p.cookieURL)...,
+		)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	err := caller.APICall("Admin", 3, "", "Login", request, &result)
+	if err != nil {
+		var resp params.RedirectInfoResult
+		if params.IsRedirect(err) {
+			// We've been asked to redirect. Find out the redirection info.
+			// If the rpc packet allowed us to return arbitrary information in
+			// an error, we'd probably put this information in the Login response,
+			// but we can't do that currently.
+			if err := caller.APICall("Admin", 3, "", "RedirectInfo", nil, &resp); err != nil {
+				return nil, errors.Annotatef(err, "cannot get redirect addresses")
+			}
+			return nil, &RedirectError{
+				Servers: params.NetworkHostsPorts(resp.Servers),
+				CACert:  resp.CACert,
+			}
+		}
+		return nil, errors.Trace(err)
+	}
+	if result.DischargeRequired != nil {
+		// The result contains a discharge-required
+		// macaroon. We discharge it and retry
+		// the login request with the original macaroon
+		// and its discharges.
+		if result.DischargeRequiredReason == "" {
+			result.DischargeRequiredReason = "no reason given for discharge requirement"
+		}
+		if err := p.bakeryClient.HandleError(p.cookieURL, &httpbakery.Error{
+			Message: result.DischargeRequiredReason,
+			Code:    httpbakery.ErrDischargeRequired,
+			Info: &httpbakery.ErrorInfo{
+				Macaroon:     result.DischargeRequired,
+				MacaroonPath: "/",
+			},
+		}); err != nil {
+			cause := errors.Cause(err)
+			if httpbakery.IsInteractionError(cause) {
+				// Just inform the user of the reason for the
+				// failure, e.g. because the username/password
+				// they presented was invalid.
+				err = cause.(*httpbakery.InteractionError).Reason
+			}
+			return nil, errors.Trace(err)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		// Add the macaroons that have been saved by HandleError to our login request.
+		request.Macaroons = httpbakery.MacaroonsForURL(p.bakeryClient.Client.Jar, p.cookieURL)
+		result = params.LoginResult{} // zero result
+		err = caller.APICall("Admin", 3, "", "Login", request, &result)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if result.DischargeRequired != nil {
+			return nil, errors.Errorf("login with discharged macaroons failed: %s", result.DischargeRequiredReason)
+		}
+	}
+	return newLoginResultInfo(p.tag, result)
+}
+
+// newLoginResultInfo returns the LoginResultInfo corresponding
+// to the given login result, for an entity that attempted to
+// log in with the given tag.
+func newLoginResultInfo(tag names.Tag, result params.LoginResult) (*LoginResultInfo, error) {
+	info := &LoginResultInfo{
+		AuthTag: tag,
+		Servers: params.NetworkHostsPorts(result.Servers),
+	}
+	if result.UserInfo != nil {
+		var err error
+		info.AuthTag, err = names.ParseTag(result.UserInfo.Identity)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		info.ControllerAccess = result.UserInfo.ControllerAccess
+		info.ModelAccess = result.UserInfo.ModelAccess
+	}
+	if result.ModelTag != "" {
+		modelTag, err := names.ParseModelTag(result.ModelTag)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid model tag in login result")
+		}
+		info.ModelTag = modelTag
+	}
+	controllerTag, err := names.ParseControllerTag(result.ControllerTag)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid controller tag %q returned from login", result.ControllerTag)
+	}
+	info.ControllerTag = controllerTag
+	info.ServerVersion, err = version.Parse(result.ServerVersion)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	info.Facades = make(map[string][]int, len(result.Facades))
+	for _, facade := range result.Facades {
+		info.Facades[facade.Name] = facade.Versions
+	}
+	return info, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/juju/juju/rpc"
+	"github.com/juju/juju/rpc/rpcreflect"
+)
+
+// notificationBufferSize is the number of notifications held for
+// a connection before further notifications are dropped.
+const notificationBufferSize = 100
+
+// Notification holds a message pushed by the API server outside
+// of any request made by the client, such as a report that the
+// leadership of an application has changed.
+type Notification struct {
+	// Facade holds the name of the facade the message concerns.
+	Facade string
+
+	// Kind holds the kind of message, such as the name
+	// of the event it reports.
+	Kind string
+
+	// Payload holds the message's parameters, undecoded, or nil
+	// if it has none. Its form depends on Facade and Kind.
+	Payload json.RawMessage
+}
+
+// Notifications implements Connection.Notifications.
+func (s *state) Notifications() <-chan Notification {
+	return s.notifications
+}
+
+// serveNotifications makes the given RPC connection deliver each
+// request made of it by the API server to the returned channel as a
+// Notification, in place of replying that it serves no requests.
+func serveNotifications(conn *rpc.Conn, label string, logger Logger) <-chan Notification {
+	notifications := make(chan Notification, notificationBufferSize)
+	conn.ServeRoot(&notificationRoot{
+		notifications: notifications,
+		label:         label,
+		logger:        logger,
+	}, nil)
+	return notifications
+}
+
+// notificationRoot is an rpc.Root that accepts
+// every request, delivering it as a Notification.
+type notificationRoot struct {
+	notifications chan<- Notification
+	label         string
+	logger        Logger
+}
+
+// FindMethod implements rpc.Root.FindMethod.
+func (r *notificationRoot) FindMethod(facade string, version int, kind string) (rpcreflect.MethodCaller, error) {
+	return &notificationCaller{
+		root:   r,
+		facade: facade,
+		kind:   kind,
+	}, nil
+}
+
+// Kill implements rpc.Killer.Kill. Notifications are
+// delivered without blocking, so there is nothing to abort.
+func (r *notificationRoot) Kill() {
+}
+
+// deliver sends the given notification, dropping it if the
+// channel is full: the RPC connection waits for its requests
+// to complete before closing, so must not wait for notifications
+// that nothing may receive.
+func (r *notificationRoot) deliver(n Notification) {
+	select {
+	case r.notifications <- n:
+	default:
+		r.logger.Warningf("%sdropping %s %s notification: %d notifications not yet received", logPrefix(r.label), n.Facade, n.Kind, notificationBufferSize)
+	}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+// notificationCaller is an rpcreflect.MethodCaller
+// that delivers the request it is called with.
+type notificationCaller struct {
+	root   *notificationRoot
+	facade string
+	kind   string
+}
+
+// ParamsType implements rpcreflect.MethodCaller.ParamsType.
+func (c *notificationCaller) ParamsType() reflect.Type {
+	return rawMessageType
+}
+
+// ResultType implements rpcreflect.MethodCaller.ResultType.
+func (c *notificationCaller) ResultType() reflect.Type {
+	return nil
+}
+
+// Call implements rpcreflect.MethodCaller.Call.
+func (c *notificationCaller) Call(objId string, arg reflect.Value) (reflect.Value, error) {
+	c.root.deliver(Notification{
+		Facade:  c.facade,
+		Kind:    c.kind,
+		Payload: arg.Interface().(json.RawMessage),
+	})
+	return reflect.Value{}, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/apiserver/observer"
+	"github.com/juju/juju/rpc"
+	"github.com/juju/juju/rpc/jsoncodec"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type notificationsSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&notificationsSuite{})
+
+func (s *notificationsSuite) TestPushedMessageIsNotified(c *gc.C) {
+	clientSide, serverSide := net.Pipe()
+	conn := rpc.NewConn(jsoncodec.NewNet(clientSide), observer.None())
+	notifications := api.ServeNotifications(conn)
+	conn.Start()
+	defer conn.Close()
+
+	// Push a message from the server side, as a request
+	// made of the client.
+	err := json.NewEncoder(serverSide).Encode(map[string]interface{}{
+		"request-id": 1,
+		"type":       "LeadershipService",
+		"version":    2,
+		"request":    "LeadershipChanged",
+		"params": map[string]string{
+			"application": "mysql",
+			"leader":      "mysql/1",
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+
+	select {
+	case n := <-notifications:
+		c.Assert(n.Facade, gc.Equals, "LeadershipService")
+		c.Assert(n.Kind, gc.Equals, "LeadershipChanged")
+		var payload map[string]string
+		err := json.Unmarshal(n.Payload, &payload)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(payload, jc.DeepEquals, map[string]string{
+			"application": "mysql",
+			"leader":      "mysql/1",
+		})
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("timed out waiting for notification")
+	}
+
+	// The message is acknowledged without error.
+	var reply struct {
+		RequestId uint64 `json:"request-id"`
+		Error     string `json:"error"`
+	}
+	err = json.NewDecoder(serverSide).Decode(&reply)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(reply.RequestId, gc.Equals, uint64(1))
+	c.Assert(reply.Error, gc.Equals, "")
+}
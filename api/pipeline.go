@@ -0,0 +1,86 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/api/base"
+)
+
+// Pipeline queues API calls that are independent of one another, so
+// that they can be made concurrently rather than one at a time, each
+// waiting a round trip for the last. The RPC connection multiplexes
+// concurrent requests, so a pipeline of calls takes little longer
+// than the slowest of them.
+//
+// A Pipeline is not safe to use concurrently.
+type Pipeline struct {
+	caller base.APICaller
+	calls  []*pipelinedCall
+}
+
+// pipelinedCall holds the arguments of a call queued in a Pipeline.
+type pipelinedCall struct {
+	facade   string
+	version  int
+	id       string
+	method   string
+	args     interface{}
+	response interface{}
+}
+
+// NewPipeline returns a Pipeline that makes calls with the given
+// caller, which may be a Connection or, for example, a caller
+// returned by RetryingCaller.
+func NewPipeline(caller base.APICaller) *Pipeline {
+	return &Pipeline{caller: caller}
+}
+
+// Pipeline implements Connection.Pipeline.
+func (s *state) Pipeline() *Pipeline {
+	return NewPipeline(s)
+}
+
+// Add queues a call to be made when the pipeline is next flushed,
+// taking the same arguments as base.APICaller.APICall. The response
+// is filled in when the pipeline is flushed, if the call succeeds.
+func (p *Pipeline) Add(facade string, version int, id, method string, args, response interface{}) {
+	p.calls = append(p.calls, &pipelinedCall{
+		facade:   facade,
+		version:  version,
+		id:       id,
+		method:   method,
+		args:     args,
+		response: response,
+	})
+}
+
+// Len returns the number of calls queued.
+func (p *Pipeline) Len() int {
+	return len(p.calls)
+}
+
+// Flush makes the calls queued concurrently, waits for them all to
+// complete, and empties the queue. It returns the error of each call,
+// in the order the calls were added, with nil for each call that
+// succeeded; the failure of one call does not affect the others.
+func (p *Pipeline) Flush() []error {
+	calls := p.calls
+	p.calls = nil
+	errs := make([]error, len(calls))
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call *pipelinedCall) {
+			defer wg.Done()
+			err := p.caller.APICall(call.facade, call.version, call.id, call.method, call.args, call.response)
+			errs[i] = errors.Trace(err)
+		}(i, call)
+	}
+	wg.Wait()
+	return errs
+}
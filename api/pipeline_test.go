@@ -0,0 +1,76 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	basetesting "github.com/juju/juju/api/base/testing"
+	"github.com/juju/juju/apiserver/params"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type pipelineSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&pipelineSuite{})
+
+func (s *pipelineSuite) TestFlushMakesCallsConcurrently(c *gc.C) {
+	// Each call waits until all three have been made,
+	// so the calls only complete if they are concurrent.
+	var started sync.WaitGroup
+	started.Add(3)
+	allStarted := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+	caller := basetesting.APICallerFunc(func(facade string, version int, id, method string, args, response interface{}) error {
+		started.Done()
+		select {
+		case <-allStarted:
+		case <-time.After(coretesting.LongWait):
+			return errors.New("calls not made concurrently")
+		}
+		switch method {
+		case "Status":
+			*(response.(*string)) = "status of " + args.(string)
+		case "Config":
+			*(response.(*int)) = version
+		case "Relations":
+			return &params.Error{Code: params.CodeNotFound, Message: "no relations for " + id}
+		}
+		return nil
+	})
+
+	pipeline := api.NewPipeline(caller)
+	var status string
+	var config int
+	var relations []string
+	pipeline.Add("Client", 1, "", "Status", "mysql", &status)
+	pipeline.Add("Application", 3, "", "Config", nil, &config)
+	pipeline.Add("Application", 3, "wordpress", "Relations", nil, &relations)
+	c.Assert(pipeline.Len(), gc.Equals, 3)
+
+	errs := pipeline.Flush()
+	c.Assert(errs, gc.HasLen, 3)
+	c.Check(errs[0], jc.ErrorIsNil)
+	c.Check(status, gc.Equals, "status of mysql")
+	c.Check(errs[1], jc.ErrorIsNil)
+	c.Check(config, gc.Equals, 3)
+	c.Check(errs[2], gc.ErrorMatches, "no relations for wordpress")
+	c.Check(errs[2], jc.Satisfies, params.IsCodeNotFound)
+	c.Check(relations, gc.IsNil)
+
+	// The queue is emptied by flushing it.
+	c.Assert(pipeline.Len(), gc.Equals, 0)
+	c.Assert(pipeline.Flush(), gc.HasLen, 0)
+}
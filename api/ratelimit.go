@@ -0,0 +1,73 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/clock"
+
+	"github.com/juju/juju/rpc"
+)
+
+// rateLimiter limits the rate at which API calls are made, allowing
+// bursts of up to a given number of calls. It is a token bucket,
+// implemented by tracking the time at which the bucket will next be
+// full.
+type rateLimiter struct {
+	clock    clock.Clock
+	interval time.Duration
+	burst    int
+
+	mu   sync.Mutex
+	full time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing the given number of
+// calls per second on average, in bursts of up to burst calls.
+func newRateLimiter(clock clock.Clock, limit float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		clock:    clock,
+		interval: time.Duration(float64(time.Second) / limit),
+		burst:    burst,
+	}
+}
+
+// reserve reserves the next call, returning the time to wait
+// before making it.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	next := l.full
+	if next.Before(now) {
+		next = now
+	}
+	l.full = next.Add(l.interval)
+	wait := next.Sub(now) - time.Duration(l.burst-1)*l.interval
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// wait blocks until the next call may be made, or until abort
+// is closed, in which case it returns an error.
+func (l *rateLimiter) wait(abort <-chan struct{}) error {
+	d := l.reserve()
+	if d == 0 {
+		return nil
+	}
+	select {
+	case <-l.clock.After(d):
+		return nil
+	case <-abort:
+		return errors.Trace(rpc.ErrShutdown)
+	}
+}
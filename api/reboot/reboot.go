@@ -43,8 +43,15 @@ type state struct {
 // NewState returns a version of the state that provides functionality
 // required by the reboot worker.
 func NewState(caller base.APICaller, machineTag names.MachineTag) State {
+	return NewStateFromFacade(base.NewFacadeCaller(caller, "Reboot"), machineTag)
+}
+
+// NewStateFromFacade returns a version of the state that provides
+// functionality required by the reboot worker, using the given
+// caller for the Reboot facade.
+func NewStateFromFacade(facade base.FacadeCaller, machineTag names.MachineTag) State {
 	return &state{
-		facade:     base.NewFacadeCaller(caller, "Reboot"),
+		facade:     facade,
 		machineTag: machineTag,
 	}
 }
@@ -0,0 +1,126 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/rpc"
+)
+
+// responseBudget limits the memory that may be taken by the responses
+// to API calls in flight. The size of a response is not known until
+// it has been received, so each call reserves the size of the last
+// response received to the same facade method, until it returns. The
+// first call of a method, whose responses have not been seen, reserves
+// the most that its response may take, as limited by the size of the
+// largest message the connection receives.
+type responseBudget struct {
+	max int64
+
+	mu   sync.Mutex
+	used int64
+	// released is closed, and replaced, whenever
+	// reserved bytes are returned to the budget.
+	released chan struct{}
+	// sizes holds the size of the last response received
+	// to each facade method, keyed by responseKey.
+	sizes map[string]int64
+}
+
+// newResponseBudget returns a responseBudget
+// allowing up to max bytes of responses.
+func newResponseBudget(max int64) *responseBudget {
+	return &responseBudget{
+		max:      max,
+		released: make(chan struct{}),
+		sizes:    make(map[string]int64),
+	}
+}
+
+// exemptFromResponseBudget reports whether calls of the given facade
+// method are exempt from DialOpts.MaxConcurrentResponseBytes. Pings
+// have no results, and the Next methods of watchers, whose results are
+// small, block until there is a change to report, for however long
+// that is, so would hold their reservations and hold up other calls
+// all the while.
+func exemptFromResponseBudget(facade, method string) bool {
+	return facade == "Pinger" || (method == "Next" && strings.HasSuffix(facade, "Watcher"))
+}
+
+// responseKey returns the key under which the sizes
+// of responses to the given facade method are held.
+func responseKey(facade, method string) string {
+	return facade + "." + method
+}
+
+// acquire blocks until the bytes that a response to the facade method
+// with the given key is expected to take may be reserved, and reserves
+// them, or until abort is closed, in which case it returns an error. If
+// no response to the method has been recorded, limit bytes are
+// reserved; a reservation larger than the whole budget, or one for a
+// response whose size is not limited, as given by a limit that is not
+// positive, is made for the whole budget. It returns the size of the
+// reservation, which must be passed to release once the call returns.
+func (b *responseBudget) acquire(key string, limit int64, abort <-chan struct{}) (int64, error) {
+	for {
+		b.mu.Lock()
+		n, ok := b.sizes[key]
+		if !ok {
+			n = limit
+			if n <= 0 {
+				n = b.max
+			}
+		}
+		if n > b.max {
+			n = b.max
+		}
+		if b.used+n <= b.max {
+			b.used += n
+			b.mu.Unlock()
+			return n, nil
+		}
+		released := b.released
+		b.mu.Unlock()
+		select {
+		case <-released:
+		case <-abort:
+			return 0, errors.Trace(rpc.ErrShutdown)
+		}
+	}
+}
+
+// release returns n bytes, reserved by acquire, to the budget.
+func (b *responseBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// record records the size of a response received
+// to the facade method with the given key.
+func (b *responseBudget) record(key string, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sizes[key] = size
+}
+
+// sizedResponse wraps the value into which the response to
+// a call is unmarshalled, recording the size of the response.
+type sizedResponse struct {
+	response interface{}
+	size     int64
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *sizedResponse) UnmarshalJSON(data []byte) error {
+	r.size = int64(len(data))
+	return json.Unmarshal(data, r.response)
+}
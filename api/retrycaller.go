@@ -0,0 +1,169 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/retry"
+	"github.com/juju/utils/clock"
+	"golang.org/x/net/context"
+
+	"github.com/juju/juju/api/base"
+	"github.com/juju/juju/apiserver/params"
+)
+
+// defaultRetryDelay is the delay before the first retry made
+// under a RetryPolicy whose Delay is zero.
+const defaultRetryDelay = 100 * time.Millisecond
+
+// RetryPolicy holds the parameters of the retries made by a caller
+// returned by RetryingCaller, or by CallWithRetry.
+type RetryPolicy struct {
+	// Attempts holds the largest number of times a call is made,
+	// including the first. If it is less than 2, calls are not
+	// retried.
+	Attempts int
+
+	// Delay holds the time waited before the first retry. It is
+	// doubled before each subsequent retry. If it is zero, 100ms
+	// is used.
+	Delay time.Duration
+
+	// MaxDelay, if positive, holds the longest time waited
+	// before any retry.
+	MaxDelay time.Duration
+
+	// Clock is used to time the delays between attempts.
+	// If it is nil, the wall clock is used.
+	Clock clock.Clock
+}
+
+// RetryingCaller returns a base.APICaller that makes calls using the
+// given connection, retrying any call that fails with a transient
+// error according to the given policy. If the last attempt fails, its
+// error is returned.
+//
+// Only errors with which the API server rejects a call without
+// running it, as it does while it is restarting for an upgrade or is
+// otherwise busy, are treated as transient. As such a call has not
+// been applied, it is safe to retry whether or not it is idempotent.
+// Errors that leave the outcome of a call unknown, such as the
+// connection being reset, are never retried: the call may already
+// have been applied, and the connection is unusable in any case.
+// Authorization and other errors are returned immediately.
+func RetryingCaller(conn Connection, policy RetryPolicy) base.APICaller {
+	return &retryingCaller{
+		Connection: conn,
+		policy:     policy.withDefaults(),
+	}
+}
+
+// retryingCaller is a base.APICaller that retries
+// calls that fail with transient errors.
+type retryingCaller struct {
+	Connection
+	policy RetryPolicy
+}
+
+// APICall implements base.APICaller.APICall.
+func (c *retryingCaller) APICall(facade string, version int, id, method string, args, response interface{}) error {
+	call := func() error {
+		return c.Connection.APICall(facade, version, id, method, args, response)
+	}
+	notify := func(err error, attempt int) {
+		logger.Debugf("%s(%d).%s failed on attempt %d, retrying: %v", facade, version, method, attempt, err)
+	}
+	return errors.Trace(retryCall(call, notify, c.policy, c.Connection.Broken()))
+}
+
+// CallWithRetry calls the given function, which should make one or
+// more calls using the given connection, such as through a facade,
+// retrying it according to the given policy for as long as it fails
+// with a transient error, as a caller returned by RetryingCaller does.
+// If the last attempt fails, its error is returned.
+//
+// Retries stop early if the connection is broken, in which case the
+// error of the last attempt is returned, or if the context is done,
+// in which case the context's error is returned. The function is not
+// called at all if the context is already done.
+//
+// As the function may make several calls, only the last of which
+// failed, it is safe to retry only if the calls before it are
+// idempotent.
+func CallWithRetry(ctx context.Context, conn Connection, call func() error, policy RetryPolicy) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-conn.Broken():
+		case <-finished:
+			return
+		}
+		close(stop)
+	}()
+	notify := func(err error, attempt int) {
+		logger.Debugf("call failed on attempt %d, retrying: %v", attempt, err)
+	}
+	err := retryCall(call, notify, policy.withDefaults(), stop)
+	if err != nil && ctx.Err() != nil {
+		return errors.Trace(ctx.Err())
+	}
+	return errors.Trace(err)
+}
+
+// withDefaults returns the policy with
+// defaults in place of unset fields.
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	if policy.Delay <= 0 {
+		policy.Delay = defaultRetryDelay
+	}
+	if policy.Clock == nil {
+		policy.Clock = clock.WallClock
+	}
+	return policy
+}
+
+// retryCall calls the given function, retrying it according to the
+// given policy, which has its defaults set, for as long as it fails
+// with a transient error and the given stop channel is open. The
+// given notify function is called before each retry. If the last
+// attempt fails, its error is returned.
+func retryCall(call func() error, notify func(err error, attempt int), policy RetryPolicy, stop <-chan struct{}) error {
+	err := retry.Call(retry.CallArgs{
+		Func: call,
+		IsFatalError: func(err error) bool {
+			return !isTransientCallError(err)
+		},
+		NotifyFunc:  notify,
+		Attempts:    policy.Attempts,
+		Delay:       policy.Delay,
+		MaxDelay:    policy.MaxDelay,
+		BackoffFunc: retry.DoubleDelay,
+		Clock:       policy.Clock,
+		Stop:        stop,
+	})
+	if retry.IsAttemptsExceeded(err) || retry.IsRetryStopped(err) {
+		// Return the error of the last attempt rather
+		// than that reporting why there were no more.
+		err = retry.LastError(err)
+	}
+	return err
+}
+
+// isTransientCallError reports whether err is one with which the API
+// server rejects a call without running it, so that the call may
+// succeed if made again later.
+func isTransientCallError(err error) bool {
+	return params.IsCodeTryAgain(err) || params.IsCodeUpgradeInProgress(err)
+}
@@ -0,0 +1,216 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	"golang.org/x/net/context"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/apiserver/params"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type retryingCallerSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&retryingCallerSuite{})
+
+var testRetryPolicy = api.RetryPolicy{
+	Attempts: 3,
+	Delay:    time.Millisecond,
+}
+
+func (s *retryingCallerSuite) TestRetriesTransientErrors(c *gc.C) {
+	conn := &flakyConnection{errs: []error{
+		&params.Error{Code: params.CodeUpgradeInProgress, Message: "upgrade in progress"},
+		&params.Error{Code: params.CodeTryAgain, Message: "try again"},
+	}}
+	caller := api.RetryingCaller(conn, testRetryPolicy)
+
+	var response string
+	err := caller.APICall("Facade", 1, "", "Method", nil, &response)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(response, gc.Equals, "hello")
+	c.Assert(conn.calls, gc.Equals, 3)
+}
+
+func (s *retryingCallerSuite) TestAttemptsExceeded(c *gc.C) {
+	conn := &flakyConnection{errs: []error{
+		&params.Error{Code: params.CodeTryAgain, Message: "first"},
+		&params.Error{Code: params.CodeTryAgain, Message: "second"},
+		&params.Error{Code: params.CodeTryAgain, Message: "third"},
+	}}
+	caller := api.RetryingCaller(conn, testRetryPolicy)
+
+	err := caller.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "third")
+	c.Assert(err, jc.Satisfies, params.IsCodeTryAgain)
+	c.Assert(conn.calls, gc.Equals, 3)
+}
+
+func (s *retryingCallerSuite) TestAuthErrorNotRetried(c *gc.C) {
+	conn := &flakyConnection{errs: []error{
+		&params.Error{Code: params.CodeUnauthorized, Message: "permission denied"},
+	}}
+	caller := api.RetryingCaller(conn, testRetryPolicy)
+
+	err := caller.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "permission denied")
+	c.Assert(err, jc.Satisfies, params.IsCodeUnauthorized)
+	c.Assert(conn.calls, gc.Equals, 1)
+}
+
+func (s *retryingCallerSuite) TestConnectionErrorNotRetried(c *gc.C) {
+	conn := &flakyConnection{errs: []error{
+		errors.New("connection reset by peer"),
+	}}
+	caller := api.RetryingCaller(conn, testRetryPolicy)
+
+	err := caller.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "connection reset by peer")
+	c.Assert(conn.calls, gc.Equals, 1)
+}
+
+func (s *retryingCallerSuite) TestNoRetriesByDefault(c *gc.C) {
+	conn := &flakyConnection{errs: []error{
+		&params.Error{Code: params.CodeTryAgain, Message: "try again"},
+	}}
+	caller := api.RetryingCaller(conn, api.RetryPolicy{})
+
+	err := caller.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "try again")
+	c.Assert(conn.calls, gc.Equals, 1)
+}
+
+func (s *retryingCallerSuite) TestStopsWhenBroken(c *gc.C) {
+	broken := make(chan struct{})
+	close(broken)
+	conn := &flakyConnection{
+		broken: broken,
+		errs: []error{
+			&params.Error{Code: params.CodeTryAgain, Message: "try again"},
+		},
+	}
+	caller := api.RetryingCaller(conn, api.RetryPolicy{
+		Attempts: 3,
+		Delay:    coretesting.LongWait,
+	})
+
+	err := caller.APICall("Facade", 1, "", "Method", nil, nil)
+	c.Assert(err, gc.ErrorMatches, "try again")
+	c.Assert(conn.calls, gc.Equals, 1)
+}
+
+func (s *retryingCallerSuite) TestCallWithRetrySucceedsFirstTime(c *gc.C) {
+	calls := 0
+	err := api.CallWithRetry(context.Background(), &flakyConnection{}, func() error {
+		calls++
+		return nil
+	}, testRetryPolicy)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, gc.Equals, 1)
+}
+
+func (s *retryingCallerSuite) TestCallWithRetryRetriesTransientErrors(c *gc.C) {
+	errs := []error{
+		&params.Error{Code: params.CodeTryAgain, Message: "try again"},
+		&params.Error{Code: params.CodeUpgradeInProgress, Message: "upgrade in progress"},
+		nil,
+	}
+	calls := 0
+	err := api.CallWithRetry(context.Background(), &flakyConnection{}, func() error {
+		calls++
+		return errs[calls-1]
+	}, testRetryPolicy)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, gc.Equals, 3)
+}
+
+func (s *retryingCallerSuite) TestCallWithRetryFatalErrorNotRetried(c *gc.C) {
+	calls := 0
+	err := api.CallWithRetry(context.Background(), &flakyConnection{}, func() error {
+		calls++
+		return &params.Error{Code: params.CodeUnauthorized, Message: "permission denied"}
+	}, testRetryPolicy)
+	c.Assert(err, gc.ErrorMatches, "permission denied")
+	c.Assert(err, jc.Satisfies, params.IsCodeUnauthorized)
+	c.Assert(calls, gc.Equals, 1)
+}
+
+func (s *retryingCallerSuite) TestCallWithRetryStopsWhenContextCancelled(c *gc.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	err := api.CallWithRetry(ctx, &flakyConnection{}, func() error {
+		calls++
+		cancel()
+		return &params.Error{Code: params.CodeTryAgain, Message: "try again"}
+	}, api.RetryPolicy{
+		Attempts: 3,
+		Delay:    coretesting.LongWait,
+	})
+	c.Assert(errors.Cause(err), gc.Equals, context.Canceled)
+	c.Assert(calls, gc.Equals, 1)
+}
+
+func (s *retryingCallerSuite) TestCallWithRetryNotCalledWhenContextDone(c *gc.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := api.CallWithRetry(ctx, &flakyConnection{}, func() error {
+		calls++
+		return nil
+	}, testRetryPolicy)
+	c.Assert(errors.Cause(err), gc.Equals, context.Canceled)
+	c.Assert(calls, gc.Equals, 0)
+}
+
+func (s *retryingCallerSuite) TestCallWithRetryStopsWhenBroken(c *gc.C) {
+	broken := make(chan struct{})
+	calls := 0
+	err := api.CallWithRetry(context.Background(), &flakyConnection{broken: broken}, func() error {
+		calls++
+		close(broken)
+		return &params.Error{Code: params.CodeTryAgain, Message: "try again"}
+	}, api.RetryPolicy{
+		Attempts: 3,
+		Delay:    coretesting.LongWait,
+	})
+	c.Assert(err, gc.ErrorMatches, "try again")
+	c.Assert(err, jc.Satisfies, params.IsCodeTryAgain)
+	c.Assert(calls, gc.Equals, 1)
+}
+
+// flakyConnection is an api.Connection whose APICall method
+// returns each of errs in turn, and then succeeds, storing
+// "hello" in the response.
+type flakyConnection struct {
+	api.Connection
+	broken chan struct{}
+	errs   []error
+	calls  int
+}
+
+func (c *flakyConnection) APICall(facade string, version int, id, method string, args, response interface{}) error {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return err
+	}
+	if r, ok := response.(*string); ok {
+		*r = "hello"
+	}
+	return nil
+}
+
+func (c *flakyConnection) Broken() <-chan struct{} {
+	return c.broken
+}
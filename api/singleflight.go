@@ -0,0 +1,80 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// SingleflightOpen returns an OpenFunc that opens connections using
+// the given function, coalescing calls made while another for the
+// same Info is in progress into that one: rather than each opening a
+// connection, they wait for it to return and share its result, so
+// that goroutines that all find a connection broken at once do not
+// all dial and log in to the API server again.
+//
+// Calls for the same Info share a single Connection, so it must only
+// be closed once none of them uses it. The DialOpts of the call that
+// opens the connection are used; those of the calls that wait for it
+// are ignored. A call made once the connection has been opened opens
+// another.
+func SingleflightOpen(open OpenFunc) OpenFunc {
+	var (
+		mu    sync.Mutex
+		calls = make(map[[sha256.Size]byte]*openCall)
+	)
+	return func(info *Info, opts DialOpts) (Connection, error) {
+		key := infoKey(info)
+		mu.Lock()
+		if call, ok := calls[key]; ok {
+			mu.Unlock()
+			<-call.done
+			return call.conn, call.err
+		}
+		call := &openCall{done: make(chan struct{})}
+		calls[key] = call
+		mu.Unlock()
+
+		call.conn, call.err = open(info, opts)
+
+		mu.Lock()
+		delete(calls, key)
+		mu.Unlock()
+		close(call.done)
+		return call.conn, call.err
+	}
+}
+
+// openCall holds a call to open a connection that
+// is in progress, and its result once done is closed.
+type openCall struct {
+	done chan struct{}
+	conn Connection
+	err  error
+}
+
+// infoKey returns a digest of the given Info, equal for Infos with
+// which the same connection would be opened. A digest is used, rather
+// than the fields themselves, so that passwords are not kept.
+func infoKey(info *Info) [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n%q\n%q\n%q\n%v\n", info.Addrs, info.CACert, info.ModelTag.String(), info.ControllerTag.String(), info.SkipLogin)
+	if info.Tag != nil {
+		fmt.Fprintf(h, "%q\n", info.Tag.String())
+	} else {
+		fmt.Fprintf(h, "\n")
+	}
+	fmt.Fprintf(h, "%q\n%q\n", info.Password, info.Nonce)
+	for _, ms := range info.Macaroons {
+		for _, m := range ms {
+			fmt.Fprintf(h, "%x ", m.Signature())
+		}
+		fmt.Fprintf(h, "\n")
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
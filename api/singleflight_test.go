@@ -0,0 +1,132 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	coretesting "github.com/juju/juju/testing"
+)
+
+type singleflightOpenSuite struct {
+	coretesting.BaseSuite
+}
+
+var _ = gc.Suite(&singleflightOpenSuite{})
+
+// blockingOpen returns an OpenFunc that returns the given connection
+// and error once release is closed, counting the times it is called.
+func blockingOpen(conn api.Connection, err error, release <-chan struct{}) (api.OpenFunc, func() int) {
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	open := func(*api.Info, api.DialOpts) (api.Connection, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return conn, err
+	}
+	return open, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+// openConcurrently calls open n times at once with the given info,
+// closing release once they have all been made, and returns the
+// results.
+func openConcurrently(open api.OpenFunc, info *api.Info, n int, release chan struct{}) ([]api.Connection, []error) {
+	conns := make([]api.Connection, n)
+	errs := make([]error, n)
+	var started, done sync.WaitGroup
+	started.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer done.Done()
+			started.Done()
+			conns[i], errs[i] = open(info, api.DialOpts{})
+		}(i)
+	}
+	started.Wait()
+	// Give the calls time to find the one in progress.
+	time.Sleep(coretesting.ShortWait)
+	close(release)
+	done.Wait()
+	return conns, errs
+}
+
+func (s *singleflightOpenSuite) TestConcurrentOpensCoalesced(c *gc.C) {
+	conn := api.NewTestingState(api.TestingStateParams{})
+	release := make(chan struct{})
+	open, calls := blockingOpen(conn, nil, release)
+	info := &api.Info{Addrs: []string{"0.1.2.3:17070"}, Password: "secret"}
+
+	conns, errs := openConcurrently(api.SingleflightOpen(open), info, 20, release)
+	c.Assert(calls(), gc.Equals, 1)
+	for i := range conns {
+		c.Check(errs[i], jc.ErrorIsNil)
+		c.Check(conns[i], gc.Equals, conn)
+	}
+}
+
+func (s *singleflightOpenSuite) TestConcurrentOpensShareError(c *gc.C) {
+	release := make(chan struct{})
+	open, calls := blockingOpen(nil, errors.New("boom"), release)
+	info := &api.Info{Addrs: []string{"0.1.2.3:17070"}}
+
+	conns, errs := openConcurrently(api.SingleflightOpen(open), info, 10, release)
+	c.Assert(calls(), gc.Equals, 1)
+	for i := range conns {
+		c.Check(errs[i], gc.ErrorMatches, "boom")
+		c.Check(conns[i], gc.IsNil)
+	}
+}
+
+func (s *singleflightOpenSuite) TestOpensForDifferentInfoNotCoalesced(c *gc.C) {
+	release := make(chan struct{})
+	open, calls := blockingOpen(nil, nil, release)
+	singleflight := api.SingleflightOpen(open)
+
+	var done sync.WaitGroup
+	for _, password := range []string{"one", "two"} {
+		info := &api.Info{Addrs: []string{"0.1.2.3:17070"}, Password: password}
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			_, err := singleflight(info, api.DialOpts{})
+			c.Check(err, jc.ErrorIsNil)
+		}()
+	}
+	for a := coretesting.LongAttempt.Start(); a.Next(); {
+		if calls() == 2 {
+			break
+		}
+	}
+	c.Assert(calls(), gc.Equals, 2)
+	close(release)
+	done.Wait()
+}
+
+func (s *singleflightOpenSuite) TestSequentialOpensNotCoalesced(c *gc.C) {
+	release := make(chan struct{})
+	close(release)
+	open, calls := blockingOpen(nil, nil, release)
+	singleflight := api.SingleflightOpen(open)
+	info := &api.Info{Addrs: []string{"0.1.2.3:17070"}}
+	for i := 0; i < 2; i++ {
+		_, err := singleflight(info, api.DialOpts{})
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	c.Assert(calls(), gc.Equals, 2)
+}
@@ -4,12 +4,15 @@
 package api
 
 import (
+	"crypto/tls"
 	"net"
+	"net/http"
 	"net/url"
 	"strconv"
 
 	"github.com/juju/errors"
 	"github.com/juju/version"
+	"golang.org/x/net/context"
 	"gopkg.in/juju/names.v2"
 	"gopkg.in/macaroon-bakery.v1/httpbakery"
 	"gopkg.in/macaroon.v1"
@@ -34,165 +37,400 @@ import (
 // This method is usually called automatically by Open. The machine nonce
 // should be empty unless logging in as a machine agent.
 func (st *state) Login(tag names.Tag, password, nonce string, macaroons []macaroon.Slice) error {
-	var result params.LoginResult
-	request := &params.LoginRequest{
-		AuthTag:     tagToString(tag),
-		Credentials: password,
-		Nonce:       nonce,
-		Macaroons:   macaroons,
-	}
-	if password == "" {
-		// Add any macaroons from the cookie jar that might work for
-		// authenticating the login request.
-		request.Macaroons = append(request.Macaroons,
-			httpbakery.MacaroonsForURL(st.bakeryClient.Client.Jar, st.cookieURL)...,
-		)
-	}
-	err := st.APICall("Admin", 3, "", "Login", request, &result)
+	p, err := st.builtinLoginProvider(tag, password, nonce, macaroons)
 	if err != nil {
-		var resp params.RedirectInfoResult
-		if params.IsRedirect(err) {
-			// We've been asked to redirect. Find out the redirection info.
-			// If the rpc packet allowed us to return arbitrary information in
-			// an error, we'd probably put this information in the Login response,
-			// but we can't do that currently.
-			if err := st.APICall("Admin", 3, "", "RedirectInfo", nil, &resp); err != nil {
-				return errors.Annotatef(err, "cannot get redirect addresses")
-			}
-			return &RedirectError{
-				Servers: params.NetworkHostsPorts(resp.Servers),
-				CACert:  resp.CACert,
-			}
-		}
 		return errors.Trace(err)
 	}
-	if result.DischargeRequired != nil {
-		// The result contains a discharge-required
-		// macaroon. We discharge it and retry
-		// the login request with the original macaroon
-		// and its discharges.
-		if result.DischargeRequiredReason == "" {
-			result.DischargeRequiredReason = "no reason given for discharge requirement"
-		}
-		if err := st.bakeryClient.HandleError(st.cookieURL, &httpbakery.Error{
-			Message: result.DischargeRequiredReason,
-			Code:    httpbakery.ErrDischargeRequired,
-			Info: &httpbakery.ErrorInfo{
-				Macaroon:     result.DischargeRequired,
-				MacaroonPath: "/",
-			},
-		}); err != nil {
-			cause := errors.Cause(err)
-			if httpbakery.IsInteractionError(cause) {
-				// Just inform the user of the reason for the
-				// failure, e.g. because the username/password
-				// they presented was invalid.
-				err = cause.(*httpbakery.InteractionError).Reason
-			}
-			return errors.Trace(err)
+	return errors.Trace(st.loginWithProvider(p))
+}
+
+// builtinLoginProvider returns the LoginProvider used to log in
+// with the given credentials when no other is specified: that
+// returned by DefaultLoginProvider, with the password discarded
+// if the connection requires macaroon authentication.
+func (st *state) builtinLoginProvider(tag names.Tag, password, nonce string, macaroons []macaroon.Slice) (LoginProvider, error) {
+	if st.requireMacaroonAuth {
+		if tag != nil && tag.Kind() != names.UserTagKind {
+			return nil, errors.Errorf("cannot log in as %s: macaroon authentication required", names.ReadableString(tag))
 		}
-		// Add the macaroons that have been saved by HandleError to our login request.
-		request.Macaroons = httpbakery.MacaroonsForURL(st.bakeryClient.Client.Jar, st.cookieURL)
-		result = params.LoginResult{} // zero result
-		err = st.APICall("Admin", 3, "", "Login", request, &result)
-		if err != nil {
-			return errors.Trace(err)
+		password = ""
+	}
+	return DefaultLoginProvider(tag, password, nonce, macaroons, st.bakeryClient, st.cookieURL), nil
+}
+
+// passwordUnlessMacaroonAuth returns the given password, or
+// the empty string if the given options require macaroon
+// authentication.
+func passwordUnlessMacaroonAuth(password string, opts DialOpts) string {
+	if opts.RequireMacaroonAuth {
+		return ""
+	}
+	return password
+}
+
+// ForModel implements Connection.ForModel.
+func (st *state) ForModel(modelTag names.ModelTag) (Connection, error) {
+	if st.dialInfo == nil {
+		return nil, errors.NotSupportedf("ForModel on a connection not made by Open")
+	}
+	st.credentialsMu.Lock()
+	info := *st.dialInfo
+	st.credentialsMu.Unlock()
+	info.ModelTag = modelTag
+	// Try the address in use first, as it is known to work.
+	info.Addrs = append([]string{st.addr}, info.Addrs...)
+	for i := 1; i < len(info.Addrs); i++ {
+		if info.Addrs[i] == st.addr {
+			info.Addrs = append(info.Addrs[:i], info.Addrs[i+1:]...)
+			break
 		}
-		if result.DischargeRequired != nil {
-			return errors.Errorf("login with discharged macaroons failed: %s", result.DischargeRequiredReason)
+	}
+	info.SkipLogin = !st.isLoggedIn()
+	opts := st.dialOpts
+	if !info.SkipLogin && passwordUnlessMacaroonAuth(info.Password, opts) == "" {
+		// Log in with the macaroons in use, including any discharges,
+		// so that they need not be acquired again.
+		info.Macaroons = st.Macaroons()
+	}
+	// Share the cookie jar, so that any discharges acquired by
+	// either connection are available to the other.
+	opts.BakeryClient = st.bakeryClient
+	conn, err := open(&info, opts, st.clock)
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot connect to model %q", modelTag.Id())
+	}
+	return conn, nil
+}
+
+// Macaroons implements Connection.Macaroons.
+func (st *state) Macaroons() []macaroon.Slice {
+	_, _, _, all := st.loginCredentials()
+	if st.bakeryClient != nil && st.bakeryClient.Client.Jar != nil {
+		// Discharges acquired during login are held in the
+		// cookie jar, along with the macaroons they discharge.
+		all = append(all[:len(all):len(all)], httpbakery.MacaroonsForURL(st.bakeryClient.Client.Jar, st.cookieURL)...)
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	copies := make([]macaroon.Slice, len(all))
+	for i, ms := range all {
+		copies[i] = make(macaroon.Slice, len(ms))
+		for j, m := range ms {
+			copies[i][j] = m.Clone()
 		}
 	}
+	return copies
+}
 
-	var controllerAccess string
-	var modelAccess string
-	if result.UserInfo != nil {
-		tag, err = names.ParseTag(result.UserInfo.Identity)
-		if err != nil {
-			return errors.Trace(err)
+// EnsureLogin implements Connection.EnsureLogin.
+func (st *state) EnsureLogin(tag names.Tag, password, nonce string, macaroons []macaroon.Slice) error {
+	if tag != nil && st.isLoggedIn() {
+		st.loginResultMu.Lock()
+		same := !st.anonymous && st.authTag.String() == tag.String()
+		st.loginResultMu.Unlock()
+		if same {
+			return nil
 		}
-		controllerAccess = result.UserInfo.ControllerAccess
-		modelAccess = result.UserInfo.ModelAccess
-	}
-	servers := params.NetworkHostsPorts(result.Servers)
-	if err = st.setLoginResult(loginResultParams{
-		tag:              tag,
-		modelTag:         result.ModelTag,
-		controllerTag:    result.ControllerTag,
-		servers:          servers,
-		facades:          result.Facades,
-		modelAccess:      modelAccess,
-		controllerAccess: controllerAccess,
-	}); err != nil {
+	}
+	return errors.Trace(st.Login(tag, password, nonce, macaroons))
+}
+
+// ChangeUser implements Connection.ChangeUser.
+func (st *state) ChangeUser(tag names.Tag, password string, ms []macaroon.Slice) error {
+	p, err := st.builtinLoginProvider(tag, password, "", ms)
+	if err != nil {
 		return errors.Trace(err)
 	}
-	st.serverVersion, err = version.Parse(result.ServerVersion)
+	result, err := p.Login(context.Background(), internalCaller{st})
+	if params.IsCodeNotImplemented(err) {
+		// Once logged in, the API server replaces the Admin facade
+		// with the facades available to the authenticated entity.
+		return errors.NotSupportedf("changing user on an established connection")
+	}
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err := st.setLoginResult(result); err != nil {
+		return errors.Trace(err)
+	}
+	// The connection itself has not changed, so this
+	// is not counted or reported as a reconnect.
+	st.setCredentials(tag, password, ms)
 	return nil
 }
 
-type loginResultParams struct {
-	tag              names.Tag
-	modelTag         string
-	controllerTag    string
-	modelAccess      string
-	controllerAccess string
-	servers          [][]network.HostPort
-	facades          []params.FacadeVersions
+// loginCredentials returns the cached login credentials.
+func (st *state) loginCredentials() (tag, password, nonce string, macaroons []macaroon.Slice) {
+	st.credentialsMu.Lock()
+	defer st.credentialsMu.Unlock()
+	return st.tag, st.password, st.nonce, st.macaroons
 }
 
-func (st *state) setLoginResult(p loginResultParams) error {
-	st.authTag = p.tag
-	var modelTag names.ModelTag
-	if p.modelTag != "" {
-		var err error
-		modelTag, err = names.ParseModelTag(p.modelTag)
-		if err != nil {
-			return errors.Annotatef(err, "invalid model tag in login result")
-		}
+// setCredentials replaces the cached login credentials, and those
+// used by ForModel, with those of a user logged in by ChangeUser.
+func (st *state) setCredentials(tag names.Tag, password string, ms []macaroon.Slice) {
+	st.credentialsMu.Lock()
+	defer st.credentialsMu.Unlock()
+	st.tag = tagToString(tag)
+	st.password = password
+	if st.requireMacaroonAuth {
+		st.password = ""
 	}
-	if modelTag.Id() != st.modelTag.Id() {
-		return errors.Errorf("mismatched model tag in login result (got %q want %q)", modelTag.Id(), st.modelTag.Id())
+	st.macaroons = ms
+	st.nonce = ""
+	if st.dialInfo != nil {
+		info := *st.dialInfo
+		info.Tag = tag
+		info.Password = password
+		info.Macaroons = ms
+		info.Nonce = ""
+		st.dialInfo = &info
 	}
-	ctag, err := names.ParseControllerTag(p.controllerTag)
+}
+
+// loginWithProvider authenticates using the given login provider,
+// recording the result on success.
+func (st *state) loginWithProvider(p LoginProvider) error {
+	relogin := st.isLoggedIn()
+	result, err := p.Login(context.Background(), internalCaller{st})
 	if err != nil {
-		return errors.Annotatef(err, "invalid controller tag %q returned from login", p.controllerTag)
+		return errors.Trace(err)
 	}
-	st.controllerTag = ctag
-	st.controllerAccess = p.controllerAccess
-	st.modelAccess = p.modelAccess
+	if err := st.setLoginResult(result); err != nil {
+		return errors.Trace(err)
+	}
+	if relogin {
+		st.runReconnectCallbacks()
+	}
+	return nil
+}
 
-	hostPorts, err := addAddress(p.servers, st.addr)
+// reconnectCallback holds a callback registered with OnReconnect.
+// It is referred to by pointer so that it can be deregistered even
+// if the same function is registered more than once.
+type reconnectCallback struct {
+	f func()
+}
+
+// OnReconnect implements Connection.OnReconnect.
+func (st *state) OnReconnect(f func()) func() {
+	cb := &reconnectCallback{f}
+	st.reconnectMu.Lock()
+	st.reconnectCallbacks = append(st.reconnectCallbacks, cb)
+	st.reconnectMu.Unlock()
+	return func() {
+		st.reconnectMu.Lock()
+		defer st.reconnectMu.Unlock()
+		for i, other := range st.reconnectCallbacks {
+			if other == cb {
+				st.reconnectCallbacks = append(st.reconnectCallbacks[:i:i], st.reconnectCallbacks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// connectCallback holds a callback registered with OnConnect.
+// It is referred to by pointer so that it can be deregistered even
+// if the same function is registered more than once.
+type connectCallback struct {
+	f func(addr string)
+}
+
+// OnConnect implements Connection.OnConnect.
+func (st *state) OnConnect(f func(addr string)) func() {
+	cb := &connectCallback{f}
+	st.reconnectRunMu.Lock()
+	// reconnectRunMu is held while f is registered and first
+	// called, so that it is called for the connect already made
+	// before any reconnect, and never alongside other callbacks.
+	st.reconnectMu.Lock()
+	st.connectCallbacks = append(st.connectCallbacks, cb)
+	st.reconnectMu.Unlock()
+	f(st.addr)
+	st.reconnectRunMu.Unlock()
+	return func() {
+		st.reconnectMu.Lock()
+		defer st.reconnectMu.Unlock()
+		for i, other := range st.connectCallbacks {
+			if other == cb {
+				st.connectCallbacks = append(st.connectCallbacks[:i:i], st.connectCallbacks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// runReconnectCallbacks calls the callbacks registered with
+// OnConnect and then those registered with OnReconnect, each in
+// registration order. They are called without reconnectMu held,
+// so may register or deregister callbacks.
+func (st *state) runReconnectCallbacks() {
+	st.reconnectRunMu.Lock()
+	defer st.reconnectRunMu.Unlock()
+	st.healthMu.Lock()
+	st.reconnects++
+	st.lastConnectedAt = st.clock.Now()
+	st.healthMu.Unlock()
+	st.logger.Infof("%slogged in again to %q", logPrefix(st.label), st.addr)
+	st.reconnectMu.Lock()
+	connectCallbacks := st.connectCallbacks
+	callbacks := st.reconnectCallbacks
+	st.reconnectMu.Unlock()
+	for _, cb := range connectCallbacks {
+		cb.f(st.addr)
+	}
+	for _, cb := range callbacks {
+		cb.f()
+	}
+}
+
+func (st *state) setLoginResult(p *LoginResultInfo) error {
+	if p.ModelTag.Id() != st.modelTag.Id() {
+		return errors.Errorf("mismatched model tag in login result (got %q want %q)", p.ModelTag.Id(), st.modelTag.Id())
+	}
+	st.loginResultMu.Lock()
+	st.authTag = p.AuthTag
+	st.anonymous = p.AuthTag == nil
+	st.controllerTag = p.ControllerTag
+	st.controllerAccess = p.ControllerAccess
+	st.modelAccess = p.ModelAccess
+	st.serverVersion = p.ServerVersion
+	st.loginResultMu.Unlock()
+
+	hostPorts, err := addAddress(p.Servers, st.addr)
 	if err != nil {
 		if clerr := st.Close(); clerr != nil {
 			err = errors.Annotatef(err, "error closing state: %v", clerr)
 		}
 		return err
 	}
-	st.hostPorts = hostPorts
+	st.setHostPorts(hostPorts)
 
-	st.facadeVersions = make(map[string][]int, len(p.facades))
-	for _, facade := range p.facades {
-		st.facadeVersions[facade.Name] = facade.Versions
-	}
+	st.legacyFacadeVersions = st.allowLegacyLogin && len(p.Facades) == 0
+	st.setFacadeVersions(p.Facades)
 
 	st.setLoggedIn()
 	return nil
 }
 
+// LoginResultInfo holds the outcome of a successful login, as
+// reported by the API server.
+type LoginResultInfo struct {
+	// AuthTag holds the tag of the authenticated entity.
+	AuthTag names.Tag
+
+	// ControllerTag holds the tag of the controller.
+	ControllerTag names.ControllerTag
+
+	// ModelTag holds the tag of the connected model. It is
+	// empty for a controller-only login.
+	ModelTag names.ModelTag
+
+	// ControllerAccess holds the access level of the
+	// authenticated user to the controller.
+	ControllerAccess string
+
+	// ModelAccess holds the access level of the
+	// authenticated user to the model.
+	ModelAccess string
+
+	// ServerVersion holds the version of the API server.
+	// This may be zero if the server did not report it.
+	ServerVersion version.Number
+
+	// Servers holds the API server addresses returned from
+	// login, which the client may cache and use for failover.
+	Servers [][]network.HostPort
+
+	// Facades holds the versions of all facades supported by
+	// the API server, keyed by facade name.
+	Facades map[string][]int
+}
+
+// TLSConnectionState implements Connection.TLSConnectionState.
+func (st *state) TLSConnectionState() (tls.ConnectionState, bool) {
+	if st.tlsState == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *st.tlsState, true
+}
+
+// LocalAddr implements Connection.LocalAddr.
+func (st *state) LocalAddr() net.Addr {
+	return st.localAddr
+}
+
+// RemoteAddr implements Connection.RemoteAddr.
+func (st *state) RemoteAddr() net.Addr {
+	return st.remoteAddr
+}
+
+// BytesRead implements Connection.BytesRead.
+func (st *state) BytesRead() int64 {
+	return st.counts.Read()
+}
+
+// BytesWritten implements Connection.BytesWritten.
+func (st *state) BytesWritten() int64 {
+	return st.counts.Written()
+}
+
+// IsAnonymous implements Connection.IsAnonymous.
+func (st *state) IsAnonymous() bool {
+	if !st.isLoggedIn() {
+		return true
+	}
+	st.loginResultMu.Lock()
+	defer st.loginResultMu.Unlock()
+	return st.anonymous
+}
+
+// Authenticated implements Connection.Authenticated.
+func (st *state) Authenticated() bool {
+	return st.isLoggedIn()
+}
+
+// LoginResult returns the outcome of the most recent successful login.
+// The zero value is returned if the connection has not logged in.
+func (st *state) LoginResult() LoginResultInfo {
+	if !st.isLoggedIn() {
+		return LoginResultInfo{}
+	}
+	st.loginResultMu.Lock()
+	result := LoginResultInfo{
+		AuthTag:          st.authTag,
+		ControllerTag:    st.controllerTag,
+		ModelTag:         st.modelTag,
+		ControllerAccess: st.controllerAccess,
+		ModelAccess:      st.modelAccess,
+		ServerVersion:    st.serverVersion,
+	}
+	st.loginResultMu.Unlock()
+	result.Servers = st.APIHostPorts()
+	result.Facades = st.AllFacadeVersions()
+	return result
+}
+
 // AuthTag returns the tag of the authorized user of the state API connection.
 func (st *state) AuthTag() names.Tag {
+	st.loginResultMu.Lock()
+	defer st.loginResultMu.Unlock()
 	return st.authTag
 }
 
 // ModelAccess returns the access level of authorized user to the model.
 func (st *state) ModelAccess() string {
+	st.loginResultMu.Lock()
+	defer st.loginResultMu.Unlock()
 	return st.modelAccess
 }
 
 // ControllerAccess returns the access level of authorized user to the model.
 func (st *state) ControllerAccess() string {
+	st.loginResultMu.Lock()
+	defer st.loginResultMu.Unlock()
 	return st.controllerAccess
 }
 
@@ -203,6 +441,24 @@ func (st *state) CookieURL() *url.URL {
 	return &copy
 }
 
+// ExportCookies implements Connection.ExportCookies.
+func (st *state) ExportCookies() []*http.Cookie {
+	if st.bakeryClient == nil || st.bakeryClient.Client.Jar == nil {
+		return nil
+	}
+	cookies := st.bakeryClient.Client.Jar.Cookies(st.cookieURL)
+	if len(cookies) == 0 {
+		return nil
+	}
+	copies := make([]*http.Cookie, len(cookies))
+	for i, cookie := range cookies {
+		c := *cookie
+		c.Unparsed = append([]string(nil), cookie.Unparsed...)
+		copies[i] = &c
+	}
+	return copies
+}
+
 // slideAddressToFront moves the address at the location (serverIndex, addrIndex) to be
 // the first address of the first server.
 func slideAddressToFront(servers [][]network.HostPort, serverIndex, addrIndex int) {
@@ -261,11 +517,18 @@ func (st *state) UnitAssigner() unitassigner.API {
 // Uniter returns a version of the state that provides functionality
 // required by the uniter worker.
 func (st *state) Uniter() (*uniter.State, error) {
-	unitTag, ok := st.authTag.(names.UnitTag)
+	return newUniter(st)
+}
+
+// newUniter returns the Uniter API of the given connection,
+// which is a state or a clone of one.
+func newUniter(conn Connection) (*uniter.State, error) {
+	authTag := conn.AuthTag()
+	unitTag, ok := authTag.(names.UnitTag)
 	if !ok {
-		return nil, errors.Errorf("expected UnitTag, got %T %v", st.authTag, st.authTag)
+		return nil, errors.Errorf("expected UnitTag, got %T %v", authTag, authTag)
 	}
-	return uniter.NewState(st, unitTag), nil
+	return uniter.NewState(conn, unitTag), nil
 }
 
 // Upgrader returns access to the Upgrader API
@@ -275,9 +538,19 @@ func (st *state) Upgrader() *upgrader.State {
 
 // Reboot returns access to the Reboot API
 func (st *state) Reboot() (reboot.State, error) {
-	switch tag := st.authTag.(type) {
+	return newReboot(st)
+}
+
+// newReboot returns the Reboot API of the given connection,
+// which is a state or a clone of one.
+func newReboot(conn Connection) (reboot.State, error) {
+	switch tag := conn.AuthTag().(type) {
 	case names.MachineTag:
-		return reboot.NewState(st, tag), nil
+		facade, err := conn.Facade("Reboot", facadeVersions["Reboot"])
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return reboot.NewStateFromFacade(facade, tag), nil
 	default:
 		return nil, errors.Errorf("expected names.MachineTag, got %T", tag)
 	}
@@ -305,7 +578,69 @@ func (st *state) CharmRevisionUpdater() *charmrevisionupdater.State {
 
 // Cleaner returns a version of the state that provides access to the cleaner API
 func (st *state) Cleaner() *cleaner.API {
-	return cleaner.NewAPI(st)
+	return newCleaner(st)
+}
+
+// CleanerFacade implements Connection.CleanerFacade.
+func (st *state) CleanerFacade() (*cleaner.API, error) {
+	return newCleanerFacade(st)
+}
+
+// newCleaner returns the Cleaner API of the given connection,
+// which is a state or a clone of one.
+//
+// If the API server does not support the Cleaner facade,
+// the API is returned all the same, and the error is
+// reported by the first call made through it.
+func newCleaner(conn Connection) *cleaner.API {
+	result, err := newCleanerFacade(conn)
+	if err != nil {
+		return cleaner.NewAPI(conn)
+	}
+	return result
+}
+
+// newCleanerFacade returns the Cleaner API of the given connection,
+// which is a state or a clone of one, or an error satisfying
+// errors.IsNotSupported if the API server does not support it.
+func newCleanerFacade(conn Connection) (*cleaner.API, error) {
+	facade, err := conn.Facade("Cleaner", facadeVersions["Cleaner"])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cleaner.NewAPIFromFacade(facade), nil
+}
+
+// Facade implements Connection.Facade.
+func (st *state) Facade(name string, version int) (base.FacadeCaller, error) {
+	return st.facadeFor(st, name, version)
+}
+
+// facadeFor returns a caller for the named facade, as Facade does,
+// that makes its calls with the given caller, which is the state or
+// one of its clones.
+func (st *state) facadeFor(caller base.APICaller, name string, version int) (base.FacadeCaller, error) {
+	if st.legacyFacadeVersions {
+		// The server did not say which facades it supports, so
+		// assume the earliest version, which all servers have.
+		return base.NewFacadeCallerForVersion(caller, name, 0), nil
+	}
+	st.facadeVersionsMu.Lock()
+	versions, found := st.facadeVersions[name]
+	st.facadeVersionsMu.Unlock()
+	best, ok := -1, false
+	for _, v := range versions {
+		if v <= version && v > best {
+			best, ok = v, true
+		}
+	}
+	if !ok {
+		if !found {
+			return nil, errors.NotSupportedf("facade %q", name)
+		}
+		return nil, errors.NotSupportedf("facade %q at version %d or earlier", name, version)
+	}
+	return base.NewFacadeCallerForVersion(caller, name, best), nil
 }
 
 // ServerVersion holds the version of the API server that we are connected to.
@@ -313,7 +648,37 @@ func (st *state) Cleaner() *cleaner.API {
 // during login. The second result argument indicates if the version number is
 // set.
 func (st *state) ServerVersion() (version.Number, bool) {
-	return st.serverVersion, st.serverVersion != version.Zero
+	v := st.ServerVersionOrZero()
+	return v, v != version.Zero
+}
+
+// NegotiatedServerVersion implements Connection.NegotiatedServerVersion.
+func (st *state) NegotiatedServerVersion() (version.Number, bool) {
+	if !st.isLoggedIn() {
+		return version.Zero, false
+	}
+	return st.ServerVersionOrZero(), true
+}
+
+// ServerVersionOrZero implements Connection.ServerVersionOrZero.
+func (st *state) ServerVersionOrZero() version.Number {
+	st.loginResultMu.Lock()
+	defer st.loginResultMu.Unlock()
+	return st.serverVersion
+}
+
+// AwaitServerVersion implements Connection.AwaitServerVersion.
+func (st *state) AwaitServerVersion(ctx context.Context) (version.Number, error) {
+	select {
+	case <-st.loggedInChan():
+	case <-ctx.Done():
+		return version.Zero, errors.Trace(ctx.Err())
+	}
+	v := st.ServerVersionOrZero()
+	if v == version.Zero {
+		return version.Zero, errors.NotFoundf("server version")
+	}
+	return v, nil
 }
 
 // MetadataUpdater returns access to the imageMetadata API
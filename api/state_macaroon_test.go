@@ -4,15 +4,20 @@
 package api_test
 
 import (
+	"net/http/cookiejar"
 	"net/url"
 
 	"github.com/juju/errors"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v1/httpbakery"
 
 	"github.com/juju/juju/api"
 	apitesting "github.com/juju/juju/api/testing"
+	"github.com/juju/juju/apiserver/params"
 	"github.com/juju/juju/rpc"
+	"github.com/juju/juju/testing/factory"
 )
 
 var _ = gc.Suite(&macaroonLoginSuite{})
@@ -43,6 +48,105 @@ func (s *macaroonLoginSuite) TestSuccessfulLogin(c *gc.C) {
 	c.Assert(err, jc.ErrorIsNil)
 }
 
+func (s *macaroonLoginSuite) TestMacaroonsIncludeDischarges(c *gc.C) {
+	c.Assert(s.client.Macaroons(), gc.HasLen, 0)
+
+	s.DischargerLogin = func() string { return testUserName }
+	err := s.client.Login(nil, "", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The macaroon issued by the controller is held along
+	// with the discharge acquired from the third party.
+	ms := s.client.Macaroons()
+	c.Assert(ms, gc.HasLen, 1)
+	c.Assert(ms[0], gc.HasLen, 2)
+	discharged := false
+	for _, cav := range ms[0][0].Caveats() {
+		if cav.Id == ms[0][1].Id() {
+			discharged = true
+		}
+	}
+	c.Assert(discharged, jc.IsTrue)
+
+	// The returned macaroons are copies.
+	err = ms[0][0].AddFirstPartyCaveat("mutated")
+	c.Assert(err, jc.ErrorIsNil)
+	ms[0] = nil
+	again := s.client.Macaroons()
+	c.Assert(again, gc.HasLen, 1)
+	c.Assert(again[0], gc.HasLen, 2)
+	for _, cav := range again[0][0].Caveats() {
+		c.Assert(cav.Id, gc.Not(gc.Equals), "mutated")
+	}
+}
+
+func (s *macaroonLoginSuite) TestExportCookiesIncludeDischarges(c *gc.C) {
+	c.Assert(s.client.ExportCookies(), gc.HasLen, 0)
+
+	s.DischargerLogin = func() string { return testUserName }
+	err := s.client.Login(nil, "", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The exported cookies hold the macaroons used to log in,
+	// including the discharge acquired from the third party.
+	cookies := s.client.ExportCookies()
+	c.Assert(cookies, gc.Not(gc.HasLen), 0)
+	jar, err := cookiejar.New(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	jar.SetCookies(s.client.CookieURL(), cookies)
+	exported := httpbakery.MacaroonsForURL(jar, s.client.CookieURL())
+	held := s.client.Macaroons()
+	c.Assert(exported, gc.HasLen, len(held))
+	for i, ms := range held {
+		c.Assert(exported[i], gc.HasLen, len(ms))
+		for j, m := range ms {
+			c.Assert(exported[i][j].Id(), gc.Equals, m.Id())
+			c.Assert(exported[i][j].Signature(), jc.DeepEquals, m.Signature())
+		}
+	}
+	c.Assert(held, gc.HasLen, 1)
+	c.Assert(held[0], gc.HasLen, 2)
+
+	// The exported cookies are copies.
+	cookies[0].Value = "mutated"
+	for _, cookie := range s.client.ExportCookies() {
+		c.Assert(cookie.Value, gc.Not(gc.Equals), "mutated")
+	}
+}
+
+func (s *macaroonLoginSuite) TestOpenRequireMacaroonAuth(c *gc.C) {
+	s.DischargerLogin = func() string { return testUserName }
+	info := s.APIInfo(c)
+	info.Password = "leaked-password"
+	var sent []string
+	conn, err := api.Open(info, api.DialOpts{
+		BakeryClient:        httpbakery.NewClient(),
+		RequireMacaroonAuth: true,
+		CallInterceptor: func(facade, method string, version int, args interface{}) error {
+			if facade == "Admin" && method == "Login" {
+				sent = append(sent, args.(*params.LoginRequest).Credentials)
+			}
+			return nil
+		},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer conn.Close()
+	c.Assert(conn.AuthTag(), gc.Equals, names.NewUserTag(testUserName))
+	c.Assert(sent, gc.Not(gc.HasLen), 0)
+	for _, credentials := range sent {
+		c.Assert(credentials, gc.Equals, "")
+	}
+}
+
+func (s *macaroonLoginSuite) TestOpenRequireMacaroonAuthWithLoginProvider(c *gc.C) {
+	_, err := api.Open(s.APIInfo(c), api.DialOpts{
+		RequireMacaroonAuth: true,
+		LoginProvider:       &legacyLoginProvider{},
+	})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	c.Assert(err, gc.ErrorMatches, "specifying RequireMacaroonAuth and LoginProvider not valid")
+}
+
 func (s *macaroonLoginSuite) TestFailedToObtainDischargeLogin(c *gc.C) {
 	err := s.client.Login(nil, "", "", nil)
 	c.Assert(err, gc.ErrorMatches, `cannot get discharge from "https://.*": third party refused discharge: cannot discharge: login denied by discharger`)
@@ -129,3 +233,74 @@ func (s *macaroonLoginSuite) TestConnectStreamFailedDischarge(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `cannot get discharge from "https://.*": third party refused discharge: cannot discharge: login denied by discharger`)
 	c.Assert(conn, gc.IsNil)
 }
+
+func (s *macaroonLoginSuite) TestForModelReusesMacaroons(c *gc.C) {
+	dischargeCount := 0
+	s.DischargerLogin = func() string {
+		dischargeCount++
+		return testUserName
+	}
+	err := s.client.Login(nil, "", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(dischargeCount, gc.Equals, 1)
+
+	st := s.Factory.MakeModel(c, nil)
+	defer st.Close()
+	factory.NewFactory(st).MakeModelUser(c, &factory.ModelUserParams{
+		User: testUserName,
+	})
+
+	conn, err := s.client.ForModel(st.ModelTag())
+	c.Assert(err, jc.ErrorIsNil)
+	defer conn.Close()
+	modelTag, ok := conn.ModelTag()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(modelTag, gc.Equals, st.ModelTag())
+	c.Assert(conn.AuthTag(), gc.Equals, names.NewUserTag(testUserName))
+	// The macaroons of the first connection were used to log in,
+	// so no further discharge was needed.
+	c.Assert(dischargeCount, gc.Equals, 1)
+
+	// The connections are independent.
+	err = conn.Close()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.client.Ping(), jc.ErrorIsNil)
+}
+
+func (s *macaroonLoginSuite) TestForModelSkipLogin(c *gc.C) {
+	s.DischargerLogin = func() string { return testUserName }
+	err := s.client.Login(nil, "", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// A connection that has not logged in, but whose
+	// cookie jar holds macaroons, opens one that does not
+	// log in either.
+	jar, err := cookiejar.New(nil)
+	c.Assert(err, jc.ErrorIsNil)
+	jar.SetCookies(s.client.CookieURL(), s.client.ExportCookies())
+	info := s.APIInfo(c)
+	info.SkipLogin = true
+	parent := s.OpenAPI(c, info, jar)
+	defer parent.Close()
+	c.Assert(parent.Macaroons(), gc.Not(gc.HasLen), 0)
+
+	conn, err := parent.ForModel(s.State.ModelTag())
+	c.Assert(err, jc.ErrorIsNil)
+	defer conn.Close()
+	c.Assert(conn.Authenticated(), jc.IsFalse)
+}
+
+func (s *macaroonLoginSuite) TestForModelNoAccess(c *gc.C) {
+	s.DischargerLogin = func() string { return testUserName }
+	err := s.client.Login(nil, "", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	st := s.Factory.MakeModel(c, nil)
+	defer st.Close()
+	conn, err := s.client.ForModel(st.ModelTag())
+	c.Assert(err, gc.ErrorMatches, `cannot connect to model "`+st.ModelUUID()+`": .*`)
+	// The error from the login is returned.
+	_, ok := errors.Cause(err).(*rpc.RequestError)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(conn, gc.IsNil)
+}
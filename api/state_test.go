@@ -4,19 +4,33 @@
 package api_test
 
 import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	stdtesting "testing"
+	"time"
 
+	"github.com/juju/errors"
+	"github.com/juju/httprequest"
 	jc "github.com/juju/testing/checkers"
+	"github.com/juju/version"
+	"golang.org/x/net/context"
 	gc "gopkg.in/check.v1"
 	"gopkg.in/juju/names.v2"
+	"gopkg.in/macaroon-bakery.v1/httpbakery"
 	"gopkg.in/macaroon.v1"
 
 	"github.com/juju/juju/api"
 	"github.com/juju/juju/api/modelmanager"
 	"github.com/juju/juju/api/usermanager"
+	"github.com/juju/juju/apiserver/params"
 	jujutesting "github.com/juju/juju/juju/testing"
 	"github.com/juju/juju/network"
+	"github.com/juju/juju/rpc"
 	coretesting "github.com/juju/juju/testing"
+	jujuversion "github.com/juju/juju/version"
 )
 
 func TestAll(t *stdtesting.T) {
@@ -41,6 +55,27 @@ func (s *stateSuite) TestCloseMultipleOk(c *gc.C) {
 	c.Assert(s.APIState.Close(), gc.IsNil)
 }
 
+func (s *stateSuite) TestHealthAfterClose(c *gc.C) {
+	health := s.APIState.Health(true)
+	c.Assert(health.PingError, jc.ErrorIsNil)
+	c.Assert(health.Connected, jc.IsTrue)
+	c.Assert(health.Authenticated, jc.IsTrue)
+	c.Assert(health.LastPing.IsZero(), jc.IsFalse)
+
+	c.Assert(s.APIState.Close(), gc.IsNil)
+	health = s.APIState.Health(false)
+	c.Assert(health.Connected, jc.IsFalse)
+	c.Assert(health.BrokenReason, gc.Equals, "connection is closed")
+}
+
+func (s *stateSuite) TestNoNotificationsByDefault(c *gc.C) {
+	select {
+	case n := <-s.APIState.Notifications():
+		c.Fatalf("unexpected notification %#v", n)
+	case <-time.After(coretesting.ShortWait):
+	}
+}
+
 // OpenAPIWithoutLogin connects to the API and returns an api.State without
 // actually calling st.Login already. The returned strings are the "tag" and
 // "password" that we would have used to login.
@@ -78,6 +113,95 @@ func (s *stateSuite) TestAPIHostPortsAlwaysIncludesTheConnection(c *gc.C) {
 	})
 }
 
+func (s *stateSuite) TestRefreshAPIHostPorts(c *gc.C) {
+	initial := [][]network.HostPort{network.NewHostPorts(17070, "10.0.0.1")}
+	changed := [][]network.HostPort{
+		network.NewHostPorts(17070, "10.0.0.1"),
+		network.NewHostPorts(17070, "10.0.0.2"),
+	}
+	conn := &apiHostPortsRPCConnection{servers: params.FromNetworkHostsPorts(changed)}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "10.0.0.1:17070",
+		APIHostPorts:  initial,
+		RPCConnection: conn,
+		Clock:         &fakeClock{},
+	})
+	c.Assert(st.APIHostPorts(), jc.DeepEquals, initial)
+
+	hostPorts, err := st.RefreshAPIHostPorts()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(hostPorts, jc.DeepEquals, changed)
+	c.Assert(st.APIHostPorts(), jc.DeepEquals, changed)
+}
+
+func (s *stateSuite) TestRefreshAPIHostPortsIncludesTheConnection(c *gc.C) {
+	servers := [][]network.HostPort{network.NewHostPorts(17070, "10.0.0.2")}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "10.0.0.1:17070",
+		RPCConnection: &apiHostPortsRPCConnection{servers: params.FromNetworkHostsPorts(servers)},
+		Clock:         &fakeClock{},
+	})
+	hostPorts, err := st.RefreshAPIHostPorts()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(hostPorts, jc.DeepEquals, [][]network.HostPort{
+		network.NewHostPorts(17070, "10.0.0.1"),
+		servers[0],
+	})
+}
+
+func (s *stateSuite) TestRefreshAPIHostPortsError(c *gc.C) {
+	initial := [][]network.HostPort{network.NewHostPorts(17070, "10.0.0.1")}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "10.0.0.1:17070",
+		APIHostPorts:  initial,
+		RPCConnection: &apiHostPortsRPCConnection{err: errors.New("boom")},
+		Clock:         &fakeClock{},
+	})
+	_, err := st.RefreshAPIHostPorts()
+	c.Assert(err, gc.ErrorMatches, "cannot get API addresses: boom")
+	c.Assert(st.APIHostPorts(), jc.DeepEquals, initial)
+}
+
+func (s *stateSuite) TestRefreshAPIHostPortsConcurrent(c *gc.C) {
+	servers := [][]network.HostPort{network.NewHostPorts(17070, "10.0.0.1")}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "10.0.0.1:17070",
+		RPCConnection: &apiHostPortsRPCConnection{servers: params.FromNetworkHostsPorts(servers)},
+		Clock:         &fakeClock{},
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := st.RefreshAPIHostPorts()
+			c.Check(err, jc.ErrorIsNil)
+		}()
+		go func() {
+			defer wg.Done()
+			st.APIHostPorts()
+		}()
+	}
+	wg.Wait()
+	c.Assert(st.APIHostPorts(), jc.DeepEquals, servers)
+}
+
+func (s *stateSuite) TestRefreshAPIHostPortsFromController(c *gc.C) {
+	hostPorts := s.APIState.APIHostPorts()
+	c.Assert(hostPorts, gc.HasLen, 1)
+	newServer := network.NewHostPorts(1234, "0.1.2.3")
+	err := s.State.SetAPIHostPorts([][]network.HostPort{newServer})
+	c.Assert(err, jc.ErrorIsNil)
+
+	refreshed, err := s.APIState.RefreshAPIHostPorts()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(refreshed, jc.DeepEquals, [][]network.HostPort{
+		hostPorts[0],
+		newServer,
+	})
+	c.Assert(s.APIState.APIHostPorts(), jc.DeepEquals, refreshed)
+}
+
 func (s *stateSuite) TestTags(c *gc.C) {
 	model, err := s.State.Model()
 	c.Assert(err, jc.ErrorIsNil)
@@ -158,6 +282,641 @@ func (s *stateSuite) TestLoginTracksFacadeVersions(c *gc.C) {
 	c.Check(allVersions["Client"][0], gc.Equals, 1)
 }
 
+func (s *stateSuite) TestLoginResult(c *gc.C) {
+	apistate, tag, password := s.OpenAPIWithoutLogin(c)
+	defer apistate.Close()
+	// We haven't called Login yet, so the result should be empty.
+	c.Check(apistate.LoginResult(), jc.DeepEquals, api.LoginResultInfo{})
+	err := apistate.Login(tag, password, "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	result := apistate.LoginResult()
+	c.Check(result.AuthTag, gc.Equals, tag)
+	c.Check(result.ControllerTag, gc.Equals, coretesting.ControllerTag)
+	c.Check(result.ModelTag, gc.Equals, s.State.ModelTag())
+	c.Check(result.ControllerAccess, gc.Equals, "superuser")
+	c.Check(result.ModelAccess, gc.Equals, "admin")
+	c.Check(result.ServerVersion, gc.Equals, jujuversion.Current)
+	c.Check(result.Facades, jc.DeepEquals, apistate.AllFacadeVersions())
+	c.Check(result.Facades["Client"], gc.Not(gc.HasLen), 0)
+}
+
+func (s *stateSuite) TestLoginResultReadDuringRelogin(c *gc.C) {
+	st, _ := s.newLoginTestingState()
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			st.AuthTag()
+			st.ModelAccess()
+			st.ControllerAccess()
+			st.IsAnonymous()
+			st.LoginResult()
+			st.NegotiatedServerVersion()
+			st.ServerVersionOrZero()
+			st.ControllerTag()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		c.Check(st.Login(names.NewUserTag("bob"), "bob-password", "", nil), jc.ErrorIsNil)
+		c.Check(st.ChangeUser(names.NewUserTag("alice"), "alice-password", nil), jc.ErrorIsNil)
+		c.Check(st.EnsureLogin(names.NewUserTag("bob"), "bob-password", "", nil), jc.ErrorIsNil)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func (s *stateSuite) TestChangeUser(c *gc.C) {
+	conn := &loginRPCConnection{
+		result: params.LoginResult{
+			ControllerTag: coretesting.ControllerTag.String(),
+			ServerVersion: "2.0.0",
+			UserInfo: &params.AuthUserInfo{
+				Identity:         "user-bob",
+				ControllerAccess: "login",
+				ModelAccess:      "read",
+			},
+		},
+	}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "localhost:17070",
+		RPCConnection: conn,
+		Clock:         &fakeClock{},
+	})
+	err := st.ChangeUser(names.NewUserTag("bob"), "bob-password", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(conn.requests, jc.DeepEquals, []params.LoginRequest{{
+		AuthTag:     "user-bob",
+		Credentials: "bob-password",
+	}})
+	c.Assert(st.AuthTag(), gc.Equals, names.NewUserTag("bob"))
+	c.Assert(st.ControllerAccess(), gc.Equals, "login")
+	c.Assert(st.ModelAccess(), gc.Equals, "read")
+}
+
+func (s *stateSuite) TestChangeUserFailureLeavesConnectionUnchanged(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		Address: "localhost:17070",
+		RPCConnection: &loginRPCConnection{
+			err: &rpc.RequestError{
+				Message: "invalid entity name or password",
+				Code:    params.CodeUnauthorized,
+			},
+		},
+		Clock: &fakeClock{},
+	})
+	err := st.ChangeUser(names.NewUserTag("bob"), "bad-password", nil)
+	c.Assert(err, gc.ErrorMatches, "invalid entity name or password \\(unauthorized access\\)")
+	c.Assert(st.AuthTag(), gc.IsNil)
+	c.Assert(st.ControllerAccess(), gc.Equals, "")
+	c.Assert(st.ModelAccess(), gc.Equals, "")
+}
+
+func (s *stateSuite) TestChangeUserNotSupported(c *gc.C) {
+	tag := s.APIState.AuthTag()
+	err := s.APIState.ChangeUser(names.NewUserTag("bob"), "bob-password", nil)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(s.APIState.AuthTag(), gc.Equals, tag)
+	c.Assert(s.APIState.ModelAccess(), gc.Equals, "admin")
+	c.Assert(s.APIState.ControllerAccess(), gc.Equals, "superuser")
+}
+
+func (s *stateSuite) TestFacade(c *gc.C) {
+	facade, err := s.APIState.Facade("Pinger", 1)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(facade.Name(), gc.Equals, "Pinger")
+	c.Assert(facade.BestAPIVersion(), gc.Equals, 1)
+	err = facade.FacadeCall("Ping", nil, nil)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *stateSuite) TestFacadeNegotiatesVersion(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		FacadeVersions: map[string][]int{
+			"Foo": {0, 1, 3},
+		},
+	})
+	for desired, expected := range map[int]int{0: 0, 1: 1, 2: 1, 3: 3, 9: 3} {
+		facade, err := st.Facade("Foo", desired)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(facade.BestAPIVersion(), gc.Equals, expected)
+	}
+}
+
+func (s *stateSuite) TestFacadeNotSupported(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		FacadeVersions: map[string][]int{
+			"Foo": {2, 3},
+		},
+	})
+	_, err := st.Facade("Bar", 1)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, `facade "Bar" not supported`)
+	_, err = st.Facade("Foo", 1)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, `facade "Foo" at version 1 or earlier not supported`)
+}
+
+func (s *stateSuite) TestCleaner(c *gc.C) {
+	c.Assert(s.APIState.Cleaner(), gc.NotNil)
+	cleaner, err := s.APIState.CleanerFacade()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cleaner, gc.NotNil)
+}
+
+func (s *stateSuite) TestCleanerNotSupported(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{})
+	// Cleaner leaves the error to be reported by the first call.
+	c.Assert(st.Cleaner(), gc.NotNil)
+	_, err := st.CleanerFacade()
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *stateSuite) TestServerVersionOrZeroBeforeLogin(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{})
+	c.Assert(st.ServerVersionOrZero(), gc.Equals, version.Zero)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := st.AwaitServerVersion(ctx)
+	c.Assert(errors.Cause(err), gc.Equals, context.Canceled)
+}
+
+func (s *stateSuite) TestAwaitServerVersionCompletesAfterLogin(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		Address: "localhost:17070",
+		RPCConnection: &loginRPCConnection{
+			result: params.LoginResult{
+				ControllerTag: coretesting.ControllerTag.String(),
+				ServerVersion: "2.0.1",
+			},
+		},
+		Clock: &fakeClock{},
+	})
+	type result struct {
+		v   version.Number
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := st.AwaitServerVersion(context.Background())
+		done <- result{v, err}
+	}()
+	select {
+	case <-done:
+		c.Fatalf("AwaitServerVersion returned before login")
+	case <-time.After(coretesting.ShortWait):
+	}
+
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	select {
+	case r := <-done:
+		c.Assert(r.err, jc.ErrorIsNil)
+		c.Assert(r.v, gc.Equals, version.MustParse("2.0.1"))
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("timed out waiting for server version")
+	}
+	c.Assert(st.ServerVersionOrZero(), gc.Equals, version.MustParse("2.0.1"))
+}
+
+func (s *stateSuite) TestAwaitServerVersionNotReported(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		Address: "localhost:17070",
+		RPCConnection: &loginRPCConnection{
+			result: params.LoginResult{
+				ControllerTag: coretesting.ControllerTag.String(),
+				ServerVersion: "0.0.0",
+			},
+		},
+		Clock: &fakeClock{},
+	})
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = st.AwaitServerVersion(context.Background())
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *stateSuite) TestNegotiatedServerVersionSkipLogin(c *gc.C) {
+	info := s.APIInfo(c)
+	tag, password := info.Tag, info.Password
+	info.Tag = nil
+	info.Password = ""
+	info.Macaroons = nil
+	info.SkipLogin = true
+	st, err := api.Open(info, api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	_, ok := st.NegotiatedServerVersion()
+	c.Assert(ok, jc.IsFalse)
+
+	err = st.Login(tag, password, "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	v, ok := st.NegotiatedServerVersion()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(v, gc.Equals, jujuversion.Current)
+}
+
+func (s *stateSuite) TestNegotiatedServerVersionNotReported(c *gc.C) {
+	st := api.NewTestingState(api.TestingStateParams{
+		Address: "localhost:17070",
+		RPCConnection: &loginRPCConnection{
+			result: params.LoginResult{
+				ControllerTag: coretesting.ControllerTag.String(),
+				ServerVersion: "0.0.0",
+			},
+		},
+		Clock: &fakeClock{},
+	})
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	v, ok := st.NegotiatedServerVersion()
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(v, gc.Equals, version.Zero)
+}
+
+func (s *stateSuite) TestOnReconnect(c *gc.C) {
+	st, _ := s.newLoginTestingState()
+	var calls []string
+	st.OnReconnect(func() { calls = append(calls, "first") })
+	remove := st.OnReconnect(func() { calls = append(calls, "second") })
+
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, gc.HasLen, 0)
+
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, jc.DeepEquals, []string{"first", "second"})
+
+	remove()
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, jc.DeepEquals, []string{"first", "second", "first"})
+}
+
+func (s *stateSuite) TestChangeUserIsNotReconnect(c *gc.C) {
+	st, _ := s.newLoginTestingState()
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	var calls []string
+	st.OnReconnect(func() { calls = append(calls, "reconnect") })
+	st.OnConnect(func(addr string) { calls = append(calls, "connect") })
+	calls = nil
+
+	err = st.ChangeUser(names.NewUserTag("alice"), "alice-password", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, gc.HasLen, 0)
+	c.Assert(st.Health(false).Reconnects, gc.Equals, 0)
+}
+
+func (s *stateSuite) TestChangeUserUsedByHTTPRequests(c *gc.C) {
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, password, _ := req.BasicAuth()
+		auths = append(auths, user+":"+password)
+		httprequest.WriteJSON(w, http.StatusOK, "ok")
+	}))
+	defer srv.Close()
+	st := api.NewTestingState(api.TestingStateParams{
+		Address: strings.TrimPrefix(srv.URL, "http://"),
+		RPCConnection: &loginRPCConnection{
+			result: params.LoginResult{
+				ControllerTag: coretesting.ControllerTag.String(),
+				ServerVersion: "2.0.1",
+			},
+		},
+		ServerScheme: "http",
+		Clock:        &fakeClock{},
+		BakeryClient: httpbakery.NewClient(),
+	})
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	err = st.ChangeUser(names.NewUserTag("alice"), "alice-password", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	client, err := st.HTTPClient()
+	c.Assert(err, jc.ErrorIsNil)
+	err = client.Get("/", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(auths, jc.DeepEquals, []string{"user-alice:alice-password"})
+}
+
+func (s *stateSuite) TestOnReconnectNotCalledOnFailedLogin(c *gc.C) {
+	st, conn := s.newLoginTestingState()
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	called := false
+	st.OnReconnect(func() { called = true })
+
+	conn.err = errors.New("bad password")
+	err = st.Login(names.NewUserTag("bob"), "wrong", "", nil)
+	c.Assert(err, gc.ErrorMatches, "bad password")
+	c.Assert(called, jc.IsFalse)
+}
+
+func (s *stateSuite) TestOnReconnectCallbackMayDeregister(c *gc.C) {
+	st, _ := s.newLoginTestingState()
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	calls := 0
+	var remove func()
+	remove = st.OnReconnect(func() {
+		calls++
+		remove()
+	})
+	for i := 0; i < 2; i++ {
+		err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+		c.Assert(err, jc.ErrorIsNil)
+	}
+	c.Assert(calls, gc.Equals, 1)
+}
+
+func (s *stateSuite) TestOnConnect(c *gc.C) {
+	st, _ := s.newLoginTestingState()
+	var addrs []string
+	remove := st.OnConnect(func(addr string) { addrs = append(addrs, addr) })
+	c.Assert(addrs, jc.DeepEquals, []string{"localhost:17070"})
+
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(addrs, jc.DeepEquals, []string{"localhost:17070"})
+
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(addrs, jc.DeepEquals, []string{"localhost:17070", "localhost:17070"})
+
+	remove()
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(addrs, gc.HasLen, 2)
+}
+
+func (s *stateSuite) TestOnConnectCalledBeforeOnReconnect(c *gc.C) {
+	st, _ := s.newLoginTestingState()
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	var calls []string
+	st.OnReconnect(func() { calls = append(calls, "reconnect") })
+	st.OnConnect(func(addr string) { calls = append(calls, "connect "+addr) })
+	c.Assert(calls, jc.DeepEquals, []string{"connect localhost:17070"})
+
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(calls, jc.DeepEquals, []string{
+		"connect localhost:17070",
+		"connect localhost:17070",
+		"reconnect",
+	})
+}
+
+func (s *stateSuite) newLoginTestingState() (api.Connection, *loginRPCConnection) {
+	conn := &loginRPCConnection{
+		result: params.LoginResult{
+			ControllerTag: coretesting.ControllerTag.String(),
+			ServerVersion: "2.0.1",
+		},
+	}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:       "localhost:17070",
+		RPCConnection: conn,
+		Clock:         &fakeClock{},
+	})
+	return st, conn
+}
+
+func (s *stateSuite) TestLoginRequireMacaroonAuthDoesNotSendPassword(c *gc.C) {
+	conn := &loginRPCConnection{
+		result: params.LoginResult{
+			ControllerTag: coretesting.ControllerTag.String(),
+			ServerVersion: "2.0.1",
+		},
+	}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:             "localhost:17070",
+		RPCConnection:       conn,
+		Clock:               &fakeClock{},
+		BakeryClient:        httpbakery.NewClient(),
+		RequireMacaroonAuth: true,
+	})
+	mac, err := macaroon.New([]byte("root-key"), "id", "juju")
+	c.Assert(err, jc.ErrorIsNil)
+	ms := []macaroon.Slice{{mac}}
+
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", ms)
+	c.Assert(err, jc.ErrorIsNil)
+	err = st.ChangeUser(names.NewUserTag("bob"), "bob-password", ms)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(conn.requests, gc.HasLen, 2)
+	for _, req := range conn.requests {
+		c.Check(req.AuthTag, gc.Equals, "user-bob")
+		c.Check(req.Credentials, gc.Equals, "")
+		c.Check(req.Macaroons, jc.DeepEquals, ms)
+	}
+}
+
+func (s *stateSuite) TestLoginRequireMacaroonAuthAgent(c *gc.C) {
+	conn := &loginRPCConnection{}
+	st := api.NewTestingState(api.TestingStateParams{
+		Address:             "localhost:17070",
+		RPCConnection:       conn,
+		Clock:               &fakeClock{},
+		BakeryClient:        httpbakery.NewClient(),
+		RequireMacaroonAuth: true,
+	})
+	err := st.Login(names.NewMachineTag("0"), "machine-password", "fake_nonce", nil)
+	c.Assert(err, gc.ErrorMatches, "cannot log in as machine 0: macaroon authentication required")
+	c.Assert(conn.requests, gc.HasLen, 0)
+}
+
+func (s *stateSuite) TestEnsureLoginNotLoggedIn(c *gc.C) {
+	st, conn := s.newLoginTestingState()
+	err := st.EnsureLogin(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(conn.requests, gc.HasLen, 1)
+	c.Assert(conn.requests[0].AuthTag, gc.Equals, "user-bob")
+	c.Assert(st.AuthTag(), gc.Equals, names.NewUserTag("bob"))
+}
+
+func (s *stateSuite) TestEnsureLoginAlreadyLoggedIn(c *gc.C) {
+	st, conn := s.newLoginTestingState()
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(conn.requests, gc.HasLen, 1)
+
+	err = st.EnsureLogin(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(conn.requests, gc.HasLen, 1)
+}
+
+func (s *stateSuite) TestEnsureLoginDifferentIdentity(c *gc.C) {
+	st, conn := s.newLoginTestingState()
+	err := st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = st.EnsureLogin(names.NewMachineTag("0"), "machine-password", "fake_nonce", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(conn.requests, gc.HasLen, 2)
+	c.Assert(conn.requests[1].AuthTag, gc.Equals, "machine-0")
+	c.Assert(conn.requests[1].Nonce, gc.Equals, "fake_nonce")
+	c.Assert(st.AuthTag(), gc.Equals, names.NewMachineTag("0"))
+}
+
+func (s *stateSuite) TestEnsureLoginError(c *gc.C) {
+	st, conn := s.newLoginTestingState()
+	conn.err = errors.New("boom")
+	err := st.EnsureLogin(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, gc.ErrorMatches, "boom")
+	c.Assert(st.AuthTag(), gc.IsNil)
+}
+
+func (s *stateSuite) TestIsAnonymousAuthenticatedLogin(c *gc.C) {
+	c.Assert(s.APIState.IsAnonymous(), jc.IsFalse)
+}
+
+func (s *stateSuite) TestIsAnonymousMacaroonLogin(c *gc.C) {
+	mac, err := macaroon.New([]byte("root-key"), "id", "juju")
+	c.Assert(err, jc.ErrorIsNil)
+	for i, test := range []struct {
+		about     string
+		userInfo  *params.AuthUserInfo
+		anonymous bool
+	}{{
+		about:     "no identity in login result",
+		anonymous: true,
+	}, {
+		about: "identity in login result",
+		userInfo: &params.AuthUserInfo{
+			Identity: "user-bob@external",
+		},
+		anonymous: false,
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		jar, err := cookiejar.New(nil)
+		c.Assert(err, jc.ErrorIsNil)
+		st := api.NewTestingState(api.TestingStateParams{
+			Address: "localhost:17070",
+			RPCConnection: &loginRPCConnection{
+				result: params.LoginResult{
+					ControllerTag: coretesting.ControllerTag.String(),
+					ServerVersion: "2.0.1",
+					UserInfo:      test.userInfo,
+				},
+			},
+			Clock:        &fakeClock{},
+			BakeryClient: &httpbakery.Client{Client: &http.Client{Jar: jar}},
+		})
+		c.Check(st.IsAnonymous(), jc.IsTrue)
+		err = st.Login(nil, "", "", []macaroon.Slice{{mac}})
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(st.IsAnonymous(), gc.Equals, test.anonymous)
+		c.Check(st.IsAnonymous(), gc.Equals, st.AuthTag() == nil)
+	}
+}
+
+func (s *stateSuite) TestIsAnonymousSkipLogin(c *gc.C) {
+	info := s.APIInfo(c)
+	info.Tag = nil
+	info.Password = ""
+	info.Macaroons = nil
+	info.SkipLogin = true
+	st, err := api.Open(info, api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.IsAnonymous(), jc.IsTrue)
+}
+
+func (s *stateSuite) TestAuthenticatedSkipLogin(c *gc.C) {
+	info := s.APIInfo(c)
+	info.SkipLogin = true
+	st, err := api.Open(info, api.DialOpts{})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+	c.Assert(st.Authenticated(), jc.IsFalse)
+
+	err = st.Login(info.Tag, info.Password, "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.Authenticated(), jc.IsTrue)
+}
+
+func (s *stateSuite) TestAuthenticatedAfterLogin(c *gc.C) {
+	c.Assert(s.APIState.Authenticated(), jc.IsTrue)
+
+	st, _ := s.newLoginTestingState()
+	c.Assert(st.Authenticated(), jc.IsFalse)
+	err := st.EnsureLogin(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(st.Authenticated(), jc.IsTrue)
+}
+
+func (s *stateSuite) TestAuthenticatedAfterFailedLogin(c *gc.C) {
+	st, conn := s.newLoginTestingState()
+	conn.err = errors.New("bad password")
+	err := st.Login(names.NewUserTag("bob"), "wrong", "", nil)
+	c.Assert(err, gc.ErrorMatches, "bad password")
+	c.Assert(st.Authenticated(), jc.IsFalse)
+
+	// A failed login after a successful one leaves
+	// the connection logged in as before.
+	conn.err = nil
+	err = st.Login(names.NewUserTag("bob"), "bob-password", "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	conn.err = errors.New("bad password")
+	err = st.Login(names.NewUserTag("bob"), "wrong", "", nil)
+	c.Assert(err, gc.ErrorMatches, "bad password")
+	c.Assert(st.Authenticated(), jc.IsTrue)
+	c.Assert(st.AuthTag(), gc.Equals, names.NewUserTag("bob"))
+}
+
+func (s *stateSuite) TestWatchFacadeVersionsAcrossLogin(c *gc.C) {
+	apistate, tag, password := s.OpenAPIWithoutLogin(c)
+	defer apistate.Close()
+	versions, stop, err := apistate.WatchFacadeVersions()
+	c.Assert(err, jc.ErrorIsNil)
+	defer stop()
+
+	// Before logging in, no facades are known.
+	select {
+	case v := <-versions:
+		c.Assert(v, gc.HasLen, 0)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("facade versions not sent")
+	}
+
+	err = apistate.Login(tag, password, "", nil)
+	c.Assert(err, jc.ErrorIsNil)
+	select {
+	case v := <-versions:
+		c.Assert(v, jc.DeepEquals, apistate.AllFacadeVersions())
+		c.Assert(v["Client"], gc.Not(gc.HasLen), 0)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("facade versions not sent after login")
+	}
+
+	// The channel is closed when the connection is.
+	err = apistate.Close()
+	c.Assert(err, jc.ErrorIsNil)
+	select {
+	case _, ok := <-versions:
+		c.Assert(ok, jc.IsFalse)
+	case <-time.After(coretesting.LongWait):
+		c.Fatalf("facade versions channel not closed")
+	}
+	_, _, err = apistate.WatchFacadeVersions()
+	c.Assert(err, gc.ErrorMatches, "connection is closed")
+}
+
 func (s *stateSuite) TestAllFacadeVersionsSafeFromMutation(c *gc.C) {
 	allVersions := s.APIState.AllFacadeVersions()
 	clients := allVersions["Client"]
@@ -300,3 +1059,53 @@ func (s *slideSuite) TestSlideToFrontBoth(c *gc.C) {
 	api.SlideAddressToFront(servers, 1, 1)
 	c.Check(servers, gc.DeepEquals, expected)
 }
+
+// loginRPCConnection is an api.RPCConnection that responds to
+// Admin.Login requests with a fixed result or error, recording
+// the requests made.
+type loginRPCConnection struct {
+	requests []params.LoginRequest
+	result   params.LoginResult
+	err      error
+}
+
+func (f *loginRPCConnection) Close() error {
+	return nil
+}
+
+func (f *loginRPCConnection) Call(req rpc.Request, args, response interface{}) error {
+	if req.Type != "Admin" || req.Action != "Login" {
+		return errors.Errorf("unexpected call to %s.%s", req.Type, req.Action)
+	}
+	f.requests = append(f.requests, *args.(*params.LoginRequest))
+	if f.err != nil {
+		return f.err
+	}
+	*response.(*params.LoginResult) = f.result
+	return nil
+}
+
+// apiHostPortsRPCConnection is an rpc connection that responds to
+// Client.APIHostPorts calls with servers, or fails with err.
+type apiHostPortsRPCConnection struct {
+	mu      sync.Mutex
+	servers [][]params.HostPort
+	err     error
+}
+
+func (f *apiHostPortsRPCConnection) Close() error {
+	return nil
+}
+
+func (f *apiHostPortsRPCConnection) Call(req rpc.Request, args, response interface{}) error {
+	if req.Type != "Client" || req.Action != "APIHostPorts" {
+		return errors.Errorf("unexpected call to %s.%s", req.Type, req.Action)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	*response.(*params.APIHostPortsResult) = params.APIHostPortsResult{Servers: f.servers}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Tracer creates the spans with which a connection traces its
+// operations, as set in DialOpts.Tracer. It is deliberately small,
+// so that it can be implemented by an adapter for any tracing
+// system, such as OpenTelemetry, without the api package depending
+// on it.
+type Tracer interface {
+	// StartSpan starts a span with the given name and attributes.
+	// If parent is non-nil, the span is a child of it.
+	StartSpan(name string, parent Span, attrs map[string]string) Span
+}
+
+// Span is an operation traced by a Tracer.
+type Span interface {
+	// End ends the span, recording the error with which the
+	// operation failed, or nil if it succeeded.
+	End(err error)
+}
+
+// openSpanName is the name of the span traced for Open.
+const openSpanName = "api.Open"
+
+// startOpenSpan starts the span traced for opening a connection
+// with the given info, or returns nil if opts has no tracer.
+func startOpenSpan(info *Info, opts DialOpts) Span {
+	if opts.Tracer == nil {
+		return nil
+	}
+	attrs := map[string]string{
+		"addrs": strings.Join(info.Addrs, ","),
+	}
+	if info.ModelTag.Id() != "" {
+		attrs["model"] = info.ModelTag.Id()
+	}
+	return opts.Tracer.StartSpan(openSpanName, nil, attrs)
+}
+
+// startCallSpan starts the span traced for the given API call, or
+// returns nil if the connection has no tracer. Calls made while the
+// connection is being opened, to log in, are children of the span
+// traced for Open; others have no parent, as there is no context
+// from which to take one.
+func (s *state) startCallSpan(facade string, version int, id, method string) Span {
+	if s.tracer == nil {
+		return nil
+	}
+	attrs := map[string]string{
+		"facade":  facade,
+		"method":  method,
+		"version": strconv.Itoa(version),
+	}
+	if id != "" {
+		attrs["id"] = id
+	}
+	return s.tracer.StartSpan(facade+"."+method, s.openSpan, attrs)
+}
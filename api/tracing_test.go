@@ -0,0 +1,134 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package api_test
+
+import (
+	"strconv"
+	"sync"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/api"
+	"github.com/juju/juju/apiserver/params"
+	jujutesting "github.com/juju/juju/juju/testing"
+)
+
+type tracingSuite struct {
+	jujutesting.JujuConnSuite
+}
+
+var _ = gc.Suite(&tracingSuite{})
+
+func (s *tracingSuite) TestOpenAndCallSpans(c *gc.C) {
+	tracer := &fakeTracer{}
+	info := s.APIInfo(c)
+	st, err := api.Open(info, api.DialOpts{
+		Tracer: tracer,
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	defer st.Close()
+
+	var result params.FullStatus
+	err = st.APICall("Client", st.BestFacadeVersion("Client"), "", "FullStatus", params.StatusParams{}, &result)
+	c.Assert(err, jc.ErrorIsNil)
+
+	open := tracer.span("api.Open")
+	c.Assert(open, gc.NotNil)
+	c.Assert(open.parent, gc.IsNil)
+	c.Assert(open.attrs["addrs"], gc.Equals, info.Addrs[0])
+	c.Assert(open.attrs["model"], gc.Equals, info.ModelTag.Id())
+	c.Assert(open.ended(), jc.IsTrue)
+	c.Assert(open.err, jc.ErrorIsNil)
+
+	login := tracer.span("Admin.Login")
+	c.Assert(login, gc.NotNil)
+	c.Assert(login.parent, gc.Equals, open)
+	c.Assert(login.ended(), jc.IsTrue)
+
+	call := tracer.span("Client.FullStatus")
+	c.Assert(call, gc.NotNil)
+	c.Assert(call.parent, gc.IsNil)
+	c.Assert(call.attrs, jc.DeepEquals, map[string]string{
+		"facade":  "Client",
+		"method":  "FullStatus",
+		"version": strconv.Itoa(st.BestFacadeVersion("Client")),
+	})
+	c.Assert(call.ended(), jc.IsTrue)
+	c.Assert(call.err, jc.ErrorIsNil)
+}
+
+func (s *tracingSuite) TestOpenSpanRecordsError(c *gc.C) {
+	tracer := &fakeTracer{}
+	info := s.APIInfo(c)
+	info.Password = "not-the-password"
+	_, err := api.Open(info, api.DialOpts{
+		Tracer: tracer,
+	})
+	c.Assert(err, gc.NotNil)
+
+	open := tracer.span("api.Open")
+	c.Assert(open, gc.NotNil)
+	c.Assert(open.ended(), jc.IsTrue)
+	c.Assert(open.err, gc.Equals, err)
+
+	login := tracer.span("Admin.Login")
+	c.Assert(login, gc.NotNil)
+	c.Assert(login.err, gc.NotNil)
+}
+
+// fakeTracer is an api.Tracer that records the spans started.
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) StartSpan(name string, parent api.Span, attrs map[string]string) api.Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &fakeSpan{
+		name:  name,
+		attrs: attrs,
+	}
+	if parent != nil {
+		span.parent = parent.(*fakeSpan)
+	}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+// span returns the first span started with the given name, or nil.
+func (t *fakeTracer) span(name string) *fakeSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, span := range t.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+type fakeSpan struct {
+	name   string
+	parent *fakeSpan
+	attrs  map[string]string
+
+	mu   sync.Mutex
+	done bool
+	err  error
+}
+
+func (s *fakeSpan) ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+func (s *fakeSpan) End(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.err = err
+}
@@ -21,8 +21,11 @@ func addPackageCommandsCommon(
 	addUpgradeScripts bool,
 	series string,
 ) {
-	// Set the package mirror.
-	cfg.SetPackageMirror(packageMirror)
+	// Set the package mirror. If none is given, any mirror
+	// already set, as by a provider, is left in place.
+	if packageMirror != "" {
+		cfg.SetPackageMirror(packageMirror)
+	}
 
 	// For LTS series which need support for the cloud-tools archive,
 	// we need to enable package-list update regardless of the environ
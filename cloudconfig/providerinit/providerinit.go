@@ -67,6 +67,10 @@ func ComposeUserData(icfg *instancecfg.InstanceConfig, cloudcfg cloudinit.CloudC
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	logger.Tracef("Generated cloud init:\n%s", string(udata))
+	logged := udata
+	if r, ok := renderer.(renderers.Redacter); ok {
+		logged = r.Redact(udata)
+	}
+	logger.Tracef("Generated cloud init:\n%s", string(logged))
 	return udata, err
 }
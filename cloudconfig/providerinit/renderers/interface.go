@@ -22,3 +22,11 @@ import (
 type ProviderRenderer interface {
 	Render(cloudinit.CloudConfig, os.OSType) ([]byte, error)
 }
+
+// Redacter is implemented by ProviderRenderers whose rendered
+// userdata may hold secrets, such as tokens taken from the model
+// configuration. Redact returns a copy of the rendered userdata
+// with the secrets removed, suitable for logging.
+type Redacter interface {
+	Redact(udata []byte) []byte
+}
@@ -1200,6 +1200,18 @@ func (s *cloudinitSuite) TestAptMirrorNotSet(c *gc.C) {
 	s.testAptMirror(c, environConfig, "")
 }
 
+func (s *cloudinitSuite) TestAptMirrorNotSetKeepsExistingMirror(c *gc.C) {
+	instanceCfg := s.createInstanceConfig(c, minimalModelConfig(c))
+	cloudcfg, err := cloudinit.New("quantal")
+	c.Assert(err, jc.ErrorIsNil)
+	cloudcfg.SetPackageMirror("http://mirror.example.com/ubuntu")
+	udata, err := cloudconfig.NewUserdataConfig(instanceCfg, cloudcfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = udata.Configure()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.PackageMirror(), gc.Equals, "http://mirror.example.com/ubuntu")
+}
+
 func (s *cloudinitSuite) testAptMirror(c *gc.C, cfg *config.Config, expect string) {
 	instanceCfg := s.createInstanceConfig(c, cfg)
 	cloudcfg, err := cloudinit.New("quantal")
@@ -116,6 +116,12 @@ func NewAPIConnection(args NewAPIConnectionParams) (api.Connection, error) {
 		AddrConnectedTo:  []network.HostPort{addrConnectedTo},
 		CurrentHostPorts: hostPorts,
 	}
+	if args.DialOpts.DisableAddressLearning {
+		// Only the configured addresses are to be dialed
+		// by later connections, so don't cache any others.
+		params.AddrConnectedTo = nil
+		params.CurrentHostPorts = nil
+	}
 	err = updateControllerDetailsFromLogin(args.Store, args.ControllerName, controller, params)
 	if err != nil {
 		logger.Errorf("cannot cache API addresses: %v", err)
@@ -187,6 +187,42 @@ func (s *NewAPIClientSuite) TestUpdatesLastKnownAccess(c *gc.C) {
 	)
 }
 
+func (s *NewAPIClientSuite) TestDisableAddressLearning(c *gc.C) {
+	store := newClientStore(c, "noconfig")
+
+	var dialed [][]string
+	expectState := mockedAPIState(mockedHostPort | mockedModelTag)
+	apiOpen := func(apiInfo *api.Info, opts api.DialOpts) (api.Connection, error) {
+		dialed = append(dialed, apiInfo.Addrs)
+		return expectState, nil
+	}
+	accountDetails, err := store.AccountDetails("noconfig")
+	c.Assert(err, jc.ErrorIsNil)
+	dialOpts := api.DefaultDialOpts()
+	dialOpts.DisableAddressLearning = true
+	params := juju.NewAPIConnectionParams{
+		Store:          store,
+		ControllerName: "noconfig",
+		AccountDetails: accountDetails,
+		ModelUUID:      fakeUUID,
+		DialOpts:       dialOpts,
+		OpenAPI:        apiOpen,
+	}
+
+	// Connect twice; the second connection must only dial the
+	// original address, even though the server reported others.
+	for i := 0; i < 2; i++ {
+		st, err := juju.NewAPIConnection(params)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(st, gc.Equals, expectState)
+		c.Assert(st.APIHostPorts()[0], gc.HasLen, 2)
+	}
+	c.Assert(dialed, jc.DeepEquals, [][]string{{"0.1.2.3:5678"}, {"0.1.2.3:5678"}})
+	c.Assert(store.Controllers["noconfig"].APIEndpoints, jc.DeepEquals, []string{"0.1.2.3:5678"})
+	// Other details learned on login are still recorded.
+	c.Assert(store.Controllers["noconfig"].AgentVersion, gc.Equals, "1.2.3")
+}
+
 func (s *NewAPIClientSuite) TestWithInfoNoAddresses(c *gc.C) {
 	store := newClientStore(c, "noconfig")
 	err := store.UpdateController("noconfig", jujuclient.ControllerDetails{
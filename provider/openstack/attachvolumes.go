@@ -0,0 +1,67 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package openstack
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils"
+	gooseerrors "gopkg.in/goose.v1/errors"
+)
+
+// attachVolumesAttempt is used when attaching volumes to a new
+// server, which fails until both the server is active and the
+// volume is available.
+var attachVolumesAttempt = utils.AttemptStrategy{
+	Total: 5 * time.Minute,
+	Delay: 5 * time.Second,
+}
+
+// attachVolumes attaches the given volumes to the server with the
+// given id.
+func (e *Environ) attachVolumes(serverId string, volumes []AttachedVolume) error {
+	storageAdapter, err := newOpenstackStorage(e)
+	if err != nil {
+		return errors.Annotate(err, "cannot attach volumes")
+	}
+	return attachVolumes(storageAdapter, serverId, volumes)
+}
+
+// attachVolumes attaches the given volumes to the server with the
+// given id, retrying each until attachVolumesAttempt is exhausted.
+func attachVolumes(storageAdapter OpenstackStorage, serverId string, volumes []AttachedVolume) error {
+	for _, v := range volumes {
+		if err := attachVolumeWhenReady(storageAdapter, serverId, v); err != nil {
+			return errors.Annotatef(err, "cannot attach volume %q", v.VolumeId)
+		}
+		logger.Infof("attached volume %q to %q at %s", v.VolumeId, serverId, v.Device)
+	}
+	return nil
+}
+
+func attachVolumeWhenReady(storageAdapter OpenstackStorage, serverId string, v AttachedVolume) error {
+	var lastErr error
+	for a := attachVolumesAttempt.Start(); a.Next(); {
+		volume, err := storageAdapter.GetVolume(v.VolumeId)
+		if gooseerrors.IsNotFound(err) {
+			return errors.NotFoundf("volume %q", v.VolumeId)
+		}
+		if err != nil {
+			return errors.Annotate(err, "getting volume")
+		}
+		if volume.Status != "available" {
+			lastErr = errors.Errorf("volume is %s", volume.Status)
+			continue
+		}
+		// The attachment is refused while the server is still
+		// being built, so failures are retried.
+		if _, err := storageAdapter.AttachVolume(serverId, v.VolumeId, v.Device); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return errors.Annotatef(lastErr, "timed out after %v", attachVolumesAttempt.Total)
+}
@@ -84,6 +84,68 @@ func (s *cinderVolumeSourceSuite) TestAttachVolumes(c *gc.C) {
 	}})
 }
 
+func (s *cinderVolumeSourceSuite) TestAttachVolumesAtBoot(c *gc.C) {
+	s.PatchValue(openstack.AttachVolumesAttempt, utils.AttemptStrategy{Min: 3})
+	statuses := []string{"creating", "available", "available"}
+	mockAdapter := &mockAdapter{
+		getVolume: func(volumeId string) (*cinder.Volume, error) {
+			status := statuses[0]
+			statuses = statuses[1:]
+			return &cinder.Volume{ID: volumeId, Status: status}, nil
+		},
+		attachVolume: func(serverId, volId, device string) (*nova.VolumeAttachment, error) {
+			return &nova.VolumeAttachment{
+				VolumeId: volId,
+				ServerId: serverId,
+				Device:   device,
+			}, nil
+		},
+	}
+	err := openstack.AttachVolumes(mockAdapter, mockServerId, []openstack.AttachedVolume{
+		{VolumeId: "vol-0", Device: "/dev/xvdb"},
+		{VolumeId: "vol-1", Device: "/dev/xvdc"},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	mockAdapter.CheckCalls(c, []gitjujutesting.StubCall{
+		{"GetVolume", []interface{}{"vol-0"}},
+		{"GetVolume", []interface{}{"vol-0"}},
+		{"AttachVolume", []interface{}{mockServerId, "vol-0", "/dev/xvdb"}},
+		{"GetVolume", []interface{}{"vol-1"}},
+		{"AttachVolume", []interface{}{mockServerId, "vol-1", "/dev/xvdc"}},
+	})
+}
+
+func (s *cinderVolumeSourceSuite) TestAttachVolumesAtBootRetriesAttach(c *gc.C) {
+	s.PatchValue(openstack.AttachVolumesAttempt, utils.AttemptStrategy{Min: 3})
+	attachErrors := []error{errors.New("server is building"), nil}
+	mockAdapter := &mockAdapter{
+		attachVolume: func(serverId, volId, device string) (*nova.VolumeAttachment, error) {
+			err := attachErrors[0]
+			attachErrors = attachErrors[1:]
+			return &nova.VolumeAttachment{}, err
+		},
+	}
+	err := openstack.AttachVolumes(mockAdapter, mockServerId, []openstack.AttachedVolume{
+		{VolumeId: "vol-0", Device: "/dev/xvdb"},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	mockAdapter.CheckCallNames(c, "GetVolume", "AttachVolume", "GetVolume", "AttachVolume")
+}
+
+func (s *cinderVolumeSourceSuite) TestAttachVolumesAtBootTimeout(c *gc.C) {
+	s.PatchValue(openstack.AttachVolumesAttempt, utils.AttemptStrategy{Min: 3})
+	mockAdapter := &mockAdapter{
+		getVolume: func(volumeId string) (*cinder.Volume, error) {
+			return &cinder.Volume{ID: volumeId, Status: "in-use"}, nil
+		},
+	}
+	err := openstack.AttachVolumes(mockAdapter, mockServerId, []openstack.AttachedVolume{
+		{VolumeId: "vol-0", Device: "/dev/xvdb"},
+	})
+	c.Assert(err, gc.ErrorMatches, `cannot attach volume "vol-0": timed out after 0s: volume is in-use`)
+	mockAdapter.CheckCallNames(c, "GetVolume", "GetVolume", "GetVolume")
+}
+
 func (s *cinderVolumeSourceSuite) TestCreateVolume(c *gc.C) {
 	const (
 		requestedSize = 2 * 1024
@@ -16,6 +16,7 @@ import (
 
 	"github.com/juju/juju/constraints"
 	"github.com/juju/juju/environs"
+	"github.com/juju/juju/environs/config"
 	"github.com/juju/juju/environs/imagemetadata"
 	"github.com/juju/juju/environs/instances"
 	"github.com/juju/juju/environs/simplestreams"
@@ -56,7 +57,9 @@ func MetadataStorage(e environs.Environ) envstorage.Storage {
 }
 
 func InstanceAddress(publicIP string, addresses map[string][]nova.IPAddress) string {
-	addr, _ := network.SelectPublicAddress(convertNovaAddresses(publicIP, addresses))
+	addr, _ := network.SelectPublicAddress(convertNovaAddresses(publicIP, addresses, (&defaultConfigurator{}).GetNetworkScope, func(string) network.SpaceName {
+		return ""
+	}))
 	return addr.Value
 }
 
@@ -72,6 +75,8 @@ var (
 	NovaListAvailabilityZones   = &novaListAvailabilityZones
 	AvailabilityZoneAllocations = &availabilityZoneAllocations
 	NewOpenstackStorage         = &newOpenstackStorage
+	AttachVolumesAttempt        = &attachVolumesAttempt
+	AttachVolumes               = attachVolumes
 )
 
 func NewCinderVolumeSource(s OpenstackStorage) storage.VolumeSource {
@@ -429,6 +434,33 @@ func FindInstanceSpec(
 	}, imageMetadata)
 }
 
+// RestrictFlavors makes the given environ choose
+// the flavors of new servers only from those named.
+func RestrictFlavors(e environs.Environ, names ...string) {
+	env := e.(*Environ)
+	env.configurator = &flavorRestrictingConfigurator{
+		ProviderConfigurator: env.configurator,
+		names:                names,
+	}
+}
+
+type flavorRestrictingConfigurator struct {
+	ProviderConfigurator
+	names []string
+}
+
+func (c *flavorRestrictingConfigurator) GetFlavors(cfg *config.Config, flavors []nova.FlavorDetail) ([]nova.FlavorDetail, error) {
+	var result []nova.FlavorDetail
+	for _, flavor := range flavors {
+		for _, name := range c.names {
+			if flavor.Name == name {
+				result = append(result, flavor)
+			}
+		}
+	}
+	return result, nil
+}
+
 func GetSwiftURL(e environs.Environ) (string, error) {
 	return e.(*Environ).client.MakeServiceURL("object-store", nil)
 }
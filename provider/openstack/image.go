@@ -4,6 +4,14 @@
 package openstack
 
 import (
+	"github.com/juju/errors"
+	"github.com/juju/utils/arch"
+	"github.com/juju/utils/set"
+	"gopkg.in/goose.v1/client"
+	gooseerrors "gopkg.in/goose.v1/errors"
+	"gopkg.in/goose.v1/glance"
+	goosehttp "gopkg.in/goose.v1/http"
+
 	"github.com/juju/juju/environs/imagemetadata"
 	"github.com/juju/juju/environs/instances"
 )
@@ -17,10 +25,14 @@ func findInstanceSpec(
 ) (*instances.InstanceSpec, error) {
 	// First construct all available instance types from the supported flavors.
 	nova := e.nova()
-	flavors, err := nova.ListFlavorsDetail()
+	allFlavors, err := nova.ListFlavorsDetail()
 	if err != nil {
 		return nil, err
 	}
+	flavors, err := e.configurator.GetFlavors(e.Config(), allFlavors)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	// Not all needed information is available in flavors,
 	// for e.g. architectures or virtualisation types.
 	// For these properties, we assume that all instance types support
@@ -47,6 +59,12 @@ func findInstanceSpec(
 	images := instances.ImageMetadataToImages(imageMetadata)
 	spec, err := instances.FindInstanceSpec(images, ic, allInstanceTypes)
 	if err != nil {
+		if len(flavors) < len(allFlavors) {
+			return nil, errors.Annotatef(err,
+				"considering only the %d of %d flavors permitted by the model configuration",
+				len(flavors), len(allFlavors),
+			)
+		}
 		return nil, err
 	}
 
@@ -58,3 +76,83 @@ func findInstanceSpec(
 	}
 	return spec, nil
 }
+
+// imageDetailGetter is the part of the glance client used to
+// look up pinned images.
+type imageDetailGetter interface {
+	GetImageDetail(imageId string) (*glance.ImageDetail, error)
+}
+
+var newImageDetailGetter = func(e *Environ) imageDetailGetter {
+	e.ecfgMutex.Lock()
+	defer e.ecfgMutex.Unlock()
+	return glance.New(e.client)
+}
+
+// pinnedImageMetadata returns image metadata describing the image with
+// the given id, for use in place of the metadata found via simplestreams.
+// It checks that the image exists and that its architecture is one of
+// those given. The image metadata does not record the series, so it is
+// up to the operator to pin an image of the right series.
+func pinnedImageMetadata(images imageDetailGetter, id string, arches []string) ([]*imagemetadata.ImageMetadata, error) {
+	detail, err := images.GetImageDetail(id)
+	if gooseerrors.IsNotFound(err) {
+		return nil, errors.NotFoundf("image %q", id)
+	} else if err != nil {
+		return nil, errors.Annotatef(err, "cannot get image %q", id)
+	}
+	imageArch := arch.NormaliseArch(detail.Metadata.Architecture)
+	switch {
+	case imageArch == "" && len(arches) == 1:
+		// Not all images record their architecture,
+		// so trust the operator's choice.
+		logger.Warningf("image %q does not specify its architecture, assuming %s", id, arches[0])
+		imageArch = arches[0]
+	case imageArch == "":
+		return nil, errors.Errorf("image %q does not specify its architecture", id)
+	case !set.NewStrings(arches...).Contains(imageArch):
+		return nil, errors.Errorf("image %q has architecture %s, not one of %v", id, imageArch, arches)
+	}
+	return []*imagemetadata.ImageMetadata{{
+		Id:   id,
+		Arch: imageArch,
+	}}, nil
+}
+
+// ImageFormats holds the formats of an image,
+// as recorded by the Image service.
+type ImageFormats struct {
+	// DiskFormat holds the format of the image's disk, such as vhd.
+	DiskFormat string
+
+	// ContainerFormat holds the format of the container
+	// holding the image's disk, such as bare or ovf.
+	ContainerFormat string
+}
+
+var newImageFormatGetter = func(e *Environ) func(imageId string) (ImageFormats, error) {
+	e.ecfgMutex.Lock()
+	defer e.ecfgMutex.Unlock()
+	c := e.client
+	return func(imageId string) (ImageFormats, error) {
+		return getImageFormats(c, imageId)
+	}
+}
+
+// getImageFormats returns the formats of the image with the given id.
+// They are not reported by the compute API, through which images are
+// otherwise looked up, so are fetched from version 2 of the Image API.
+func getImageFormats(c client.Client, imageId string) (ImageFormats, error) {
+	var resp struct {
+		DiskFormat      string `json:"disk_format"`
+		ContainerFormat string `json:"container_format"`
+	}
+	requestData := goosehttp.RequestData{RespValue: &resp}
+	if err := c.SendRequest(client.GET, "image", "v2/images/"+imageId, &requestData); err != nil {
+		return ImageFormats{}, errors.Annotatef(err, "cannot get formats of image %q", imageId)
+	}
+	return ImageFormats{
+		DiskFormat:      resp.DiskFormat,
+		ContainerFormat: resp.ContainerFormat,
+	}, nil
+}
@@ -1089,6 +1089,38 @@ func (s *localServerSuite) TestFindImageInvalidInstanceConstraint(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `no instance types in some-region matching constraints "instance-type=m1.large"`)
 }
 
+func (s *localServerSuite) TestFindInstanceRestrictedFlavors(c *gc.C) {
+	env := s.Open(c, s.env.Config())
+	openstack.RestrictFlavors(env, "m1.tiny", "m1.medium")
+	imageMetadata := []*imagemetadata.ImageMetadata{{
+		Id:   "image-id",
+		Arch: "amd64",
+	}}
+
+	// m1.small would be chosen from all the flavors.
+	spec, err := openstack.FindInstanceSpec(
+		env, series.LatestLts(), "amd64", "",
+		imageMetadata,
+	)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(spec.InstanceType.Name, gc.Equals, "m1.medium")
+}
+
+func (s *localServerSuite) TestFindInstanceRestrictedFlavorsNoMatch(c *gc.C) {
+	env := s.Open(c, s.env.Config())
+	openstack.RestrictFlavors(env, "m1.tiny")
+	imageMetadata := []*imagemetadata.ImageMetadata{{
+		Id:   "image-id",
+		Arch: "amd64",
+	}}
+	_, err := openstack.FindInstanceSpec(
+		env, series.LatestLts(), "amd64", "instance-type=m1.small",
+		imageMetadata,
+	)
+	c.Assert(err, gc.ErrorMatches, `considering only the 1 of [0-9]+ flavors permitted by the model configuration: `+
+		`no instance types in some-region matching constraints "instance-type=m1.small"`)
+}
+
 func (s *localServerSuite) TestPrecheckInstanceValidInstanceType(c *gc.C) {
 	env := s.Open(c, s.env.Config())
 	cons := constraints.MustParse("instance-type=m1.small")
@@ -288,11 +288,17 @@ func (inst *openstackInstance) Addresses() ([]network.Address, error) {
 		floatingIP = inst.floatingIP.IP
 		logger.Debugf("instance %v has floating IP address: %v", inst.Id(), floatingIP)
 	}
-	return convertNovaAddresses(floatingIP, addresses), nil
+	region := inst.e.cloud.Region
+	networkSpace := func(networkName string) network.SpaceName {
+		return inst.e.configurator.GetNetworkSpace(region, networkName)
+	}
+	return convertNovaAddresses(floatingIP, addresses, inst.e.configurator.GetNetworkScope, networkSpace), nil
 }
 
-// convertNovaAddresses returns nova addresses in generic format
-func convertNovaAddresses(publicIP string, addresses map[string][]nova.IPAddress) []network.Address {
+// convertNovaAddresses returns nova addresses in generic format,
+// using networkScope to find the scope of each network's addresses,
+// and networkSpace the space they are annotated with.
+func convertNovaAddresses(publicIP string, addresses map[string][]nova.IPAddress, networkScope func(string) network.Scope, networkSpace func(string) network.SpaceName) []network.Address {
 	var machineAddresses []network.Address
 	if publicIP != "" {
 		publicAddr := network.NewScopedAddress(publicIP, network.ScopePublic)
@@ -302,10 +308,8 @@ func convertNovaAddresses(publicIP string, addresses map[string][]nova.IPAddress
 	// the map, see lp:1188126 for example. That could potentially be fixed
 	// in goose, or left to be derived by other means.
 	for netName, ips := range addresses {
-		networkScope := network.ScopeUnknown
-		if netName == "public" {
-			networkScope = network.ScopePublic
-		}
+		scope := networkScope(netName)
+		space := networkSpace(netName)
 		for _, address := range ips {
 			// If this address has already been added as a floating IP, skip it.
 			if publicIP == address.Address {
@@ -316,7 +320,8 @@ func convertNovaAddresses(publicIP string, addresses map[string][]nova.IPAddress
 			if address.Version == 6 {
 				addrtype = network.IPv6Address
 			}
-			machineAddr := network.NewScopedAddress(address.Address, networkScope)
+			machineAddr := network.NewScopedAddress(address.Address, scope)
+			machineAddr.SpaceName = space
 			if machineAddr.Type != addrtype {
 				logger.Warningf("derived address type %v, nova reports %v", machineAddr.Type, addrtype)
 			}
@@ -643,7 +648,9 @@ func (e *Environ) SetConfig(cfg *config.Config) error {
 	defer e.ecfgMutex.Unlock()
 	e.ecfgUnlocked = ecfg
 
-	client, err := authClient(e.cloud, ecfg)
+	client, err := e.configurator.GetClient(e.cloud, cfg, func(spec environs.CloudSpec) (client.AuthenticatingClient, error) {
+		return authClient(spec, ecfg)
+	})
 	if err != nil {
 		return errors.Annotate(err, "cannot set config")
 	}
@@ -861,15 +868,33 @@ func (e *Environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 
 	series := args.Tools.OneSeries()
 	arches := args.Tools.Arches()
+	imageMetadata := args.ImageMetadata
+	pinnedImageId, err := e.configurator.GetPinnedImageId(e.Config())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if pinnedImageId != "" {
+		imageMetadata, err = pinnedImageMetadata(newImageDetailGetter(e), pinnedImageId, arches)
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot use pinned image")
+		}
+	}
+	attachedVolumes, err := e.configurator.GetAttachedVolumes(e.Config())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	spec, err := findInstanceSpec(e, &instances.InstanceConstraint{
 		Region:      e.cloud.Region,
 		Series:      series,
 		Arches:      arches,
 		Constraints: args.Constraints,
-	}, args.ImageMetadata)
+	}, imageMetadata)
 	if err != nil {
 		return nil, err
 	}
+	if err := e.configurator.ValidateImage(e.Config(), spec.Image.Id, newImageFormatGetter(e)); err != nil {
+		return nil, errors.Annotatef(err, "cannot use image %q", spec.Image.Id)
+	}
 	tools, err := args.Tools.Match(tools.Filter{Arch: spec.Image.Arch})
 	if err != nil {
 		return nil, errors.Errorf("chosen architecture %v not present in %v", spec.Image.Arch, arches)
@@ -882,11 +907,18 @@ func (e *Environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 	if err := instancecfg.FinishInstanceConfig(args.InstanceConfig, e.Config()); err != nil {
 		return nil, err
 	}
-	cloudcfg, err := e.configurator.GetCloudConfig(args)
+	cloudcfg, err := e.configurator.GetCloudConfig(args, e.Config())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := e.configurator.ModifyCloudConfig(cloudcfg, e.Config(), spec.InstanceType); err != nil {
+		return nil, errors.Trace(err)
+	}
+	renderer, err := e.configurator.GetUserDataRenderer(e.Config())
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	userData, err := providerinit.ComposeUserData(args.InstanceConfig, cloudcfg, OpenstackRenderer{})
+	userData, err := providerinit.ComposeUserData(args.InstanceConfig, cloudcfg, renderer)
 	if err != nil {
 		return nil, errors.Annotate(err, "cannot make user data")
 	}
@@ -902,6 +934,10 @@ func (e *Environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 		logger.Debugf("using network id %q", networkId)
 		networks = append(networks, nova.ServerNetworks{NetworkId: networkId})
 	}
+	networks, err = e.configurator.GetServerNetworks(e.Config(), networks)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	withPublicIP := e.ecfg().useFloatingIP()
 	var publicIP *nova.FloatingIP
 	if withPublicIP {
@@ -1010,6 +1046,22 @@ func (e *Environ) StartInstance(args environs.StartInstanceParams) (*environs.St
 		inst.floatingIP = publicIP
 		logger.Infof("assigned public IP %s to %q", publicIP.IP, inst.Id())
 	}
+	if len(attachedVolumes) > 0 {
+		if err := e.attachVolumes(string(inst.Id()), attachedVolumes); err != nil {
+			if err := e.terminateInstances([]instance.Id{inst.Id()}); err != nil {
+				// ignore the failure at this stage, just log it
+				logger.Debugf("failed to terminate instance %q: %v", inst.Id(), err)
+			}
+			return nil, errors.Trace(err)
+		}
+	}
+	if err := e.configurator.RegisterInstance(e.Config(), e.client, string(inst.Id())); err != nil {
+		if err := e.terminateInstances([]instance.Id{inst.Id()}); err != nil {
+			// ignore the failure at this stage, just log it
+			logger.Debugf("failed to terminate instance %q: %v", inst.Id(), err)
+		}
+		return nil, errors.Annotatef(err, "cannot register instance %q", inst.Id())
+	}
 	return &environs.StartInstanceResult{
 		Instance: inst,
 		Hardware: inst.hardwareCharacteristics(),
@@ -1034,6 +1086,13 @@ func (e *Environ) StopInstances(ids ...instance.Id) error {
 	if err != nil {
 		return err
 	}
+	serverIds := make([]string, len(ids))
+	for i, id := range ids {
+		serverIds[i] = string(id)
+	}
+	if err := e.configurator.DeregisterInstances(e.Config(), e.client, serverIds); err != nil {
+		logger.Warningf("cannot deregister instances %v: %v", ids, err)
+	}
 	logger.Debugf("terminating instances %v", ids)
 	if err := e.terminateInstances(ids); err != nil {
 		return err
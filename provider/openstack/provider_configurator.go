@@ -5,10 +5,15 @@ package openstack
 
 import (
 	"github.com/juju/schema"
+	"gopkg.in/goose.v1/client"
 	"gopkg.in/goose.v1/nova"
 
 	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/cloudconfig/providerinit/renderers"
 	"github.com/juju/juju/environs"
+	"github.com/juju/juju/environs/config"
+	"github.com/juju/juju/environs/instances"
+	"github.com/juju/juju/network"
 )
 
 // This interface is added to allow to customize openstack provider behaviour.
@@ -17,26 +22,173 @@ type ProviderConfigurator interface {
 	// GetConfigDefaults sets some configuration default values, if any
 	GetConfigDefaults() schema.Defaults
 
+	// This method returns the client with which requests are made
+	// of the cloud described by the given spec, given a function
+	// that makes a new, unauthenticated, one for a cloud spec.
+	// Providers can use it to share clients, and so the tokens they
+	// authenticate with, between environs, or to change the spec,
+	// such as to use another identity endpoint.
+	GetClient(spec environs.CloudSpec, cfg *config.Config, newClient func(environs.CloudSpec) (client.AuthenticatingClient, error)) (client.AuthenticatingClient, error)
+
 	// This method allows to adjust defult RunServerOptions, before new server is actually created.
 	ModifyRunServerOptions(options *nova.RunServerOpts)
 
 	// This method provides default cloud config.
 	// This config can be different for different providers.
-	GetCloudConfig(args environs.StartInstanceParams) (cloudinit.CloudConfig, error)
+	// The model configuration is supplied so that providers
+	// can customise the cloud config using their own attributes.
+	GetCloudConfig(args environs.StartInstanceParams, cfg *config.Config) (cloudinit.CloudConfig, error)
+
+	// This method allows to adjust the cloud config provided by
+	// GetCloudConfig once the instance type of the new server has
+	// been chosen, so that providers can customise it using the
+	// server's resources, such as its memory and root disk.
+	ModifyCloudConfig(cloudcfg cloudinit.CloudConfig, cfg *config.Config, instType instances.InstanceType) error
+
+	// This method provides the renderer used to encode the user data
+	// passed to new servers. The model configuration is supplied so
+	// that providers can choose an encoding using their own attributes.
+	GetUserDataRenderer(cfg *config.Config) (renderers.ProviderRenderer, error)
+
+	// This method returns the id of an image that new servers must
+	// use, in place of one found in the simplestreams image metadata,
+	// or the empty string if the image metadata should be used.
+	GetPinnedImageId(cfg *config.Config) (string, error)
+
+	// This method checks that new servers can boot the image with
+	// the given id, chosen for them using the image metadata or
+	// pinned, given a function that looks up the formats of images.
+	// Providers can use it to reject images in formats their cloud
+	// cannot boot, rather than start servers that never boot.
+	ValidateImage(cfg *config.Config, imageId string, formats func(imageId string) (ImageFormats, error)) error
+
+	// This method returns the existing volumes to attach to new
+	// servers once they have started, if any.
+	GetAttachedVolumes(cfg *config.Config) ([]AttachedVolume, error)
+
+	// This method returns the networks to attach new servers to,
+	// given those chosen by the firewaller and the network
+	// attribute. Providers can use it to honour their own
+	// attributes, for example by removing a public network.
+	GetServerNetworks(cfg *config.Config, networks []nova.ServerNetworks) ([]nova.ServerNetworks, error)
+
+	// This method returns the scope of the addresses a server
+	// reports for the named network.
+	GetNetworkScope(networkName string) network.Scope
+
+	// This method returns the space that the addresses a server
+	// in the given region reports for the named network are
+	// annotated with, if any. Providers can use it to tell the
+	// addresses of one region from those of another, so that
+	// clients can prefer those in their own region.
+	GetNetworkSpace(region, networkName string) network.SpaceName
+
+	// This method returns the flavors from which those of new
+	// servers are chosen to satisfy their constraints, given all
+	// those the cloud offers. Providers can use it to honour their
+	// own attributes, for example by choosing only flavors of a
+	// given class.
+	GetFlavors(cfg *config.Config, flavors []nova.FlavorDetail) ([]nova.FlavorDetail, error)
+
+	// This method registers the new server with the given id, once
+	// it has started, with any services it should be registered
+	// with, using the given client. Providers can use it to add
+	// servers to their own load balancers, for example. If it fails,
+	// the server is terminated.
+	RegisterInstance(cfg *config.Config, client client.AuthenticatingClient, serverId string) error
+
+	// This method deregisters the servers with the given ids from
+	// the services RegisterInstance registered them with, before
+	// they are terminated, using the given client. If it fails, the
+	// failure is logged and the servers terminated regardless.
+	DeregisterInstances(cfg *config.Config, client client.AuthenticatingClient, serverIds []string) error
+}
+
+// AttachedVolume describes an existing volume to be attached to a
+// new server once it has started.
+type AttachedVolume struct {
+	// VolumeId holds the id of the volume.
+	VolumeId string
+
+	// Device holds the device at which the volume is attached.
+	Device string
 }
 
 type defaultConfigurator struct {
 }
 
+// GetClient implements ProviderConfigurator interface.
+func (c *defaultConfigurator) GetClient(spec environs.CloudSpec, cfg *config.Config, newClient func(environs.CloudSpec) (client.AuthenticatingClient, error)) (client.AuthenticatingClient, error) {
+	return newClient(spec)
+}
+
 // ModifyRunServerOptions implements ProviderConfigurator interface.
 func (c *defaultConfigurator) ModifyRunServerOptions(options *nova.RunServerOpts) {
 }
 
 // GetCloudConfig implements ProviderConfigurator interface.
-func (c *defaultConfigurator) GetCloudConfig(args environs.StartInstanceParams) (cloudinit.CloudConfig, error) {
+func (c *defaultConfigurator) GetCloudConfig(args environs.StartInstanceParams, cfg *config.Config) (cloudinit.CloudConfig, error) {
 	return nil, nil
 }
 
+// ModifyCloudConfig implements ProviderConfigurator interface.
+func (c *defaultConfigurator) ModifyCloudConfig(cloudcfg cloudinit.CloudConfig, cfg *config.Config, instType instances.InstanceType) error {
+	return nil
+}
+
+// GetUserDataRenderer implements ProviderConfigurator interface.
+func (c *defaultConfigurator) GetUserDataRenderer(cfg *config.Config) (renderers.ProviderRenderer, error) {
+	return OpenstackRenderer{}, nil
+}
+
+// GetPinnedImageId implements ProviderConfigurator interface.
+func (c *defaultConfigurator) GetPinnedImageId(cfg *config.Config) (string, error) {
+	return "", nil
+}
+
+// ValidateImage implements ProviderConfigurator interface.
+func (c *defaultConfigurator) ValidateImage(cfg *config.Config, imageId string, formats func(imageId string) (ImageFormats, error)) error {
+	return nil
+}
+
+// GetAttachedVolumes implements ProviderConfigurator interface.
+func (c *defaultConfigurator) GetAttachedVolumes(cfg *config.Config) ([]AttachedVolume, error) {
+	return nil, nil
+}
+
+// GetServerNetworks implements ProviderConfigurator interface.
+func (c *defaultConfigurator) GetServerNetworks(cfg *config.Config, networks []nova.ServerNetworks) ([]nova.ServerNetworks, error) {
+	return networks, nil
+}
+
+// GetNetworkScope implements ProviderConfigurator interface.
+func (c *defaultConfigurator) GetNetworkScope(networkName string) network.Scope {
+	if networkName == "public" {
+		return network.ScopePublic
+	}
+	return network.ScopeUnknown
+}
+
+// GetNetworkSpace implements ProviderConfigurator interface.
+func (c *defaultConfigurator) GetNetworkSpace(region, networkName string) network.SpaceName {
+	return ""
+}
+
+// GetFlavors implements ProviderConfigurator interface.
+func (c *defaultConfigurator) GetFlavors(cfg *config.Config, flavors []nova.FlavorDetail) ([]nova.FlavorDetail, error) {
+	return flavors, nil
+}
+
+// RegisterInstance implements ProviderConfigurator interface.
+func (c *defaultConfigurator) RegisterInstance(cfg *config.Config, client client.AuthenticatingClient, serverId string) error {
+	return nil
+}
+
+// DeregisterInstances implements ProviderConfigurator interface.
+func (c *defaultConfigurator) DeregisterInstances(cfg *config.Config, client client.AuthenticatingClient, serverIds []string) error {
+	return nil
+}
+
 // GetConfigDefaults implements ProviderConfigurator interface.
 func (c *defaultConfigurator) GetConfigDefaults() schema.Defaults {
 	return schema.Defaults{
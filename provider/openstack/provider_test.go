@@ -4,13 +4,17 @@
 package openstack
 
 import (
+	"github.com/juju/errors"
 	gitjujutesting "github.com/juju/testing"
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
+	gooseerrors "gopkg.in/goose.v1/errors"
+	"gopkg.in/goose.v1/glance"
 	"gopkg.in/goose.v1/nova"
 
 	"github.com/juju/juju/cloud"
 	"github.com/juju/juju/environs"
+	"github.com/juju/juju/environs/imagemetadata"
 	"github.com/juju/juju/network"
 )
 
@@ -213,6 +217,24 @@ func (t *localTests) TestGetServerAddresses(c *gc.C) {
 	}
 }
 
+func (*localTests) TestConvertNovaAddressesSpaces(c *gc.C) {
+	addresses := convertNovaAddresses("203.0.113.1", map[string][]nova.IPAddress{
+		"private": {{Version: 4, Address: "10.0.0.1"}},
+	}, (&defaultConfigurator{}).GetNetworkScope, func(networkName string) network.SpaceName {
+		return network.SpaceName("space-" + networkName)
+	})
+	c.Assert(addresses, jc.DeepEquals, []network.Address{{
+		Value: "203.0.113.1",
+		Type:  network.IPv4Address,
+		Scope: network.ScopePublic,
+	}, {
+		Value:     "10.0.0.1",
+		Type:      network.IPv4Address,
+		Scope:     network.ScopeUnknown,
+		SpaceName: "space-private",
+	}})
+}
+
 func (*localTests) TestPortsToRuleInfo(c *gc.C) {
 	groupId := "groupid"
 	testCases := []struct {
@@ -401,3 +423,67 @@ func (s *providerUnitTests) TestIdentityClientVersion_ParsesGoodURL(c *gc.C) {
 	c.Assert(err, jc.ErrorIsNil)
 	c.Check(version, gc.Equals, 2)
 }
+
+type fakeImageDetailGetter struct {
+	images map[string]glance.ImageDetail
+	err    error
+}
+
+func (f *fakeImageDetailGetter) GetImageDetail(id string) (*glance.ImageDetail, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	detail, ok := f.images[id]
+	if !ok {
+		return nil, gooseerrors.NewNotFoundf(nil, id, "image %q", id)
+	}
+	return &detail, nil
+}
+
+func (s *providerUnitTests) TestPinnedImageMetadata(c *gc.C) {
+	images := &fakeImageDetailGetter{images: map[string]glance.ImageDetail{
+		"image-0": {Id: "image-0", Metadata: glance.ImageMetadata{Architecture: "x86_64"}},
+	}}
+	metadata, err := pinnedImageMetadata(images, "image-0", []string{"amd64", "arm64"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(metadata, jc.DeepEquals, []*imagemetadata.ImageMetadata{{
+		Id:   "image-0",
+		Arch: "amd64",
+	}})
+}
+
+func (s *providerUnitTests) TestPinnedImageMetadataNoArchitecture(c *gc.C) {
+	images := &fakeImageDetailGetter{images: map[string]glance.ImageDetail{
+		"image-0": {Id: "image-0"},
+	}}
+	metadata, err := pinnedImageMetadata(images, "image-0", []string{"amd64"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(metadata, jc.DeepEquals, []*imagemetadata.ImageMetadata{{
+		Id:   "image-0",
+		Arch: "amd64",
+	}})
+
+	_, err = pinnedImageMetadata(images, "image-0", []string{"amd64", "arm64"})
+	c.Assert(err, gc.ErrorMatches, `image "image-0" does not specify its architecture`)
+}
+
+func (s *providerUnitTests) TestPinnedImageMetadataNotFound(c *gc.C) {
+	images := &fakeImageDetailGetter{}
+	_, err := pinnedImageMetadata(images, "image-0", []string{"amd64"})
+	c.Assert(err, gc.ErrorMatches, `image "image-0" not found`)
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *providerUnitTests) TestPinnedImageMetadataError(c *gc.C) {
+	images := &fakeImageDetailGetter{err: errors.New("boom")}
+	_, err := pinnedImageMetadata(images, "image-0", []string{"amd64"})
+	c.Assert(err, gc.ErrorMatches, `cannot get image "image-0": boom`)
+}
+
+func (s *providerUnitTests) TestPinnedImageMetadataArchMismatch(c *gc.C) {
+	images := &fakeImageDetailGetter{images: map[string]glance.ImageDetail{
+		"image-0": {Id: "image-0", Metadata: glance.ImageMetadata{Architecture: "ppc64el"}},
+	}}
+	_, err := pinnedImageMetadata(images, "image-0", []string{"amd64", "arm64"})
+	c.Assert(err, gc.ErrorMatches, `image "image-0" has architecture ppc64el, not one of \[amd64 arm64\]`)
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+)
+
+// validateBootCommands checks that none of the
+// given boot commands is empty.
+func validateBootCommands(cmds []string) error {
+	for i, cmd := range cmds {
+		if strings.TrimSpace(cmd) == "" {
+			return errors.NotValidf("%s line %d (expected a command)", bootCmdKey, i+1)
+		}
+	}
+	return nil
+}
+
+// addBootCommands configures the instance with the given cloud
+// config to run the given commands as cloud-init bootcmds, before
+// any of Juju's own, so that they can prepare the instance, as by
+// fixing its MTU, before the rest of its configuration is applied.
+func addBootCommands(cloudcfg cloudinit.CloudConfig, cmds []string) error {
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu, jujuos.CentOS:
+	default:
+		return errors.NotSupportedf("running boot commands on %s", os)
+	}
+	for _, cmd := range cmds {
+		cloudcfg.AddBootCmd(cmd)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/juju/errors"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+)
+
+// centOSCAAnchorsDir is where certificates are added
+// to the system trust store on new CentOS instances.
+const centOSCAAnchorsDir = "/etc/pki/ca-trust/source/anchors"
+
+// parseTrustedCACerts returns the PEM-encoded certificates
+// concatenated in the given value of trusted-ca-certs, each
+// re-encoded on its own, checking that each is a certificate
+// and that nothing else is given.
+func parseTrustedCACerts(value string) ([]string, error) {
+	var certs []string
+	rest := []byte(value)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.NotValidf("%s entry %d (expected a CERTIFICATE block, got %s)", trustedCACertsKey, len(certs)+1, block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, errors.NewNotValid(err, fmt.Sprintf("%s entry %d", trustedCACertsKey, len(certs)+1))
+		}
+		certs = append(certs, string(pem.EncodeToMemory(block)))
+	}
+	if strings.TrimSpace(string(rest)) != "" {
+		return nil, errors.NotValidf("%s (expected only PEM-encoded certificates)", trustedCACertsKey)
+	}
+	return certs, nil
+}
+
+// addTrustedCACerts configures the instance with the given
+// cloud config to add the given PEM-encoded certificates to the
+// system trust store, so that services signed by them, such as
+// private package repositories, are trusted before packages are
+// installed.
+//
+// On Ubuntu this uses the cloud-init ca-certs module. The releases
+// of cloud-init on CentOS do not support it there, so the
+// certificates are instead written to the trust store's anchors
+// and the store extracted by bootcmds, which are run as early.
+func addTrustedCACerts(cloudcfg cloudinit.CloudConfig, certs []string) error {
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu:
+		cloudcfg.SetAttr("ca-certs", map[string]interface{}{
+			"trusted": certs,
+		})
+	case jujuos.CentOS:
+		for i, cert := range certs {
+			path := fmt.Sprintf("%s/juju-trusted-ca-%d.crt", centOSCAAnchorsDir, i)
+			cloudcfg.AddBootTextFile(path, strings.TrimSuffix(cert, "\n"), 0644)
+		}
+		cloudcfg.AddBootCmd("update-ca-trust extract")
+	default:
+		return errors.NotSupportedf("adding trusted CA certificates on %s", os)
+	}
+	return nil
+}
@@ -0,0 +1,808 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/schema"
+	"github.com/juju/utils"
+	"gopkg.in/juju/environschema.v1"
+
+	"github.com/juju/juju/environs/config"
+	"github.com/juju/juju/provider/openstack"
+)
+
+const (
+	// manageIptablesPersistenceKey is the model attribute that
+	// controls whether instances have iptables-persistent
+	// installed to save their firewall rules between restarts.
+	manageIptablesPersistenceKey = "manage-iptables-persistence"
+
+	// compressUserDataKey is the model attribute that controls
+	// whether the user data passed to new instances is compressed.
+	compressUserDataKey = "compress-user-data"
+
+	// injectedFilesKey is the model attribute holding files,
+	// keyed by path, to inject into new instances using the
+	// server personality extension.
+	injectedFilesKey = "injected-files"
+
+	// networkConfigVersionKey is the model attribute holding the
+	// version of the cloud-init network configuration format to
+	// emit for new instances, if any.
+	networkConfigVersionKey = "network-config-version"
+
+	// imageIdKey is the model attribute holding the id of the
+	// image to use for new instances, if any.
+	imageIdKey = "image-id"
+
+	// dnsNameserversKey is the model attribute holding a
+	// comma-separated list of nameservers to be used by new
+	// instances in place of those provided by Rackspace.
+	dnsNameserversKey = "dns-nameservers"
+
+	// diskLayoutKey is the model attribute holding the layout
+	// applied to the data disks of new instances, if any.
+	diskLayoutKey = "disk-layout"
+
+	// dataDisksKey is the model attribute holding a comma-separated
+	// list of the data disk devices of new instances.
+	dataDisksKey = "data-disks"
+
+	// attachVolumesKey is the model attribute holding existing
+	// volumes, keyed by id, to attach to new instances.
+	attachVolumesKey = "attach-volumes"
+
+	// allocatePublicIPKey is the model attribute that controls
+	// whether new instances are attached to PublicNet, and so
+	// have a public address.
+	allocatePublicIPKey = "allocate-public-ip"
+
+	// monitoringAgentTokenKey is the model attribute holding the
+	// token with which the Rackspace Cloud Monitoring agent on new
+	// instances authenticates, if the agent should be installed.
+	monitoringAgentTokenKey = "monitoring-agent-token"
+
+	// schedulerHintsKey is the model attribute holding the
+	// scheduler hints passed to the compute API when new
+	// instances are started.
+	schedulerHintsKey = "scheduler-hints"
+
+	// keypairNameKey is the model attribute holding the name of
+	// the provider keypair whose key new instances are given.
+	keypairNameKey = "keypair-name"
+
+	// sshKeyModeKey is the model attribute that controls whether
+	// new instances are given Juju's authorized keys, the keys of
+	// a provider keypair, or both.
+	sshKeyModeKey = "ssh-key-mode"
+
+	// packageMirrorKey is the model attribute holding the URL of
+	// the package mirror to be used by new instances, if any.
+	packageMirrorKey = "package-mirror"
+
+	// configDriveFormatKey is the model attribute holding the
+	// filesystem format of the config drive of new instances.
+	configDriveFormatKey = "config-drive-format"
+
+	// timezoneKey is the model attribute holding the timezone
+	// of new instances, if it should be set.
+	timezoneKey = "timezone"
+
+	// ntpServersKey is the model attribute holding a
+	// comma-separated list of the NTP servers to be used by
+	// new instances in place of their defaults.
+	ntpServersKey = "ntp-servers"
+
+	// flavorClassKey is the model attribute holding the class
+	// of the flavors new instances may use, if it is limited.
+	flavorClassKey = "flavor-class"
+
+	// swapSizeKey is the model attribute holding the size of the
+	// swap file of new instances, either absolute or relative to
+	// their memory.
+	swapSizeKey = "swap-size"
+
+	// accountTypeKey is the model attribute holding whether the
+	// Rackspace account is a managed or an unmanaged one.
+	accountTypeKey = "rackspace-account-type"
+
+	// phoneHomeURLKey is the model attribute holding the URL
+	// new instances post to once cloud-init has finished.
+	phoneHomeURLKey = "phone-home-url"
+
+	// packageUpdateKey is the model attribute that controls
+	// whether new instances update their package lists at boot.
+	packageUpdateKey = "package-update"
+
+	// packageUpgradeKey is the model attribute that controls
+	// whether new instances upgrade their packages at boot.
+	packageUpgradeKey = "package-upgrade"
+
+	// allowUnsupportedImageFormatsKey is the model attribute that
+	// controls whether new instances may use images in formats
+	// that Rackspace is not known to boot.
+	allowUnsupportedImageFormatsKey = "allow-unsupported-image-formats"
+
+	// identityEndpointKey is the model attribute holding the URL
+	// of the identity service to authenticate with, if it is not
+	// that of the cloud.
+	identityEndpointKey = "identity-endpoint"
+
+	// computeEndpointKey is the model attribute holding the URL
+	// of the compute API, if it is not that in the identity
+	// service catalog for the region.
+	computeEndpointKey = "compute-endpoint"
+
+	// localStoragePersistenceKey is the model attribute holding
+	// whether the local storage of new instances must persist.
+	localStoragePersistenceKey = "local-storage-persistence"
+
+	// cloudLoadBalancerIdKey is the model attribute holding the id
+	// of the cloud load balancer new instances are registered with.
+	cloudLoadBalancerIdKey = "cloud-load-balancer-id"
+
+	// trustedCACertsKey is the model attribute holding the
+	// PEM-encoded CA certificates new instances should trust.
+	trustedCACertsKey = "trusted-ca-certs"
+
+	// bootCmdKey is the model attribute holding the commands
+	// new instances should run early in each boot, one per line.
+	bootCmdKey = "bootcmd"
+
+	// computeAPIMicroversionKey is the model attribute holding
+	// the compute API microversion requested by the provider.
+	computeAPIMicroversionKey = "compute-api-microversion"
+
+	// preemptibleKey is the model attribute that controls
+	// whether new instances use preemptible flavors.
+	preemptibleKey = "preemptible"
+)
+
+// The ways in which the authorized keys of new instances
+// may be chosen.
+const (
+	sshKeyModeMerge        = "merge"
+	sshKeyModeProviderOnly = "provider-only"
+	sshKeyModeJujuOnly     = "juju-only"
+)
+
+// configDriveFormatISO9660 is the filesystem
+// format of Rackspace config drives.
+const configDriveFormatISO9660 = "iso9660"
+
+// The classes of Rackspace flavors that new instances may be limited to.
+const (
+	flavorClassGeneral = "general"
+	flavorClassCompute = "compute"
+	flavorClassMemory  = "memory"
+	flavorClassIO      = "io"
+)
+
+// The persistence of the local storage of new instances.
+const (
+	localStorageEphemeral  = "ephemeral"
+	localStoragePersistent = "persistent"
+)
+
+// The types of Rackspace account.
+const (
+	accountTypeManaged   = "managed"
+	accountTypeUnmanaged = "unmanaged"
+)
+
+// The limits Rackspace places on the server personality.
+const (
+	maxInjectedFiles          = 5
+	maxInjectedFilePathBytes  = 255
+	maxInjectedFileTotalBytes = 5000
+)
+
+// configSchema holds the rackspace specific model attributes.
+// These are in addition to those of the openstack provider.
+var configSchema = environschema.Fields{
+	manageIptablesPersistenceKey: {
+		Description: "Whether the iptables-persistent package should be installed on new instances to persist their firewall rules. Disable this for images that already manage firewall persistence.",
+		Type:        environschema.Tbool,
+	},
+	compressUserDataKey: {
+		Description: "Whether the cloud-init user data passed to new instances is gzip compressed. This keeps large configurations within the Rackspace user data size limit.",
+		Type:        environschema.Tbool,
+	},
+	injectedFilesKey: {
+		Description: "Files to inject into new instances at boot using the server personality, as a map from path to contents. This is independent of any files written by cloud-init.",
+		Type:        environschema.Tattrs,
+	},
+	networkConfigVersionKey: {
+		Description: "The cloud-init network configuration format (1 or 2) used to configure the interfaces of new instances. If unset, no network configuration is emitted.",
+		Type:        environschema.Tint,
+	},
+	imageIdKey: {
+		Description: "The id of the image to use for new instances, in place of one found in the image metadata. Its series is not checked.",
+		Type:        environschema.Tstring,
+	},
+	allowUnsupportedImageFormatsKey: {
+		Description: "Whether new instances may use images whose disk or container format Rackspace is not known to boot. If false, such images are rejected.",
+		Type:        environschema.Tbool,
+	},
+	dnsNameserversKey: {
+		Description: "A comma-separated list of nameserver IP addresses to be used by new instances. If unset, the Rackspace resolvers are used.",
+		Type:        environschema.Tstring,
+	},
+	diskLayoutKey: {
+		Description: "How the data-disks of new instances are formatted and mounted: raid0 stripes them into a single filesystem, and separate gives each its own. If unset, they are left unformatted.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{diskLayoutRAID0, diskLayoutSeparate},
+	},
+	dataDisksKey: {
+		Description: "A comma-separated list of the data disk devices provided by the flavor of new instances, for example /dev/xvde,/dev/xvdf.",
+		Type:        environschema.Tstring,
+	},
+	attachVolumesKey: {
+		Description: "Existing Cloud Block Storage volumes to attach to new instances once they are active, as a map from volume id to device and mount point, for example /dev/xvdb:/srv/data.",
+		Type:        environschema.Tattrs,
+	},
+	allocatePublicIPKey: {
+		Description: "Whether new instances are attached to PublicNet as well as ServiceNet. If false, instances have no public address.",
+		Type:        environschema.Tbool,
+	},
+	monitoringAgentTokenKey: {
+		Description: "The token with which the Rackspace Cloud Monitoring agent authenticates. If set, the agent is installed on new Ubuntu and CentOS instances.",
+		Type:        environschema.Tstring,
+		Secret:      true,
+	},
+	schedulerHintsKey: {
+		Description: "Scheduler hints to pass to the compute API when starting new instances, as a map from hint to value. The different_host and same_host hints take a comma-separated list of server ids.",
+		Type:        environschema.Tattrs,
+	},
+	keypairNameKey: {
+		Description: "The name of the provider keypair whose key new instances are given, as chosen by ssh-key-mode. If unset, no keypair is used.",
+		Type:        environschema.Tstring,
+	},
+	sshKeyModeKey: {
+		Description: "Which authorized keys new instances are given: merge gives them both Juju's keys and that of keypair-name, juju-only Juju's keys alone, and provider-only the keypair's alone.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{sshKeyModeMerge, sshKeyModeProviderOnly, sshKeyModeJujuOnly},
+	},
+	configDriveFormatKey: {
+		Description: "The filesystem format of the config drive attached to new instances. Only iso9660, the format Rackspace provides, is accepted.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{configDriveFormatISO9660},
+	},
+	timezoneKey: {
+		Description: "The timezone of new instances, as a tz database name such as UTC or Europe/London. If unset, the timezone of the image is kept.",
+		Type:        environschema.Tstring,
+	},
+	ntpServersKey: {
+		Description: "A comma-separated list of the NTP servers to be used by new instances. If unset, the default servers are used.",
+		Type:        environschema.Tstring,
+	},
+	flavorClassKey: {
+		Description: "The class of the flavors new instances may use: general, compute, memory or io. If unset, flavors of any class may be used.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{flavorClassGeneral, flavorClassCompute, flavorClassMemory, flavorClassIO},
+	},
+	accountTypeKey: {
+		Description: "The type of the Rackspace account, managed or unmanaged. On managed accounts, new instances wait for Rackspace's automation to complete before Juju's agent is set up.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{accountTypeManaged, accountTypeUnmanaged},
+	},
+	phoneHomeURLKey: {
+		Description: "The http or https URL that new instances post their instance id, hostname and FQDN to once cloud-init has finished. If unset, instances do not phone home.",
+		Type:        environschema.Tstring,
+	},
+	swapSizeKey: {
+		Description: "The size of a swap file to be created on new instances, in megabytes or with a suffix such as G, or as a multiple of the flavor's memory, such as 2xRAM. If unset, no swap file is created.",
+		Type:        environschema.Tstring,
+	},
+	packageUpdateKey: {
+		Description: "Whether new instances update their package lists when they first boot. If unset, enable-os-refresh-update is used.",
+		Type:        environschema.Tbool,
+	},
+	packageUpgradeKey: {
+		Description: "Whether new instances upgrade their packages when they first boot. If unset, enable-os-upgrade is used.",
+		Type:        environschema.Tbool,
+	},
+	identityEndpointKey: {
+		Description: "The https URL of the identity service to authenticate with, in place of the endpoint of the cloud. If unset, the endpoint of the cloud is used.",
+		Type:        environschema.Tstring,
+	},
+	computeEndpointKey: {
+		Description: "The https URL of the compute API, including the tenant id, in place of the one listed for the region. If unset, the listed one is used.",
+		Type:        environschema.Tstring,
+	},
+	localStoragePersistenceKey: {
+		Description: "Whether the local storage of new instances must persist for the life of the server, ephemeral or persistent. If persistent, only flavors with a local system disk are used.",
+		Type:        environschema.Tstring,
+		Values:      []interface{}{localStorageEphemeral, localStoragePersistent},
+	},
+	cloudLoadBalancerIdKey: {
+		Description: "The id of a Cloud Load Balancer in the region that new instances are registered with once they are active. If unset, instances are not registered with any load balancer.",
+		Type:        environschema.Tstring,
+	},
+	trustedCACertsKey: {
+		Description: "PEM-encoded CA certificates to be added to the system trust store of new instances when they first boot. If unset, no certificates are added.",
+		Type:        environschema.Tstring,
+	},
+	bootCmdKey: {
+		Description: "Shell commands, one per line, to be run as cloud-init bootcmds early in every boot of new instances. If unset, no commands are added.",
+		Type:        environschema.Tstring,
+	},
+	computeAPIMicroversionKey: {
+		Description: "The compute API microversion requested with each compute API request, from 2.1 to 2.35. If unset, none is requested.",
+		Type:        environschema.Tstring,
+	},
+	preemptibleKey: {
+		Description: "Whether new instances use preemptible flavors, which the region may reclaim at any time. New instances stop their Juju agents when preempted; this requires Ubuntu xenial or later, or CentOS.",
+		Type:        environschema.Tbool,
+	},
+	packageMirrorKey: {
+		Description: "The http or https URL of a package mirror to be used by new instances in place of the distribution's. If apt-mirror is set, it takes precedence.",
+		Type:        environschema.Tstring,
+	},
+}
+
+var configDefaults = schema.Defaults{
+	manageIptablesPersistenceKey:    true,
+	compressUserDataKey:             true,
+	injectedFilesKey:                schema.Omit,
+	networkConfigVersionKey:         schema.Omit,
+	imageIdKey:                      schema.Omit,
+	dnsNameserversKey:               schema.Omit,
+	diskLayoutKey:                   schema.Omit,
+	dataDisksKey:                    schema.Omit,
+	attachVolumesKey:                schema.Omit,
+	allocatePublicIPKey:             true,
+	monitoringAgentTokenKey:         schema.Omit,
+	schedulerHintsKey:               schema.Omit,
+	keypairNameKey:                  schema.Omit,
+	sshKeyModeKey:                   sshKeyModeMerge,
+	packageMirrorKey:                schema.Omit,
+	configDriveFormatKey:            configDriveFormatISO9660,
+	timezoneKey:                     schema.Omit,
+	ntpServersKey:                   schema.Omit,
+	flavorClassKey:                  schema.Omit,
+	swapSizeKey:                     schema.Omit,
+	accountTypeKey:                  accountTypeUnmanaged,
+	phoneHomeURLKey:                 schema.Omit,
+	packageUpdateKey:                schema.Omit,
+	packageUpgradeKey:               schema.Omit,
+	allowUnsupportedImageFormatsKey: false,
+	identityEndpointKey:             schema.Omit,
+	computeEndpointKey:              schema.Omit,
+	localStoragePersistenceKey:      localStorageEphemeral,
+	cloudLoadBalancerIdKey:          schema.Omit,
+	trustedCACertsKey:               schema.Omit,
+	bootCmdKey:                      schema.Omit,
+	computeAPIMicroversionKey:       defaultComputeAPIMicroversion,
+	preemptibleKey:                  false,
+}
+
+var configFields = func() schema.Fields {
+	fs, _, err := configSchema.ValidationSchema()
+	if err != nil {
+		panic(err)
+	}
+	return fs
+}()
+
+// environConfig provides access to the rackspace
+// specific attributes of a model configuration.
+type environConfig struct {
+	*config.Config
+	attrs map[string]interface{}
+}
+
+// newConfig validates the rackspace specific attributes of the
+// given configuration, filling in any defaults.
+func newConfig(cfg *config.Config) (*environConfig, error) {
+	attrs, err := cfg.ValidateUnknownAttrs(configFields, configDefaults)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ecfg := &environConfig{cfg, attrs}
+	if err := validateInjectedFiles(ecfg.injectedFiles()); err != nil {
+		return nil, errors.Annotatef(err, "invalid %s", injectedFilesKey)
+	}
+	for _, ns := range ecfg.dnsNameservers() {
+		if net.ParseIP(ns) == nil {
+			return nil, errors.NotValidf("%s entry %q (expected an IP address)", dnsNameserversKey, ns)
+		}
+	}
+	if tz := ecfg.timezone(); tz != "" {
+		if err := validateTimezone(tz); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	for _, server := range ecfg.ntpServers() {
+		if err := validateNTPServer(server); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	for _, disk := range ecfg.dataDisks() {
+		if !strings.HasPrefix(disk, "/dev/") {
+			return nil, errors.NotValidf("%s entry %q (expected a device path)", dataDisksKey, disk)
+		}
+	}
+	if _, err := ecfg.attachVolumes(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := validateSchedulerHints(ecfg.schedulerHints()); err != nil {
+		return nil, errors.Annotatef(err, "invalid %s", schedulerHintsKey)
+	}
+	if _, err := ecfg.trustedCACerts(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := validateBootCommands(ecfg.bootCommands()); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := validateComputeAPIMicroversion(ecfg.computeAPIMicroversion()); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err := ecfg.swapSize(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if ecfg.sshKeyMode() == sshKeyModeProviderOnly && ecfg.keypairName() == "" {
+		return nil, errors.NotValidf("%s %q without %s", sshKeyModeKey, sshKeyModeProviderOnly, keypairNameKey)
+	}
+	if mirror := ecfg.packageMirror(); mirror != "" {
+		if err := validatePackageMirror(mirror); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if phoneHome := ecfg.phoneHomeURL(); phoneHome != "" {
+		if err := validatePhoneHomeURL(phoneHome); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if endpoint := ecfg.identityEndpoint(); endpoint != "" {
+		if err := validateEndpoint(identityEndpointKey, endpoint); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if endpoint := ecfg.computeEndpoint(); endpoint != "" {
+		if err := validateEndpoint(computeEndpointKey, endpoint); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if id := ecfg.cloudLoadBalancerId(); id != "" {
+		if err := validateLoadBalancerId(id); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	switch v := ecfg.networkConfigVersion(); v {
+	case 0, 1, 2:
+	default:
+		return nil, errors.NotValidf("%s %d (expected 1 or 2)", networkConfigVersionKey, v)
+	}
+	if ecfg.preemptible() && ecfg.flavorClass() != "" {
+		// Preemptible flavors form a class of their own.
+		return nil, errors.NotValidf("%s with %s", preemptibleKey, flavorClassKey)
+	}
+	return ecfg, nil
+}
+
+// validateInjectedFiles checks that the given files are within the
+// limits Rackspace places on the server personality.
+func validateInjectedFiles(files map[string]string) error {
+	if len(files) > maxInjectedFiles {
+		return errors.Errorf("%d files specified, at most %d allowed", len(files), maxInjectedFiles)
+	}
+	total := 0
+	for path, contents := range files {
+		if len(path) > maxInjectedFilePathBytes {
+			return errors.Errorf("path %q longer than %d bytes", path, maxInjectedFilePathBytes)
+		}
+		total += len(contents)
+	}
+	if total > maxInjectedFileTotalBytes {
+		return errors.Errorf("total file size %d bytes exceeds limit of %d bytes", total, maxInjectedFileTotalBytes)
+	}
+	return nil
+}
+
+// validateSchedulerHints checks the values of the scheduler hints
+// that are known to take server or server group ids. Other hints
+// are left for the compute API to check.
+func validateSchedulerHints(hints map[string]string) error {
+	for hint, value := range hints {
+		var ids []string
+		switch hint {
+		case "group":
+			ids = []string{value}
+		case "different_host", "same_host":
+			ids = strings.Split(value, ",")
+		}
+		for _, id := range ids {
+			if !utils.IsValidUUIDString(strings.TrimSpace(id)) {
+				return errors.Errorf("%s hint %q is not a valid id", hint, id)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePackageMirror checks that the given package mirror is an
+// http or https URL. The URL is written into shell commands on
+// CentOS, so characters that would need quoting are rejected.
+func validatePackageMirror(mirror string) error {
+	u, err := url.Parse(mirror)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.NotValidf("%s %q (expected an http or https URL)", packageMirrorKey, mirror)
+	}
+	if strings.ContainsAny(mirror, "'|\\ \t\n") {
+		return errors.NotValidf("%s %q (must not contain quotes, pipes, backslashes or whitespace)", packageMirrorKey, mirror)
+	}
+	return nil
+}
+
+func (c *environConfig) manageIptablesPersistence() bool {
+	return c.attrs[manageIptablesPersistenceKey].(bool)
+}
+
+func (c *environConfig) compressUserData() bool {
+	return c.attrs[compressUserDataKey].(bool)
+}
+
+func (c *environConfig) injectedFiles() map[string]string {
+	files, _ := c.attrs[injectedFilesKey].(map[string]string)
+	return files
+}
+
+// networkConfigVersion returns the cloud-init network configuration
+// version to emit, or 0 if none should be.
+func (c *environConfig) networkConfigVersion() int {
+	v, _ := c.attrs[networkConfigVersionKey].(int)
+	return v
+}
+
+// imageId returns the id of the image pinned for new
+// instances, or the empty string if none is.
+func (c *environConfig) imageId() string {
+	id, _ := c.attrs[imageIdKey].(string)
+	return id
+}
+
+// identityEndpoint returns the URL of the identity service
+// to authenticate with, or the empty string if it is that
+// of the cloud.
+func (c *environConfig) identityEndpoint() string {
+	endpoint, _ := c.attrs[identityEndpointKey].(string)
+	return endpoint
+}
+
+// computeEndpoint returns the URL of the compute API, or the
+// empty string if it is that listed for the region.
+func (c *environConfig) computeEndpoint() string {
+	endpoint, _ := c.attrs[computeEndpointKey].(string)
+	return endpoint
+}
+
+// cloudLoadBalancerId returns the id of the cloud load balancer
+// new instances are registered with, or the empty string if they
+// are not registered with any.
+func (c *environConfig) cloudLoadBalancerId() string {
+	id, _ := c.attrs[cloudLoadBalancerIdKey].(string)
+	return id
+}
+
+// localStoragePersistence returns whether the local
+// storage of new instances must persist.
+func (c *environConfig) localStoragePersistence() string {
+	return c.attrs[localStoragePersistenceKey].(string)
+}
+
+// allowUnsupportedImageFormats returns whether new instances may
+// use images in formats Rackspace is not known to boot.
+func (c *environConfig) allowUnsupportedImageFormats() bool {
+	return c.attrs[allowUnsupportedImageFormatsKey].(bool)
+}
+
+// dnsNameservers returns the nameservers to be used by
+// new instances, or nil if the defaults should be used.
+func (c *environConfig) dnsNameservers() []string {
+	value, _ := c.attrs[dnsNameserversKey].(string)
+	var nameservers []string
+	for _, ns := range strings.Split(value, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			nameservers = append(nameservers, ns)
+		}
+	}
+	return nameservers
+}
+
+// timezone returns the timezone of new instances,
+// or the empty string if it should not be set.
+func (c *environConfig) timezone() string {
+	tz, _ := c.attrs[timezoneKey].(string)
+	return tz
+}
+
+// ntpServers returns the NTP servers to be used by new
+// instances, or nil if the defaults should be used.
+func (c *environConfig) ntpServers() []string {
+	value, _ := c.attrs[ntpServersKey].(string)
+	var servers []string
+	for _, server := range strings.Split(value, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// flavorClass returns the class of the flavors new instances
+// may use, or the empty string if they may use any flavor.
+func (c *environConfig) flavorClass() string {
+	class, _ := c.attrs[flavorClassKey].(string)
+	return class
+}
+
+// diskLayout returns the layout to apply to the data disks
+// of new instances, or the empty string if none should be.
+func (c *environConfig) diskLayout() string {
+	layout, _ := c.attrs[diskLayoutKey].(string)
+	return layout
+}
+
+// dataDisks returns the data disk devices of new instances.
+func (c *environConfig) dataDisks() []string {
+	value, _ := c.attrs[dataDisksKey].(string)
+	var disks []string
+	for _, disk := range strings.Split(value, ",") {
+		if disk = strings.TrimSpace(disk); disk != "" {
+			disks = append(disks, disk)
+		}
+	}
+	return disks
+}
+
+// allocatePublicIP reports whether new instances are
+// attached to PublicNet.
+func (c *environConfig) allocatePublicIP() bool {
+	return c.attrs[allocatePublicIPKey].(bool)
+}
+
+// monitoringAgentToken returns the token for the Rackspace Cloud
+// Monitoring agent, or the empty string if the agent should not be
+// installed.
+func (c *environConfig) monitoringAgentToken() string {
+	token, _ := c.attrs[monitoringAgentTokenKey].(string)
+	return token
+}
+
+// schedulerHints returns the scheduler hints to pass
+// when starting new instances.
+func (c *environConfig) schedulerHints() map[string]string {
+	hints, _ := c.attrs[schedulerHintsKey].(map[string]string)
+	return hints
+}
+
+// trustedCACerts returns the PEM-encoded CA certificates new
+// instances should trust, one per entry, or nil if there are none.
+func (c *environConfig) trustedCACerts() ([]string, error) {
+	value, _ := c.attrs[trustedCACertsKey].(string)
+	return parseTrustedCACerts(value)
+}
+
+// bootCommands returns the commands new instances
+// should run early in each boot, or nil if there are none.
+func (c *environConfig) bootCommands() []string {
+	value, _ := c.attrs[bootCmdKey].(string)
+	if value = strings.TrimSuffix(value, "\n"); value == "" {
+		return nil
+	}
+	return strings.Split(value, "\n")
+}
+
+// computeAPIMicroversion returns the compute
+// API microversion requested by the provider.
+func (c *environConfig) computeAPIMicroversion() string {
+	return c.attrs[computeAPIMicroversionKey].(string)
+}
+
+// preemptible reports whether new instances
+// use preemptible flavors.
+func (c *environConfig) preemptible() bool {
+	return c.attrs[preemptibleKey].(bool)
+}
+
+// swapSize returns the size of the swap file of new instances,
+// or the zero swapSize if they should have none.
+func (c *environConfig) swapSize() (swapSize, error) {
+	size, _ := c.attrs[swapSizeKey].(string)
+	if size == "" {
+		return swapSize{}, nil
+	}
+	return parseSwapSize(size)
+}
+
+// phoneHomeURL returns the URL new instances post to once
+// cloud-init has finished, or the empty string if they should
+// not phone home.
+func (c *environConfig) phoneHomeURL() string {
+	phoneHome, _ := c.attrs[phoneHomeURLKey].(string)
+	return phoneHome
+}
+
+// packageUpdate returns whether new instances update their
+// package lists at boot, or nil if it is left to Juju.
+func (c *environConfig) packageUpdate() *bool {
+	if update, ok := c.attrs[packageUpdateKey].(bool); ok {
+		return &update
+	}
+	return nil
+}
+
+// packageUpgrade returns whether new instances upgrade
+// their packages at boot, or nil if it is left to Juju.
+func (c *environConfig) packageUpgrade() *bool {
+	if upgrade, ok := c.attrs[packageUpgradeKey].(bool); ok {
+		return &upgrade
+	}
+	return nil
+}
+
+// accountType returns the type of the Rackspace account.
+func (c *environConfig) accountType() string {
+	return c.attrs[accountTypeKey].(string)
+}
+
+// keypairName returns the name of the provider keypair,
+// or the empty string if none is used.
+func (c *environConfig) keypairName() string {
+	name, _ := c.attrs[keypairNameKey].(string)
+	return name
+}
+
+// sshKeyMode returns how the authorized keys
+// of new instances are chosen.
+func (c *environConfig) sshKeyMode() string {
+	return c.attrs[sshKeyModeKey].(string)
+}
+
+// packageMirror returns the URL of the package mirror to be
+// used by new instances, or the empty string if none is.
+func (c *environConfig) packageMirror() string {
+	mirror, _ := c.attrs[packageMirrorKey].(string)
+	return mirror
+}
+
+// attachedVolume describes an existing volume to attach
+// to new instances, and where to mount it.
+type attachedVolume struct {
+	openstack.AttachedVolume
+	mountPoint string
+}
+
+// attachVolumes returns the existing volumes to attach
+// to new instances, ordered by volume id.
+func (c *environConfig) attachVolumes() ([]attachedVolume, error) {
+	attrs, _ := c.attrs[attachVolumesKey].(map[string]string)
+	ids := make([]string, 0, len(attrs))
+	for id := range attrs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var volumes []attachedVolume
+	for _, id := range ids {
+		parts := strings.SplitN(attrs[id], ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/dev/") || !strings.HasPrefix(parts[1], "/") {
+			return nil, errors.NotValidf("%s entry %q for volume %q (expected device:mount-point)", attachVolumesKey, attrs[id], id)
+		}
+		volumes = append(volumes, attachedVolume{
+			AttachedVolume: openstack.AttachedVolume{
+				VolumeId: id,
+				Device:   parts[0],
+			},
+			mountPoint: parts[1],
+		})
+	}
+	return volumes, nil
+}
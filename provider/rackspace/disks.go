@@ -0,0 +1,134 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+)
+
+// The disk layouts that may be applied to the data disks
+// of new instances.
+const (
+	diskLayoutRAID0    = "raid0"
+	diskLayoutSeparate = "separate"
+)
+
+const (
+	// raidDevice is the device on which the data disks are
+	// striped by the raid0 disk layout.
+	raidDevice = "/dev/md0"
+
+	// dataMountPoint is where the striped data disks are mounted
+	// by the raid0 disk layout. The separate disk layout mounts
+	// each data disk at this path followed by its index.
+	dataMountPoint = "/mnt/data"
+
+	// dataMountOptions are the fstab options for mounted data
+	// disks. nofail keeps an instance booting if a disk is missing.
+	dataMountOptions = "defaults,nofail"
+)
+
+// addDiskLayout configures the instance with the given cloud config
+// to format and mount the given data disks according to the given
+// layout. Nothing is done for fewer than two disks, as there is
+// nothing to lay out.
+func addDiskLayout(cloudcfg cloudinit.CloudConfig, layout string, disks []string) error {
+	if layout == "" || len(disks) < 2 {
+		return nil
+	}
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch layout {
+	case diskLayoutRAID0:
+		if os != jujuos.Ubuntu {
+			// mdadm is only known to be present on first
+			// boot, before any packages are installed, in
+			// the Ubuntu images.
+			return errors.NotSupportedf("%s disk layout on %s", layout, os)
+		}
+		// cloud-init cannot create software RAID devices, so the
+		// array is created from bootcmd, which runs before the
+		// disk_setup and mounts modules. The array is assembled
+		// rather than created if it already exists.
+		members := strings.Join(disks, " ")
+		cloudcfg.AddBootCmd(fmt.Sprintf(
+			"[ -b %[1]s ] || mdadm --assemble %[1]s %[2]s || mdadm --create %[1]s --run --level=0 --raid-devices=%[3]d %[2]s",
+			raidDevice, members, len(disks),
+		))
+		cloudcfg.SetAttr("fs_setup", []map[string]interface{}{{
+			"label":      "data",
+			"filesystem": "ext4",
+			"device":     raidDevice,
+			"partition":  "none",
+		}})
+		cloudcfg.AddMount(raidDevice, dataMountPoint, "ext4", dataMountOptions, "0", "2")
+	case diskLayoutSeparate:
+		if os == jujuos.Windows {
+			return errors.NotSupportedf("%s disk layout on %s", layout, os)
+		}
+		diskSetup := make(map[string]interface{})
+		var fsSetup []map[string]interface{}
+		for i, disk := range disks {
+			diskSetup[disk] = map[string]interface{}{
+				"table_type": "mbr",
+				"layout":     true,
+				"overwrite":  false,
+			}
+			fsSetup = append(fsSetup, map[string]interface{}{
+				"label":      fmt.Sprintf("data%d", i),
+				"filesystem": "ext4",
+				"device":     disk,
+				"partition":  "auto",
+			})
+			mountPoint := fmt.Sprintf("%s%d", dataMountPoint, i)
+			cloudcfg.AddMount(disk+"1", mountPoint, "ext4", dataMountOptions, "0", "2")
+		}
+		cloudcfg.SetAttr("disk_setup", diskSetup)
+		cloudcfg.SetAttr("fs_setup", fsSetup)
+	default:
+		return errors.NotValidf("disk layout %q", layout)
+	}
+	return nil
+}
+
+// attachedVolumeWait is how long a new instance waits for each
+// attached volume to appear before giving up on mounting it.
+// The volumes are attached once the instance is active, so
+// may appear after the mounts module has run.
+const attachedVolumeWait = 5 * time.Minute
+
+// addVolumeMounts configures the instance with the given cloud
+// config to mount the given attached volumes.
+func addVolumeMounts(cloudcfg cloudinit.CloudConfig, volumes []attachedVolume) error {
+	if len(volumes) == 0 {
+		return nil
+	}
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if os == jujuos.Windows {
+		return errors.NotSupportedf("mounting volumes on %s", os)
+	}
+	for _, v := range volumes {
+		cloudcfg.AddMount(v.Device, v.mountPoint, "auto", dataMountOptions, "0", "2")
+		tries := int(attachedVolumeWait / (5 * time.Second))
+		cloudcfg.AddRunCmd(fmt.Sprintf(
+			"for i in $(seq %[1]d); do [ -b %[2]s ] && break; sleep 5; done; mountpoint -q %[3]s || mount %[3]s",
+			tries, utils.ShQuote(v.Device), utils.ShQuote(v.mountPoint),
+		))
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/client"
+	gooseerrors "gopkg.in/goose.v1/errors"
+	goosehttp "gopkg.in/goose.v1/http"
+	"gopkg.in/goose.v1/identity"
+)
+
+// computeServiceType is the type of the compute
+// service in the identity service catalog.
+const computeServiceType = "compute"
+
+// validateEndpoint checks that the given value of the
+// given attribute is an absolute https URL.
+func validateEndpoint(key, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.NotValidf("%s %q (expected an absolute https URL)", key, endpoint)
+	}
+	return nil
+}
+
+// computeEndpointClient is a client that sends compute API requests
+// to the given endpoint in place of the one the identity service
+// catalog holds for the region, so that the compute API can be
+// reached where the catalog is wrong or incomplete, as for staging
+// and private deployments. Other requests are sent by the client
+// it holds as usual.
+type computeEndpointClient struct {
+	endpoint   string
+	httpClient *goosehttp.Client
+	newClient  func() (client.AuthenticatingClient, error)
+
+	mu     sync.Mutex
+	client client.AuthenticatingClient
+}
+
+var _ client.AuthenticatingClient = (*computeEndpointClient)(nil)
+
+// newComputeEndpointClient returns a client that sends compute API
+// requests to the given endpoint, and other requests using the client
+// returned by the given function, which is called again to replace
+// the client if its token is rejected by the compute API.
+func newComputeEndpointClient(endpoint string, sslHostnameVerification bool, newClient func() (client.AuthenticatingClient, error)) (*computeEndpointClient, error) {
+	newClient = withoutRequiredCompute(newClient)
+	cl, err := newClient()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	httpClient := goosehttp.New()
+	if !sslHostnameVerification {
+		httpClient = goosehttp.NewNonSSLValidating()
+	}
+	return &computeEndpointClient{
+		endpoint:   strings.TrimRight(endpoint, "/"),
+		httpClient: httpClient,
+		newClient:  newClient,
+		client:     cl,
+	}, nil
+}
+
+// withoutRequiredCompute returns a function that returns the clients
+// returned by the given function, having made them not require the
+// compute service to be in the catalog, as it is not used from there.
+func withoutRequiredCompute(newClient func() (client.AuthenticatingClient, error)) func() (client.AuthenticatingClient, error) {
+	return func() (client.AuthenticatingClient, error) {
+		cl, err := newClient()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		cl.SetRequiredServiceTypes(nil)
+		return cl, nil
+	}
+}
+
+// current returns the client held.
+func (c *computeEndpointClient) current() client.AuthenticatingClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+// renew replaces the given client, if it is still
+// the one held, with a new one that authenticates anew.
+func (c *computeEndpointClient) renew(old client.AuthenticatingClient) error {
+	cl, err := c.newClient()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == old {
+		c.client = cl
+	}
+	return nil
+}
+
+// SendRequest implements client.Client.SendRequest.
+func (c *computeEndpointClient) SendRequest(method, svcType, apiCall string, requestData *goosehttp.RequestData) error {
+	cl := c.current()
+	if svcType != computeServiceType {
+		return cl.SendRequest(method, svcType, apiCall, requestData)
+	}
+	err := c.sendComputeRequest(cl, method, apiCall, requestData)
+	if gooseerrors.IsUnauthorised(err) {
+		// The client's token has been rejected, but cannot be
+		// discarded as the client itself does when its own
+		// requests are rejected, so the client is replaced and
+		// the request tried once more.
+		if err := c.renew(cl); err != nil {
+			return err
+		}
+		err = c.sendComputeRequest(c.current(), method, apiCall, requestData)
+	}
+	return err
+}
+
+// sendComputeRequest sends the given compute API request to
+// the endpoint, with the token of the given client.
+func (c *computeEndpointClient) sendComputeRequest(cl client.AuthenticatingClient, method, apiCall string, requestData *goosehttp.RequestData) error {
+	if err := cl.Authenticate(); err != nil {
+		return err
+	}
+	u, err := c.MakeServiceURL(computeServiceType, []string{apiCall})
+	if err != nil {
+		return err
+	}
+	if requestData.ReqValue != nil || requestData.RespValue != nil {
+		return c.httpClient.JsonRequest(method, u, cl.Token(), requestData, nil)
+	}
+	return c.httpClient.BinaryRequest(method, u, cl.Token(), requestData, nil)
+}
+
+// MakeServiceURL implements client.Client.MakeServiceURL.
+func (c *computeEndpointClient) MakeServiceURL(serviceType string, parts []string) (string, error) {
+	if serviceType != computeServiceType {
+		return c.current().MakeServiceURL(serviceType, parts)
+	}
+	if len(parts) == 0 {
+		return c.endpoint, nil
+	}
+	return c.endpoint + "/" + strings.Join(parts, "/"), nil
+}
+
+// SetRequiredServiceTypes implements client.AuthenticatingClient.SetRequiredServiceTypes.
+// The compute service is never required.
+func (c *computeEndpointClient) SetRequiredServiceTypes(requiredServiceTypes []string) {
+	var required []string
+	for _, serviceType := range requiredServiceTypes {
+		if serviceType != computeServiceType {
+			required = append(required, serviceType)
+		}
+	}
+	c.current().SetRequiredServiceTypes(required)
+}
+
+// Authenticate implements client.AuthenticatingClient.Authenticate.
+func (c *computeEndpointClient) Authenticate() error {
+	return c.current().Authenticate()
+}
+
+// IsAuthenticated implements client.AuthenticatingClient.IsAuthenticated.
+func (c *computeEndpointClient) IsAuthenticated() bool {
+	return c.current().IsAuthenticated()
+}
+
+// Token implements client.AuthenticatingClient.Token.
+func (c *computeEndpointClient) Token() string {
+	return c.current().Token()
+}
+
+// UserId implements client.AuthenticatingClient.UserId.
+func (c *computeEndpointClient) UserId() string {
+	return c.current().UserId()
+}
+
+// TenantId implements client.AuthenticatingClient.TenantId.
+func (c *computeEndpointClient) TenantId() string {
+	return c.current().TenantId()
+}
+
+// EndpointsForRegion implements client.AuthenticatingClient.EndpointsForRegion.
+func (c *computeEndpointClient) EndpointsForRegion(region string) identity.ServiceURLs {
+	return c.current().EndpointsForRegion(region)
+}
+
+// IdentityAuthOptions implements client.AuthenticatingClient.IdentityAuthOptions.
+func (c *computeEndpointClient) IdentityAuthOptions() (identity.AuthOptions, error) {
+	return c.current().IdentityAuthOptions()
+}
@@ -5,6 +5,7 @@ package rackspace
 
 import (
 	"github.com/juju/juju/environs"
+	"github.com/juju/juju/provider/openstack"
 )
 
 func NewProvider(innerProvider environs.EnvironProvider) environs.EnvironProvider {
@@ -20,3 +21,20 @@ var Bootstrap = &bootstrap
 var WaitSSH = &waitSSH
 
 var NewInstanceConfigurator = &newInstanceConfigurator
+
+func NewConfigurator() openstack.ProviderConfigurator {
+	return &rackspaceConfigurator{}
+}
+
+var RenderNetworkConfig = renderNetworkConfig
+
+var Clients = &clients
+
+var NewClientCache = newClientCache
+
+var LoadBalancerAttempt = &loadBalancerAttempt
+
+const (
+	TokenLifetime      = tokenLifetime
+	TokenRefreshMargin = tokenRefreshMargin
+)
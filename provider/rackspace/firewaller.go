@@ -30,11 +30,11 @@ var _ openstack.Firewaller = (*rackspaceFirewaller)(nil)
 
 // InitialNetworks implements Firewaller interface.
 func (c *rackspaceFirewaller) InitialNetworks() []nova.ServerNetworks {
-	// These are the default rackspace networks, see:
-	// http://docs.rackspace.com/servers/api/v2/cs-devguide/content/provision_server_with_networks.html
+	// These are the default rackspace networks. PublicNet is
+	// removed by the configurator if allocate-public-ip is false.
 	return []nova.ServerNetworks{
-		{NetworkId: "00000000-0000-0000-0000-000000000000"}, //Racksapce PublicNet
-		{NetworkId: "11111111-1111-1111-1111-111111111111"}, //Rackspace ServiceNet
+		{NetworkId: publicNetId},
+		{NetworkId: serviceNetId},
 	}
 }
 
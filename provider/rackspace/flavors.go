@@ -0,0 +1,30 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"regexp"
+
+	"gopkg.in/goose.v1/nova"
+)
+
+// flavorIdPattern matches the ids of Rackspace flavors, which are
+// made of the flavor class and its generation, followed by a size,
+// as in "general1-2" or "memory1-15". Standard flavors, whose ids
+// are plain numbers, belong to no class.
+var flavorIdPattern = regexp.MustCompile(`^([a-z]+)[0-9]+-`)
+
+// flavorsOfClass returns those of the given flavors that belong to
+// the given class. The nova client does not report the class held in
+// the extra specs of each flavor, so it is taken from the flavor id.
+func flavorsOfClass(flavors []nova.FlavorDetail, class string) []nova.FlavorDetail {
+	var result []nova.FlavorDetail
+	for _, flavor := range flavors {
+		match := flavorIdPattern.FindStringSubmatch(flavor.Id)
+		if match != nil && match[1] == class {
+			result = append(result, flavor)
+		}
+	}
+	return result
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"github.com/juju/errors"
+	"github.com/juju/utils/set"
+
+	"github.com/juju/juju/provider/openstack"
+)
+
+// The disk and container formats of images that Rackspace boots, see:
+// https://developer.rackspace.com/docs/cloud-images/v2/developer-guide/
+var (
+	bootableDiskFormats      = set.NewStrings("vhd")
+	bootableContainerFormats = set.NewStrings("ovf", "bare")
+)
+
+// validateImageFormats checks that the given formats, those of the
+// image with the given id, are ones that Rackspace boots. The Image
+// service does not record the formats of every image, so formats that
+// are unknown are not checked.
+func validateImageFormats(imageId string, formats openstack.ImageFormats) error {
+	if f := formats.DiskFormat; f != "" && !bootableDiskFormats.Contains(f) {
+		return errors.NotSupportedf(
+			"disk format %q of image %q (expected one of %v; set %s to true to use it anyway)",
+			f, imageId, bootableDiskFormats.SortedValues(), allowUnsupportedImageFormatsKey,
+		)
+	}
+	if f := formats.ContainerFormat; f != "" && !bootableContainerFormats.Contains(f) {
+		return errors.NotSupportedf(
+			"container format %q of image %q (expected one of %v; set %s to true to use it anyway)",
+			f, imageId, bootableContainerFormats.SortedValues(), allowUnsupportedImageFormatsKey,
+		)
+	}
+	return nil
+}
@@ -4,6 +4,8 @@
 package rackspace
 
 import (
+	"github.com/juju/loggo"
+
 	"github.com/juju/juju/environs"
 	"github.com/juju/juju/provider/openstack"
 )
@@ -12,6 +14,8 @@ const (
 	providerType = "rackspace"
 )
 
+var logger = loggo.GetLogger("juju.provider.rackspace")
+
 func init() {
 	osProvider := openstack.EnvironProvider{
 		openstack.OpenstackCredentials{},
@@ -0,0 +1,227 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils"
+	"gopkg.in/goose.v1/client"
+	gooseerrors "gopkg.in/goose.v1/errors"
+	goosehttp "gopkg.in/goose.v1/http"
+	"gopkg.in/goose.v1/nova"
+)
+
+// loadBalancerServiceType is the type of the Cloud Load
+// Balancers service in the identity service catalog.
+const loadBalancerServiceType = "rax:load-balancer"
+
+// loadBalancerActive is the status of a cloud load
+// balancer whose nodes may be changed.
+const loadBalancerActive = "ACTIVE"
+
+// loadBalancerAttempt is used when registering new servers with a
+// cloud load balancer, which waits until the server is active and
+// has an address, and when changing the nodes of a load balancer,
+// which fails while it is being updated, as it is while other nodes
+// are added or removed.
+var loadBalancerAttempt = utils.AttemptStrategy{
+	Total: 10 * time.Minute,
+	Delay: 5 * time.Second,
+}
+
+// loadBalancer holds the parts of a cloud load balancer that are used.
+type loadBalancer struct {
+	Port   int                `json:"port"`
+	Status string             `json:"status"`
+	Nodes  []loadBalancerNode `json:"nodes"`
+}
+
+// loadBalancerNode holds a node of a cloud load balancer.
+type loadBalancerNode struct {
+	Id        int    `json:"id,omitempty"`
+	Address   string `json:"address"`
+	Port      int    `json:"port"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// validateLoadBalancerId checks that the given
+// cloud load balancer id is a positive integer.
+func validateLoadBalancerId(id string) error {
+	if n, err := strconv.ParseUint(id, 10, 64); err != nil || n == 0 {
+		return errors.NotValidf("%s %q (expected a positive integer)", cloudLoadBalancerIdKey, id)
+	}
+	return nil
+}
+
+// getLoadBalancer returns the cloud load balancer with the given id.
+func getLoadBalancer(cl client.AuthenticatingClient, id string) (*loadBalancer, error) {
+	var resp struct {
+		LoadBalancer loadBalancer `json:"loadBalancer"`
+	}
+	err := cl.SendRequest(client.GET, loadBalancerServiceType, "loadbalancers/"+id, &goosehttp.RequestData{
+		RespValue: &resp,
+	})
+	if gooseerrors.IsNotFound(err) {
+		return nil, errors.NotFoundf("cloud load balancer %q", id)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "cannot get cloud load balancer %q", id)
+	}
+	return &resp.LoadBalancer, nil
+}
+
+// isImmutable reports whether the given error is the response of the
+// Cloud Load Balancers API to a request to change a load balancer that
+// is being updated, and so cannot be changed until it is active again.
+func isImmutable(err error) bool {
+	httpErr, ok := err.(*goosehttp.HttpError)
+	return ok && httpErr.StatusCode == 422
+}
+
+// serverLoadBalancerAddress returns the address of the given server
+// to register with a cloud load balancer: its ServiceNet address, as
+// the balancer is reached over ServiceNet within the region without
+// bandwidth charges, or its PublicNet address if it has none. The
+// empty string is returned if it has neither yet.
+func serverLoadBalancerAddress(server *nova.ServerDetail) string {
+	for _, name := range []string{serviceNetName, publicNetName} {
+		for _, addr := range server.Addresses[name] {
+			if addr.Version == 4 {
+				return addr.Address
+			}
+		}
+	}
+	return ""
+}
+
+// registerInstance adds the server with the given id to the cloud
+// load balancer with the given id, as a node on the port it balances,
+// once the server is active.
+//
+// A server whose address is already a node of the balancer is reported
+// as already existing rather than added again: the node is most likely
+// that of a server that has been removed, and which held the address
+// before, so would be removed with it.
+func registerInstance(cl client.AuthenticatingClient, lbId, serverId string) error {
+	novaClient := nova.New(cl)
+	var address string
+	for a := loadBalancerAttempt.Start(); a.Next(); {
+		server, err := novaClient.GetServer(serverId)
+		if err != nil {
+			return errors.Annotate(err, "cannot get server")
+		}
+		if server.Status == nova.StatusActive {
+			if address = serverLoadBalancerAddress(server); address != "" {
+				break
+			}
+		}
+	}
+	if address == "" {
+		return errors.Errorf("server has no ServiceNet or PublicNet address after %v", loadBalancerAttempt.Total)
+	}
+	var lastErr error
+	for a := loadBalancerAttempt.Start(); a.Next(); {
+		lb, err := getLoadBalancer(cl, lbId)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, node := range lb.Nodes {
+			if node.Address == address && node.Port == lb.Port {
+				return errors.AlreadyExistsf("node %s:%d of cloud load balancer %q", address, lb.Port, lbId)
+			}
+		}
+		if lb.Status != loadBalancerActive {
+			lastErr = errors.Errorf("cloud load balancer %q is %s", lbId, lb.Status)
+			continue
+		}
+		req := struct {
+			Nodes []loadBalancerNode `json:"nodes"`
+		}{[]loadBalancerNode{{
+			Address:   address,
+			Port:      lb.Port,
+			Condition: "ENABLED",
+		}}}
+		err = cl.SendRequest(client.POST, loadBalancerServiceType, "loadbalancers/"+lbId+"/nodes", &goosehttp.RequestData{
+			ReqValue:       req,
+			RespValue:      &struct{}{},
+			ExpectedStatus: []int{http.StatusAccepted},
+		})
+		if isImmutable(err) {
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			return errors.Annotatef(err, "cannot add node %s:%d to cloud load balancer %q", address, lb.Port, lbId)
+		}
+		logger.Infof("registered %q as node %s:%d of cloud load balancer %q", serverId, address, lb.Port, lbId)
+		return nil
+	}
+	return errors.Annotatef(lastErr, "timed out after %v", loadBalancerAttempt.Total)
+}
+
+// deregisterInstances removes the nodes of the servers with the given
+// ids, which are those with any of their addresses, from the cloud
+// load balancer with the given id. Servers that no longer exist are
+// skipped, as their addresses are no longer known.
+func deregisterInstances(cl client.AuthenticatingClient, lbId string, serverIds []string) error {
+	novaClient := nova.New(cl)
+	addresses := make(map[string]bool)
+	for _, id := range serverIds {
+		server, err := novaClient.GetServer(id)
+		if gooseerrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Annotatef(err, "cannot get server %q", id)
+		}
+		for _, addrs := range server.Addresses {
+			for _, addr := range addrs {
+				addresses[addr.Address] = true
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	var lastErr error
+	for a := loadBalancerAttempt.Start(); a.Next(); {
+		lb, err := getLoadBalancer(cl, lbId)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		params := make(url.Values)
+		for _, node := range lb.Nodes {
+			if addresses[node.Address] {
+				params.Add("id", fmt.Sprint(node.Id))
+			}
+		}
+		if len(params) == 0 {
+			return nil
+		}
+		if lb.Status != loadBalancerActive {
+			lastErr = errors.Errorf("cloud load balancer %q is %s", lbId, lb.Status)
+			continue
+		}
+		err = cl.SendRequest(client.DELETE, loadBalancerServiceType, "loadbalancers/"+lbId+"/nodes", &goosehttp.RequestData{
+			Params:         &params,
+			ExpectedStatus: []int{http.StatusAccepted},
+		})
+		if isImmutable(err) {
+			lastErr = err
+			continue
+		}
+		if err != nil {
+			return errors.Annotatef(err, "cannot remove nodes %v from cloud load balancer %q", params["id"], lbId)
+		}
+		logger.Infof("deregistered %v from cloud load balancer %q", serverIds, lbId)
+		return nil
+	}
+	return errors.Annotatef(lastErr, "timed out after %v", loadBalancerAttempt.Total)
+}
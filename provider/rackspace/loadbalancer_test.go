@@ -0,0 +1,351 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/utils"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/goose.v1/client"
+	"gopkg.in/goose.v1/identity"
+
+	"github.com/juju/juju/environs/config"
+	"github.com/juju/juju/provider/openstack"
+	"github.com/juju/juju/provider/rackspace"
+	"github.com/juju/juju/testing"
+)
+
+type loadBalancerSuite struct {
+	testing.BaseSuite
+	configurator openstack.ProviderConfigurator
+	cloud        *fakeLoadBalancerCloud
+	client       client.AuthenticatingClient
+}
+
+var _ = gc.Suite(&loadBalancerSuite{})
+
+func (s *loadBalancerSuite) SetUpTest(c *gc.C) {
+	s.BaseSuite.SetUpTest(c)
+	s.PatchValue(rackspace.LoadBalancerAttempt, utils.AttemptStrategy{
+		Total: testing.LongWait,
+		Delay: time.Millisecond,
+	})
+	s.configurator = rackspace.NewConfigurator()
+	s.cloud = &fakeLoadBalancerCloud{
+		identity: &fakeIdentity{
+			revoked:       make(map[string]bool),
+			loadBalancers: true,
+		},
+		servers: map[string]fakeServer{
+			"server-0": {status: "ACTIVE", serviceNet: "10.176.0.10", publicNet: "203.0.113.10"},
+			"server-1": {status: "ACTIVE", serviceNet: "10.176.0.11", publicNet: "203.0.113.11"},
+		},
+		loadBalancers: map[string]*fakeLoadBalancer{
+			"1234": {port: 80, status: "ACTIVE"},
+		},
+	}
+	server := httptest.NewTLSServer(s.cloud)
+	s.AddCleanup(func(*gc.C) { server.Close() })
+	s.cloud.identity.url = server.URL
+	s.client = client.NewNonValidatingClient(&identity.Credentials{
+		URL:        server.URL + "/v2.0",
+		User:       "user",
+		Secrets:    "secret",
+		Region:     "DFW",
+		TenantName: "tenant",
+	}, identity.AuthUserPass, nil)
+}
+
+func (s *loadBalancerSuite) modelConfig(c *gc.C, id string) *config.Config {
+	attrs := testing.Attrs{}
+	if id != "" {
+		attrs["cloud-load-balancer-id"] = id
+	}
+	return testing.CustomModelConfig(c, attrs)
+}
+
+func (s *loadBalancerSuite) TestRegisterInstance(c *gc.C) {
+	err := s.configurator.RegisterInstance(s.modelConfig(c, "1234"), s.client, "server-0")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.cloud.nodes("1234"), jc.DeepEquals, []string{"10.176.0.10:80 ENABLED"})
+	c.Assert(s.cloud.changes(), jc.DeepEquals, []string{
+		`POST /load-balancers/loadbalancers/1234/nodes {"nodes":[{"address":"10.176.0.10","port":80,"condition":"ENABLED"}]}`,
+	})
+}
+
+func (s *loadBalancerSuite) TestRegisterInstancePublicNetOnly(c *gc.C) {
+	s.cloud.servers["server-0"] = fakeServer{status: "ACTIVE", publicNet: "203.0.113.10"}
+	err := s.configurator.RegisterInstance(s.modelConfig(c, "1234"), s.client, "server-0")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.cloud.nodes("1234"), jc.DeepEquals, []string{"203.0.113.10:80 ENABLED"})
+}
+
+func (s *loadBalancerSuite) TestRegisterInstanceWaitsUntilActive(c *gc.C) {
+	s.cloud.servers["server-0"] = fakeServer{status: "BUILD", builds: 3, serviceNet: "10.176.0.10"}
+	s.cloud.loadBalancers["1234"].pendingUpdates = 2
+	err := s.configurator.RegisterInstance(s.modelConfig(c, "1234"), s.client, "server-0")
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.cloud.nodes("1234"), jc.DeepEquals, []string{"10.176.0.10:80 ENABLED"})
+	c.Assert(s.cloud.changes(), gc.HasLen, 1)
+}
+
+func (s *loadBalancerSuite) TestRegisterInstanceAlreadyRegistered(c *gc.C) {
+	s.cloud.loadBalancers["1234"].nodes = []fakeNode{{id: 1, address: "10.176.0.10", port: 80}}
+	err := s.configurator.RegisterInstance(s.modelConfig(c, "1234"), s.client, "server-0")
+	c.Assert(err, jc.Satisfies, errors.IsAlreadyExists)
+	c.Assert(err, gc.ErrorMatches, `cannot use cloud-load-balancer-id: node 10.176.0.10:80 of cloud load balancer "1234" already exists`)
+	c.Assert(s.cloud.changes(), gc.HasLen, 0)
+}
+
+func (s *loadBalancerSuite) TestRegisterInstanceLoadBalancerNotFound(c *gc.C) {
+	err := s.configurator.RegisterInstance(s.modelConfig(c, "5678"), s.client, "server-0")
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+	c.Assert(err, gc.ErrorMatches, `cannot use cloud-load-balancer-id: cloud load balancer "5678" not found`)
+}
+
+func (s *loadBalancerSuite) TestDeregisterInstances(c *gc.C) {
+	s.cloud.loadBalancers["1234"].nodes = []fakeNode{
+		{id: 1, address: "10.176.0.10", port: 80},
+		{id: 2, address: "10.176.0.11", port: 80},
+		{id: 3, address: "10.176.0.12", port: 80},
+	}
+	err := s.configurator.DeregisterInstances(s.modelConfig(c, "1234"), s.client, []string{"server-0", "server-1", "server-gone"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.cloud.nodes("1234"), jc.DeepEquals, []string{"10.176.0.12:80 ENABLED"})
+	c.Assert(s.cloud.changes(), jc.DeepEquals, []string{
+		`DELETE /load-balancers/loadbalancers/1234/nodes id=1&id=2`,
+	})
+}
+
+func (s *loadBalancerSuite) TestDeregisterInstancesNotRegistered(c *gc.C) {
+	err := s.configurator.DeregisterInstances(s.modelConfig(c, "1234"), s.client, []string{"server-0"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.cloud.changes(), gc.HasLen, 0)
+}
+
+func (s *loadBalancerSuite) TestDeregisterInstancesLoadBalancerNotFound(c *gc.C) {
+	err := s.configurator.DeregisterInstances(s.modelConfig(c, "5678"), s.client, []string{"server-0"})
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+	c.Assert(err, gc.ErrorMatches, `cannot use cloud-load-balancer-id: cloud load balancer "5678" not found`)
+}
+
+func (s *loadBalancerSuite) TestNoLoadBalancer(c *gc.C) {
+	cfg := s.modelConfig(c, "")
+	err := s.configurator.RegisterInstance(cfg, s.client, "server-0")
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.configurator.DeregisterInstances(cfg, s.client, []string{"server-0"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.cloud.requests(), gc.Equals, 0)
+}
+
+func (s *loadBalancerSuite) TestInvalidLoadBalancerId(c *gc.C) {
+	for _, id := range []string{"lb-1", "0", "-1"} {
+		c.Logf("cloud-load-balancer-id %q", id)
+		err := s.configurator.RegisterInstance(s.modelConfig(c, id), s.client, "server-0")
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+		c.Check(err, gc.ErrorMatches, `cloud-load-balancer-id ".*" \(expected a positive integer\) not valid`)
+	}
+}
+
+// fakeServer holds a server of a fakeLoadBalancerCloud, which reports
+// the BUILD status, without addresses, the first builds times it is
+// got.
+type fakeServer struct {
+	status                string
+	builds                int
+	serviceNet, publicNet string
+}
+
+// fakeLoadBalancer holds a cloud load balancer of a
+// fakeLoadBalancerCloud, which reports the PENDING_UPDATE status the
+// first pendingUpdates times it is got.
+type fakeLoadBalancer struct {
+	port           int
+	status         string
+	pendingUpdates int
+	nodes          []fakeNode
+	nextId         int
+}
+
+type fakeNode struct {
+	id      int
+	address string
+	port    int
+}
+
+// fakeLoadBalancerCloud is an http.Handler serving the identity
+// service of a fakeIdentity, the server details of the compute API,
+// and the load balancers and nodes of the Cloud Load Balancers API.
+type fakeLoadBalancerCloud struct {
+	identity *fakeIdentity
+
+	mu            sync.Mutex
+	servers       map[string]fakeServer
+	loadBalancers map[string]*fakeLoadBalancer
+	served        int
+	changed       []string
+}
+
+func (f *fakeLoadBalancerCloud) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/v2.0/tokens" {
+		f.identity.ServeHTTP(w, req)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.served++
+	w.Header().Set("Content-Type", "application/json")
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "compute" && parts[1] == "servers" && req.Method == "GET":
+		f.serveServer(w, parts[2])
+	case len(parts) >= 3 && parts[0] == "load-balancers" && parts[1] == "loadbalancers":
+		lb, ok := f.loadBalancers[parts[2]]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		switch {
+		case len(parts) == 3 && req.Method == "GET":
+			f.serveLoadBalancer(w, lb)
+		case len(parts) == 4 && parts[3] == "nodes" && req.Method == "POST":
+			f.addNodes(w, req, lb)
+		case len(parts) == 4 && parts[3] == "nodes" && req.Method == "DELETE":
+			f.removeNodes(w, req, lb)
+		default:
+			http.NotFound(w, req)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (f *fakeLoadBalancerCloud) serveServer(w http.ResponseWriter, id string) {
+	server, ok := f.servers[id]
+	if !ok {
+		http.Error(w, `{"itemNotFound": {"message": "Instance could not be found", "code": 404}}`, http.StatusNotFound)
+		return
+	}
+	detail := map[string]interface{}{"id": id, "status": server.status}
+	if server.builds > 0 {
+		server.builds--
+		f.servers[id] = server
+		detail["status"] = "BUILD"
+	} else {
+		addresses := make(map[string]interface{})
+		if server.serviceNet != "" {
+			addresses["private"] = []interface{}{map[string]interface{}{"version": 4, "addr": server.serviceNet}}
+		}
+		if server.publicNet != "" {
+			addresses["public"] = []interface{}{map[string]interface{}{"version": 4, "addr": server.publicNet}}
+		}
+		detail["addresses"] = addresses
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"server": detail})
+}
+
+func (f *fakeLoadBalancerCloud) serveLoadBalancer(w http.ResponseWriter, lb *fakeLoadBalancer) {
+	status := lb.status
+	if lb.pendingUpdates > 0 {
+		lb.pendingUpdates--
+		status = "PENDING_UPDATE"
+	}
+	nodes := []interface{}{}
+	for _, node := range lb.nodes {
+		nodes = append(nodes, map[string]interface{}{
+			"id":        node.id,
+			"address":   node.address,
+			"port":      node.port,
+			"condition": "ENABLED",
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"loadBalancer": map[string]interface{}{
+			"port":   lb.port,
+			"status": status,
+			"nodes":  nodes,
+		},
+	})
+}
+
+func (f *fakeLoadBalancerCloud) addNodes(w http.ResponseWriter, req *http.Request, lb *fakeLoadBalancer) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Nodes []struct {
+			Address string `json:"address"`
+			Port    int    `json:"port"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.changed = append(f.changed, fmt.Sprintf("POST %s %s", req.URL.Path, data))
+	for _, node := range body.Nodes {
+		lb.nextId++
+		lb.nodes = append(lb.nodes, fakeNode{id: 100 + lb.nextId, address: node.Address, port: node.Port})
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, "{}")
+}
+
+func (f *fakeLoadBalancerCloud) removeNodes(w http.ResponseWriter, req *http.Request, lb *fakeLoadBalancer) {
+	f.changed = append(f.changed, fmt.Sprintf("DELETE %s %s", req.URL.Path, req.URL.RawQuery))
+	ids := make(map[string]bool)
+	for _, id := range req.URL.Query()["id"] {
+		ids[id] = true
+	}
+	var nodes []fakeNode
+	for _, node := range lb.nodes {
+		if !ids[fmt.Sprint(node.id)] {
+			nodes = append(nodes, node)
+		}
+	}
+	lb.nodes = nodes
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// nodes returns the nodes of the given load balancer,
+// as address:port followed by their condition, sorted.
+func (f *fakeLoadBalancerCloud) nodes(id string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var nodes []string
+	for _, node := range f.loadBalancers[id].nodes {
+		nodes = append(nodes, fmt.Sprintf("%s:%d ENABLED", node.address, node.port))
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// changes returns the requests made to change
+// the nodes of load balancers, in order.
+func (f *fakeLoadBalancerCloud) changes() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.changed...)
+}
+
+// requests returns the number of compute and
+// load balancer API requests made.
+func (f *fakeLoadBalancerCloud) requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.served
+}
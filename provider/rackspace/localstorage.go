@@ -0,0 +1,31 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/environs/instances"
+)
+
+// validateLocalStorage checks that instances of the given type
+// can have local storage of the given persistence.
+//
+// Flavors with a local system disk keep it until the server is
+// deleted, so give persistent local storage as they are. Those with
+// none boot from a Cloud Block Storage volume, and could only be given
+// a persistent local disk with a block device mapping, which the
+// version of the nova client in use cannot send, so they are reported
+// as not supporting it, rather than started without it.
+func validateLocalStorage(persistence string, instType instances.InstanceType) error {
+	if persistence != localStoragePersistent || instType.RootDisk > 0 {
+		return nil
+	}
+	return errors.NewNotSupported(nil, fmt.Sprintf(
+		"%s %q not supported by flavor %q: it has no local disk",
+		localStoragePersistenceKey, persistence, instType.Name,
+	))
+}
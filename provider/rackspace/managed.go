@@ -0,0 +1,53 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juju/errors"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+)
+
+// managedAutomationCompleteFile is created on the servers of managed
+// accounts once the managed cloud automation has set them up.
+const managedAutomationCompleteFile = "/tmp/rs_managed_cloud_automation_complete"
+
+// managedAutomationTimeout is how long new instances of managed
+// accounts wait for the managed cloud automation to complete.
+const managedAutomationTimeout = 30 * time.Minute
+
+// addManagedAutomationWait configures the instance with the given
+// cloud config to wait for the managed cloud automation to complete
+// before Juju's agent is set up.
+//
+// The automation logs in to new servers over SSH, which cloud-init
+// holds back until its early boot stages are done, so the wait
+// cannot be made there, as with a bootcmd, without the automation
+// waiting in turn for the instance. Instead it is the first of the
+// runcmds, which cloud-init runs in its final stage, and which are
+// followed by those with which Juju sets up its agent. If the
+// automation takes too long, the instance carries on regardless,
+// as Juju can better report a failure to set up the agent than an
+// instance that never finishes starting.
+func addManagedAutomationWait(cloudcfg cloudinit.CloudConfig) error {
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu, jujuos.CentOS:
+	default:
+		return errors.NotSupportedf("waiting for managed cloud automation on %s", os)
+	}
+	cloudcfg.AddRunCmd(fmt.Sprintf(
+		`timeout %d sh -c 'until [ -e %s ]; do sleep 10; done' || echo 'timed out waiting for Rackspace managed cloud automation' >&2`,
+		int(managedAutomationTimeout.Seconds()), managedAutomationCompleteFile,
+	))
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/client"
+	goosehttp "gopkg.in/goose.v1/http"
+)
+
+const (
+	// defaultComputeAPIMicroversion is the compute API microversion
+	// used when none is requested, as by the compute client.
+	defaultComputeAPIMicroversion = "2.1"
+
+	// maxComputeAPIMinor is the minor version of the latest compute
+	// API microversion that may be requested. From 2.36, the compute
+	// API no longer proxies the image, network and security group
+	// APIs that the compute client uses.
+	maxComputeAPIMinor = 35
+
+	// openStackAPIVersionMinor is the minor version of the first
+	// compute API microversion that accepts the OpenStack-API-Version
+	// header, which replaces X-OpenStack-Nova-API-Version.
+	openStackAPIVersionMinor = 27
+)
+
+// microversionPattern matches compute API microversions.
+var microversionPattern = regexp.MustCompile(`^2\.(0|[1-9][0-9]*)$`)
+
+// validateComputeAPIMicroversion checks that the given compute API
+// microversion is well formed and may be requested.
+func validateComputeAPIMicroversion(version string) error {
+	m := microversionPattern.FindStringSubmatch(version)
+	if m == nil {
+		return errors.NotValidf("%s %q (expected a version such as 2.19)", computeAPIMicroversionKey, version)
+	}
+	minor, err := strconv.Atoi(m[1])
+	if err != nil {
+		return errors.NotValidf("%s %q (expected a version such as 2.19)", computeAPIMicroversionKey, version)
+	}
+	if minor < 1 || minor > maxComputeAPIMinor {
+		return errors.NotValidf("%s %q (expected a version from %s to 2.%d)", computeAPIMicroversionKey, version, defaultComputeAPIMicroversion, maxComputeAPIMinor)
+	}
+	return nil
+}
+
+// microversionClient is a client that requests the given
+// microversion of the compute API with each compute API request.
+// Other requests are sent by the client it holds as usual.
+type microversionClient struct {
+	client.AuthenticatingClient
+	version string
+	minor   int
+}
+
+// newMicroversionClient returns a client that requests the given,
+// valid, microversion of the compute API using the given client.
+func newMicroversionClient(cl client.AuthenticatingClient, version string) *microversionClient {
+	m := microversionPattern.FindStringSubmatch(version)
+	minor, _ := strconv.Atoi(m[1])
+	return &microversionClient{
+		AuthenticatingClient: cl,
+		version:              version,
+		minor:                minor,
+	}
+}
+
+// SendRequest implements client.Client.SendRequest.
+func (c *microversionClient) SendRequest(method, svcType, apiCall string, requestData *goosehttp.RequestData) error {
+	if svcType != computeServiceType {
+		return c.AuthenticatingClient.SendRequest(method, svcType, apiCall, requestData)
+	}
+	// The request data belongs to the caller, so
+	// its headers are copied rather than modified.
+	withHeaders := *requestData
+	withHeaders.ReqHeaders = make(http.Header)
+	for name, values := range requestData.ReqHeaders {
+		withHeaders.ReqHeaders[name] = values
+	}
+	withHeaders.ReqHeaders.Set("X-OpenStack-Nova-API-Version", c.version)
+	if c.minor >= openStackAPIVersionMinor {
+		withHeaders.ReqHeaders.Set("OpenStack-API-Version", "compute "+c.version)
+	}
+	err := c.AuthenticatingClient.SendRequest(method, svcType, apiCall, &withHeaders)
+	requestData.RespReader = withHeaders.RespReader
+	requestData.RespHeaders = withHeaders.RespHeaders
+	return err
+}
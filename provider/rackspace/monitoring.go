@@ -0,0 +1,50 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"github.com/juju/errors"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+)
+
+const (
+	// monitoringAgentPackage is the package providing the
+	// Rackspace Cloud Monitoring agent.
+	monitoringAgentPackage = "rackspace-monitoring-agent"
+
+	// monitoringAgentConfigFile is where the Rackspace Cloud
+	// Monitoring agent reads its configuration, including the
+	// token it authenticates with.
+	monitoringAgentConfigFile = "/etc/rackspace-monitoring-agent.cfg"
+)
+
+// addMonitoringAgent configures the instance with the given cloud
+// config to run the Rackspace Cloud Monitoring agent using the given
+// token. The configuration file is written with cloud-init's
+// write_files before any packages are installed, so the agent starts
+// with it as soon as it is installed. The agent is packaged for
+// Ubuntu and CentOS only; on other OSes nothing is done, and false
+// is returned.
+func addMonitoringAgent(cloudcfg cloudinit.CloudConfig, token string) (bool, error) {
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu, jujuos.CentOS:
+	default:
+		return false, nil
+	}
+	cloudcfg.SetAttr("write_files", []map[string]interface{}{{
+		"path":        monitoringAgentConfigFile,
+		"owner":       "root:root",
+		"permissions": "0600",
+		"content":     "monitoring_token " + token + "\n",
+	}})
+	cloudcfg.AddPackage(monitoringAgentPackage)
+	return true, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+	goyaml "gopkg.in/yaml.v2"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/network"
+)
+
+// networkConfigFile is where the cloud-init network configuration
+// is written on new instances.
+const networkConfigFile = "/etc/cloud/cloud.cfg.d/99-juju-network.cfg"
+
+// The ids of the networks Rackspace attaches instances to, see:
+// http://docs.rackspace.com/servers/api/v2/cs-devguide/content/provision_server_with_networks.html
+const (
+	publicNetId  = "00000000-0000-0000-0000-000000000000"
+	serviceNetId = "11111111-1111-1111-1111-111111111111"
+)
+
+// The names under which servers report their PublicNet
+// and ServiceNet addresses.
+const (
+	publicNetName  = "public"
+	serviceNetName = "private"
+)
+
+// The suffixes of the spaces, named after the region, with which
+// the PublicNet and ServiceNet addresses of servers are annotated.
+const (
+	publicNetSpaceSuffix  = "-publicnet"
+	serviceNetSpaceSuffix = "-servicenet"
+)
+
+// regionSpace returns the space with which the addresses of servers in
+// the given region on the named network are annotated, such as
+// dfw-servicenet, or the empty string if the region or network is
+// not known.
+func regionSpace(region, networkName string) network.SpaceName {
+	if region == "" {
+		return ""
+	}
+	region = strings.ToLower(region)
+	switch networkName {
+	case publicNetName:
+		return network.SpaceName(region + publicNetSpaceSuffix)
+	case serviceNetName:
+		return network.SpaceName(region + serviceNetSpaceSuffix)
+	}
+	return ""
+}
+
+// rackspaceInterfaces returns the interfaces attached to rackspace
+// instances: PublicNet, if attached, followed by ServiceNet.
+func rackspaceInterfaces(publicNet bool) []string {
+	if publicNet {
+		return []string{"eth0", "eth1"}
+	}
+	return []string{"eth0"}
+}
+
+type networkConfigV1 struct {
+	Network struct {
+		Version int           `yaml:"version"`
+		Config  []interface{} `yaml:"config"`
+	} `yaml:"network"`
+}
+
+type networkConfigV1Phy struct {
+	Type    string                  `yaml:"type"`
+	Name    string                  `yaml:"name"`
+	Subnets []networkConfigV1Subnet `yaml:"subnets"`
+}
+
+type networkConfigV1Subnet struct {
+	Type string `yaml:"type"`
+}
+
+type networkConfigV1Nameserver struct {
+	Type    string   `yaml:"type"`
+	Address []string `yaml:"address"`
+}
+
+type networkConfigV2 struct {
+	Network struct {
+		Version   int                                `yaml:"version"`
+		Ethernets map[string]networkConfigV2Ethernet `yaml:"ethernets"`
+	} `yaml:"network"`
+}
+
+type networkConfigV2Ethernet struct {
+	DHCP4       bool                        `yaml:"dhcp4"`
+	Nameservers *networkConfigV2Nameservers `yaml:"nameservers,omitempty"`
+}
+
+type networkConfigV2Nameservers struct {
+	Addresses []string `yaml:"addresses"`
+}
+
+// renderNetworkConfig returns the cloud-init network configuration,
+// in the given format version, that brings up the rackspace
+// interfaces using DHCP; publicNet reports whether PublicNet is
+// attached. If any nameservers are given, they are used in place
+// of those provided by DHCP.
+func renderNetworkConfig(version int, publicNet bool, nameservers []string) (string, error) {
+	var doc interface{}
+	switch version {
+	case 1:
+		var cfg networkConfigV1
+		cfg.Network.Version = 1
+		for _, name := range rackspaceInterfaces(publicNet) {
+			cfg.Network.Config = append(cfg.Network.Config, networkConfigV1Phy{
+				Type:    "physical",
+				Name:    name,
+				Subnets: []networkConfigV1Subnet{{Type: "dhcp"}},
+			})
+		}
+		if len(nameservers) > 0 {
+			cfg.Network.Config = append(cfg.Network.Config, networkConfigV1Nameserver{
+				Type:    "nameserver",
+				Address: nameservers,
+			})
+		}
+		doc = cfg
+	case 2:
+		var cfg networkConfigV2
+		cfg.Network.Version = 2
+		cfg.Network.Ethernets = make(map[string]networkConfigV2Ethernet)
+		for _, name := range rackspaceInterfaces(publicNet) {
+			ethernet := networkConfigV2Ethernet{DHCP4: true}
+			if len(nameservers) > 0 {
+				ethernet.Nameservers = &networkConfigV2Nameservers{Addresses: nameservers}
+			}
+			cfg.Network.Ethernets[name] = ethernet
+		}
+		doc = cfg
+	default:
+		return "", errors.NotValidf("network config version %d", version)
+	}
+	data, err := goyaml.Marshal(doc)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(data), nil
+}
+
+// resolvconfHeadFile holds lines that resolvconf places at the start
+// of the generated resolv.conf on Ubuntu, ahead of any nameservers
+// provided by DHCP.
+const resolvconfHeadFile = "/etc/resolvconf/resolv.conf.d/head"
+
+// addNameservers configures the instance with the given cloud config
+// to use the given nameservers, in the way appropriate to its OS.
+// They are added from bootcmd, so take effect before any packages
+// are installed.
+func addNameservers(cloudcfg cloudinit.CloudConfig, nameservers []string) error {
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu:
+		var lines []string
+		for _, ns := range nameservers {
+			lines = append(lines, "nameserver "+ns)
+		}
+		cloudcfg.AddBootTextFile(resolvconfHeadFile, strings.Join(lines, "\n"), 0644)
+		cloudcfg.AddBootCmd("resolvconf -u")
+	case jujuos.CentOS:
+		cloudcfg.SetAttr("manage_resolv_conf", true)
+		cloudcfg.SetAttr("resolv_conf", map[string]interface{}{
+			"nameservers": nameservers,
+		})
+	default:
+		return errors.NotSupportedf("setting nameservers on %s", os)
+	}
+	return nil
+}
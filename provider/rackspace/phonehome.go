@@ -0,0 +1,60 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"net/url"
+
+	"github.com/juju/errors"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+)
+
+// phoneHomeTries is how many times new instances
+// try to post to the phone home URL.
+const phoneHomeTries = 10
+
+// phoneHomeFinalMessage is the message cloud-init logs to the
+// console of new instances that phone home once it has finished.
+const phoneHomeFinalMessage = "cloud-init finished at $TIMESTAMP, after $UPTIME seconds; phoned home"
+
+// validatePhoneHomeURL checks that the given
+// phone home URL is an http or https URL.
+func validatePhoneHomeURL(phoneHome string) error {
+	u, err := url.Parse(phoneHome)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.NotValidf("%s %q (expected an http or https URL)", phoneHomeURLKey, phoneHome)
+	}
+	return nil
+}
+
+// addPhoneHome configures the instance with the given cloud config
+// to post its instance id, hostname and FQDN to the given URL once
+// cloud-init has finished, using the cloud-init phone_home module,
+// and to log a final message saying so.
+//
+// cloud-init phones home in its final stage, after running the
+// runcmds with which Juju sets up its agent, so the post neither
+// delays nor stands in for the agent's own report that it has
+// started.
+func addPhoneHome(cloudcfg cloudinit.CloudConfig, phoneHome string) error {
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu, jujuos.CentOS:
+	default:
+		return errors.NotSupportedf("phoning home from %s", os)
+	}
+	cloudcfg.SetAttr("phone_home", map[string]interface{}{
+		"url":   phoneHome,
+		"post":  []string{"instance_id", "hostname", "fqdn"},
+		"tries": phoneHomeTries,
+	})
+	cloudcfg.SetFinalMessage(phoneHomeFinalMessage)
+	return nil
+}
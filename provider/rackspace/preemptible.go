@@ -0,0 +1,102 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+	"gopkg.in/goose.v1/nova"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/service"
+)
+
+const (
+	// preemptibleFlavorClass is the class of the discounted
+	// flavors whose servers may be reclaimed by the region
+	// at any time, as given by their ids.
+	preemptibleFlavorClass = "preemptible"
+
+	// preemptionDrainService is the name of the systemd service
+	// that drains the Juju agents of preemptible instances.
+	preemptionDrainService = "juju-preemption-drain.service"
+
+	// preemptionDrainTimeout is how long the agents of a
+	// preempted instance are given to stop, in seconds. The
+	// compute service waits 60 seconds by default for a server
+	// to shut down before powering it off.
+	preemptionDrainTimeout = 50
+)
+
+// preemptionDrainUnit is the systemd unit of the preemptionDrainService.
+// The compute service gives notice of the preemption of a server by
+// requesting that it shut down cleanly. The service starts at boot,
+// doing nothing; as it is ordered after the network, it is stopped
+// before the network is brought down when the instance shuts down,
+// and stops the Juju agents, so that they finish what they are doing
+// and close their API connections while the controller
+// can still be reached.
+var preemptionDrainUnit = fmt.Sprintf(`[Unit]
+Description=Drain Juju agents when the instance is preempted
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/true
+ExecStop=/bin/systemctl stop 'jujud-*'
+TimeoutStopSec=%d
+
+[Install]
+WantedBy=multi-user.target
+`, preemptionDrainTimeout)
+
+// preemptibleFlavors returns those of the given flavors that are
+// preemptible. If there are none, the tenant is not offered
+// preemptible capacity in the region, and an error satisfying
+// errors.IsNotSupported is returned.
+func preemptibleFlavors(flavors []nova.FlavorDetail) ([]nova.FlavorDetail, error) {
+	result := flavorsOfClass(flavors, preemptibleFlavorClass)
+	if len(result) == 0 {
+		return nil, errors.NewNotSupported(nil, fmt.Sprintf(
+			"preemptible capacity not offered in this region (no flavors of class %q)",
+			preemptibleFlavorClass,
+		))
+	}
+	return result, nil
+}
+
+// addPreemptionDrain configures the instance with the given cloud
+// config to drain its Juju agents when it is preempted, by installing
+// and starting the preemptionDrainService. It is supported only on
+// OSes using systemd: Ubuntu since xenial, and CentOS.
+func addPreemptionDrain(cloudcfg cloudinit.CloudConfig) error {
+	ser := cloudcfg.GetSeries()
+	os, err := series.GetOSFromSeries(ser)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu:
+		initSystem, err := service.VersionInitSystem(ser)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if initSystem != service.InitSystemSystemd {
+			return errors.NotSupportedf("draining preempted instances on %s", ser)
+		}
+	case jujuos.CentOS:
+	default:
+		return errors.NotSupportedf("draining preempted instances on %s", os)
+	}
+	cloudcfg.AddRunTextFile("/etc/systemd/system/"+preemptionDrainService, preemptionDrainUnit, 0644)
+	cloudcfg.AddRunCmd("systemctl daemon-reload")
+	cloudcfg.AddRunCmd("systemctl enable " + preemptionDrainService)
+	cloudcfg.AddRunCmd("systemctl start " + preemptionDrainService)
+	return nil
+}
@@ -6,6 +6,8 @@ package rackspace
 import (
 	"strings"
 
+	"github.com/juju/errors"
+
 	"github.com/juju/juju/environs"
 	"github.com/juju/juju/environs/config"
 )
@@ -18,20 +20,57 @@ var providerInstance *environProvider
 
 // PrepareConfig is part of the EnvironProvider interface.
 func (p *environProvider) PrepareConfig(args environs.PrepareConfigParams) (*config.Config, error) {
-	args.Cloud = transformCloudSpec(args.Cloud)
+	spec, err := transformCloudSpec(args.Cloud)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	args.Cloud = spec
 	return p.EnvironProvider.PrepareConfig(args)
 }
 
+// Validate is part of the EnvironProvider interface.
+func (p *environProvider) Validate(cfg, old *config.Config) (*config.Config, error) {
+	valid, err := p.EnvironProvider.Validate(cfg, old)
+	if err != nil {
+		return nil, err
+	}
+	ecfg, err := newConfig(valid)
+	if err != nil {
+		return nil, err
+	}
+	return valid.Apply(ecfg.attrs)
+}
+
 // Open is part of the EnvironProvider interface.
 func (p *environProvider) Open(args environs.OpenParams) (environs.Environ, error) {
-	args.Cloud = transformCloudSpec(args.Cloud)
+	spec, err := transformCloudSpec(args.Cloud)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	args.Cloud = spec
 	return p.EnvironProvider.Open(args)
 }
 
-func transformCloudSpec(spec environs.CloudSpec) environs.CloudSpec {
+// regions holds the codes of the known Rackspace regions.
+var regions = []string{"DFW", "ORD", "IAD", "LON", "SYD", "HKG"}
+
+func transformCloudSpec(spec environs.CloudSpec) (environs.CloudSpec, error) {
 	// Rackspace regions are expected to be uppercase, but Juju
 	// stores and displays them in lowercase in the CLI. Ensure
 	// they're uppercase when they get to the Rackspace API.
 	spec.Region = strings.ToUpper(spec.Region)
-	return spec
+	if spec.Region == "" {
+		return spec, nil
+	}
+	for _, region := range regions {
+		if spec.Region == region {
+			return spec, nil
+		}
+	}
+	// A mistyped region would otherwise surface only as
+	// an authentication or endpoint lookup failure.
+	return environs.CloudSpec{}, errors.NotValidf(
+		"region %q (expected one of %s)",
+		spec.Region, strings.Join(regions, ", "),
+	)
 }
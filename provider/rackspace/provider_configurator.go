@@ -6,34 +6,379 @@ package rackspace
 import (
 	"github.com/juju/errors"
 	"github.com/juju/schema"
+	"github.com/juju/utils/proxy"
+	"gopkg.in/goose.v1/client"
 	"gopkg.in/goose.v1/nova"
 
 	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/cloudconfig/providerinit/renderers"
 	"github.com/juju/juju/environs"
+	"github.com/juju/juju/environs/config"
+	"github.com/juju/juju/environs/instances"
+	"github.com/juju/juju/network"
+	"github.com/juju/juju/provider/openstack"
 )
 
 type rackspaceConfigurator struct {
 }
 
+// GetClient implements ProviderConfigurator interface.
+// Clients are shared between environs using the same credentials,
+// so that their identity tokens are reused until they near expiry;
+// see clientCache. They authenticate with identity-endpoint and send
+// compute API requests to compute-endpoint, if set, requesting
+// compute-api-microversion if it is not the default. Requests to
+// create servers carry the options the compute client cannot set
+// itself, such as injected-files; see serverCreateClient.
+func (c *rackspaceConfigurator) GetClient(spec environs.CloudSpec, cfg *config.Config, newClient func(environs.CloudSpec) (client.AuthenticatingClient, error)) (client.AuthenticatingClient, error) {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if endpoint := ecfg.identityEndpoint(); endpoint != "" {
+		spec.Endpoint = endpoint
+	}
+	newSpecClient := func() (client.AuthenticatingClient, error) {
+		return newClient(spec)
+	}
+	if endpoint := ecfg.computeEndpoint(); endpoint != "" {
+		newSpecClient = func() (client.AuthenticatingClient, error) {
+			return newComputeEndpointClient(endpoint, ecfg.SSLHostnameVerification(), func() (client.AuthenticatingClient, error) {
+				return newClient(spec)
+			})
+		}
+	}
+	if version := ecfg.computeAPIMicroversion(); version != defaultComputeAPIMicroversion {
+		newVersionedClient := newSpecClient
+		newSpecClient = func() (client.AuthenticatingClient, error) {
+			cl, err := newVersionedClient()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return newMicroversionClient(cl, version), nil
+		}
+	}
+	cl, err := clients.get(spec, ecfg, newSpecClient)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if opts := newServerCreateOptions(ecfg); !opts.empty() {
+		// The options are those of this model, so the
+		// shared client is wrapped rather than cached.
+		return &serverCreateClient{AuthenticatingClient: cl, opts: opts}, nil
+	}
+	return cl, nil
+}
+
 // ModifyRunServerOptions implements ProviderConfigurator interface.
 func (c *rackspaceConfigurator) ModifyRunServerOptions(options *nova.RunServerOpts) {
 	// More on how ConfigDrive option is used on rackspace:
 	// http://docs.rackspace.com/servers/api/v2/cs-devguide/content/config_drive_ext.html
+	// Its format cannot be chosen here; see configDriveFormatKey.
 	options.ConfigDrive = true
 }
 
 // GetCloudConfig implements ProviderConfigurator interface.
-func (c *rackspaceConfigurator) GetCloudConfig(args environs.StartInstanceParams) (cloudinit.CloudConfig, error) {
+func (c *rackspaceConfigurator) GetCloudConfig(args environs.StartInstanceParams, cfg *config.Config) (cloudinit.CloudConfig, error) {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	cloudcfg, err := cloudinit.New(args.Tools.OneSeries())
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	// Additional package required for sshInstanceConfigurator, to save
-	// iptables state between restarts.
-	cloudcfg.AddPackage("iptables-persistent")
+	if cmds := ecfg.bootCommands(); len(cmds) > 0 {
+		if err := addBootCommands(cloudcfg, cmds); err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", bootCmdKey)
+		}
+	}
+	if ecfg.accountType() == accountTypeManaged {
+		// This must come first, so that no other runcmd
+		// races with the managed cloud automation.
+		if err := addManagedAutomationWait(cloudcfg); err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", accountTypeKey)
+		}
+	}
+	proxySettings, aptProxySettings := cfg.ProxySettings(), cfg.AptProxySettings()
+	if proxySettings != (proxy.Settings{}) || aptProxySettings != (proxy.Settings{}) {
+		if err := addProxySettings(cloudcfg, proxySettings, aptProxySettings); err != nil {
+			return nil, errors.Annotate(err, "cannot use proxy settings")
+		}
+	}
+	certs, err := ecfg.trustedCACerts()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(certs) > 0 {
+		if err := addTrustedCACerts(cloudcfg, certs); err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", trustedCACertsKey)
+		}
+	}
+	// These are set again when the user data is
+	// rendered; see userDataRenderer.
+	if update := ecfg.packageUpdate(); update != nil {
+		cloudcfg.SetSystemUpdate(*update)
+	}
+	if upgrade := ecfg.packageUpgrade(); upgrade != nil {
+		cloudcfg.SetSystemUpgrade(*upgrade)
+	}
+	if ecfg.manageIptablesPersistence() {
+		// Additional package required for sshInstanceConfigurator, to save
+		// iptables state between restarts. Images that manage firewall
+		// persistence themselves can opt out of this.
+		cloudcfg.AddPackage("iptables-persistent")
+	}
+	if mirror := ecfg.packageMirror(); mirror != "" {
+		// The model's apt-mirror, if set, replaces
+		// this when the user data is composed.
+		cloudcfg.SetPackageMirror(mirror)
+	}
+	nameservers := ecfg.dnsNameservers()
+	if len(nameservers) > 0 {
+		if err := addNameservers(cloudcfg, nameservers); err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", dnsNameserversKey)
+		}
+	}
+	if tz := ecfg.timezone(); tz != "" {
+		if err := addTimezone(cloudcfg, tz); err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", timezoneKey)
+		}
+	}
+	if servers := ecfg.ntpServers(); len(servers) > 0 {
+		if err := addNTPServers(cloudcfg, servers); err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", ntpServersKey)
+		}
+	}
+	if err := addDiskLayout(cloudcfg, ecfg.diskLayout(), ecfg.dataDisks()); err != nil {
+		return nil, errors.Annotatef(err, "cannot use %s", diskLayoutKey)
+	}
+	volumes, err := ecfg.attachVolumes()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := addVolumeMounts(cloudcfg, volumes); err != nil {
+		return nil, errors.Annotatef(err, "cannot use %s", attachVolumesKey)
+	}
+	if token := ecfg.monitoringAgentToken(); token != "" {
+		added, err := addMonitoringAgent(cloudcfg, token)
+		if err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", monitoringAgentTokenKey)
+		}
+		if !added {
+			logger.Infof("not installing monitoring agent: not available for series %q", cloudcfg.GetSeries())
+		}
+	}
+	if phoneHome := ecfg.phoneHomeURL(); phoneHome != "" {
+		if err := addPhoneHome(cloudcfg, phoneHome); err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", phoneHomeURLKey)
+		}
+	}
+	if ecfg.preemptible() {
+		if err := addPreemptionDrain(cloudcfg); err != nil {
+			return nil, errors.Annotatef(err, "cannot use %s", preemptibleKey)
+		}
+	}
+	if v := ecfg.networkConfigVersion(); v != 0 {
+		// cloud-init reads its network configuration before any
+		// user data is processed, so the file written here is
+		// only used when cloud-init next renders the network
+		// configuration, rather than on first boot.
+		netcfg, err := renderNetworkConfig(v, ecfg.allocatePublicIP(), nameservers)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		cloudcfg.AddBootTextFile(networkConfigFile, netcfg, 0644)
+	}
 	return cloudcfg, nil
 }
 
+// ModifyCloudConfig implements ProviderConfigurator interface.
+// If local-storage-persistence is persistent, flavors without
+// a local disk are refused; see validateLocalStorage.
+func (c *rackspaceConfigurator) ModifyCloudConfig(cloudcfg cloudinit.CloudConfig, cfg *config.Config, instType instances.InstanceType) error {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := validateLocalStorage(ecfg.localStoragePersistence(), instType); err != nil {
+		return errors.Trace(err)
+	}
+	size, err := ecfg.swapSize()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if size != (swapSize{}) {
+		if err := addSwap(cloudcfg, size, instType); err != nil {
+			return errors.Annotatef(err, "cannot use %s", swapSizeKey)
+		}
+	}
+	return nil
+}
+
+// GetUserDataRenderer implements ProviderConfigurator interface.
+func (c *rackspaceConfigurator) GetUserDataRenderer(cfg *config.Config) (renderers.ProviderRenderer, error) {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var secrets []string
+	if token := ecfg.monitoringAgentToken(); token != "" {
+		secrets = append(secrets, token)
+	}
+	return userDataRenderer{
+		compress:       ecfg.compressUserData(),
+		secrets:        secrets,
+		packageUpdate:  ecfg.packageUpdate(),
+		packageUpgrade: ecfg.packageUpgrade(),
+		noJujuKeys:     ecfg.sshKeyMode() == sshKeyModeProviderOnly,
+	}, nil
+}
+
+// GetPinnedImageId implements ProviderConfigurator interface.
+func (c *rackspaceConfigurator) GetPinnedImageId(cfg *config.Config) (string, error) {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return ecfg.imageId(), nil
+}
+
+// ValidateImage implements ProviderConfigurator interface.
+// Images in formats Rackspace does not boot are rejected unless
+// allow-unsupported-image-formats is true. If the formats cannot be
+// looked up, the image is used regardless, as the check is only to
+// report a failure to boot early.
+func (c *rackspaceConfigurator) ValidateImage(cfg *config.Config, imageId string, formats func(imageId string) (openstack.ImageFormats, error)) error {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f, err := formats(imageId)
+	if err != nil {
+		logger.Warningf("not checking the formats of image %q: %v", imageId, err)
+		return nil
+	}
+	err = validateImageFormats(imageId, f)
+	if err != nil && ecfg.allowUnsupportedImageFormats() {
+		logger.Warningf("%v; using it as %s is true", err, allowUnsupportedImageFormatsKey)
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+// GetAttachedVolumes implements ProviderConfigurator interface.
+func (c *rackspaceConfigurator) GetAttachedVolumes(cfg *config.Config) ([]openstack.AttachedVolume, error) {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	volumes, err := ecfg.attachVolumes()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var result []openstack.AttachedVolume
+	for _, v := range volumes {
+		result = append(result, v.AttachedVolume)
+	}
+	return result, nil
+}
+
+// GetServerNetworks implements ProviderConfigurator interface.
+// PublicNet is removed from the networks unless allocate-public-ip
+// is true, so that instances are attached to ServiceNet only.
+func (c *rackspaceConfigurator) GetServerNetworks(cfg *config.Config, networks []nova.ServerNetworks) ([]nova.ServerNetworks, error) {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if ecfg.allocatePublicIP() {
+		return networks, nil
+	}
+	var result []nova.ServerNetworks
+	for _, n := range networks {
+		if n.NetworkId != publicNetId {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+// GetFlavors implements ProviderConfigurator interface.
+// If preemptible is set, only preemptible flavors are returned;
+// otherwise, if flavor-class is set, only flavors of that class are.
+func (c *rackspaceConfigurator) GetFlavors(cfg *config.Config, flavors []nova.FlavorDetail) ([]nova.FlavorDetail, error) {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if ecfg.preemptible() {
+		return preemptibleFlavors(flavors)
+	}
+	class := ecfg.flavorClass()
+	if class == "" {
+		return flavors, nil
+	}
+	result := flavorsOfClass(flavors, class)
+	if len(result) == 0 {
+		return nil, errors.NotFoundf("flavors of class %q", class)
+	}
+	return result, nil
+}
+
+// GetNetworkScope implements ProviderConfigurator interface.
+// ServiceNet addresses are reachable only from within the
+// region, so are reported as cloud-local.
+func (c *rackspaceConfigurator) GetNetworkScope(networkName string) network.Scope {
+	switch networkName {
+	case publicNetName:
+		return network.ScopePublic
+	case serviceNetName:
+		return network.ScopeCloudLocal
+	}
+	return network.ScopeUnknown
+}
+
+// GetNetworkSpace implements ProviderConfigurator interface.
+// PublicNet and ServiceNet addresses are annotated with spaces
+// named after the region, such as dfw-servicenet, so that agents
+// and clients can prefer the addresses of controllers in their own
+// region, as with api.DialOpts.AddressPriority. The ServiceNet
+// addresses of other regions cannot be reached at all.
+func (c *rackspaceConfigurator) GetNetworkSpace(region, networkName string) network.SpaceName {
+	return regionSpace(region, networkName)
+}
+
+// RegisterInstance implements ProviderConfigurator interface.
+// If cloud-load-balancer-id is set, the server is added to the load
+// balancer once active; see registerInstance.
+func (c *rackspaceConfigurator) RegisterInstance(cfg *config.Config, client client.AuthenticatingClient, serverId string) error {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	id := ecfg.cloudLoadBalancerId()
+	if id == "" {
+		return nil
+	}
+	return errors.Annotatef(registerInstance(client, id, serverId), "cannot use %s", cloudLoadBalancerIdKey)
+}
+
+// DeregisterInstances implements ProviderConfigurator interface.
+// If cloud-load-balancer-id is set, the nodes of the servers are
+// removed from the load balancer; see deregisterInstances.
+func (c *rackspaceConfigurator) DeregisterInstances(cfg *config.Config, client client.AuthenticatingClient, serverIds []string) error {
+	ecfg, err := newConfig(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	id := ecfg.cloudLoadBalancerId()
+	if id == "" {
+		return nil
+	}
+	return errors.Annotatef(deregisterInstances(client, id, serverIds), "cannot use %s", cloudLoadBalancerIdKey)
+}
+
 // GetConfigDefaults implements ProviderConfigurator interface.
 func (c *rackspaceConfigurator) GetConfigDefaults() schema.Defaults {
 	return schema.Defaults{
@@ -0,0 +1,1740 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/juju/errors"
+	jc "github.com/juju/testing/checkers"
+	jujuos "github.com/juju/utils/os"
+	sshtesting "github.com/juju/utils/ssh/testing"
+	"github.com/juju/version"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/goose.v1/nova"
+	"gopkg.in/juju/names.v2"
+	goyaml "gopkg.in/yaml.v2"
+
+	"github.com/juju/juju/cloudconfig"
+	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/cloudconfig/providerinit/renderers"
+	"github.com/juju/juju/environs"
+	"github.com/juju/juju/environs/config"
+	"github.com/juju/juju/environs/instances"
+	"github.com/juju/juju/network"
+	"github.com/juju/juju/provider/openstack"
+	"github.com/juju/juju/provider/rackspace"
+	"github.com/juju/juju/testing"
+	"github.com/juju/juju/tools"
+)
+
+type configuratorSuite struct {
+	testing.BaseSuite
+	configurator openstack.ProviderConfigurator
+}
+
+var _ = gc.Suite(&configuratorSuite{})
+
+func (s *configuratorSuite) SetUpTest(c *gc.C) {
+	s.BaseSuite.SetUpTest(c)
+	s.configurator = rackspace.NewConfigurator()
+}
+
+func (s *configuratorSuite) startInstanceParams(series string) environs.StartInstanceParams {
+	return environs.StartInstanceParams{
+		Tools: tools.List{&tools.Tools{
+			Version: version.Binary{Series: series},
+		}},
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigManagesIptablesByDefault(c *gc.C) {
+	cfg := testing.ModelConfig(c)
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.Packages(), jc.Contains, "iptables-persistent")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigManageIptablesPersistence(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"manage-iptables-persistence": true,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.Packages(), jc.Contains, "iptables-persistent")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoIptablesPersistence(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"manage-iptables-persistence": false,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.Packages(), gc.Not(jc.Contains), "iptables-persistent")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidAttribute(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"manage-iptables-persistence": "maybe",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, gc.ErrorMatches, `manage-iptables-persistence: expected bool, got string\("maybe"\)`)
+}
+
+// userDataLimit is the maximum size of the base64 encoded
+// user data accepted by Rackspace.
+const userDataLimit = 64 * 1024
+
+// largeCloudConfig returns a cloud config that renders to more
+// than userDataLimit bytes of uncompressed YAML.
+func largeCloudConfig(c *gc.C) cloudinit.CloudConfig {
+	cloudcfg, err := cloudinit.New("trusty")
+	c.Assert(err, jc.ErrorIsNil)
+	for i := 0; i < 2000; i++ {
+		cloudcfg.AddRunCmd(fmt.Sprintf("echo %d %s", i, strings.Repeat("x", 64)))
+	}
+	return cloudcfg
+}
+
+func (s *configuratorSuite) TestGetUserDataRendererCompressesByDefault(c *gc.C) {
+	renderer, err := s.configurator.GetUserDataRenderer(testing.ModelConfig(c))
+	c.Assert(err, jc.ErrorIsNil)
+	cloudcfg := largeCloudConfig(c)
+	plain, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(len(plain) > userDataLimit, jc.IsTrue)
+
+	data, err := renderer.Render(cloudcfg, jujuos.Ubuntu)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(base64.StdEncoding.EncodedLen(len(data)) < userDataLimit, jc.IsTrue)
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	c.Assert(err, jc.ErrorIsNil)
+	uncompressed, err := ioutil.ReadAll(r)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(uncompressed), gc.Equals, string(plain))
+}
+
+func (s *configuratorSuite) TestGetUserDataRendererNoCompression(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"compress-user-data": false,
+	})
+	renderer, err := s.configurator.GetUserDataRenderer(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	cloudcfg := largeCloudConfig(c)
+	plain, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+
+	data, err := renderer.Render(cloudcfg, jujuos.Ubuntu)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Equals, string(plain))
+}
+
+func (s *configuratorSuite) TestGetUserDataRendererInvalidAttribute(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"compress-user-data": "yes please",
+	})
+	_, err := s.configurator.GetUserDataRenderer(cfg)
+	c.Assert(err, gc.ErrorMatches, `compress-user-data: expected bool, got string\("yes please"\)`)
+}
+
+func (s *configuratorSuite) TestInjectedFilesLimits(c *gc.C) {
+	tooMany := map[string]interface{}{}
+	for i := 0; i < 6; i++ {
+		tooMany[fmt.Sprintf("/etc/file%d", i)] = "x"
+	}
+	for i, test := range []struct {
+		about  string
+		files  map[string]interface{}
+		expect string
+	}{{
+		about:  "too many files",
+		files:  tooMany,
+		expect: `invalid injected-files: 6 files specified, at most 5 allowed`,
+	}, {
+		about: "path too long",
+		files: map[string]interface{}{
+			"/" + strings.Repeat("p", 255): "x",
+		},
+		expect: `invalid injected-files: path "/p+" longer than 255 bytes`,
+	}, {
+		about: "contents too large",
+		files: map[string]interface{}{
+			"/etc/a": strings.Repeat("x", 2500),
+			"/etc/b": strings.Repeat("x", 2501),
+		},
+		expect: `invalid injected-files: total file size 5001 bytes exceeds limit of 5000 bytes`,
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"injected-files": test.files,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+		c.Check(err, gc.ErrorMatches, test.expect)
+	}
+}
+
+func (s *configuratorSuite) TestInjectedFilesWithinLimits(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"injected-files": map[string]interface{}{
+			"/etc/a": strings.Repeat("x", 2500),
+			"/etc/b": strings.Repeat("x", 2500),
+		},
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *configuratorSuite) TestNoInjectedFiles(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"injected-files": map[string]interface{}{},
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *configuratorSuite) TestSchedulerHintsValidation(c *gc.C) {
+	for i, test := range []struct {
+		about  string
+		hints  map[string]interface{}
+		expect string
+	}{{
+		about: "invalid group",
+		hints: map[string]interface{}{
+			"group": "controllers",
+		},
+		expect: `invalid scheduler-hints: group hint "controllers" is not a valid id`,
+	}, {
+		about: "invalid different_host",
+		hints: map[string]interface{}{
+			"different_host": "0b5e4914-9f4b-4d8d-a5b0-b3cd4f6ccc35,machine-0",
+		},
+		expect: `invalid scheduler-hints: different_host hint "machine-0" is not a valid id`,
+	}, {
+		about: "anti-affinity group",
+		hints: map[string]interface{}{
+			"group": "8d7b5c4a-5a3c-4f4e-9b7e-2c1c9bdbc4a1",
+		},
+	}, {
+		about: "unknown hints are passed through",
+		hints: map[string]interface{}{
+			"same_host":          "0b5e4914-9f4b-4d8d-a5b0-b3cd4f6ccc35, 1c6f5a25-0a5c-4e9e-b6c1-c4de5a7ddd46",
+			"build_near_host_ip": "10.0.0.1",
+		},
+	}} {
+		c.Logf("test %d: %s", i, test.about)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"scheduler-hints": test.hints,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+		if test.expect == "" {
+			c.Check(err, jc.ErrorIsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, test.expect)
+		}
+	}
+}
+
+func (s *configuratorSuite) TestNoSchedulerHints(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"scheduler-hints": map[string]interface{}{},
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *configuratorSuite) TestSSHKeyMode(c *gc.C) {
+	for i, test := range []struct {
+		mode     string
+		jujuKeys bool
+	}{
+		{"merge", true},
+		{"juju-only", true},
+		{"provider-only", false},
+	} {
+		c.Logf("test %d: %s", i, test.mode)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"ssh-key-mode":       test.mode,
+			"keypair-name":       "ops",
+			"compress-user-data": false,
+		})
+		renderer, err := s.configurator.GetUserDataRenderer(cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		// Juju adds its authorized keys to the ubuntu user
+		// after the provider's cloud config has been made.
+		cloudcfg, err := cloudinit.New("trusty")
+		c.Assert(err, jc.ErrorIsNil)
+		cloudconfig.SetUbuntuUser(cloudcfg, sshtesting.ValidKeyOne.Key)
+
+		data, err := renderer.Render(cloudcfg, jujuos.Ubuntu)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), jc.Contains, "name: ubuntu")
+		blob := strings.Fields(sshtesting.ValidKeyOne.Key)[1]
+		c.Check(strings.Contains(string(data), blob), gc.Equals, test.jujuKeys)
+	}
+}
+
+func (s *configuratorSuite) TestSSHKeyModeProviderOnlyWithoutKeypair(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"ssh-key-mode": "provider-only",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, gc.ErrorMatches, `ssh-key-mode "provider-only" without keypair-name not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *configuratorSuite) TestSSHKeyModeInvalid(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"ssh-key-mode": "both",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, gc.ErrorMatches, `ssh-key-mode: expected one of \[merge provider-only juju-only\], got "both"`)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigPackageMirror(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"package-mirror": "http://mirror.example.com/os",
+	})
+	for i, test := range []struct {
+		series string
+		expect string
+	}{{
+		series: "xenial",
+		expect: "apt_mirror: http://mirror.example.com/os\n",
+	}, {
+		series: "centos7",
+		expect: "baseurl=http://mirror.example.com/os|g",
+	}} {
+		c.Logf("test %d: %s", i, test.series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(test.series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(cloudcfg.PackageMirror(), gc.Equals, "http://mirror.example.com/os")
+		c.Check(cloudcfg.Packages(), jc.Contains, "iptables-persistent")
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), jc.Contains, test.expect)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoPackageMirrorByDefault(c *gc.C) {
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), testing.ModelConfig(c))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.PackageMirror(), gc.Equals, "")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidPackageMirror(c *gc.C) {
+	for i, test := range []struct {
+		mirror string
+		expect string
+	}{{
+		mirror: "mirror.example.com/ubuntu",
+		expect: `package-mirror "mirror.example.com/ubuntu" \(expected an http or https URL\) not valid`,
+	}, {
+		mirror: "ftp://mirror.example.com/ubuntu",
+		expect: `package-mirror "ftp://mirror.example.com/ubuntu" \(expected an http or https URL\) not valid`,
+	}, {
+		mirror: "http://mirror.example.com/it's",
+		expect: `package-mirror "http://mirror.example.com/it's" \(must not contain quotes, pipes, backslashes or whitespace\) not valid`,
+	}} {
+		c.Logf("test %d: %s", i, test.mirror)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"package-mirror": test.mirror,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Check(err, gc.ErrorMatches, test.expect)
+	}
+}
+
+func (s *configuratorSuite) TestConfigDriveFormat(c *gc.C) {
+	for _, attrs := range []testing.Attrs{{}, {"config-drive-format": "iso9660"}} {
+		c.Logf("attrs %v", attrs)
+		cfg := testing.CustomModelConfig(c, attrs)
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+
+		var opts nova.RunServerOpts
+		s.configurator.ModifyRunServerOptions(&opts)
+		c.Check(opts.ConfigDrive, jc.IsTrue)
+	}
+}
+
+func (s *configuratorSuite) TestConfigDriveFormatInvalid(c *gc.C) {
+	for _, format := range []string{"vfat", "ext4"} {
+		c.Logf("format %q", format)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"config-drive-format": format,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+		c.Check(err, gc.ErrorMatches, `config-drive-format: expected one of \[iso9660\], got "`+format+`"`)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoNetworkConfigByDefault(c *gc.C) {
+	cfg := testing.ModelConfig(c)
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(strings.Join(cloudcfg.BootCmds(), "\n"), gc.Not(jc.Contains), "99-juju-network.cfg")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNetworkConfigVersion(c *gc.C) {
+	for _, version := range []int{1, 2} {
+		c.Logf("version %d", version)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"network-config-version": version,
+		})
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		expected, err := rackspace.RenderNetworkConfig(version, true, nil)
+		c.Assert(err, jc.ErrorIsNil)
+		bootcmds := strings.Join(cloudcfg.BootCmds(), "\n")
+		c.Check(bootcmds, jc.Contains, "/etc/cloud/cloud.cfg.d/99-juju-network.cfg")
+		c.Check(bootcmds, jc.Contains, expected)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidNetworkConfigVersion(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"network-config-version": 3,
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, gc.ErrorMatches, `network-config-version 3 \(expected 1 or 2\) not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *configuratorSuite) TestRenderNetworkConfigV1(c *gc.C) {
+	netcfg, err := rackspace.RenderNetworkConfig(1, true, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(netcfg, gc.Equals, `
+network:
+  version: 1
+  config:
+  - type: physical
+    name: eth0
+    subnets:
+    - type: dhcp
+  - type: physical
+    name: eth1
+    subnets:
+    - type: dhcp
+`[1:])
+}
+
+func (s *configuratorSuite) TestRenderNetworkConfigV2(c *gc.C) {
+	netcfg, err := rackspace.RenderNetworkConfig(2, true, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(netcfg, gc.Equals, `
+network:
+  version: 2
+  ethernets:
+    eth0:
+      dhcp4: true
+    eth1:
+      dhcp4: true
+`[1:])
+}
+
+func (s *configuratorSuite) TestGetPinnedImageIdUnset(c *gc.C) {
+	cfg := testing.ModelConfig(c)
+	id, err := s.configurator.GetPinnedImageId(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(id, gc.Equals, "")
+}
+
+func (s *configuratorSuite) TestGetPinnedImageId(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"image-id": "a1b2c3d4-0000-4000-8000-000000000000",
+	})
+	id, err := s.configurator.GetPinnedImageId(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(id, gc.Equals, "a1b2c3d4-0000-4000-8000-000000000000")
+}
+
+func (s *configuratorSuite) TestRenderNetworkConfigV1Nameservers(c *gc.C) {
+	netcfg, err := rackspace.RenderNetworkConfig(1, true, []string{"10.0.0.1", "10.0.0.2"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(netcfg, gc.Equals, `
+network:
+  version: 1
+  config:
+  - type: physical
+    name: eth0
+    subnets:
+    - type: dhcp
+  - type: physical
+    name: eth1
+    subnets:
+    - type: dhcp
+  - type: nameserver
+    address:
+    - 10.0.0.1
+    - 10.0.0.2
+`[1:])
+}
+
+func (s *configuratorSuite) TestRenderNetworkConfigV2Nameservers(c *gc.C) {
+	netcfg, err := rackspace.RenderNetworkConfig(2, true, []string{"10.0.0.1"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(netcfg, gc.Equals, `
+network:
+  version: 2
+  ethernets:
+    eth0:
+      dhcp4: true
+      nameservers:
+        addresses:
+        - 10.0.0.1
+    eth1:
+      dhcp4: true
+      nameservers:
+        addresses:
+        - 10.0.0.1
+`[1:])
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoNameserversByDefault(c *gc.C) {
+	cfg := testing.ModelConfig(c)
+	for _, series := range []string{"trusty", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(strings.Join(cloudcfg.BootCmds(), "\n"), gc.Not(jc.Contains), "resolv")
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), gc.Not(jc.Contains), "resolv_conf")
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNameserversUbuntu(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"dns-nameservers": "10.0.0.1, 10.0.0.2",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	bootcmds := cloudcfg.BootCmds()
+	c.Assert(bootcmds, jc.DeepEquals, []string{
+		"install -D -m 644 /dev/null '/etc/resolvconf/resolv.conf.d/head'",
+		"printf '%s\\n' 'nameserver 10.0.0.1\nnameserver 10.0.0.2' > '/etc/resolvconf/resolv.conf.d/head'",
+		"resolvconf -u",
+	})
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNameserversCentOS(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"dns-nameservers": "10.0.0.1,10.0.0.2",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("centos7"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), jc.Contains, "manage_resolv_conf: true\n")
+	c.Assert(string(data), jc.Contains, `
+resolv_conf:
+  nameservers:
+  - 10.0.0.1
+  - 10.0.0.2
+`[1:])
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNameserversWithNetworkConfig(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"dns-nameservers":        "10.0.0.1",
+		"network-config-version": 2,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	expected, err := rackspace.RenderNetworkConfig(2, true, []string{"10.0.0.1"})
+	c.Assert(err, jc.ErrorIsNil)
+	bootcmds := strings.Join(cloudcfg.BootCmds(), "\n")
+	c.Check(bootcmds, jc.Contains, "/etc/resolvconf/resolv.conf.d/head")
+	c.Check(bootcmds, jc.Contains, expected)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNameserversNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"dns-nameservers": "10.0.0.1",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(err, gc.ErrorMatches, "cannot use dns-nameservers: setting nameservers on Windows not supported")
+	c.Assert(errors.Cause(err), jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidNameserver(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"dns-nameservers": "10.0.0.1,ns.example.com",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, gc.ErrorMatches, `dns-nameservers entry "ns.example.com" \(expected an IP address\) not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoTimeSettingsByDefault(c *gc.C) {
+	cfg := testing.ModelConfig(c)
+	for _, series := range []string{"trusty", "xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), gc.Not(jc.Contains), "timezone")
+		c.Check(string(data), gc.Not(jc.Contains), "ntp")
+		c.Check(string(data), gc.Not(jc.Contains), "chrony")
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigTimezone(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"timezone": "Europe/London",
+	})
+	for _, series := range []string{"trusty", "xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), jc.Contains, "timezone: Europe/London\n")
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNTPServersUbuntuSystemd(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"ntp-servers": "ntp1.example.com, 10.0.0.1",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.Packages(), gc.Not(jc.Contains), "ntp")
+	c.Assert(cloudcfg.RunCmds(), jc.DeepEquals, []string{
+		"install -D -m 644 /dev/null '/etc/systemd/timesyncd.conf.d/99-juju.conf'",
+		"printf '%s\\n' '[Time]\nNTP=ntp1.example.com 10.0.0.1' > '/etc/systemd/timesyncd.conf.d/99-juju.conf'",
+		"systemctl restart systemd-timesyncd",
+	})
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNTPServersUbuntuUpstart(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"ntp-servers": "ntp1.example.com,10.0.0.1",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.Packages(), jc.Contains, "ntp")
+	c.Assert(cloudcfg.RunCmds(), jc.DeepEquals, []string{
+		"sed -r -i -e 's/^(server|pool) /#&/' /etc/ntp.conf && " +
+			"printf '%s\\n' 'server ntp1.example.com iburst' 'server 10.0.0.1 iburst' >> /etc/ntp.conf",
+		"service ntp restart",
+	})
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNTPServersCentOS(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"ntp-servers": "ntp1.example.com",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("centos7"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.RunCmds(), jc.DeepEquals, []string{
+		"sed -r -i -e 's/^(server|pool) /#&/' /etc/chrony.conf && " +
+			"printf '%s\\n' 'server ntp1.example.com iburst' >> /etc/chrony.conf",
+		"systemctl restart chronyd",
+	})
+}
+
+func (s *configuratorSuite) TestGetCloudConfigTimeSettingsNotSupported(c *gc.C) {
+	for _, attrs := range []testing.Attrs{
+		{"timezone": "UTC"},
+		{"ntp-servers": "10.0.0.1"},
+	} {
+		c.Logf("attrs %v", attrs)
+		cfg := testing.CustomModelConfig(c, attrs)
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+		c.Check(errors.Cause(err), jc.Satisfies, errors.IsNotSupported)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidTimezone(c *gc.C) {
+	for _, tz := range []string{"Mars/Olympus_Mons", "Local"} {
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"timezone": tz,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Check(err, gc.ErrorMatches, `timezone "`+tz+`" \(expected a tz database name\) not valid`)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidNTPServer(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"ntp-servers": "10.0.0.1,ntp_1.example.com",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, gc.ErrorMatches, `ntp-servers entry "ntp_1.example.com" \(expected a hostname or IP address\) not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigDiskLayoutSeparate(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"disk-layout": "separate",
+		"data-disks":  "/dev/xvde, /dev/xvdf",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), jc.Contains, `
+disk_setup:
+  /dev/xvde:
+    layout: true
+    overwrite: false
+    table_type: mbr
+  /dev/xvdf:
+    layout: true
+    overwrite: false
+    table_type: mbr
+`[1:])
+	c.Check(string(data), jc.Contains, `
+fs_setup:
+- device: /dev/xvde
+  filesystem: ext4
+  label: data0
+  partition: auto
+- device: /dev/xvdf
+  filesystem: ext4
+  label: data1
+  partition: auto
+`[1:])
+	c.Check(string(data), jc.Contains, `
+mounts:
+- - /dev/xvde1
+  - /mnt/data0
+  - ext4
+  - defaults,nofail
+  - "0"
+  - "2"
+- - /dev/xvdf1
+  - /mnt/data1
+  - ext4
+  - defaults,nofail
+  - "0"
+  - "2"
+`[1:])
+}
+
+func (s *configuratorSuite) TestGetCloudConfigDiskLayoutRAID0(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"disk-layout": "raid0",
+		"data-disks":  "/dev/xvde,/dev/xvdf",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(cloudcfg.BootCmds(), jc.DeepEquals, []string{
+		"[ -b /dev/md0 ] || mdadm --assemble /dev/md0 /dev/xvde /dev/xvdf || " +
+			"mdadm --create /dev/md0 --run --level=0 --raid-devices=2 /dev/xvde /dev/xvdf",
+	})
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Not(jc.Contains), "disk_setup")
+	c.Check(string(data), jc.Contains, `
+fs_setup:
+- device: /dev/md0
+  filesystem: ext4
+  label: data
+  partition: none
+`[1:])
+	c.Check(string(data), jc.Contains, `
+mounts:
+- - /dev/md0
+  - /mnt/data
+`[1:])
+}
+
+func (s *configuratorSuite) TestGetCloudConfigDiskLayoutSingleDisk(c *gc.C) {
+	for _, layout := range []string{"raid0", "separate"} {
+		c.Logf("layout %s", layout)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"disk-layout": layout,
+			"data-disks":  "/dev/xvde",
+		})
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), gc.Not(jc.Contains), "fs_setup")
+		c.Check(string(data), gc.Not(jc.Contains), "mounts")
+		c.Check(cloudcfg.BootCmds(), gc.HasLen, 0)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigDiskLayoutRAID0NotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"disk-layout": "raid0",
+		"data-disks":  "/dev/xvde,/dev/xvdf",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("centos7"), cfg)
+	c.Assert(err, gc.ErrorMatches, "cannot use disk-layout: raid0 disk layout on CentOS not supported")
+	c.Assert(errors.Cause(err), jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidDiskLayout(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"disk-layout": "raid5",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, gc.ErrorMatches, `disk-layout: expected one of \[raid0 separate\], got "raid5"`)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidDataDisk(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"disk-layout": "separate",
+		"data-disks":  "/dev/xvde,xvdf",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, gc.ErrorMatches, `data-disks entry "xvdf" \(expected a device path\) not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *configuratorSuite) TestGetAttachedVolumes(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"attach-volumes": map[string]interface{}{
+			"vol-1": "/dev/xvdc:/srv/logs",
+			"vol-0": "/dev/xvdb:/srv/data",
+		},
+	})
+	volumes, err := s.configurator.GetAttachedVolumes(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(volumes, jc.DeepEquals, []openstack.AttachedVolume{
+		{VolumeId: "vol-0", Device: "/dev/xvdb"},
+		{VolumeId: "vol-1", Device: "/dev/xvdc"},
+	})
+}
+
+func (s *configuratorSuite) TestGetAttachedVolumesUnset(c *gc.C) {
+	volumes, err := s.configurator.GetAttachedVolumes(testing.ModelConfig(c))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(volumes, gc.HasLen, 0)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigAttachVolumes(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"attach-volumes": map[string]interface{}{
+			"vol-0": "/dev/xvdb:/srv/data",
+		},
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), jc.Contains, `
+mounts:
+- - /dev/xvdb
+  - /srv/data
+  - auto
+  - defaults,nofail
+  - "0"
+  - "2"
+`[1:])
+	c.Check(cloudcfg.RunCmds(), jc.DeepEquals, []string{
+		"for i in $(seq 60); do [ -b '/dev/xvdb' ] && break; sleep 5; done; " +
+			"mountpoint -q '/srv/data' || mount '/srv/data'",
+	})
+}
+
+func (s *configuratorSuite) TestGetCloudConfigAttachVolumesNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"attach-volumes": map[string]interface{}{
+			"vol-0": "/dev/xvdb:/srv/data",
+		},
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(err, gc.ErrorMatches, "cannot use attach-volumes: mounting volumes on Windows not supported")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidAttachVolume(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"attach-volumes": map[string]interface{}{
+			"vol-0": "/srv/data",
+		},
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, gc.ErrorMatches, `attach-volumes entry "/srv/data" for volume "vol-0" \(expected device:mount-point\) not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+var (
+	publicNet  = nova.ServerNetworks{NetworkId: "00000000-0000-0000-0000-000000000000"}
+	serviceNet = nova.ServerNetworks{NetworkId: "11111111-1111-1111-1111-111111111111"}
+	userNet    = nova.ServerNetworks{NetworkId: "a1b2c3d4-0000-4000-8000-000000000000"}
+)
+
+// serverAddresses returns the addresses reported for a server
+// with the given nova addresses, scoped by the configurator.
+func (s *configuratorSuite) serverAddresses(addresses map[string][]nova.IPAddress) []network.Address {
+	var result []network.Address
+	for _, name := range []string{"public", "private"} {
+		for _, addr := range addresses[name] {
+			result = append(result, network.NewScopedAddress(addr.Address, s.configurator.GetNetworkScope(name)))
+		}
+	}
+	return result
+}
+
+var mixedFlavors = []nova.FlavorDetail{
+	{Id: "2", Name: "512MB Standard Instance", RAM: 512, VCPUs: 1},
+	{Id: "general1-1", Name: "1 GB General Purpose v1", RAM: 1024, VCPUs: 1},
+	{Id: "general1-8", Name: "8 GB General Purpose v1", RAM: 8192, VCPUs: 8},
+	{Id: "compute1-4", Name: "3.75 GB Compute v1", RAM: 3840, VCPUs: 2},
+	{Id: "memory1-15", Name: "15 GB Memory v1", RAM: 15360, VCPUs: 2},
+	{Id: "memory1-30", Name: "30 GB Memory v1", RAM: 30720, VCPUs: 4},
+	{Id: "io1-15", Name: "15 GB I/O v1", RAM: 15360, VCPUs: 4},
+	{Id: "performance1-1", Name: "1 GB Performance", RAM: 1024, VCPUs: 1},
+}
+
+func (s *configuratorSuite) TestGetFlavorsAnyClassByDefault(c *gc.C) {
+	flavors, err := s.configurator.GetFlavors(testing.ModelConfig(c), mixedFlavors)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(flavors, jc.DeepEquals, mixedFlavors)
+}
+
+func (s *configuratorSuite) TestGetFlavorsOfClass(c *gc.C) {
+	for class, expect := range map[string][]string{
+		"general": {"general1-1", "general1-8"},
+		"compute": {"compute1-4"},
+		"memory":  {"memory1-15", "memory1-30"},
+		"io":      {"io1-15"},
+	} {
+		c.Logf("class %s", class)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"flavor-class": class,
+		})
+		flavors, err := s.configurator.GetFlavors(cfg, mixedFlavors)
+		c.Assert(err, jc.ErrorIsNil)
+		var ids []string
+		for _, flavor := range flavors {
+			ids = append(ids, flavor.Id)
+		}
+		c.Check(ids, jc.DeepEquals, expect)
+	}
+}
+
+func (s *configuratorSuite) TestGetFlavorsNoneOfClass(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"flavor-class": "io",
+	})
+	_, err := s.configurator.GetFlavors(cfg, mixedFlavors[:3])
+	c.Assert(err, gc.ErrorMatches, `flavors of class "io" not found`)
+	c.Assert(err, jc.Satisfies, errors.IsNotFound)
+}
+
+func (s *configuratorSuite) TestFlavorClassInvalid(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"flavor-class": "gpu",
+	})
+	_, err := s.configurator.GetFlavors(cfg, mixedFlavors)
+	c.Assert(err, gc.ErrorMatches, `flavor-class: expected one of \[general compute memory io\], got "gpu"`)
+}
+
+func (s *configuratorSuite) TestGetFlavorsPreemptible(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"preemptible": true,
+	})
+	available := append([]nova.FlavorDetail{
+		{Id: "preemptible1-4", Name: "4 GB Preemptible v1", RAM: 4096, VCPUs: 2},
+		{Id: "preemptible1-8", Name: "8 GB Preemptible v1", RAM: 8192, VCPUs: 4},
+	}, mixedFlavors...)
+	flavors, err := s.configurator.GetFlavors(cfg, available)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(flavors, jc.DeepEquals, available[:2])
+}
+
+func (s *configuratorSuite) TestGetFlavorsPreemptibleNotOffered(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"preemptible": true,
+	})
+	_, err := s.configurator.GetFlavors(cfg, mixedFlavors)
+	c.Assert(err, gc.ErrorMatches, `preemptible capacity not offered in this region \(no flavors of class "preemptible"\)`)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *configuratorSuite) TestPreemptibleWithFlavorClassInvalid(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"preemptible":  true,
+		"flavor-class": "general",
+	})
+	_, err := s.configurator.GetFlavors(cfg, mixedFlavors)
+	c.Assert(err, gc.ErrorMatches, `preemptible with flavor-class not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigPreemptible(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"preemptible": true,
+	})
+	for _, series := range []string{"xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		cmds := cloudcfg.RunCmds()
+		c.Assert(cmds, gc.HasLen, 5)
+		c.Check(cmds[0], gc.Equals, "install -D -m 644 /dev/null '/etc/systemd/system/juju-preemption-drain.service'")
+		c.Check(cmds[1], jc.Contains, "After=network-online.target")
+		c.Check(cmds[1], jc.Contains, "ExecStop=/bin/systemctl stop ")
+		c.Check(cmds[1], jc.Contains, "jujud-*")
+		c.Check(cmds[1], jc.Contains, "TimeoutStopSec=50")
+		c.Check(cmds[2:], jc.DeepEquals, []string{
+			"systemctl daemon-reload",
+			"systemctl enable juju-preemption-drain.service",
+			"systemctl start juju-preemption-drain.service",
+		})
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNotPreemptibleByDefault(c *gc.C) {
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), testing.ModelConfig(c))
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Not(jc.Contains), "juju-preemption-drain")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigPreemptibleNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"preemptible": true,
+	})
+	for _, series := range []string{"trusty", "win2012r2"} {
+		c.Logf("series %s", series)
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Check(err, gc.ErrorMatches, `cannot use preemptible: draining preempted instances on .* not supported`)
+		c.Check(errors.Cause(err), jc.Satisfies, errors.IsNotSupported)
+	}
+}
+
+func (s *configuratorSuite) TestAllocatePublicIP(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"allocate-public-ip": true,
+	})
+	networks, err := s.configurator.GetServerNetworks(cfg, []nova.ServerNetworks{publicNet, serviceNet, userNet})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(networks, jc.DeepEquals, []nova.ServerNetworks{publicNet, serviceNet, userNet})
+
+	addrs := s.serverAddresses(map[string][]nova.IPAddress{
+		"public":  {{Version: 4, Address: "203.0.113.10"}},
+		"private": {{Version: 4, Address: "10.176.0.10"}},
+	})
+	c.Assert(addrs, jc.DeepEquals, []network.Address{
+		network.NewScopedAddress("203.0.113.10", network.ScopePublic),
+		network.NewScopedAddress("10.176.0.10", network.ScopeCloudLocal),
+	})
+	public, ok := network.SelectPublicAddress(addrs)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(public.Value, gc.Equals, "203.0.113.10")
+	internal, ok := network.SelectInternalAddress(addrs, false)
+	c.Assert(ok, jc.IsTrue)
+	c.Assert(internal.Value, gc.Equals, "10.176.0.10")
+}
+
+func (s *configuratorSuite) TestAllocatePublicIPByDefault(c *gc.C) {
+	networks, err := s.configurator.GetServerNetworks(testing.ModelConfig(c), []nova.ServerNetworks{publicNet, serviceNet})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(networks, jc.DeepEquals, []nova.ServerNetworks{publicNet, serviceNet})
+}
+
+func (s *configuratorSuite) TestNoAllocatePublicIP(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"allocate-public-ip": false,
+	})
+	networks, err := s.configurator.GetServerNetworks(cfg, []nova.ServerNetworks{publicNet, serviceNet, userNet})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(networks, jc.DeepEquals, []nova.ServerNetworks{serviceNet, userNet})
+
+	// A server attached to ServiceNet only reports no public
+	// addresses, and its ServiceNet address is not mistaken
+	// for a public one.
+	addrs := s.serverAddresses(map[string][]nova.IPAddress{
+		"private": {{Version: 4, Address: "10.176.0.10"}},
+	})
+	c.Assert(addrs, jc.DeepEquals, []network.Address{
+		network.NewScopedAddress("10.176.0.10", network.ScopeCloudLocal),
+	})
+	for _, addr := range addrs {
+		c.Check(addr.Scope, gc.Not(gc.Equals), network.ScopePublic)
+	}
+}
+
+func (s *configuratorSuite) TestNoAllocatePublicIPNetworkConfig(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"allocate-public-ip":     false,
+		"network-config-version": 2,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	expected, err := rackspace.RenderNetworkConfig(2, false, nil)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(expected, gc.Equals, `
+network:
+  version: 2
+  ethernets:
+    eth0:
+      dhcp4: true
+`[1:])
+	c.Assert(strings.Join(cloudcfg.BootCmds(), "\n"), jc.Contains, expected)
+}
+
+func (s *configuratorSuite) TestGetNetworkScope(c *gc.C) {
+	c.Assert(s.configurator.GetNetworkScope("public"), gc.Equals, network.ScopePublic)
+	c.Assert(s.configurator.GetNetworkScope("private"), gc.Equals, network.ScopeCloudLocal)
+	c.Assert(s.configurator.GetNetworkScope("other"), gc.Equals, network.ScopeUnknown)
+}
+
+func (s *configuratorSuite) TestGetNetworkSpace(c *gc.C) {
+	c.Assert(s.configurator.GetNetworkSpace("DFW", "public"), gc.Equals, network.SpaceName("dfw-publicnet"))
+	c.Assert(s.configurator.GetNetworkSpace("DFW", "private"), gc.Equals, network.SpaceName("dfw-servicenet"))
+	c.Assert(s.configurator.GetNetworkSpace("LON", "private"), gc.Equals, network.SpaceName("lon-servicenet"))
+	c.Assert(s.configurator.GetNetworkSpace("DFW", "other"), gc.Equals, network.SpaceName(""))
+	c.Assert(s.configurator.GetNetworkSpace("", "private"), gc.Equals, network.SpaceName(""))
+}
+
+func (s *configuratorSuite) TestInstanceAddressesAnnotatedWithRegion(c *gc.C) {
+	var addresses []network.Address
+	for name, value := range map[string]string{
+		"public":  "203.0.113.10",
+		"private": "10.176.1.10",
+		"other":   "192.168.0.10",
+	} {
+		addr := network.NewScopedAddress(value, s.configurator.GetNetworkScope(name))
+		addr.SpaceName = s.configurator.GetNetworkSpace("IAD", name)
+		addresses = append(addresses, addr)
+	}
+	c.Assert(addresses, jc.SameContents, []network.Address{{
+		Value:     "203.0.113.10",
+		Type:      network.IPv4Address,
+		Scope:     network.ScopePublic,
+		SpaceName: "iad-publicnet",
+	}, {
+		Value:     "10.176.1.10",
+		Type:      network.IPv4Address,
+		Scope:     network.ScopeCloudLocal,
+		SpaceName: "iad-servicenet",
+	}, {
+		Value: "192.168.0.10",
+		Type:  network.IPv4Address,
+		Scope: network.ScopeUnknown,
+	}})
+	for _, addr := range addresses {
+		if addr.SpaceName != "" {
+			c.Check(names.IsValidSpace(string(addr.SpaceName)), jc.IsTrue)
+		}
+	}
+}
+
+const monitoringToken = "0123456789abcdef.12345"
+
+func (s *configuratorSuite) TestGetCloudConfigMonitoringAgent(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"monitoring-agent-token": monitoringToken,
+	})
+	for _, series := range []string{"xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(cloudcfg.Packages(), jc.Contains, "rackspace-monitoring-agent")
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), jc.Contains, `
+write_files:
+- content: |
+    monitoring_token 0123456789abcdef.12345
+  owner: root:root
+  path: /etc/rackspace-monitoring-agent.cfg
+  permissions: "0600"
+`[1:])
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoMonitoringAgentByDefault(c *gc.C) {
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), testing.ModelConfig(c))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.Packages(), gc.Not(jc.Contains), "rackspace-monitoring-agent")
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Not(jc.Contains), "write_files")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigMonitoringAgentWindows(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"monitoring-agent-token": monitoringToken,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.Packages(), gc.Not(jc.Contains), "rackspace-monitoring-agent")
+	c.Assert(c.GetTestLog(), jc.Contains, `not installing monitoring agent: not available for series "win2012r2"`)
+	c.Assert(c.GetTestLog(), gc.Not(jc.Contains), monitoringToken)
+}
+
+func (s *configuratorSuite) TestGetUserDataRendererRedactsMonitoringToken(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"compress-user-data":     false,
+		"monitoring-agent-token": monitoringToken,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	renderer, err := s.configurator.GetUserDataRenderer(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := renderer.Render(cloudcfg, jujuos.Ubuntu)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), jc.Contains, "monitoring_token "+monitoringToken)
+
+	redacter, ok := renderer.(renderers.Redacter)
+	c.Assert(ok, jc.IsTrue)
+	redacted := string(redacter.Redact(data))
+	c.Assert(redacted, gc.Not(jc.Contains), monitoringToken)
+	c.Assert(redacted, jc.Contains, "monitoring_token <redacted>")
+	// The rendered user data itself is left untouched.
+	c.Assert(string(data), jc.Contains, monitoringToken)
+	c.Assert(c.GetTestLog(), gc.Not(jc.Contains), monitoringToken)
+}
+
+func (s *configuratorSuite) modifiedCloudConfig(c *gc.C, series, swapSize string, instType instances.InstanceType) cloudinit.CloudConfig {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"swap-size": swapSize,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.configurator.ModifyCloudConfig(cloudcfg, cfg, instType)
+	c.Assert(err, jc.ErrorIsNil)
+	return cloudcfg
+}
+
+func (s *configuratorSuite) TestModifyCloudConfigSwapSize(c *gc.C) {
+	instType := instances.InstanceType{Name: "general1-8", Mem: 8192, RootDisk: 163840}
+	for _, series := range []string{"xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg := s.modifiedCloudConfig(c, series, "4G", instType)
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), jc.Contains, `
+swap:
+  filename: /swap.img
+  maxsize: 4294967296
+  size: 4294967296
+`[1:])
+	}
+}
+
+func (s *configuratorSuite) TestModifyCloudConfigSwapSizeRAMMultiple(c *gc.C) {
+	instType := instances.InstanceType{Name: "general1-1", Mem: 1024, RootDisk: 20480}
+	cloudcfg := s.modifiedCloudConfig(c, "xenial", "2xRAM", instType)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), jc.Contains, `
+swap:
+  filename: /swap.img
+  maxsize: 2147483648
+  size: 2147483648
+`[1:])
+}
+
+func (s *configuratorSuite) TestModifyCloudConfigSwapSizeClampedToRootDisk(c *gc.C) {
+	instType := instances.InstanceType{Name: "memory1-15", Mem: 15360, RootDisk: 20480}
+	cloudcfg := s.modifiedCloudConfig(c, "xenial", "2xRAM", instType)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), jc.Contains, `
+swap:
+  filename: /swap.img
+  maxsize: 10737418240
+  size: 10737418240
+`[1:])
+	c.Assert(c.GetTestLog(), jc.Contains, `swap-size of 30720M is too large for the 20480M root disk of flavor "memory1-15"; using 10240M`)
+}
+
+func (s *configuratorSuite) TestModifyCloudConfigNoSwapByDefault(c *gc.C) {
+	cfg := testing.ModelConfig(c)
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.configurator.ModifyCloudConfig(cloudcfg, cfg, instances.InstanceType{Mem: 1024, RootDisk: 20480})
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Not(jc.Contains), "swap:")
+}
+
+func (s *configuratorSuite) TestModifyCloudConfigSwapSizeNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"swap-size": "2G",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.configurator.ModifyCloudConfig(cloudcfg, cfg, instances.InstanceType{Mem: 1024, RootDisk: 20480})
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, "cannot use swap-size: creating a swap file on Windows not supported")
+}
+
+func (s *configuratorSuite) TestModifyCloudConfigPersistentLocalStorage(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"local-storage-persistence": "persistent",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.configurator.ModifyCloudConfig(cloudcfg, cfg, instances.InstanceType{Name: "general1-8", Mem: 8192, RootDisk: 163840})
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *configuratorSuite) TestModifyCloudConfigPersistentLocalStorageNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"local-storage-persistence": "persistent",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	err = s.configurator.ModifyCloudConfig(cloudcfg, cfg, instances.InstanceType{Name: "compute1-4", Mem: 3840})
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, `local-storage-persistence "persistent" not supported by flavor "compute1-4": it has no local disk`)
+}
+
+func (s *configuratorSuite) TestModifyCloudConfigEphemeralLocalStorage(c *gc.C) {
+	for _, persistence := range []string{"", "ephemeral"} {
+		c.Logf("local-storage-persistence %q", persistence)
+		attrs := testing.Attrs{}
+		if persistence != "" {
+			attrs["local-storage-persistence"] = persistence
+		}
+		cfg := testing.CustomModelConfig(c, attrs)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		err = s.configurator.ModifyCloudConfig(cloudcfg, cfg, instances.InstanceType{Name: "compute1-4", Mem: 3840})
+		c.Assert(err, jc.ErrorIsNil)
+	}
+}
+
+func (s *configuratorSuite) TestInvalidLocalStoragePersistence(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"local-storage-persistence": "forever",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, gc.ErrorMatches, `local-storage-persistence: expected one of \[ephemeral persistent\], got "forever"`)
+}
+
+func (s *configuratorSuite) TestInvalidSwapSize(c *gc.C) {
+	for _, size := range []string{"lots", "0", "-1G", "xRAM", "0xRAM", "-2xRAM", "NaNxRAM", "twoxRAM"} {
+		c.Logf("swap-size %q", size)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"swap-size": size,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+		c.Check(err, gc.ErrorMatches, `swap-size ".*" \(expected a positive .*\) not valid`)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigManagedAccount(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"rackspace-account-type": "managed",
+		"ntp-servers":            "ntp.example.com",
+	})
+	for _, series := range []string{"xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		runcmds := cloudcfg.RunCmds()
+		c.Assert(runcmds, gc.Not(gc.HasLen), 0)
+		c.Check(runcmds[0], gc.Equals, `timeout 1800 sh -c 'until [ -e /tmp/rs_managed_cloud_automation_complete ]; do sleep 10; done' || echo 'timed out waiting for Rackspace managed cloud automation' >&2`)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigUnmanagedAccount(c *gc.C) {
+	for _, cfg := range []*config.Config{
+		testing.ModelConfig(c),
+		testing.CustomModelConfig(c, testing.Attrs{
+			"rackspace-account-type": "unmanaged",
+		}),
+	} {
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(strings.Join(cloudcfg.RunCmds(), "\n"), gc.Not(jc.Contains), "rs_managed_cloud_automation_complete")
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigManagedAccountNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"rackspace-account-type": "managed",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, "cannot use rackspace-account-type: waiting for managed cloud automation on Windows not supported")
+}
+
+func (s *configuratorSuite) TestInvalidAccountType(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"rackspace-account-type": "dedicated",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, gc.ErrorMatches, `rackspace-account-type: expected one of \[managed unmanaged\], got "dedicated"`)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigPhoneHome(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"phone-home-url": "https://orchestrator.example.com/booted/$INSTANCE_ID",
+	})
+	for _, series := range []string{"xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), jc.Contains, `
+phone_home:
+  post:
+  - instance_id
+  - hostname
+  - fqdn
+  tries: 10
+  url: https://orchestrator.example.com/booted/$INSTANCE_ID
+`[1:])
+		c.Check(string(data), jc.Contains, `
+final_message: cloud-init finished at $TIMESTAMP, after $UPTIME seconds; phoned home
+`[1:])
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoPhoneHomeByDefault(c *gc.C) {
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), testing.ModelConfig(c))
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Not(jc.Contains), "phone_home")
+	c.Assert(cloudcfg.FinalMessage(), gc.Equals, "")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigPhoneHomeNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"phone-home-url": "https://orchestrator.example.com/booted",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, "cannot use phone-home-url: phoning home from Windows not supported")
+}
+
+func (s *configuratorSuite) TestInvalidPhoneHomeURL(c *gc.C) {
+	for _, phoneHome := range []string{"orchestrator.example.com", "ftp://orchestrator.example.com/", "https://", "%zz"} {
+		c.Logf("phone-home-url %q", phoneHome)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"phone-home-url": phoneHome,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+		c.Check(err, gc.ErrorMatches, `phone-home-url ".*" \(expected an http or https URL\) not valid`)
+	}
+}
+
+var proxyAttrs = testing.Attrs{
+	"http-proxy":  "http://proxy.example.com:3128",
+	"https-proxy": "http://proxy.example.com:3129",
+	"no-proxy":    "localhost,10.176.0.0/12",
+}
+
+const proxyEnvironmentCmd = "printf '%s\\n' " +
+	"'http_proxy=http://proxy.example.com:3128' 'HTTP_PROXY=http://proxy.example.com:3128' " +
+	"'https_proxy=http://proxy.example.com:3129' 'HTTPS_PROXY=http://proxy.example.com:3129' " +
+	"'no_proxy=localhost,10.176.0.0/12,10.208.0.0/12' 'NO_PROXY=localhost,10.176.0.0/12,10.208.0.0/12' " +
+	">> /etc/environment"
+
+func (s *configuratorSuite) TestGetCloudConfigProxySettings(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, proxyAttrs)
+	for _, series := range []string{"xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(cloudcfg.PackageProxy(), gc.Equals, "http://proxy.example.com:3128")
+		c.Check(cloudcfg.RunCmds(), jc.DeepEquals, []string{
+			proxyEnvironmentCmd,
+			"install -D -m 644 /dev/null '/etc/systemd/system.conf.d/99-juju-proxy.conf'",
+			"printf '%s\\n' '[Manager]\nDefaultEnvironment=" +
+				`"http_proxy=http://proxy.example.com:3128" "HTTP_PROXY=http://proxy.example.com:3128" ` +
+				`"https_proxy=http://proxy.example.com:3129" "HTTPS_PROXY=http://proxy.example.com:3129" ` +
+				`"no_proxy=localhost,10.176.0.0/12,10.208.0.0/12" "NO_PROXY=localhost,10.176.0.0/12,10.208.0.0/12"` +
+				"' > '/etc/systemd/system.conf.d/99-juju-proxy.conf'",
+			"systemctl daemon-reexec",
+		})
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigProxySettingsUpstart(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, proxyAttrs)
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("trusty"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.PackageProxy(), gc.Equals, "http://proxy.example.com:3128")
+	c.Assert(cloudcfg.RunCmds(), jc.DeepEquals, []string{proxyEnvironmentCmd})
+}
+
+func (s *configuratorSuite) TestGetCloudConfigAptProxyOnly(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"apt-http-proxy": "proxy.example.com:3142",
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.PackageProxy(), gc.Equals, "http://proxy.example.com:3142")
+	c.Assert(cloudcfg.RunCmds(), gc.HasLen, 0)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoProxySettingsByDefault(c *gc.C) {
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), testing.ModelConfig(c))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.PackageProxy(), gc.Equals, "")
+	c.Assert(cloudcfg.RunCmds(), gc.HasLen, 0)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigProxySettingsNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, proxyAttrs)
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(err, jc.Satisfies, errors.IsNotSupported)
+	c.Assert(err, gc.ErrorMatches, "cannot use proxy settings: configuring proxy settings on Windows not supported")
+}
+
+func (s *configuratorSuite) TestGetCloudConfigPackageUpdateAndUpgrade(c *gc.C) {
+	for _, test := range []struct {
+		update, upgrade bool
+	}{
+		{false, false},
+		{false, true},
+		{true, false},
+		{true, true},
+	} {
+		c.Logf("package-update %v, package-upgrade %v", test.update, test.upgrade)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"package-update":     test.update,
+			"package-upgrade":    test.upgrade,
+			"compress-user-data": false,
+		})
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(cloudcfg.SystemUpdate(), gc.Equals, test.update)
+		c.Check(cloudcfg.SystemUpgrade(), gc.Equals, test.upgrade)
+
+		// Composing the user data sets the flags from the
+		// model's enable-os-refresh-update and enable-os-upgrade
+		// settings, which rendering must override.
+		cloudcfg.SetSystemUpdate(!test.update)
+		cloudcfg.SetSystemUpgrade(!test.upgrade)
+		renderer, err := s.configurator.GetUserDataRenderer(cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		data, err := renderer.Render(cloudcfg, jujuos.Ubuntu)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), jc.Contains, fmt.Sprintf("package_update: %v\n", test.update))
+		c.Check(string(data), jc.Contains, fmt.Sprintf("package_upgrade: %v\n", test.upgrade))
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigPackageUpdateAndUpgradeLeftToJuju(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"compress-user-data": false,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("centos7"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(string(data), gc.Not(jc.Contains), "package_update")
+	c.Assert(string(data), gc.Not(jc.Contains), "package_upgrade")
+
+	cloudcfg.SetSystemUpdate(true)
+	cloudcfg.SetSystemUpgrade(false)
+	renderer, err := s.configurator.GetUserDataRenderer(cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = renderer.Render(cloudcfg, jujuos.CentOS)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(cloudcfg.SystemUpdate(), jc.IsTrue)
+	c.Assert(cloudcfg.SystemUpgrade(), jc.IsFalse)
+}
+
+// imageFormats returns a function that reports
+// the given formats for the image with id "image-0".
+func imageFormats(c *gc.C, diskFormat, containerFormat string) func(string) (openstack.ImageFormats, error) {
+	return func(imageId string) (openstack.ImageFormats, error) {
+		c.Check(imageId, gc.Equals, "image-0")
+		return openstack.ImageFormats{
+			DiskFormat:      diskFormat,
+			ContainerFormat: containerFormat,
+		}, nil
+	}
+}
+
+func (s *configuratorSuite) TestValidateImage(c *gc.C) {
+	for i, test := range []struct {
+		diskFormat, containerFormat string
+		expect                      string
+	}{{
+		diskFormat:      "vhd",
+		containerFormat: "ovf",
+	}, {
+		diskFormat:      "vhd",
+		containerFormat: "bare",
+	}, {
+		// Formats that are not recorded are not checked.
+	}, {
+		diskFormat: "qcow2",
+		expect:     `disk format "qcow2" of image "image-0" \(expected one of \[vhd\]; set allow-unsupported-image-formats to true to use it anyway\) not supported`,
+	}, {
+		diskFormat:      "vhd",
+		containerFormat: "ova",
+		expect:          `container format "ova" of image "image-0" \(expected one of \[bare ovf\]; set allow-unsupported-image-formats to true to use it anyway\) not supported`,
+	}} {
+		c.Logf("test %d: %q, %q", i, test.diskFormat, test.containerFormat)
+		err := s.configurator.ValidateImage(testing.ModelConfig(c), "image-0", imageFormats(c, test.diskFormat, test.containerFormat))
+		if test.expect == "" {
+			c.Check(err, jc.ErrorIsNil)
+		} else {
+			c.Check(err, jc.Satisfies, errors.IsNotSupported)
+			c.Check(err, gc.ErrorMatches, test.expect)
+		}
+	}
+}
+
+func (s *configuratorSuite) TestValidateImageAllowUnsupportedFormats(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"allow-unsupported-image-formats": true,
+	})
+	err := s.configurator.ValidateImage(cfg, "image-0", imageFormats(c, "qcow2", "bare"))
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(c.GetTestLog(), jc.Contains, `disk format "qcow2" of image "image-0"`)
+}
+
+func (s *configuratorSuite) TestValidateImageFormatsUnavailable(c *gc.C) {
+	err := s.configurator.ValidateImage(testing.ModelConfig(c), "image-0", func(string) (openstack.ImageFormats, error) {
+		return openstack.ImageFormats{}, errors.New("no image service")
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(c.GetTestLog(), jc.Contains, `not checking the formats of image "image-0": no image service`)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigTrustedCACertsUbuntu(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"trusted-ca-certs": testing.CACert + "\n" + testing.OtherCACert,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	var rendered struct {
+		CACerts map[string][]string `yaml:"ca-certs"`
+	}
+	c.Assert(goyaml.Unmarshal(data, &rendered), jc.ErrorIsNil)
+	c.Assert(rendered.CACerts, jc.DeepEquals, map[string][]string{
+		"trusted": {testing.CACert, testing.OtherCACert},
+	})
+	c.Assert(cloudcfg.BootCmds(), gc.HasLen, 0)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigTrustedCACertsCentOS(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"trusted-ca-certs": testing.CACert + testing.OtherCACert,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("centos7"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	data, err := cloudcfg.RenderYAML()
+	c.Assert(err, jc.ErrorIsNil)
+	c.Check(string(data), gc.Not(jc.Contains), "ca-certs")
+	bootCmds := cloudcfg.BootCmds()
+	c.Assert(bootCmds, gc.Not(gc.HasLen), 0)
+	c.Check(bootCmds[len(bootCmds)-1], gc.Equals, "update-ca-trust extract")
+	joined := strings.Join(bootCmds, "\n")
+	for i, cert := range []string{testing.CACert, testing.OtherCACert} {
+		path := fmt.Sprintf("/etc/pki/ca-trust/source/anchors/juju-trusted-ca-%d.crt", i)
+		c.Check(joined, jc.Contains, "install -D -m 644 /dev/null '"+path+"'")
+		c.Check(joined, jc.Contains, "printf '%s\\n' '"+strings.TrimSuffix(cert, "\n")+"' > '"+path+"'")
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoTrustedCACertsByDefault(c *gc.C) {
+	cfg := testing.ModelConfig(c)
+	for _, series := range []string{"xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		data, err := cloudcfg.RenderYAML()
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(string(data), gc.Not(jc.Contains), "ca-certs")
+		c.Check(string(data), gc.Not(jc.Contains), "update-ca-trust")
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigTrustedCACertsNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"trusted-ca-certs": testing.CACert,
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(errors.Cause(err), jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidTrustedCACerts(c *gc.C) {
+	for i, test := range []struct {
+		value string
+		err   string
+	}{{
+		value: "not a certificate",
+		err:   `trusted-ca-certs \(expected only PEM-encoded certificates\) not valid`,
+	}, {
+		value: testing.CACert + "trailing junk",
+		err:   `trusted-ca-certs \(expected only PEM-encoded certificates\) not valid`,
+	}, {
+		value: testing.CACert + testing.CAKey,
+		err:   `trusted-ca-certs entry 2 \(expected a CERTIFICATE block, got RSA PRIVATE KEY\) not valid`,
+	}, {
+		value: "-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydGlmaWNhdGU=\n-----END CERTIFICATE-----\n",
+		err:   `trusted-ca-certs entry 1: .*`,
+	}} {
+		c.Logf("test %d", i)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"trusted-ca-certs": test.value,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Check(err, gc.ErrorMatches, test.err)
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigBootCommands(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"bootcmd":          "ip link set dev eth0 mtu 1400\necho 'network: {config: disabled}' > /etc/cloud/cloud.cfg.d/99-disable-network-config.cfg\n",
+		"trusted-ca-certs": testing.CACert,
+	})
+	cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams("centos7"), cfg)
+	c.Assert(err, jc.ErrorIsNil)
+	bootCmds := cloudcfg.BootCmds()
+	c.Assert(len(bootCmds), jc.GreaterThan, 2)
+	c.Assert(bootCmds[:2], jc.DeepEquals, []string{
+		"ip link set dev eth0 mtu 1400",
+		"echo 'network: {config: disabled}' > /etc/cloud/cloud.cfg.d/99-disable-network-config.cfg",
+	})
+	for _, cmd := range cloudcfg.RunCmds() {
+		c.Check(cmd, gc.Not(jc.Contains), "mtu 1400")
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigNoBootCommandsByDefault(c *gc.C) {
+	cfg := testing.ModelConfig(c)
+	for _, series := range []string{"trusty", "xenial", "centos7"} {
+		c.Logf("series %s", series)
+		cloudcfg, err := s.configurator.GetCloudConfig(s.startInstanceParams(series), cfg)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Check(cloudcfg.BootCmds(), gc.HasLen, 0)
+	}
+}
+
+func (s *configuratorSuite) TestGetCloudConfigBootCommandsNotSupported(c *gc.C) {
+	cfg := testing.CustomModelConfig(c, testing.Attrs{
+		"bootcmd": "echo hello",
+	})
+	_, err := s.configurator.GetCloudConfig(s.startInstanceParams("win2012r2"), cfg)
+	c.Assert(errors.Cause(err), jc.Satisfies, errors.IsNotSupported)
+}
+
+func (s *configuratorSuite) TestGetCloudConfigInvalidBootCommands(c *gc.C) {
+	for _, value := range []string{" ", "echo one\n\necho two", "echo one\n  \n"} {
+		c.Logf("bootcmd %q", value)
+		cfg := testing.CustomModelConfig(c, testing.Attrs{
+			"bootcmd": value,
+		})
+		_, err := s.configurator.GetCloudConfig(s.startInstanceParams("xenial"), cfg)
+		c.Check(err, gc.ErrorMatches, `bootcmd line \d \(expected a command\) not valid`)
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+	}
+}
@@ -5,6 +5,7 @@ package rackspace_test
 
 import (
 	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
 	"github.com/juju/errors"
@@ -41,6 +42,21 @@ func (s *providerSuite) TestValidate(c *gc.C) {
 	s.innerProvider.CheckCallNames(c, "Validate")
 }
 
+func (s *providerSuite) TestValidateSetsDefaults(c *gc.C) {
+	cfg, err := config.New(config.UseDefaults, map[string]interface{}{
+		"name":            "some-name",
+		"type":            "some-type",
+		"uuid":            coretesting.ModelTag.Id(),
+		"controller-uuid": coretesting.ControllerTag.Id(),
+		"authorized-keys": "key",
+	})
+	c.Assert(err, gc.IsNil)
+	valid, err := s.provider.Validate(cfg, nil)
+	c.Assert(err, gc.IsNil)
+	c.Check(valid.UnknownAttrs()["manage-iptables-persistence"], gc.Equals, true)
+	c.Check(valid.UnknownAttrs()["compress-user-data"], gc.Equals, true)
+}
+
 func (s *providerSuite) TestPrepareConfig(c *gc.C) {
 	args := environs.PrepareConfigParams{
 		Cloud: environs.CloudSpec{
@@ -56,6 +72,47 @@ func (s *providerSuite) TestPrepareConfig(c *gc.C) {
 	})
 }
 
+func (s *providerSuite) TestPrepareConfigRegionCaseInsensitive(c *gc.C) {
+	for _, region := range []string{"lon", "Lon", "LON"} {
+		c.Logf("region %s", region)
+		s.innerProvider.ResetCalls()
+		args := environs.PrepareConfigParams{
+			Cloud: environs.CloudSpec{
+				Region: region,
+			},
+		}
+		_, err := s.provider.PrepareConfig(args)
+		c.Assert(err, jc.ErrorIsNil)
+
+		expect := args
+		expect.Cloud.Region = "LON"
+		s.innerProvider.CheckCalls(c, []testing.StubCall{
+			{"PrepareConfig", []interface{}{expect}},
+		})
+	}
+}
+
+func (s *providerSuite) TestPrepareConfigInvalidRegion(c *gc.C) {
+	_, err := s.provider.PrepareConfig(environs.PrepareConfigParams{
+		Cloud: environs.CloudSpec{
+			Region: "dallas",
+		},
+	})
+	c.Assert(err, gc.ErrorMatches, `region "DALLAS" \(expected one of DFW, ORD, IAD, LON, SYD, HKG\) not valid`)
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	s.innerProvider.CheckNoCalls(c)
+}
+
+func (s *providerSuite) TestOpenInvalidRegion(c *gc.C) {
+	_, err := s.provider.Open(environs.OpenParams{
+		Cloud: environs.CloudSpec{
+			Region: "dfw1",
+		},
+	})
+	c.Assert(err, jc.Satisfies, errors.IsNotValid)
+	s.innerProvider.CheckNoCalls(c)
+}
+
 type fakeProvider struct {
 	testing.Stub
 }
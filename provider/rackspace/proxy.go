@@ -0,0 +1,107 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/proxy"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/service"
+)
+
+// systemdProxyConfigFile is where the proxy settings are configured
+// for the services of new instances using systemd.
+const systemdProxyConfigFile = "/etc/systemd/system.conf.d/99-juju-proxy.conf"
+
+// serviceNetRanges holds the address ranges of ServiceNet, see:
+// https://support.rackspace.com/how-to/updating-the-routing-table-on-rackspace-cloud-servers/
+// Traffic to them never leaves the region, so never goes by proxy.
+var serviceNetRanges = []string{"10.176.0.0/12", "10.208.0.0/12"}
+
+// withServiceNetNoProxy returns the given proxy settings with the
+// ServiceNet ranges added to those that are not proxied. Not every
+// program honours ranges in no_proxy, but those that do then reach
+// ServiceNet directly.
+func withServiceNetNoProxy(settings proxy.Settings) proxy.Settings {
+	var noProxy []string
+	if settings.NoProxy != "" {
+		noProxy = strings.Split(settings.NoProxy, ",")
+	}
+	for _, r := range serviceNetRanges {
+		found := false
+		for _, entry := range noProxy {
+			if strings.TrimSpace(entry) == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			noProxy = append(noProxy, r)
+		}
+	}
+	settings.NoProxy = strings.Join(noProxy, ",")
+	return settings
+}
+
+// addProxySettings configures the instance with the given cloud
+// config to use the given proxy settings, less ServiceNet, for logins
+// and services through /etc/environment, for services again through
+// a systemd drop-in on releases using systemd, and to fetch packages
+// using the given package proxy settings.
+//
+// Juju only exports the proxy settings for its own scripts and the
+// ubuntu user, and only configures the package proxy for apt, so
+// without these the services of new instances, and yum on CentOS, do
+// not use the proxy. Juju configures apt with the same settings when
+// the user data is composed, in addition to the apt_proxy set here.
+//
+// These are runcmds, so they are run once, before those with which
+// Juju sets up its agent, which is then started with the settings.
+func addProxySettings(cloudcfg cloudinit.CloudConfig, settings, packageSettings proxy.Settings) error {
+	ser := cloudcfg.GetSeries()
+	os, err := series.GetOSFromSeries(ser)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu, jujuos.CentOS:
+	default:
+		return errors.NotSupportedf("configuring proxy settings on %s", os)
+	}
+	if packageSettings.Http != "" {
+		cloudcfg.SetPackageProxy(packageSettings.Http)
+	}
+	if (settings == proxy.Settings{}) {
+		return nil
+	}
+	settings = withServiceNetNoProxy(settings)
+	values := settings.AsEnvironmentValues()
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = utils.ShQuote(v)
+	}
+	cloudcfg.AddRunCmd(fmt.Sprintf(`printf '%%s\n' %s >> /etc/environment`, strings.Join(quoted, " ")))
+
+	initSystem, err := service.VersionInitSystem(ser)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if initSystem == service.InitSystemSystemd {
+		assignments := make([]string, len(values))
+		for i, v := range values {
+			assignments[i] = `"` + v + `"`
+		}
+		cloudcfg.AddRunTextFile(systemdProxyConfigFile, "[Manager]\nDefaultEnvironment="+strings.Join(assignments, " "), 0644)
+		// systemd reads its own configuration only when executed.
+		cloudcfg.AddRunCmd("systemctl daemon-reexec")
+	}
+	return nil
+}
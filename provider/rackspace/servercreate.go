@@ -0,0 +1,138 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"gopkg.in/goose.v1/client"
+	goosehttp "gopkg.in/goose.v1/http"
+)
+
+// serversAPICall is the compute API call that creates servers.
+const serversAPICall = "servers"
+
+// serverCreateOptions holds the fields of the requests to create
+// servers that the compute client cannot set itself.
+type serverCreateOptions struct {
+	// personality holds the files to inject into
+	// new servers, keyed by path.
+	personality map[string]string
+
+	// schedulerHints holds the scheduler hints
+	// to pass, keyed by hint.
+	schedulerHints map[string]string
+
+	// keyName holds the name of the keypair
+	// whose key new servers are given.
+	keyName string
+}
+
+// newServerCreateOptions returns the server create
+// options of the given model config.
+func newServerCreateOptions(ecfg *environConfig) serverCreateOptions {
+	opts := serverCreateOptions{
+		personality:    ecfg.injectedFiles(),
+		schedulerHints: ecfg.schedulerHints(),
+	}
+	if ecfg.sshKeyMode() != sshKeyModeJujuOnly {
+		opts.keyName = ecfg.keypairName()
+	}
+	return opts
+}
+
+// empty reports whether the options leave
+// server create requests unchanged.
+func (opts serverCreateOptions) empty() bool {
+	return len(opts.personality) == 0 && len(opts.schedulerHints) == 0 && opts.keyName == ""
+}
+
+// personalityFile is an entry of the personality of a new server.
+type personalityFile struct {
+	Path     string `json:"path"`
+	Contents string `json:"contents"`
+}
+
+// serverCreateClient is a client that adds the given options to the
+// requests it sends to create servers. Other requests are sent by the
+// client it holds as usual.
+type serverCreateClient struct {
+	client.AuthenticatingClient
+	opts serverCreateOptions
+}
+
+// SendRequest implements client.Client.SendRequest.
+func (c *serverCreateClient) SendRequest(method, svcType, apiCall string, requestData *goosehttp.RequestData) error {
+	if method != client.POST || svcType != computeServiceType || apiCall != serversAPICall || requestData.ReqValue == nil {
+		return c.AuthenticatingClient.SendRequest(method, svcType, apiCall, requestData)
+	}
+	body, err := c.opts.apply(requestData.ReqValue)
+	if err != nil {
+		return errors.Annotate(err, "cannot add server create options")
+	}
+	// The request data belongs to the caller, so
+	// its request value is replaced in a copy.
+	withBody := *requestData
+	withBody.ReqValue = body
+	err = c.AuthenticatingClient.SendRequest(method, svcType, apiCall, &withBody)
+	requestData.RespReader = withBody.RespReader
+	requestData.RespHeaders = withBody.RespHeaders
+	return err
+}
+
+// apply returns the body of the given server create request,
+// with the options added.
+func (opts serverCreateOptions) apply(req interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, errors.Trace(err)
+	}
+	server, ok := body["server"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("no server in request")
+	}
+	if len(opts.personality) > 0 {
+		paths := make([]string, 0, len(opts.personality))
+		for path := range opts.personality {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		files := make([]personalityFile, len(paths))
+		for i, path := range paths {
+			files[i] = personalityFile{
+				Path:     path,
+				Contents: base64.StdEncoding.EncodeToString([]byte(opts.personality[path])),
+			}
+		}
+		server["personality"] = files
+	}
+	if opts.keyName != "" {
+		server["key_name"] = opts.keyName
+	}
+	if len(opts.schedulerHints) > 0 {
+		hints := make(map[string]interface{}, len(opts.schedulerHints))
+		for hint, value := range opts.schedulerHints {
+			switch hint {
+			case "different_host", "same_host":
+				ids := strings.Split(value, ",")
+				for i, id := range ids {
+					ids[i] = strings.TrimSpace(id)
+				}
+				hints[hint] = ids
+			default:
+				hints[hint] = value
+			}
+		}
+		body["os:scheduler_hints"] = hints
+	}
+	return body, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/environs/instances"
+)
+
+// swapFile is where the swap file of new instances is created.
+const swapFile = "/swap.img"
+
+// ramSuffix ends a swap size that is a multiple of the
+// memory of an instance, such as 2xRAM.
+const ramSuffix = "xram"
+
+// swapSize holds the size of the swap file of new instances, either
+// absolute or as a multiple of their memory. Exactly one of the
+// fields is non-zero.
+type swapSize struct {
+	// mb holds the absolute size, in megabytes.
+	mb uint64
+
+	// ramMultiple holds the size as a multiple of the memory.
+	ramMultiple float64
+}
+
+// parseSwapSize parses the value of the swap-size attribute, either
+// an absolute size, such as 4096 or 4G, or a multiple of the memory
+// of an instance, such as 2xRAM.
+func parseSwapSize(size string) (swapSize, error) {
+	if strings.HasSuffix(strings.ToLower(size), ramSuffix) {
+		multiple, err := strconv.ParseFloat(size[:len(size)-len(ramSuffix)], 64)
+		if err != nil || math.IsNaN(multiple) || math.IsInf(multiple, 0) || multiple <= 0 {
+			return swapSize{}, errors.NotValidf("%s %q (expected a positive multiple of RAM, such as 2xRAM)", swapSizeKey, size)
+		}
+		return swapSize{ramMultiple: multiple}, nil
+	}
+	mb, err := utils.ParseSize(size)
+	if err != nil || mb == 0 {
+		return swapSize{}, errors.NotValidf("%s %q (expected a positive size, such as 4G, or a multiple of RAM, such as 2xRAM)", swapSizeKey, size)
+	}
+	return swapSize{mb: mb}, nil
+}
+
+// megabytes returns the size of the swap file of an
+// instance of the given type, in megabytes. If the root disk
+// of the instance type is known, the swap file takes no more
+// than half of it.
+func (s swapSize) megabytes(instType instances.InstanceType) uint64 {
+	mb := s.mb
+	if s.ramMultiple != 0 {
+		mb = uint64(s.ramMultiple * float64(instType.Mem))
+	}
+	if max := instType.RootDisk / 2; instType.RootDisk != 0 && mb > max {
+		logger.Warningf(
+			"%s of %dM is too large for the %dM root disk of flavor %q; using %dM",
+			swapSizeKey, mb, instType.RootDisk, instType.Name, max,
+		)
+		mb = max
+	}
+	return mb
+}
+
+// addSwap configures the instance of the given type with the given
+// cloud config to have a swap file of the given size, using the
+// cloud-init swap module.
+func addSwap(cloudcfg cloudinit.CloudConfig, size swapSize, instType instances.InstanceType) error {
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu, jujuos.CentOS:
+	default:
+		return errors.NotSupportedf("creating a swap file on %s", os)
+	}
+	mb := size.megabytes(instType)
+	if mb == 0 {
+		return errors.Errorf("flavor %q has no room for a swap file", instType.Name)
+	}
+	bytes := mb * 1024 * 1024
+	cloudcfg.SetAttr("swap", map[string]interface{}{
+		"filename": swapFile,
+		"size":     bytes,
+		"maxsize":  bytes,
+	})
+	return nil
+}
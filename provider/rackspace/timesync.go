@@ -0,0 +1,113 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	jujuos "github.com/juju/utils/os"
+	"github.com/juju/utils/series"
+
+	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/service"
+)
+
+// timesyncdConfigFile is where the NTP servers are configured
+// for systemd-timesyncd on new Ubuntu instances using systemd.
+const timesyncdConfigFile = "/etc/systemd/timesyncd.conf.d/99-juju.conf"
+
+// hostnamePattern matches DNS hostnames.
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// validateTimezone checks that the given timezone
+// is a name in the tz database.
+func validateTimezone(tz string) error {
+	if tz == "Local" {
+		return errors.NotValidf("%s %q (expected a tz database name)", timezoneKey, tz)
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return errors.NotValidf("%s %q (expected a tz database name)", timezoneKey, tz)
+	}
+	return nil
+}
+
+// validateNTPServer checks that the given NTP server
+// is an IP address or hostname.
+func validateNTPServer(server string) error {
+	if net.ParseIP(server) == nil && !hostnamePattern.MatchString(server) {
+		return errors.NotValidf("%s entry %q (expected a hostname or IP address)", ntpServersKey, server)
+	}
+	return nil
+}
+
+// addTimezone configures the instance with the given cloud config
+// to use the given timezone, using the cloud-init timezone module.
+func addTimezone(cloudcfg cloudinit.CloudConfig, tz string) error {
+	os, err := series.GetOSFromSeries(cloudcfg.GetSeries())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu, jujuos.CentOS:
+		cloudcfg.SetAttr("timezone", tz)
+	default:
+		return errors.NotSupportedf("setting the timezone on %s", os)
+	}
+	return nil
+}
+
+// addNTPServers configures the instance with the given cloud config
+// to synchronise its clock with the given NTP servers, in place of
+// its default servers, using the time service its OS provides:
+// systemd-timesyncd on Ubuntu with systemd, ntpd on earlier Ubuntu
+// releases, where it is installed for the purpose, and chronyd on
+// CentOS.
+func addNTPServers(cloudcfg cloudinit.CloudConfig, servers []string) error {
+	ser := cloudcfg.GetSeries()
+	os, err := series.GetOSFromSeries(ser)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch os {
+	case jujuos.Ubuntu:
+		initSystem, err := service.VersionInitSystem(ser)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if initSystem == service.InitSystemSystemd {
+			cloudcfg.AddRunTextFile(timesyncdConfigFile, fmt.Sprintf("[Time]\nNTP=%s", strings.Join(servers, " ")), 0644)
+			cloudcfg.AddRunCmd("systemctl restart systemd-timesyncd")
+			return nil
+		}
+		cloudcfg.AddPackage("ntp")
+		cloudcfg.AddRunCmd(replaceNTPServersCmd("/etc/ntp.conf", servers))
+		cloudcfg.AddRunCmd("service ntp restart")
+	case jujuos.CentOS:
+		cloudcfg.AddRunCmd(replaceNTPServersCmd("/etc/chrony.conf", servers))
+		cloudcfg.AddRunCmd("systemctl restart chronyd")
+	default:
+		return errors.NotSupportedf("setting NTP servers on %s", os)
+	}
+	return nil
+}
+
+// replaceNTPServersCmd returns a command that comments out the
+// servers and pools configured in the given ntpd or chronyd
+// configuration file, and adds the given servers in their place.
+// The servers have been validated, so need no quoting.
+func replaceNTPServersCmd(path string, servers []string) string {
+	var lines []string
+	for _, server := range servers {
+		lines = append(lines, "server "+server+" iburst")
+	}
+	return fmt.Sprintf(
+		`sed -r -i -e 's/^(server|pool) /#&/' %s && printf '%%s\n' %s >> %s`,
+		path, "'"+strings.Join(lines, "' '")+"'", path,
+	)
+}
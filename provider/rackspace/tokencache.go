@@ -0,0 +1,130 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/juju/utils/clock"
+	"gopkg.in/goose.v1/client"
+
+	"github.com/juju/juju/environs"
+)
+
+// tokenLifetime is how long the identity v2 tokens
+// Rackspace issues remain valid after being issued.
+const tokenLifetime = 24 * time.Hour
+
+// tokenRefreshMargin is how long before its token is due to expire
+// that a client is replaced, so that its token is refreshed before
+// requests start to fail rather than after.
+const tokenRefreshMargin = time.Hour
+
+// clientKey identifies the clients that may share a token: those
+// authenticating with the same credentials to the same endpoint.
+type clientKey struct {
+	endpoint                string
+	region                  string
+	authType                string
+	credential              [sha256.Size]byte
+	sslHostnameVerification bool
+	computeEndpoint         string
+	computeAPIMicroversion  string
+}
+
+// newClientKey returns the key of the clients
+// of the given cloud spec and model config.
+func newClientKey(spec environs.CloudSpec, ecfg *environConfig) clientKey {
+	key := clientKey{
+		endpoint:                spec.Endpoint,
+		region:                  spec.Region,
+		sslHostnameVerification: ecfg.SSLHostnameVerification(),
+		computeEndpoint:         ecfg.computeEndpoint(),
+		computeAPIMicroversion:  ecfg.computeAPIMicroversion(),
+	}
+	if spec.Credential != nil {
+		key.authType = string(spec.Credential.AuthType())
+		attrs := spec.Credential.Attributes()
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		h := sha256.New()
+		for _, name := range names {
+			fmt.Fprintf(h, "%q=%q\n", name, attrs[name])
+		}
+		copy(key.credential[:], h.Sum(nil))
+	}
+	return key
+}
+
+// cachedClient holds a client and when its token is due to expire.
+type cachedClient struct {
+	client  client.AuthenticatingClient
+	expires time.Time
+}
+
+// clientCache holds the clients of each cloud spec and set of
+// credentials, so that environs share them, and so the identity
+// tokens they hold, rather than each authenticating anew.
+//
+// A client is reused until its token nears expiry, when it is
+// replaced with a new one, which authenticates when first used.
+// If a token is nevertheless rejected, for instance because it
+// has been revoked, the client itself discards it and authenticates
+// again, retrying the request once.
+type clientCache struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	clients map[clientKey]cachedClient
+}
+
+// newClientCache returns a new, empty, client cache
+// that uses the given clock to expire its clients.
+func newClientCache(clock clock.Clock) *clientCache {
+	return &clientCache{
+		clock:   clock,
+		clients: make(map[clientKey]cachedClient),
+	}
+}
+
+// get returns the cached client of the given cloud spec and model
+// config, or, if there is none or its token nears expiry, the
+// new client returned by the given function, caching it in turn.
+//
+// The lifetime of a client's token is counted from when the client
+// is cached, rather than from when it first authenticates, which
+// is no earlier, so the token is refreshed before it expires.
+func (c *clientCache) get(spec environs.CloudSpec, ecfg *environConfig, newClient func() (client.AuthenticatingClient, error)) (client.AuthenticatingClient, error) {
+	key := newClientKey(spec, ecfg)
+	now := c.clock.Now()
+	c.mu.Lock()
+	cached, ok := c.clients[key]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires.Add(-tokenRefreshMargin)) {
+		return cached.client, nil
+	}
+	cl, err := newClient()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clients[key] = cachedClient{
+		client:  cl,
+		expires: now.Add(tokenLifetime),
+	}
+	return cl, nil
+}
+
+// clients holds the clients shared by
+// the environs of the rackspace provider.
+var clients = newClientCache(clock.WallClock)
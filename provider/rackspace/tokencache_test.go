@@ -0,0 +1,490 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	gitjujutesting "github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/goose.v1/client"
+	goosehttp "gopkg.in/goose.v1/http"
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/nova"
+
+	"github.com/juju/juju/cloud"
+	"github.com/juju/juju/environs"
+	"github.com/juju/juju/environs/config"
+	"github.com/juju/juju/provider/openstack"
+	"github.com/juju/juju/provider/rackspace"
+	"github.com/juju/juju/testing"
+)
+
+type tokenCacheSuite struct {
+	testing.BaseSuite
+	configurator openstack.ProviderConfigurator
+	clock        *gitjujutesting.Clock
+	identity     *fakeIdentity
+	server       *httptest.Server
+
+	// endpoints holds the identity endpoints of the
+	// clients made, in the order they were made.
+	endpoints []string
+}
+
+var _ = gc.Suite(&tokenCacheSuite{})
+
+func (s *tokenCacheSuite) SetUpTest(c *gc.C) {
+	s.BaseSuite.SetUpTest(c)
+	s.configurator = rackspace.NewConfigurator()
+	s.clock = gitjujutesting.NewClock(time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC))
+	s.PatchValue(rackspace.Clients, rackspace.NewClientCache(s.clock))
+	s.identity = &fakeIdentity{revoked: make(map[string]bool)}
+	s.server = httptest.NewTLSServer(s.identity)
+	s.identity.url = s.server.URL
+	s.AddCleanup(func(*gc.C) { s.server.Close() })
+	s.endpoints = nil
+}
+
+func (s *tokenCacheSuite) cloudSpec(password string) environs.CloudSpec {
+	credential := cloud.NewCredential(cloud.UserPassAuthType, map[string]string{
+		"username":    "user",
+		"password":    password,
+		"tenant-name": "tenant",
+	})
+	return environs.CloudSpec{
+		Type:       "rackspace",
+		Name:       "rackspace",
+		Region:     "DFW",
+		Endpoint:   s.server.URL + "/v2.0",
+		Credential: &credential,
+	}
+}
+
+// modelConfig returns a model config with the given attributes
+// that does not verify the fake identity's certificate.
+func (s *tokenCacheSuite) modelConfig(c *gc.C, attrs testing.Attrs) *config.Config {
+	return testing.CustomModelConfig(c, testing.Attrs{
+		"ssl-hostname-verification": false,
+	}.Merge(attrs))
+}
+
+func (s *tokenCacheSuite) newClient(spec environs.CloudSpec) (client.AuthenticatingClient, error) {
+	s.endpoints = append(s.endpoints, spec.Endpoint)
+	attrs := spec.Credential.Attributes()
+	cl := client.NewNonValidatingClient(&identity.Credentials{
+		URL:        spec.Endpoint,
+		User:       attrs["username"],
+		Secrets:    attrs["password"],
+		Region:     spec.Region,
+		TenantName: attrs["tenant-name"],
+	}, identity.AuthUserPass, nil)
+	cl.SetRequiredServiceTypes([]string{"compute"})
+	return cl, nil
+}
+
+// getClient returns the client of the given spec and model
+// config after checking that it can make compute API requests.
+func (s *tokenCacheSuite) getClient(c *gc.C, spec environs.CloudSpec, cfg *config.Config) client.AuthenticatingClient {
+	cl, err := s.configurator.GetClient(spec, cfg, s.newClient)
+	c.Assert(err, jc.ErrorIsNil)
+	var resp struct{}
+	err = cl.SendRequest("GET", "compute", "servers", &goosehttp.RequestData{RespValue: &resp})
+	c.Assert(err, jc.ErrorIsNil)
+	return cl
+}
+
+func (s *tokenCacheSuite) TestTokenReused(c *gc.C) {
+	spec := s.cloudSpec("secret")
+	cl0 := s.getClient(c, spec, s.modelConfig(c, nil))
+	s.clock.Advance(rackspace.TokenLifetime - rackspace.TokenRefreshMargin - time.Second)
+	cl1 := s.getClient(c, spec, s.modelConfig(c, nil))
+	c.Assert(cl1, gc.Equals, cl0)
+	c.Assert(s.identity.tokenRequests(), gc.Equals, 1)
+}
+
+func (s *tokenCacheSuite) TestTokenRefreshedNearExpiry(c *gc.C) {
+	spec := s.cloudSpec("secret")
+	cl0 := s.getClient(c, spec, s.modelConfig(c, nil))
+	s.clock.Advance(rackspace.TokenLifetime - rackspace.TokenRefreshMargin)
+	cl1 := s.getClient(c, spec, s.modelConfig(c, nil))
+	c.Assert(cl1, gc.Not(gc.Equals), cl0)
+	c.Assert(cl1.Token(), gc.Equals, "token-2")
+	c.Assert(s.identity.tokenRequests(), gc.Equals, 2)
+
+	// The new token is reused in turn.
+	cl2 := s.getClient(c, spec, s.modelConfig(c, nil))
+	c.Assert(cl2, gc.Equals, cl1)
+	c.Assert(s.identity.tokenRequests(), gc.Equals, 2)
+}
+
+func (s *tokenCacheSuite) TestRejectedTokenRefreshed(c *gc.C) {
+	spec := s.cloudSpec("secret")
+	cl0 := s.getClient(c, spec, s.modelConfig(c, nil))
+	s.identity.revoke(cl0.Token())
+	cl1 := s.getClient(c, spec, s.modelConfig(c, nil))
+	c.Assert(cl1, gc.Equals, cl0)
+	c.Assert(cl1.Token(), gc.Equals, "token-2")
+	c.Assert(s.identity.tokenRequests(), gc.Equals, 2)
+}
+
+func (s *tokenCacheSuite) TestTokenNotSharedBetweenCredentials(c *gc.C) {
+	cl0 := s.getClient(c, s.cloudSpec("secret"), s.modelConfig(c, nil))
+	cl1 := s.getClient(c, s.cloudSpec("other-secret"), s.modelConfig(c, nil))
+	c.Assert(cl1, gc.Not(gc.Equals), cl0)
+	c.Assert(s.identity.tokenRequests(), gc.Equals, 2)
+}
+
+func (s *tokenCacheSuite) TestRegionEndpointsUsedByDefault(c *gc.C) {
+	s.getClient(c, s.cloudSpec("secret"), s.modelConfig(c, nil))
+	c.Assert(s.endpoints, jc.DeepEquals, []string{s.server.URL + "/v2.0"})
+	c.Assert(s.identity.computePaths(), jc.DeepEquals, []string{"/compute/servers"})
+}
+
+func (s *tokenCacheSuite) TestIdentityEndpoint(c *gc.C) {
+	spec := s.cloudSpec("secret")
+	spec.Endpoint = "https://identity.invalid/v2.0"
+	s.getClient(c, spec, s.modelConfig(c, testing.Attrs{
+		"identity-endpoint": s.server.URL + "/v2.0",
+	}))
+	c.Assert(s.endpoints, jc.DeepEquals, []string{s.server.URL + "/v2.0"})
+	c.Assert(s.identity.tokenRequests(), gc.Equals, 1)
+}
+
+func (s *tokenCacheSuite) TestComputeEndpoint(c *gc.C) {
+	cfg := s.modelConfig(c, testing.Attrs{
+		"compute-endpoint": s.server.URL + "/private-compute/",
+	})
+	cl := s.getClient(c, s.cloudSpec("secret"), cfg)
+	c.Assert(s.identity.computePaths(), jc.DeepEquals, []string{"/private-compute/servers"})
+	u, err := cl.MakeServiceURL("compute", []string{"flavors", "detail"})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(u, gc.Equals, s.server.URL+"/private-compute/flavors/detail")
+
+	// A rejected token is replaced.
+	s.identity.revoke(cl.Token())
+	s.getClient(c, s.cloudSpec("secret"), cfg)
+	c.Assert(s.identity.tokenRequests(), gc.Equals, 2)
+	c.Assert(s.identity.computePaths(), gc.HasLen, 3)
+}
+
+func (s *tokenCacheSuite) TestComputeEndpointNotInCatalog(c *gc.C) {
+	s.identity.noCompute = true
+	s.getClient(c, s.cloudSpec("secret"), s.modelConfig(c, testing.Attrs{
+		"compute-endpoint": s.server.URL + "/private-compute",
+	}))
+	c.Assert(s.identity.computePaths(), jc.DeepEquals, []string{"/private-compute/servers"})
+}
+
+func (s *tokenCacheSuite) TestInvalidEndpoints(c *gc.C) {
+	for _, key := range []string{"identity-endpoint", "compute-endpoint"} {
+		for _, endpoint := range []string{"identity.example.com/v2.0", "http://identity.example.com/v2.0", "https://", "%zz"} {
+			c.Logf("%s %q", key, endpoint)
+			_, err := s.configurator.GetClient(s.cloudSpec("secret"), s.modelConfig(c, testing.Attrs{
+				key: endpoint,
+			}), s.newClient)
+			c.Check(err, jc.Satisfies, errors.IsNotValid)
+			c.Check(err, gc.ErrorMatches, key+` ".*" \(expected an absolute https URL\) not valid`)
+		}
+	}
+	c.Assert(s.endpoints, gc.HasLen, 0)
+}
+
+func (s *tokenCacheSuite) TestNoComputeAPIMicroversionByDefault(c *gc.C) {
+	s.getClient(c, s.cloudSpec("secret"), s.modelConfig(c, nil))
+	c.Assert(s.identity.computeMicroversions(), jc.DeepEquals, []string{";"})
+}
+
+func (s *tokenCacheSuite) TestComputeAPIMicroversion(c *gc.C) {
+	cl := s.getClient(c, s.cloudSpec("secret"), s.modelConfig(c, testing.Attrs{
+		"compute-api-microversion": "2.19",
+	}))
+	c.Assert(s.identity.computeMicroversions(), jc.DeepEquals, []string{"2.19;"})
+
+	// Requests of other services are sent as they are.
+	err := cl.SendRequest("GET", "object-store", "container", &goosehttp.RequestData{
+		ExpectedStatus: []int{http.StatusNotFound},
+	})
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(s.identity.computeMicroversions(), gc.HasLen, 1)
+}
+
+func (s *tokenCacheSuite) TestComputeAPIMicroversionOpenStackAPIVersionHeader(c *gc.C) {
+	s.getClient(c, s.cloudSpec("secret"), s.modelConfig(c, testing.Attrs{
+		"compute-api-microversion": "2.32",
+	}))
+	c.Assert(s.identity.computeMicroversions(), jc.DeepEquals, []string{"2.32;compute 2.32"})
+}
+
+func (s *tokenCacheSuite) TestComputeAPIMicroversionWithComputeEndpoint(c *gc.C) {
+	s.getClient(c, s.cloudSpec("secret"), s.modelConfig(c, testing.Attrs{
+		"compute-endpoint":         s.server.URL + "/private-compute",
+		"compute-api-microversion": "2.26",
+	}))
+	c.Assert(s.identity.computePaths(), jc.DeepEquals, []string{"/private-compute/servers"})
+	c.Assert(s.identity.computeMicroversions(), jc.DeepEquals, []string{"2.26;"})
+}
+
+func (s *tokenCacheSuite) TestClientNotSharedBetweenComputeAPIMicroversions(c *gc.C) {
+	spec := s.cloudSpec("secret")
+	cl0 := s.getClient(c, spec, s.modelConfig(c, nil))
+	cl1 := s.getClient(c, spec, s.modelConfig(c, testing.Attrs{
+		"compute-api-microversion": "2.19",
+	}))
+	c.Assert(cl1, gc.Not(gc.Equals), cl0)
+}
+
+func (s *tokenCacheSuite) TestInvalidComputeAPIMicroversion(c *gc.C) {
+	for _, test := range []struct {
+		version string
+		err     string
+	}{{
+		version: "latest",
+		err:     `compute-api-microversion "latest" \(expected a version such as 2.19\) not valid`,
+	}, {
+		version: "2.019",
+		err:     `compute-api-microversion "2.019" \(expected a version such as 2.19\) not valid`,
+	}, {
+		version: "3.1",
+		err:     `compute-api-microversion "3.1" \(expected a version such as 2.19\) not valid`,
+	}, {
+		version: "2.0",
+		err:     `compute-api-microversion "2.0" \(expected a version from 2.1 to 2.35\) not valid`,
+	}, {
+		version: "2.36",
+		err:     `compute-api-microversion "2.36" \(expected a version from 2.1 to 2.35\) not valid`,
+	}} {
+		c.Logf("compute-api-microversion %q", test.version)
+		_, err := s.configurator.GetClient(s.cloudSpec("secret"), s.modelConfig(c, testing.Attrs{
+			"compute-api-microversion": test.version,
+		}), s.newClient)
+		c.Check(err, gc.ErrorMatches, test.err)
+		c.Check(err, jc.Satisfies, errors.IsNotValid)
+	}
+	c.Assert(s.endpoints, gc.HasLen, 0)
+}
+
+// runServer creates a server with the given name
+// using the client of the given model config.
+func (s *tokenCacheSuite) runServer(c *gc.C, cfg *config.Config, name string) {
+	cl, err := s.configurator.GetClient(s.cloudSpec("secret"), cfg, s.newClient)
+	c.Assert(err, jc.ErrorIsNil)
+	_, err = nova.New(cl).RunServer(nova.RunServerOpts{
+		Name:     name,
+		FlavorId: "performance1-1",
+		ImageId:  "image-id",
+	})
+	c.Assert(err, jc.ErrorIsNil)
+}
+
+func (s *tokenCacheSuite) TestInjectedFiles(c *gc.C) {
+	s.runServer(c, s.modelConfig(c, testing.Attrs{
+		"injected-files": map[string]interface{}{
+			"/etc/b": "second",
+			"/etc/a": "first",
+		},
+	}), "juju-machine-0")
+	created := s.identity.createRequests()
+	c.Assert(created, gc.HasLen, 1)
+	server := created[0]["server"].(map[string]interface{})
+	c.Assert(server["name"], gc.Equals, "juju-machine-0")
+	c.Assert(server["personality"], jc.DeepEquals, []interface{}{
+		map[string]interface{}{"path": "/etc/a", "contents": base64.StdEncoding.EncodeToString([]byte("first"))},
+		map[string]interface{}{"path": "/etc/b", "contents": base64.StdEncoding.EncodeToString([]byte("second"))},
+	})
+}
+
+func (s *tokenCacheSuite) TestSchedulerHints(c *gc.C) {
+	cfg := s.modelConfig(c, testing.Attrs{
+		"scheduler-hints": map[string]interface{}{
+			"group":          "8d7b5c4a-5a3c-4f4e-9b7e-2c1c9bdbc4a1",
+			"different_host": "0b5e4914-9f4b-4d8d-a5b0-b3cd4f6ccc35, 1c6f5a25-0a5c-4e9e-b6c1-c4de5a7ddd46",
+		},
+	})
+	s.runServer(c, cfg, "juju-machine-0")
+	s.runServer(c, cfg, "juju-machine-1")
+	created := s.identity.createRequests()
+	c.Assert(created, gc.HasLen, 2)
+	for i, body := range created {
+		server := body["server"].(map[string]interface{})
+		c.Assert(server["name"], gc.Equals, fmt.Sprintf("juju-machine-%d", i))
+		c.Assert(body["os:scheduler_hints"], jc.DeepEquals, map[string]interface{}{
+			"group": "8d7b5c4a-5a3c-4f4e-9b7e-2c1c9bdbc4a1",
+			"different_host": []interface{}{
+				"0b5e4914-9f4b-4d8d-a5b0-b3cd4f6ccc35",
+				"1c6f5a25-0a5c-4e9e-b6c1-c4de5a7ddd46",
+			},
+		})
+	}
+}
+
+func (s *tokenCacheSuite) TestSSHKeyModeKeyName(c *gc.C) {
+	for i, test := range []struct {
+		mode    string
+		keyName interface{}
+	}{
+		{"merge", "ops"},
+		{"juju-only", nil},
+		{"provider-only", "ops"},
+	} {
+		c.Logf("test %d: %s", i, test.mode)
+		s.runServer(c, s.modelConfig(c, testing.Attrs{
+			"ssh-key-mode": test.mode,
+			"keypair-name": "ops",
+		}), "juju-machine-0")
+		created := s.identity.createRequests()
+		c.Assert(created, gc.HasLen, i+1)
+		server := created[i]["server"].(map[string]interface{})
+		c.Check(server["key_name"], gc.Equals, test.keyName)
+	}
+}
+
+func (s *tokenCacheSuite) TestNoServerCreateOptions(c *gc.C) {
+	s.runServer(c, s.modelConfig(c, nil), "juju-machine-0")
+	created := s.identity.createRequests()
+	c.Assert(created, gc.HasLen, 1)
+	c.Assert(created[0], gc.HasLen, 1)
+	server := created[0]["server"].(map[string]interface{})
+	c.Assert(server["name"], gc.Equals, "juju-machine-0")
+	c.Assert(server["personality"], gc.IsNil)
+}
+
+// fakeIdentity is an http.Handler that serves the identity v2
+// tokens endpoint, issuing tokens token-1, token-2 and so on, and
+// compute endpoints, at /compute and /private-compute, that accept
+// requests made with any token it has issued and not revoked.
+type fakeIdentity struct {
+	url string
+
+	// noCompute holds whether the compute
+	// service is left out of the catalog.
+	noCompute bool
+
+	// loadBalancers holds whether the Cloud Load Balancers
+	// service, at /load-balancers, is in the catalog.
+	loadBalancers bool
+
+	mu            sync.Mutex
+	issued        int
+	revoked       map[string]bool
+	requests      []string
+	microversions []string
+	created       []map[string]interface{}
+}
+
+func (f *fakeIdentity) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case req.Method == "POST" && req.URL.Path == "/v2.0/tokens":
+		f.issued++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access": map[string]interface{}{
+				"token": map[string]interface{}{
+					"id":      fmt.Sprintf("token-%d", f.issued),
+					"expires": time.Now().Add(rackspace.TokenLifetime).Format(time.RFC3339),
+					"tenant":  map[string]interface{}{"id": "tenant-id", "name": "tenant"},
+				},
+				"serviceCatalog": f.serviceCatalog(),
+				"user":           map[string]interface{}{"id": "user-id", "name": "user"},
+			},
+		})
+	case req.URL.Path == "/compute/servers" || req.URL.Path == "/private-compute/servers":
+		f.requests = append(f.requests, req.URL.Path)
+		f.microversions = append(f.microversions, req.Header.Get("X-OpenStack-Nova-API-Version")+";"+req.Header.Get("OpenStack-API-Version"))
+		token := req.Header.Get("X-Auth-Token")
+		var n int
+		if _, err := fmt.Sscanf(token, "token-%d", &n); err != nil || n > f.issued || f.revoked[token] {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.Method == "POST" {
+			var body map[string]interface{}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.created = append(f.created, body)
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, `{"server": {"id": "server-%d"}}`, len(f.created))
+			return
+		}
+		fmt.Fprint(w, "{}")
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (f *fakeIdentity) serviceCatalog() []interface{} {
+	service := func(name, serviceType, path string) interface{} {
+		return map[string]interface{}{
+			"name": name,
+			"type": serviceType,
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"region":    "DFW",
+					"publicURL": f.url + path,
+				},
+			},
+		}
+	}
+	catalog := []interface{}{
+		service("cloudFiles", "object-store", "/object-store"),
+	}
+	if !f.noCompute {
+		catalog = append(catalog, service("cloudServersOpenStack", "compute", "/compute"))
+	}
+	if f.loadBalancers {
+		catalog = append(catalog, service("cloudLoadBalancers", "rax:load-balancer", "/load-balancers"))
+	}
+	return catalog
+}
+
+// computePaths returns the paths of the
+// compute API requests made, in order.
+func (f *fakeIdentity) computePaths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// computeMicroversions returns the microversion headers of the
+// compute API requests made, in order, as the values of the
+// X-OpenStack-Nova-API-Version and OpenStack-API-Version headers
+// separated by a semicolon.
+func (f *fakeIdentity) computeMicroversions() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.microversions...)
+}
+
+// createRequests returns the bodies of the requests
+// made to create servers, in order.
+func (f *fakeIdentity) createRequests() []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]interface{}(nil), f.created...)
+}
+
+func (f *fakeIdentity) tokenRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.issued
+}
+
+func (f *fakeIdentity) revoke(token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revoked[token] = true
+}
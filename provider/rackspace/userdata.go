@@ -0,0 +1,72 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package rackspace
+
+import (
+	"bytes"
+
+	jujuos "github.com/juju/utils/os"
+
+	"github.com/juju/juju/cloudconfig"
+	"github.com/juju/juju/cloudconfig/cloudinit"
+	"github.com/juju/juju/cloudconfig/providerinit/renderers"
+	"github.com/juju/juju/provider/openstack"
+)
+
+// userDataRenderer renders the user data for rackspace instances.
+// Rackspace limits the size of user data, so by default it is gzip
+// compressed as for openstack; cloud-init detects and decompresses
+// gzipped user data without any further wrapping. If compress is
+// false, cloud-config is passed uncompressed.
+//
+// Any secrets held in the user data are listed in secrets, so that
+// they can be redacted when it is logged.
+//
+// Juju sets whether instances update and upgrade their packages from
+// the model's enable-os-refresh-update and enable-os-upgrade settings
+// when it composes the user data, after the provider's cloud config
+// has been made, so if packageUpdate or packageUpgrade is not nil,
+// the renderer sets the corresponding flag again from it. Juju adds
+// its authorized keys then too, so if noJujuKeys is true, as when
+// the instance is given only the key of a provider keypair, the
+// renderer removes them again.
+type userDataRenderer struct {
+	compress       bool
+	secrets        []string
+	packageUpdate  *bool
+	packageUpgrade *bool
+	noJujuKeys     bool
+}
+
+// redactedSecret replaces secrets in redacted user data.
+const redactedSecret = "<redacted>"
+
+// Render implements renderers.ProviderRenderer.
+func (r userDataRenderer) Render(cfg cloudinit.CloudConfig, os jujuos.OSType) ([]byte, error) {
+	if r.packageUpdate != nil {
+		cfg.SetSystemUpdate(*r.packageUpdate)
+	}
+	if r.packageUpgrade != nil {
+		cfg.SetSystemUpgrade(*r.packageUpgrade)
+	}
+	if r.noJujuKeys && (os == jujuos.Ubuntu || os == jujuos.CentOS) {
+		cfg.UnsetUsers()
+		cloudconfig.SetUbuntuUser(cfg, "")
+	}
+	if !r.compress {
+		switch os {
+		case jujuos.Ubuntu, jujuos.CentOS:
+			return renderers.RenderYAML(cfg)
+		}
+	}
+	return openstack.OpenstackRenderer{}.Render(cfg, os)
+}
+
+// Redact implements renderers.Redacter.
+func (r userDataRenderer) Redact(udata []byte) []byte {
+	for _, secret := range r.secrets {
+		udata = bytes.Replace(udata, []byte(secret), []byte(redactedSecret), -1)
+	}
+	return udata
+}
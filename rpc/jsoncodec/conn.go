@@ -5,8 +5,12 @@ package jsoncodec
 
 import (
 	"encoding/json"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 
+	"github.com/juju/errors"
 	"golang.org/x/net/websocket"
 )
 
@@ -32,6 +36,144 @@ func (conn wsJSONConn) Close() error {
 	return conn.conn.Close()
 }
 
+// NewWebsocketLimit returns an rpc codec that uses the given websocket
+// connection to send and receive messages, failing to receive any
+// message larger than maxMessageBytes rather than reading it into
+// memory.
+func NewWebsocketLimit(conn *websocket.Conn, maxMessageBytes int64) *Codec {
+	return NewWebsocketReadLimit(conn, NewReadLimit(maxMessageBytes))
+}
+
+// NewWebsocketReadLimit returns an rpc codec that uses the given
+// websocket connection to send and receive messages, failing to
+// receive any message larger than the given limit allows. The limit
+// may be changed while the codec is in use.
+func NewWebsocketReadLimit(conn *websocket.Conn, limit *ReadLimit) *Codec {
+	reader := &messageLimitReader{r: conn, limit: limit}
+	return New(&wsLimitedJSONConn{
+		conn:   conn,
+		reader: reader,
+		dec:    json.NewDecoder(reader),
+	})
+}
+
+// ReadLimit holds the size of the largest message that may be
+// received by a codec returned by NewWebsocketReadLimit. It is
+// safe to use concurrently.
+type ReadLimit struct {
+	max int64
+
+	mu       sync.Mutex
+	err      error
+	exceeded chan struct{}
+}
+
+// NewReadLimit returns a ReadLimit allowing messages of up to
+// maxMessageBytes. If maxMessageBytes is not positive, the size
+// of messages is not limited.
+func NewReadLimit(maxMessageBytes int64) *ReadLimit {
+	return &ReadLimit{
+		max:      maxMessageBytes,
+		exceeded: make(chan struct{}),
+	}
+}
+
+// Exceeded returns a channel that is closed when a codec using the
+// limit fails to receive a message because it is larger than the
+// limit allows. The codec cannot be used to receive messages after
+// that.
+func (l *ReadLimit) Exceeded() <-chan struct{} {
+	return l.exceeded
+}
+
+// Err returns the error with which a codec using the limit failed
+// to receive a message, once Exceeded is closed, or nil before.
+func (l *ReadLimit) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// exceed records that a message could not be received
+// because of the limit, with the given error.
+func (l *ReadLimit) exceed(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = err
+		close(l.exceeded)
+	}
+}
+
+// Set sets the size of the largest message that may be received. It
+// applies to all messages from the next read onwards, including one
+// that has been partly received. If maxMessageBytes is not positive,
+// the size of messages is not limited.
+func (l *ReadLimit) Set(maxMessageBytes int64) {
+	atomic.StoreInt64(&l.max, maxMessageBytes)
+}
+
+// Get returns the size of the largest message that may be
+// received, or a non-positive value if it is not limited.
+func (l *ReadLimit) Get() int64 {
+	return atomic.LoadInt64(&l.max)
+}
+
+// wsLimitedJSONConn is a JSONConn that decodes received messages
+// as a stream, so that the size of each may be limited as it is
+// read; websocket.JSON.Receive reads a whole frame before decoding.
+type wsLimitedJSONConn struct {
+	conn   *websocket.Conn
+	reader *messageLimitReader
+	dec    *json.Decoder
+}
+
+func (conn *wsLimitedJSONConn) Send(msg interface{}) error {
+	return websocket.JSON.Send(conn.conn, msg)
+}
+
+func (conn *wsLimitedJSONConn) Receive(msg interface{}) error {
+	// The decoder may already hold the start of this
+	// message, read ahead while decoding the previous one.
+	var buffered int64
+	if r, ok := conn.dec.Buffered().(interface {
+		Len() int
+	}); ok {
+		buffered = int64(r.Len())
+	}
+	conn.reader.n = buffered
+	return conn.dec.Decode(msg)
+}
+
+func (conn *wsLimitedJSONConn) Close() error {
+	return conn.conn.Close()
+}
+
+// messageLimitReader reads from r, failing once more bytes of a
+// message have been read than the limit allows, where n holds the
+// number read so far and is reset for each message.
+type messageLimitReader struct {
+	r     io.Reader
+	n     int64
+	limit *ReadLimit
+}
+
+func (l *messageLimitReader) Read(p []byte) (int, error) {
+	if max := l.limit.Get(); max > 0 {
+		if l.n >= max {
+			err := errors.Errorf("message larger than %d bytes", max)
+			l.limit.exceed(err)
+			return 0, err
+		}
+		if int64(len(p)) > max-l.n {
+			p = p[:max-l.n]
+		}
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	return n, err
+}
+
 // NewNet returns an rpc codec that uses the given net
 // connection to send and receive messages.
 func NewNet(conn net.Conn) *Codec {
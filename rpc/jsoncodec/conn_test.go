@@ -0,0 +1,129 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package jsoncodec_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/juju/testing"
+	jc "github.com/juju/testing/checkers"
+	"golang.org/x/net/websocket"
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/rpc"
+	"github.com/juju/juju/rpc/jsoncodec"
+)
+
+type websocketSuite struct {
+	testing.IsolationSuite
+}
+
+var _ = gc.Suite(&websocketSuite{})
+
+// dialMessages starts a websocket server that sends each of the
+// given messages in its own frame, and returns a connection to it.
+func (s *websocketSuite) dialMessages(c *gc.C, msgs ...string) *websocket.Conn {
+	done := make(chan struct{})
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		for _, msg := range msgs {
+			if err := websocket.Message.Send(ws, msg); err != nil {
+				c.Errorf("cannot send message: %v", err)
+				return
+			}
+		}
+		<-done
+	}))
+	s.AddCleanup(func(*gc.C) {
+		close(done)
+		srv.Close()
+	})
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", "http://localhost/")
+	c.Assert(err, jc.ErrorIsNil)
+	s.AddCleanup(func(*gc.C) { ws.Close() })
+	return ws
+}
+
+func (s *websocketSuite) TestWebsocketLimit(c *gc.C) {
+	small := `{"request-id": 1, "response": {"X": "x"}}`
+	large := `{"request-id": 2, "response": {"X": "` + strings.Repeat("x", 1000) + `"}}`
+	ws := s.dialMessages(c, small, small, large)
+	limit := jsoncodec.NewReadLimit(int64(len(small)))
+	codec := jsoncodec.NewWebsocketReadLimit(ws, limit)
+
+	for i := 0; i < 2; i++ {
+		var hdr rpc.Header
+		err := codec.ReadHeader(&hdr)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(hdr.RequestId, gc.Equals, uint64(1))
+		var v value
+		err = codec.ReadBody(&v, false)
+		c.Assert(err, jc.ErrorIsNil)
+		c.Assert(v, gc.Equals, value{X: "x"})
+	}
+	c.Assert(limit.Err(), jc.ErrorIsNil)
+
+	var hdr rpc.Header
+	err := codec.ReadHeader(&hdr)
+	c.Assert(err, gc.ErrorMatches, "error receiving message: message larger than 41 bytes")
+	select {
+	case <-limit.Exceeded():
+	default:
+		c.Fatalf("limit not reported exceeded")
+	}
+	c.Assert(limit.Err(), gc.ErrorMatches, "message larger than 41 bytes")
+}
+
+func (s *websocketSuite) TestWebsocketNoLimit(c *gc.C) {
+	large := `{"request-id": 2, "response": {"X": "` + strings.Repeat("x", 1000) + `"}}`
+	ws := s.dialMessages(c, large)
+	codec := jsoncodec.NewWebsocket(ws)
+
+	var hdr rpc.Header
+	err := codec.ReadHeader(&hdr)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(hdr.RequestId, gc.Equals, uint64(2))
+}
+
+func (s *websocketSuite) TestWebsocketReadLimitSet(c *gc.C) {
+	small := `{"request-id": 1, "response": {"X": "x"}}`
+	medium := `{"request-id": 2, "response": {"X": "` + strings.Repeat("x", 10) + `"}}`
+	large := `{"request-id": 3, "response": {"X": "` + strings.Repeat("x", 1000) + `"}}`
+	ws := s.dialMessages(c, small, medium, large)
+	limit := jsoncodec.NewReadLimit(int64(len(small)))
+	codec := jsoncodec.NewWebsocketReadLimit(ws, limit)
+
+	var hdr rpc.Header
+	err := codec.ReadHeader(&hdr)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(hdr.RequestId, gc.Equals, uint64(1))
+	err = codec.ReadBody(nil, false)
+	c.Assert(err, jc.ErrorIsNil)
+
+	// The medium message is just over the old limit.
+	limit.Set(int64(len(medium)))
+	c.Assert(limit.Get(), gc.Equals, int64(len(medium)))
+	err = codec.ReadHeader(&hdr)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(hdr.RequestId, gc.Equals, uint64(2))
+	err = codec.ReadBody(nil, false)
+	c.Assert(err, jc.ErrorIsNil)
+
+	err = codec.ReadHeader(&hdr)
+	c.Assert(err, gc.ErrorMatches, fmt.Sprintf("error receiving message: message larger than %d bytes", len(medium)))
+}
+
+func (s *websocketSuite) TestWebsocketReadLimitUnlimited(c *gc.C) {
+	large := `{"request-id": 2, "response": {"X": "` + strings.Repeat("x", 1000) + `"}}`
+	ws := s.dialMessages(c, large)
+	limit := jsoncodec.NewReadLimit(10)
+	codec := jsoncodec.NewWebsocketReadLimit(ws, limit)
+	limit.Set(0)
+
+	var hdr rpc.Header
+	err := codec.ReadHeader(&hdr)
+	c.Assert(err, jc.ErrorIsNil)
+	c.Assert(hdr.RequestId, gc.Equals, uint64(2))
+}
@@ -88,7 +88,9 @@ func connectFallback(
 	// atom in a func currently seems to be less treacherous
 	// than the alternatives.
 	var tryConnect = func() {
-		conn, err = apiOpen(info, api.DialOpts{})
+		// Agents connect together after a controller restart,
+		// so their health check pings must be spread out.
+		conn, err = apiOpen(info, api.DialOpts{PingJitter: true})
 	}
 
 	didFallback = info.Password == ""
@@ -187,7 +187,7 @@ func openCalls(model names.ModelTag, entity names.Tag, passwords ...string) []te
 		}
 		calls[i] = testing.StubCall{
 			FuncName: "apiOpen",
-			Args:     []interface{}{info, api.DialOpts{}},
+			Args:     []interface{}{info, api.DialOpts{PingJitter: true}},
 		}
 	}
 	return calls